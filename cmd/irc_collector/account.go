@@ -0,0 +1,387 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"golang.org/x/sync/errgroup"
+
+	channelrecord "github.com/Jamie-38/stream-pipeline/internal/channel_record"
+	"github.com/Jamie-38/stream-pipeline/internal/config"
+	"github.com/Jamie-38/stream-pipeline/internal/healthcheck"
+	"github.com/Jamie-38/stream-pipeline/internal/httpapi"
+	ircevents "github.com/Jamie-38/stream-pipeline/internal/irc_events"
+	"github.com/Jamie-38/stream-pipeline/internal/oauth"
+	"github.com/Jamie-38/stream-pipeline/internal/observe"
+	"github.com/Jamie-38/stream-pipeline/internal/ratelimit"
+	"github.com/Jamie-38/stream-pipeline/internal/scheduler"
+	"github.com/Jamie-38/stream-pipeline/internal/types"
+)
+
+// accountPipeline is everything one bot identity runs: its token, its
+// connection, its desired channels and the stages between them. Parsed
+// events of every account meet in the shared parseCh.
+type accountPipeline struct {
+	acct   types.Account
+	multi  bool           // health components are suffixed with the account name
+	nick   string         // login on the wire; a justinfan nick when anonymous
+	tokens *oauth.Manager // nil when anonymous
+	ctl    *channelrecord.Controller
+	limits *ratelimit.Limits
+	sender *scheduler.Sender
+	conns  *connManager
+	conn   *websocket.Conn // current connection; replaced by runConnection
+
+	controlCh      chan types.IRCCommand
+	rectifierOutCh chan types.IRCCommand
+	membershipCh   chan types.MembershipEvent
+	writerCh       chan string
+	readerCh       chan ircLine
+	chatCh         chan types.ChatEvent
+	retuneCh       chan channelrecord.Config // latest unapplied rectifier tunables
+
+	wsHealth, classifierHealth *healthcheck.Component
+}
+
+// healthName scopes a health component to the account when the collector
+// runs several, so "irc_websocket" becomes "irc_websocket/bot_b".
+func (p *accountPipeline) healthName(base string) string {
+	if !p.multi {
+		return base
+	}
+	return base + "/" + p.acct.Name()
+}
+
+// newAccountPipeline loads the account's token and channels, registers its
+// health components and connects. It fails fast so main can exit before
+// any stage runs.
+func newAccountPipeline(ctx context.Context, acct types.Account, multi bool, cfg config.Config, profile ratelimit.Profile) (*accountPipeline, error) {
+	buf := cfg.Buffers
+	p := &accountPipeline{
+		acct:           acct,
+		multi:          multi,
+		controlCh:      make(chan types.IRCCommand, buf.Control),
+		rectifierOutCh: make(chan types.IRCCommand, buf.RectifierOut),
+		membershipCh:   make(chan types.MembershipEvent, buf.Membership),
+		writerCh:       make(chan string, buf.Writer),
+		readerCh:       make(chan ircLine, buf.Reader),
+		chatCh:         make(chan types.ChatEvent, buf.Chat),
+		retuneCh:       make(chan channelrecord.Config, 1),
+	}
+	rules := cfg.Health
+	lg := observe.C("irc_collector").With("account", acct.Name())
+
+	depth := func(name string, fn func() int) {
+		observe.Metrics().GaugeFunc("pipeline_channel_depth", "Buffered items waiting in a pipeline channel.",
+			func() float64 { return float64(fn()) }, "account", acct.Name(), "channel", name)
+	}
+	depth("readerCh", func() int { return len(p.readerCh) })
+	depth("writerCh", func() int { return len(p.writerCh) })
+
+	health := healthcheck.Default()
+	p.wsHealth = health.Register(p.healthName("irc_websocket"), healthcheck.Options{MaxSilence: rules.IRCMaxSilence})
+	p.classifierHealth = health.Register(p.healthName("classifier"),
+		healthcheck.Options{StallAfter: rules.StallAfter, Pending: func() int { return len(p.readerCh) }})
+	health.Register(p.healthName("rectifier"), healthcheck.Options{StallAfter: rules.StallAfter})
+
+	var err error
+	if acct.Anonymous {
+		p.nick = anonymousNick()
+	} else if err = p.loadToken(ctx, cfg); err != nil {
+		return nil, err
+	}
+
+	// Build JSON controller (single writer), consuming HTTP intents from controlCh.
+	if p.ctl, err = channelrecord.NewController(acct.ChannelsPath, p.recordAccount(), p.controlCh); err != nil {
+		return nil, fmt.Errorf("init channels controller %q: %w", acct.ChannelsPath, err)
+	}
+
+	// Twitch rate limits are per user, so each account gets its own budget
+	p.limits = ratelimit.NewLimits(profile)
+	p.sender = scheduler.NewSender(p.limits, buf.Outbox)
+	p.sender.ReadOnly = acct.Anonymous
+
+	lg.Info("starting", "nick", p.nick, "anonymous", acct.Anonymous)
+	p.conns = &connManager{account: acct.Name(), nick: p.nick, uri: cfg.Twitch.IRCURI}
+	if p.tokens != nil {
+		p.conns.creds = p.tokens
+	}
+	if p.conn, err = p.conns.Dial(ctx); err != nil {
+		return nil, fmt.Errorf("websocket connect: %w", err)
+	}
+	lg.Info("connected", "uri", cfg.Twitch.IRCURI)
+	p.wsHealth.Beat()
+	p.wsHealth.SetReady(true, "connected")
+	return p, nil
+}
+
+// recordAccount is the owner recorded in the channels file. Anonymous nicks
+// change on every start, so those files are keyed by account name.
+func (p *accountPipeline) recordAccount() string {
+	if p.acct.Anonymous {
+		return p.acct.Name()
+	}
+	return p.acct.Nick
+}
+
+// selfLogin is the login Twitch echoes our own JOINs and PARTs under.
+func (p *accountPipeline) selfLogin() string {
+	if p.acct.Anonymous {
+		return p.nick
+	}
+	return strings.ToLower(p.acct.User)
+}
+
+// loadToken loads, validates and if needed refreshes the account's token.
+func (p *accountPipeline) loadToken(ctx context.Context, cfg config.Config) error {
+	acct := p.acct
+	p.nick = acct.Nick
+	healthcheck.Default().Register(p.healthName("oauth_token"), healthcheck.Options{})
+
+	store, err := oauth.TokenStoreFromEnv(acct.TokensPath)
+	if err != nil {
+		return fmt.Errorf("token store: %w", err)
+	}
+	tokenCfg := oauth.NewDefaultManagerConfig()
+	tokenCfg.Store = store
+	tokenCfg.ClientID = cfg.Twitch.ClientID
+	tokenCfg.ClientSecret = cfg.Twitch.ClientSecret
+	tokenCfg.HealthName = p.healthName("oauth_token")
+	tokenCfg.Endpoints = oauth.EndpointsFromEnv()
+	if cfg.Health.TokenValidate > 0 {
+		// notice revocations sooner than Twitch's hourly minimum
+		tokenCfg.ValidateEach = cfg.Health.TokenValidate
+	}
+	if p.tokens, err = oauth.NewManager(tokenCfg); err != nil {
+		return fmt.Errorf("load token %q: %w", acct.TokensPath, err)
+	}
+
+	// validate (and if needed refresh) the token before the first connect
+	if err := p.tokens.Ensure(ctx); err != nil {
+		return fmt.Errorf("token validation: %w", err)
+	}
+	if login := p.tokens.Login(); login != "" && login != acct.Name() {
+		return fmt.Errorf("token %q belongs to %q, not %q", acct.TokensPath, login, acct.Name())
+	}
+	return nil
+}
+
+// start runs the account's stages under g. policies is the merged policy
+// of every account, so a channel two accounts hold is classified the same
+// way on both connections.
+func (p *accountPipeline) start(ctx context.Context, g *errgroup.Group, parseCh chan<- ircevents.Event, policies types.PolicyLookup, cfg config.Config) {
+	// Channels controller
+	g.Go(func() error { return p.ctl.Run(ctx) })
+
+	// Token upkeep: validate periodically, refresh before expiry, report revocation
+	if p.tokens != nil {
+		g.Go(func() error { return p.tokens.Run(ctx) })
+	}
+
+	// Channel rectifier
+	rc := rectifierConfig(cfg)
+	rc.Limiter = p.limits.Join
+	rc.HealthName = p.healthName("rectifier")
+	rc.Retune = p.retuneCh
+	g.Go(func() error {
+		return channelrecord.Run(ctx, p.ctl, p.membershipCh, p.rectifierOutCh, rc)
+	})
+
+	// IRC control scheduler (JOIN/PART -> comma-batched lines -> writerCh)
+	g.Go(func() error {
+		scheduler.Control_scheduler(ctx, p.rectifierOutCh, p.writerCh, scheduler.BatchConfig{
+			MaxChannels: cfg.Scheduler.MaxChannels,
+			MaxBytes:    cfg.Scheduler.MaxBytes,
+			Linger:      cfg.Scheduler.Linger,
+		})
+		return nil
+	})
+
+	// Outbound chat (PRIVMSG -> writerCh, USERSTATE/NOTICE confirmations <- chatCh)
+	g.Go(func() error {
+		p.sender.Run(ctx, p.writerCh, p.chatCh)
+		return nil
+	})
+
+	// IRC socket reader -> readerCh and single writer <- writerCh,
+	// re-dialed with the current token whenever the socket fails
+	g.Go(func() error { return p.runConnection(ctx) })
+
+	// Parser: readerCh -> parseCh
+	g.Go(func() error {
+		ClassifyLine(ctx, p.readerCh, parseCh, p.membershipCh, p.selfLogin(), ClassifyOptions{
+			Policies: policies, Chat: p.chatCh, Health: p.classifierHealth, Anonymous: p.acct.Anonymous,
+		})
+		return nil
+	})
+}
+
+// Reconnect backoff after a failed dial.
+const (
+	redialMin = time.Second
+	redialMax = 2 * time.Minute
+)
+
+// runConnection serves the account's socket until ctx ends. When the
+// reader or writer fails it tells the rectifier every channel was lost,
+// then re-dials through the connection manager, which logs in with the
+// token current at that moment.
+func (p *accountPipeline) runConnection(ctx context.Context) error {
+	lg := observe.C("connector").With("account", p.acct.Name())
+	for {
+		err := p.serve(ctx, p.conn)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		lg.Warn("connection lost", "err", err)
+		p.wsHealth.SetReady(false, "reconnecting: "+err.Error())
+		select {
+		case p.membershipCh <- types.MembershipEvent{Op: "DISCONNECT"}:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+
+		for wait := redialMin; ; wait = min(wait*2, redialMax) {
+			select {
+			case <-time.After(wait):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+			conn, err := p.conns.Dial(ctx)
+			if err == nil {
+				p.conn = conn
+				break
+			}
+			lg.Warn("reconnect failed", "err", err, "retry_in", min(wait*2, redialMax))
+		}
+		lg.Info("reconnected")
+		p.wsHealth.Beat()
+		p.wsHealth.SetReady(true, "reconnected")
+	}
+}
+
+// serve runs the reader and writer on conn until either fails or ctx
+// ends, and closes conn.
+func (p *accountPipeline) serve(ctx context.Context, conn *websocket.Conn) error {
+	g, ctx := errgroup.WithContext(ctx)
+	g.Go(func() error { return StartReader(ctx, conn, p.writerCh, p.readerCh, p.wsHealth) })
+	g.Go(func() error { return IRCWriter(ctx, conn, p.writerCh) })
+	g.Go(func() error {
+		<-ctx.Done()
+		return conn.Close() // unblocks the reader
+	})
+	return g.Wait()
+}
+
+// rectifierConfig maps the rectifier section of cfg onto channel_record.
+func rectifierConfig(cfg config.Config) channelrecord.Config {
+	r := cfg.Rectifier
+	return channelrecord.Config{
+		JoinTimeout:    r.JoinTimeout,
+		BackoffMin:     r.BackoffMin,
+		BackoffMax:     r.BackoffMax,
+		Tick:           r.Tick,
+		RetryAging:     r.RetryAging,
+		MinJoinedRatio: cfg.Health.MinJoinedRatio,
+	}
+}
+
+// retuneRectifier hands c to the running rectifier, replacing any update
+// it has not picked up yet.
+func (p *accountPipeline) retuneRectifier(c channelrecord.Config) {
+	for {
+		select {
+		case p.retuneCh <- c:
+			return
+		default:
+		}
+		select {
+		case <-p.retuneCh:
+		default:
+		}
+	}
+}
+
+// api is the account's control surface for httpapi.
+func (p *accountPipeline) api() httpapi.Account {
+	return httpapi.Account{
+		ControlCh: p.controlCh,
+		History:   p.ctl,
+		Catalog:   p.ctl,
+		Sender:    p.sender,
+		Status: func() any {
+			st := map[string]any{
+				"nick":        p.nick,
+				"anonymous":   p.acct.Anonymous,
+				"rate_limits": p.limits.Status(time.Now()),
+			}
+			if p.tokens != nil {
+				st["token_login"] = p.tokens.Login()
+				st["token_exp"] = p.tokens.ExpiresAt()
+				st["token_revoked"] = p.tokens.Revoked()
+			}
+			return st
+		},
+	}
+}
+
+// policySet merges the channel policies of all accounts. A channel held by
+// several accounts is read on each connection, so its events reach parseCh
+// and Kafka once per account; merging keeps every copy under the strictest
+// policy: text is redacted if any account redacts it, only kinds every
+// account collects are kept, and the lowest sample rate applies.
+type policySet []types.PolicyLookup
+
+func (ps policySet) Policy(channelLogin string) (types.ChannelPolicy, bool) {
+	var merged types.ChannelPolicy
+	found := false
+	for _, l := range ps {
+		p, ok := l.Policy(channelLogin)
+		if !ok {
+			continue
+		}
+		if !found {
+			merged, found = p, true
+			continue
+		}
+		merged.RedactText = merged.RedactText || p.RedactText
+		merged.Kinds = intersectKinds(merged.Kinds, p.Kinds)
+		if sampleShare(p.SampleRate) < sampleShare(merged.SampleRate) {
+			merged.SampleRate = p.SampleRate
+		}
+	}
+	return merged, found
+}
+
+// intersectKinds keeps the kinds both lists collect; empty collects all.
+// When they share none, the result holds only "", which no event has.
+func intersectKinds(a, b []string) []string {
+	if len(a) == 0 {
+		return b
+	}
+	if len(b) == 0 {
+		return a
+	}
+	var out []string
+	for _, k := range a {
+		if slices.Contains(b, k) {
+			out = append(out, k)
+		}
+	}
+	if len(out) == 0 {
+		return []string{""}
+	}
+	return out
+}
+
+// sampleShare is the share of events a sample rate keeps; 0 means all.
+func sampleShare(r float64) float64 {
+	if r <= 0 {
+		return 1
+	}
+	return r
+}
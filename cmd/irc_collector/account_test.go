@@ -0,0 +1,58 @@
+package main
+
+import (
+	"slices"
+	"testing"
+
+	ircevents "github.com/Jamie-38/stream-pipeline/internal/irc_events"
+)
+
+func TestPolicySetMergesStrictly(t *testing.T) {
+	a := policyStub{
+		"chess":  {Kinds: []string{"privmsg", "usernotice"}, SampleRate: 0.5},
+		"poker":  {Kinds: []string{"privmsg"}},
+		"solo_a": {SampleRate: 0.2},
+	}
+	b := policyStub{
+		"chess": {Kinds: []string{"usernotice", "clearchat"}, SampleRate: 0.1, RedactText: true},
+		"poker": {Kinds: []string{"usernotice"}},
+	}
+
+	for _, order := range []policySet{{a, b}, {b, a}} {
+		p, ok := order.Policy("chess")
+		if !ok || !p.RedactText || p.SampleRate != 0.1 || !slices.Equal(p.Kinds, []string{"usernotice"}) {
+			t.Fatalf("chess = %+v, %v; want redacted usernotice at 0.1", p, ok)
+		}
+		if p, _ := order.Policy("poker"); p.Collects("privmsg") || p.Collects("usernotice") {
+			t.Fatalf("poker = %+v; accounts share no kind, want nothing collected", p)
+		}
+		if p, ok := order.Policy("solo_a"); !ok || p.SampleRate != 0.2 {
+			t.Fatalf("solo_a = %+v, %v", p, ok)
+		}
+		if _, ok := order.Policy("none"); ok {
+			t.Fatal("channel without a policy reported one")
+		}
+	}
+
+	// an account without a sample rate keeps everything; the other's rate wins
+	p, _ := policySet{policyStub{"x": {}}, policyStub{"x": {SampleRate: 0.3}}}.Policy("x")
+	if p.SampleRate != 0.3 {
+		t.Fatalf("sample rate = %v, want 0.3", p.SampleRate)
+	}
+}
+
+func TestClassifier_SharedChannelRedactedIfAnyAccountRedacts(t *testing.T) {
+	lax := policyStub{"shared": {}}
+	strict := policyStub{"shared": {RedactText: true}}
+	r := newRigWith("selfuser", ClassifyOptions{Policies: policySet{lax, strict}})
+	defer r.close()
+
+	r.in <- ":bob!bob@tmi PRIVMSG #shared :secret"
+	ev, ok := recvEvt(t, r.out)
+	if !ok {
+		t.Fatal("no event")
+	}
+	if pm := ev.(ircevents.PrivMsg); pm.Text != "" || !pm.Redacted {
+		t.Fatalf("shared channel event = %+v, want redacted", pm)
+	}
+}
@@ -2,169 +2,308 @@ package main
 
 import (
 	"context"
+	"log/slog"
+	"math/rand/v2"
 	"strings"
 
+	"github.com/Jamie-38/stream-pipeline/internal/healthcheck"
 	ircevents "github.com/Jamie-38/stream-pipeline/internal/irc_events"
 	"github.com/Jamie-38/stream-pipeline/internal/observe"
+	"github.com/Jamie-38/stream-pipeline/internal/tracing"
 	"github.com/Jamie-38/stream-pipeline/internal/types"
 )
 
-func ClassifyLine(ctx context.Context, readerCh <-chan string, parseCh chan<- ircevents.Event, membershipCh chan<- types.MembershipEvent, username string) {
+var (
+	linesParsed = observe.Metrics().Counter("irc_lines_parsed_total",
+		"IRC lines parsed, by command.", "command")
+	linesMalformed = observe.Metrics().Counter("irc_lines_malformed_total",
+		"IRC lines skipped as malformed, by reason.", "reason")
+)
+
+// ClassifyOptions carries optional collaborators of ClassifyLine.
+type ClassifyOptions struct {
+	// Policies filters and samples events per channel; nil keeps everything.
+	Policies types.PolicyLookup
+	// Chat receives USERSTATE, NOTICE, ROOMSTATE and our own JOIN and
+	// PART for the outbound sender; nil discards them.
+	Chat chan<- types.ChatEvent
+	// Health is the component to beat per line; nil means "classifier".
+	Health *healthcheck.Component
+	// Anonymous is set for justinfan logins. Their own JOIN echoes are not
+	// dependable, so a ROOMSTATE carrying the room's full state also
+	// confirms the JOIN.
+	Anonymous bool
+}
+
+func ClassifyLine(ctx context.Context, readerCh <-chan ircLine, parseCh chan<- ircevents.Event, membershipCh chan<- types.MembershipEvent, username string, opts ClassifyOptions) {
 	lg := observe.C("classifier")
+	tracer := tracing.Default()
+	c := &lineClassifier{lg: lg, parseCh: parseCh, membershipCh: membershipCh, username: username, opts: opts}
+	health := opts.Health
+	if health == nil {
+		health = healthcheck.Default().Component("classifier")
+	}
+	health.SetReady(true, "running")
 
 	for {
 		select {
 		case <-ctx.Done():
 			return
 
-		case line, ok := <-readerCh:
+		case in, ok := <-readerCh:
 			if !ok { // channel closed
 				lg.Info("reader channel closed")
 				return
 			}
-			i := 0
-
-			// TAGS
-			var tags string
-			var tagsMap map[string]string
-			if i < len(line) && line[i] == '@' {
-				j := strings.IndexByte(line[i:], ' ')
-				if j < 0 {
-					lg.Debug("skip malformed", "reason", "malformed tags")
-					continue
-				}
-				tags = line[i+1 : i+j] // drop '@'
-				tagsMap = parseTags(tags)
-				i += j + 1
-			} else {
-				tagsMap = map[string]string{}
+			sp := tracer.Child(in.Trace, "irc.classify", tracing.KindInternal)
+			stop := c.classify(ctx, in.Text, sp)
+			sp.End()
+			health.Beat()
+			if stop {
+				return
 			}
+		}
+	}
+}
 
-			// PREFIX
-			var prefix string
-			if i < len(line) && line[i] == ':' {
-				j := strings.IndexByte(line[i:], ' ')
-				if j < 0 {
-					lg.Debug("skip malformed", "reason", "malformed prefix")
-					continue
-				}
-				prefix = line[i+1 : i+j] // drop ':'
-				i += j + 1
-			}
+// lineClassifier holds ClassifyLine's collaborators so each line is handled
+// in one call with a single exit for its span.
+type lineClassifier struct {
+	lg           *slog.Logger
+	parseCh      chan<- ircevents.Event
+	membershipCh chan<- types.MembershipEvent
+	username     string
+	opts         ClassifyOptions
+}
+
+// classify handles one line. It reports true when ctx ended mid-send.
+func (c *lineClassifier) classify(ctx context.Context, line string, sp *tracing.Span) (stop bool) {
+	i := 0
+
+	// TAGS
+	var tags string
+	var tagsMap map[string]string
+	if i < len(line) && line[i] == '@' {
+		j := strings.IndexByte(line[i:], ' ')
+		if j < 0 {
+			c.lg.Debug("skip malformed", "reason", "malformed tags")
+			linesMalformed.With("malformed_tags").Inc()
+			return false
+		}
+		tags = line[i+1 : i+j] // drop '@'
+		tagsMap = parseTags(tags)
+		i += j + 1
+	} else {
+		tagsMap = map[string]string{}
+	}
+
+	// PREFIX
+	var prefix string
+	if i < len(line) && line[i] == ':' {
+		j := strings.IndexByte(line[i:], ' ')
+		if j < 0 {
+			c.lg.Debug("skip malformed", "reason", "malformed prefix")
+			linesMalformed.With("malformed_prefix").Inc()
+			return false
+		}
+		prefix = line[i+1 : i+j] // drop ':'
+		i += j + 1
+	}
 
-			// COMMAND
-			if i >= len(line) {
-				lg.Debug("skip malformed", "reason", "missing command")
+	// COMMAND
+	if i >= len(line) {
+		c.lg.Debug("skip malformed", "reason", "missing command")
+		linesMalformed.With("missing_command").Inc()
+		return false
+	}
+	var command string
+	if j := strings.IndexByte(line[i:], ' '); j < 0 {
+		command = line[i:]
+		i = len(line)
+	} else {
+		command = line[i : i+j]
+		i += j + 1
+	}
+
+	// PARAMS / TRAILING
+	var params []string
+	var trailing string
+	if i <= len(line) {
+		if k := strings.Index(line[i:], " :"); k >= 0 {
+			paramsPart := line[i : i+k]
+			trailing = line[i+k+2:] // everything to end (may contain spaces)
+			params = fieldsNoEmpty(paramsPart)
+		} else {
+			params = fieldsNoEmpty(line[i:])
+			trailing = ""
+		}
+	}
+
+	linesParsed.With(command).Inc()
+	sp.SetAttr("irc.command", command)
+	c.lg.Debug("parsed line",
+		"command", command,
+		"params_len", len(params),
+		"trailing_len", len(trailing),
+	)
+
+	// COMMAND HANDLERS
+	switch command {
+	case "PRIVMSG":
+		if len(params) == 0 || len(trailing) == 0 {
+			c.lg.Debug("skip malformed", "reason", "malformed PRIVMSG")
+			linesMalformed.With("malformed_privmsg").Inc()
+			return false
+		}
+
+		// From tags (authoritative when present)
+		userID := tagsMap["user-id"]    // stable numeric id (string of digits)
+		channelID := tagsMap["room-id"] // stable numeric id (string of digits)
+
+		// From prefix/params (logins)
+		userLogin := strings.ToLower(loginFromPrefix(prefix)) // mutable username/login
+		chanLogin := strings.TrimPrefix(strings.ToLower(params[0]), "#")
+
+		if channelID == "" && chanLogin == "" {
+			c.lg.Debug("drop PRIVMSG: no channel id or login")
+			return false
+		}
+
+		evt := ircevents.PrivMsg{
+			UserID:       userID,    // may be empty if tags missing
+			UserLogin:    userLogin, // may be empty if prefix absent
+			ChannelID:    channelID, // may be empty if tags missing
+			ChannelLogin: chanLogin, // fallback identity for channel
+			Text:         trailing,
+			Badges:       parseBadges(tagsMap["badges"]),
+			Trace:        sp.Context(),
+		}
+
+		keep, redact := collect(c.opts.Policies, chanLogin, evt.Kind())
+		if !keep {
+			return false
+		}
+		// redact here so the live tail and filter rules never see the text
+		var out ircevents.Event = evt
+		if redact {
+			out = evt.Redact()
+		}
+
+		select {
+		case c.parseCh <- out:
+		case <-ctx.Done():
+			return true
+		}
+
+	case "JOIN", "PART":
+		if len(params) == 0 {
+			c.lg.Debug("skip malformed", "reason", "missing channel")
+			linesMalformed.With("missing_channel").Inc()
+			return false
+		}
+
+		userLogin := strings.ToLower(loginFromPrefix(prefix))
+		if userLogin == "" {
+			// no prefix, can’t attribute, ignore
+			return false
+		}
+
+		// own JOIN/PART as membership confirmations
+		if userLogin != c.username {
+			return false
+		}
+
+		// a batched "JOIN #a,#b" may be echoed as one line; confirm each channel
+		for _, ch := range strings.Split(strings.ToLower(params[0]), ",") {
+			if ch == "" {
 				continue
 			}
-			var command string
-			if j := strings.IndexByte(line[i:], ' '); j < 0 {
-				command = line[i:]
-				i = len(line)
-			} else {
-				command = line[i : i+j]
-				i += j + 1
+			if !strings.HasPrefix(ch, "#") {
+				ch = "#" + ch
 			}
 
-			// PARAMS / TRAILING
-			var params []string
-			var trailing string
-			if i <= len(line) {
-				if k := strings.Index(line[i:], " :"); k >= 0 {
-					paramsPart := line[i : i+k]
-					trailing = line[i+k+2:] // everything to end (may contain spaces)
-					params = fieldsNoEmpty(paramsPart)
-				} else {
-					params = fieldsNoEmpty(line[i:])
-					trailing = ""
-				}
+			// emit membership signal
+			evt := types.MembershipEvent{
+				Op:      command, // "JOIN" or "PART"
+				Channel: ch,
 			}
+			select {
+			case c.membershipCh <- evt:
+			case <-ctx.Done():
+				return true
+			default:
+				// drop if full; rectifier will reconcile on next tick/timeout
+				c.lg.Debug("membership event dropped (full)", "channel", ch, "op", command)
+			}
+			if c.forwardChat(ctx, types.ChatEvent{Op: command, Channel: ch}) {
+				return true
+			}
+		}
 
-			lg.Debug("parsed line",
-				"command", command,
-				"params_len", len(params),
-				"trailing_len", len(trailing),
-			)
-
-			// COMMAND HANDLERS
-			switch command {
-			case "PRIVMSG":
-				if len(params) == 0 || len(trailing) == 0 {
-					lg.Debug("skip malformed", "reason", "malformed PRIVMSG")
-					continue
-				}
-
-				// From tags (authoritative when present)
-				userID := tagsMap["user-id"]    // stable numeric id (string of digits)
-				channelID := tagsMap["room-id"] // stable numeric id (string of digits)
-
-				// From prefix/params (logins)
-				userLogin := strings.ToLower(loginFromPrefix(prefix)) // mutable username/login
-				chanLogin := strings.TrimPrefix(strings.ToLower(params[0]), "#")
-
-				if channelID == "" && chanLogin == "" {
-					lg.Debug("drop PRIVMSG: no channel id or login")
-					continue
-				}
-
-				evt := ircevents.PrivMsg{
-					UserID:       userID,    // may be empty if tags missing
-					UserLogin:    userLogin, // may be empty if prefix absent
-					ChannelID:    channelID, // may be empty if tags missing
-					ChannelLogin: chanLogin, // fallback identity for channel
-					Text:         trailing,
-				}
-
-				// debug print
-				// fmt.Println(userID, trailing)
-
-				select {
-				case parseCh <- evt:
-				case <-ctx.Done():
-					return
-				}
-
-			case "JOIN", "PART":
-				if len(params) == 0 {
-					lg.Debug("skip malformed", "reason", "missing channel")
-					continue
-				}
-
-				userLogin := strings.ToLower(loginFromPrefix(prefix))
-				if userLogin == "" {
-					// no prefix, can’t attribute, ignore
-					continue
-				}
-
-				// own JOIN/PART as membership confirmations
-				if userLogin != username {
-					continue
-				}
-
-				ch := strings.ToLower(params[0])
-				if !strings.HasPrefix(ch, "#") {
-					ch = "#" + ch
-				}
-
-				// emit membership signal
-				evt := types.MembershipEvent{
-					Op:      command, // "JOIN" or "PART"
-					Channel: ch,
-				}
-				select {
-				case membershipCh <- evt:
-				case <-ctx.Done():
-					return
-				default:
-					// drop if full; rectifier will reconcile on next tick/timeout
-					lg.Debug("membership event dropped (full)", "channel", ch, "op", command)
-				}
-
+	case "USERSTATE", "NOTICE", "ROOMSTATE":
+		if len(params) == 0 {
+			return false
+		}
+		// the ROOMSTATE sent on join carries every room setting; later
+		// ones only carry what changed
+		if command == "ROOMSTATE" && c.opts.Anonymous && tagsMap["room-id"] != "" && hasKey(tagsMap, "slow") && hasKey(tagsMap, "subs-only") {
+			evt := types.MembershipEvent{Op: "JOIN", Channel: strings.ToLower(params[0])}
+			select {
+			case c.membershipCh <- evt:
+			case <-ctx.Done():
+				return true
 			default:
-				// USERNOTICE, ROOMSTATE, numerics, etc
+				c.lg.Debug("membership event dropped (full)", "channel", evt.Channel, "op", "JOIN")
 			}
 		}
+		return c.forwardChat(ctx, types.ChatEvent{
+			Op:      command,
+			Channel: strings.ToLower(params[0]),
+			Tags:    tagsMap,
+			Text:    trailing,
+		})
+
+	default:
+		// USERNOTICE, numerics, etc
 	}
+	return false
+}
+
+// forwardChat hands evt to the outbound sender, if any. It reports whether
+// ctx ended.
+func (c *lineClassifier) forwardChat(ctx context.Context, evt types.ChatEvent) (stop bool) {
+	if c.opts.Chat == nil {
+		return false
+	}
+	select {
+	case c.opts.Chat <- evt:
+	case <-ctx.Done():
+		return true
+	default:
+		// a lost confirmation surfaces as "unconfirmed" on the sender side
+		c.lg.Debug("chat event dropped (full)", "channel", evt.Channel, "op", evt.Op)
+	}
+	return false
+}
+
+// collect applies the channel's policy: kinds it does not collect are
+// dropped and the rest are sampled at the configured rate. redact reports
+// whether the kept event's text must be stripped.
+func collect(policies types.PolicyLookup, chanLogin, kind string) (keep, redact bool) {
+	if policies == nil {
+		return true, false
+	}
+	p, ok := policies.Policy(chanLogin)
+	if !ok {
+		return true, false
+	}
+	if !p.Collects(kind) {
+		return false, false
+	}
+	if p.SampleRate > 0 && p.SampleRate < 1 && rand.Float64() >= p.SampleRate {
+		return false, false
+	}
+	return true, p.RedactText
 }
 
 func fieldsNoEmpty(s string) []string {
@@ -206,6 +345,21 @@ func parseTags(tagsStr string) map[string]string {
 	return tags
 }
 
+// parseBadges reads a badges tag such as "moderator/1,subscriber/12" into
+// name -> version. An empty tag yields nil.
+func parseBadges(tag string) map[string]string {
+	if tag == "" {
+		return nil
+	}
+	badges := make(map[string]string, strings.Count(tag, ",")+1)
+	for _, b := range strings.Split(tag, ",") {
+		if name, version, _ := strings.Cut(b, "/"); name != "" {
+			badges[name] = version
+		}
+	}
+	return badges
+}
+
 // IRCv3 tag value escapes: \s (space), \: (:), \; (;), \\ (\), \r, \n
 func unescapeIRCv3(s string) string {
 	// nothing to unescape
@@ -241,3 +395,8 @@ func unescapeIRCv3(s string) string {
 	}
 	return b.String()
 }
+
+func hasKey(m map[string]string, k string) bool {
+	_, ok := m[k]
+	return ok
+}
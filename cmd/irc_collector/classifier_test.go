@@ -6,6 +6,7 @@ import (
 	"time"
 
 	ircevents "github.com/Jamie-38/stream-pipeline/internal/irc_events"
+	"github.com/Jamie-38/stream-pipeline/internal/livetail"
 	"github.com/Jamie-38/stream-pipeline/internal/types"
 )
 
@@ -18,6 +19,10 @@ type clsRig struct {
 }
 
 func newRig(self string) *clsRig {
+	return newRigWith(self, ClassifyOptions{})
+}
+
+func newRigWith(self string, opts ClassifyOptions) *clsRig {
 	ctx, cancel := context.WithCancel(context.Background())
 	r := &clsRig{
 		ctx:    ctx,
@@ -26,7 +31,18 @@ func newRig(self string) *clsRig {
 		out:    make(chan ircevents.Event, 8),
 		memb:   make(chan types.MembershipEvent, 8),
 	}
-	go ClassifyLine(ctx, r.in, r.out, r.memb, self)
+	lines := make(chan ircLine)
+	go func() {
+		defer close(lines)
+		for l := range r.in {
+			select {
+			case lines <- ircLine{Text: l}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	go ClassifyLine(ctx, lines, r.out, r.memb, self, opts)
 	return r
 }
 
@@ -48,7 +64,7 @@ func TestClassifier_PrivMsg_Full(t *testing.T) {
 	r := newRig("selfuser")
 	defer r.close()
 
-	line := "@user-id=123;room-id=999;color=\\:blue\\;;badges=subscriber/3 :bob!bob@bob.tmi.twitch.tv PRIVMSG #chess :hello\\sworld!"
+	line := "@user-id=123;room-id=999;color=\\:blue\\;;badges=subscriber/3,premium/1 :bob!bob@bob.tmi.twitch.tv PRIVMSG #chess :hello\\sworld!"
 	r.in <- line
 
 	ev, ok := recvEvt(t, r.out)
@@ -69,6 +85,9 @@ func TestClassifier_PrivMsg_Full(t *testing.T) {
 	if pm.Text != "hello\\sworld!" {
 		t.Fatalf("unexpected text (trailing is not IRCv3-escaped): %q", pm.Text)
 	}
+	if len(pm.Badges) != 2 || pm.Badges["subscriber"] != "3" || pm.Badges["premium"] != "1" {
+		t.Fatalf("badges = %v", pm.Badges)
+	}
 }
 
 func TestClassifier_PrivMsg_NoTags_Fallback(t *testing.T) {
@@ -138,6 +157,22 @@ func TestClassifier_Membership_SelfOnly(t *testing.T) {
 	}
 }
 
+func TestClassifier_Membership_SplitsBatchedChannels(t *testing.T) {
+	r := newRig("me")
+	defer r.close()
+
+	r.in <- ":me!me@tmi.twitch.tv JOIN #a,#B,c"
+	for _, want := range []string{"#a", "#b", "#c"} {
+		ev, ok := recvEvt(t, r.memb)
+		if !ok {
+			t.Fatalf("missing membership for %s", want)
+		}
+		if ev.Op != "JOIN" || ev.Channel != want {
+			t.Fatalf("got %+v, want JOIN %s", ev, want)
+		}
+	}
+}
+
 func TestParseTagsAndUnescape(t *testing.T) {
 	m := parseTags("a=1;b=hello\\sworld;c=\\:;flagonly")
 	if m["a"] != "1" {
@@ -176,3 +211,118 @@ func TestLoginFromPrefix(t *testing.T) {
 		}
 	}
 }
+
+type policyStub map[string]types.ChannelPolicy
+
+func (p policyStub) Policy(ch string) (types.ChannelPolicy, bool) {
+	v, ok := p[ch]
+	return v, ok
+}
+
+func TestClassifier_AppliesChannelPolicy(t *testing.T) {
+	r := newRigWith("selfuser", ClassifyOptions{Policies: policyStub{
+		"quiet":   {Kinds: []string{"usernotice"}},
+		"sampled": {SampleRate: 0.0000001},
+		"kept":    {Kinds: []string{"privmsg"}, SampleRate: 1},
+	}})
+	defer r.close()
+
+	r.in <- ":bob!bob@tmi PRIVMSG #quiet :hi"
+	r.in <- ":bob!bob@tmi PRIVMSG #sampled :hi"
+	r.in <- ":bob!bob@tmi PRIVMSG #kept :hi"
+	r.in <- ":bob!bob@tmi PRIVMSG #nopolicy :hi"
+
+	var got []string
+	for {
+		ev, ok := recvEvt(t, r.out)
+		if !ok {
+			break
+		}
+		got = append(got, ev.(ircevents.PrivMsg).ChannelLogin)
+	}
+	if len(got) != 2 || got[0] != "kept" || got[1] != "nopolicy" {
+		t.Fatalf("collected %v, want [kept nopolicy]", got)
+	}
+}
+
+func TestClassifier_RedactedTextNeverReachesLiveTail(t *testing.T) {
+	r := newRigWith("selfuser", ClassifyOptions{Policies: policyStub{"secret": {RedactText: true}}})
+	defer r.close()
+
+	hub := livetail.NewHub()
+	sub := hub.Subscribe(livetail.Filter{}, 4)
+	defer sub.Close()
+	next := make(chan ircevents.Event, 4)
+	go hub.Tee(r.ctx, r.out, next)
+
+	r.in <- ":bob!bob@tmi PRIVMSG #secret :my password is hunter2"
+	r.in <- ":bob!bob@tmi PRIVMSG #open :visible"
+
+	for _, want := range []string{"", "visible"} {
+		ev, ok := recvEvt(t, sub.C)
+		if !ok {
+			t.Fatal("subscriber got no event")
+		}
+		pm := ev.(ircevents.PrivMsg)
+		if pm.Text != want || pm.Redacted != (want == "") {
+			t.Fatalf("subscriber saw %+v, want text %q", pm, want)
+		}
+		if ev, _ := recvEvt(t, next); ev.(ircevents.PrivMsg).Text != want {
+			t.Fatalf("downstream saw %+v, want text %q", ev, want)
+		}
+	}
+}
+
+func TestClassifier_ForwardsChatState(t *testing.T) {
+	chat := make(chan types.ChatEvent, 4)
+	r := newRigWith("selfuser", ClassifyOptions{Chat: chat})
+	defer r.close()
+
+	r.in <- ":selfuser!selfuser@selfuser.tmi.twitch.tv JOIN #chess"
+	r.in <- "@badges=moderator/1;mod=1 :tmi.twitch.tv USERSTATE #Chess"
+	r.in <- "@msg-id=msg_duplicate :tmi.twitch.tv NOTICE #chess :Your message is identical to the previous one."
+	r.in <- "@room-id=1;slow=30 :tmi.twitch.tv ROOMSTATE #chess"
+
+	want := []string{"JOIN", "USERSTATE", "NOTICE", "ROOMSTATE"}
+	for _, op := range want {
+		ev, ok := recvEvt(t, chat)
+		if !ok {
+			t.Fatalf("no %s forwarded", op)
+		}
+		if ev.Op != op || ev.Channel != "#chess" {
+			t.Fatalf("got %+v, want %s #chess", ev, op)
+		}
+	}
+}
+
+func TestClassifier_AnonymousRoomstateConfirmsJoin(t *testing.T) {
+	r := newRigWith("justinfan12345", ClassifyOptions{Anonymous: true})
+	defer r.close()
+
+	// a settings change carries only the changed tag: not a join
+	r.in <- "@room-id=1;slow=10 :tmi.twitch.tv ROOMSTATE #chess"
+	if ev, ok := recvEvt(t, r.memb); ok {
+		t.Fatalf("partial ROOMSTATE confirmed a join: %+v", ev)
+	}
+
+	r.in <- "@emote-only=0;followers-only=-1;r9k=0;room-id=1;slow=0;subs-only=0 :tmi.twitch.tv ROOMSTATE #Chess"
+	ev, ok := recvEvt(t, r.memb)
+	if !ok || ev.Op != "JOIN" || ev.Channel != "#chess" {
+		t.Fatalf("membership = %+v, %v; want JOIN #chess", ev, ok)
+	}
+
+	r.in <- ":justinfan12345!justinfan12345@justinfan12345.tmi.twitch.tv PART #chess"
+	if ev, ok := recvEvt(t, r.memb); !ok || ev.Op != "PART" {
+		t.Fatalf("own PART echo = %+v, %v", ev, ok)
+	}
+}
+
+func TestClassifier_RoomstateIgnoredWhenAuthenticated(t *testing.T) {
+	r := newRig("bot")
+	defer r.close()
+
+	r.in <- "@emote-only=0;followers-only=-1;r9k=0;room-id=1;slow=0;subs-only=0 :tmi.twitch.tv ROOMSTATE #chess"
+	if ev, ok := recvEvt(t, r.memb); ok {
+		t.Fatalf("ROOMSTATE confirmed a join for an authenticated login: %+v", ev)
+	}
+}
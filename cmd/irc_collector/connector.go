@@ -3,11 +3,16 @@ package main
 import (
 	"context"
 	"fmt"
+	"math/rand/v2"
+	"sync/atomic"
 
 	"github.com/Jamie-38/stream-pipeline/internal/observe"
 	"github.com/gorilla/websocket"
 )
 
+var reconnects = observe.Metrics().Counter("irc_websocket_reconnects_total",
+	"Successful IRC websocket connections after an account's first.", "account")
+
 func TwitchWebsocket(ctx context.Context, token, username, uri string) (*websocket.Conn, error) {
 	lg := observe.C("connector").With("user", username, "uri", uri)
 
@@ -22,12 +27,14 @@ func TwitchWebsocket(ctx context.Context, token, username, uri string) (*websock
 		return conn.WriteMessage(websocket.TextMessage, []byte(s))
 	}
 
-	// 1) Auth
-	if err := write(fmt.Sprintf("PASS oauth:%s\r\n", token)); err != nil {
-		conn.Close()
-		return nil, fmt.Errorf("pass: %w", err)
+	// 1) Auth; anonymous logins send no PASS
+	if token != "" {
+		if err := write(fmt.Sprintf("PASS oauth:%s\r\n", token)); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("pass: %w", err)
+		}
+		lg.Debug("sent PASS")
 	}
-	lg.Debug("sent PASS")
 
 	if err := write(fmt.Sprintf("NICK %s\r\n", username)); err != nil {
 		conn.Close()
@@ -44,3 +51,39 @@ func TwitchWebsocket(ctx context.Context, token, username, uri string) (*websock
 
 	return conn, nil
 }
+
+// CredentialSource supplies the access token for each new connection.
+type CredentialSource interface {
+	AccessToken() string
+}
+
+// connManager dials Twitch with whatever credentials are current, so a
+// refreshed token is picked up by the next reconnect. Without creds it logs
+// in anonymously.
+type connManager struct {
+	creds   CredentialSource // nil for anonymous
+	account string           // metrics label
+	nick    string
+	uri     string
+	dialed  atomic.Bool // a connection succeeded before
+}
+
+func (cm *connManager) Dial(ctx context.Context) (*websocket.Conn, error) {
+	token := ""
+	if cm.creds != nil {
+		token = cm.creds.AccessToken()
+	}
+	conn, err := TwitchWebsocket(ctx, token, cm.nick, cm.uri)
+	if err != nil {
+		return nil, err
+	}
+	if cm.dialed.Swap(true) {
+		reconnects.With(cm.account).Inc()
+	}
+	return conn, nil
+}
+
+// anonymousNick is a read-only login Twitch accepts without a token.
+func anonymousNick() string {
+	return fmt.Sprintf("justinfan%d", 10000+rand.IntN(90000))
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/Jamie-38/stream-pipeline/internal/healthcheck"
+	"github.com/Jamie-38/stream-pipeline/internal/types"
+)
+
+type tokenStub struct{ tok atomic.Value }
+
+func (s *tokenStub) AccessToken() string { return s.tok.Load().(string) }
+
+// fakeTMI accepts websocket logins, reports each PASS line and drops the
+// first connection right after login.
+func fakeTMI(t *testing.T, passes chan<- string) string {
+	t.Helper()
+	var conns atomic.Int32
+	up := websocket.Upgrader{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := up.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		n := conns.Add(1)
+		for {
+			_, b, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			line := strings.TrimSpace(string(b))
+			if strings.HasPrefix(line, "PASS ") {
+				passes <- line
+			}
+			if strings.HasPrefix(line, "CAP REQ") {
+				if n == 1 {
+					return // drop the first connection
+				}
+				_ = conn.WriteMessage(websocket.TextMessage, []byte(":bob!bob@tmi PRIVMSG #chess :hi\r\n"))
+			}
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return "ws" + strings.TrimPrefix(srv.URL, "http")
+}
+
+func TestRunConnectionRedialsWithCurrentToken(t *testing.T) {
+	passes := make(chan string, 4)
+	creds := &tokenStub{}
+	creds.tok.Store("old")
+
+	p := &accountPipeline{
+		acct:         types.Account{Nick: "bot"},
+		conns:        &connManager{creds: creds, account: "bot", nick: "bot", uri: fakeTMI(t, passes)},
+		membershipCh: make(chan types.MembershipEvent, 4),
+		writerCh:     make(chan string, 4),
+		readerCh:     make(chan ircLine, 4),
+		wsHealth:     healthcheck.NewRegistry().Register("irc_websocket", healthcheck.Options{}),
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var err error
+	if p.conn, err = p.conns.Dial(ctx); err != nil {
+		t.Fatal(err)
+	}
+	creds.tok.Store("refreshed")
+
+	done := make(chan error, 1)
+	go func() { done <- p.runConnection(ctx) }()
+
+	if pass := <-passes; pass != "PASS oauth:old" {
+		t.Fatalf("first login = %q", pass)
+	}
+	select {
+	case evt := <-p.membershipCh:
+		if evt.Op != "DISCONNECT" {
+			t.Fatalf("membership event = %+v, want DISCONNECT", evt)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("rectifier not told about the lost connection")
+	}
+	select {
+	case pass := <-passes:
+		if pass != "PASS oauth:refreshed" {
+			t.Fatalf("reconnect login = %q, want the refreshed token", pass)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("no reconnect")
+	}
+	select {
+	case line := <-p.readerCh:
+		if !strings.Contains(line.Text, "PRIVMSG #chess") {
+			t.Fatalf("read %q", line.Text)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("reader not restarted on the new connection")
+	}
+
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Fatalf("runConnection = %v, want context.Canceled", err)
+	}
+	if n := reconnects.With("bot").Value(); n != 1 {
+		t.Fatalf("reconnects{account=bot} = %v, want 1", n)
+	}
+}
+
+func TestFirstDialPerAccountIsNotAReconnect(t *testing.T) {
+	uri := fakeTMI(t, make(chan string, 4))
+	for _, name := range []string{"first_a", "first_b"} {
+		cm := &connManager{account: name, nick: "justinfan12345", uri: uri}
+		conn, err := cm.Dial(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.Close()
+		if n := reconnects.With(name).Value(); n != 0 {
+			t.Fatalf("reconnects{account=%s} = %v after its first dial", name, n)
+		}
+	}
+}
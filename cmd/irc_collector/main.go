@@ -2,21 +2,26 @@ package main
 
 import (
 	"context"
+	"errors"
+	"flag"
 	"os"
 	"os/signal"
-	"strings"
 	"syscall"
+	"time"
 
 	"golang.org/x/sync/errgroup"
 
-	channelrecord "github.com/Jamie-38/stream-pipeline/internal/channel_record"
+	"github.com/Jamie-38/stream-pipeline/internal/apiauth"
 	"github.com/Jamie-38/stream-pipeline/internal/config"
+	"github.com/Jamie-38/stream-pipeline/internal/filter"
+	"github.com/Jamie-38/stream-pipeline/internal/healthcheck"
 	"github.com/Jamie-38/stream-pipeline/internal/httpapi"
 	ircevents "github.com/Jamie-38/stream-pipeline/internal/irc_events"
 	kstream "github.com/Jamie-38/stream-pipeline/internal/kafka"
-	"github.com/Jamie-38/stream-pipeline/internal/oauth"
+	"github.com/Jamie-38/stream-pipeline/internal/livetail"
 	"github.com/Jamie-38/stream-pipeline/internal/observe"
-	"github.com/Jamie-38/stream-pipeline/internal/scheduler"
+	"github.com/Jamie-38/stream-pipeline/internal/ratelimit"
+	"github.com/Jamie-38/stream-pipeline/internal/tracing"
 	"github.com/Jamie-38/stream-pipeline/internal/types"
 )
 
@@ -27,16 +32,43 @@ func main() {
 		lg.Warn("env file not loaded", "err", err)
 	}
 
-	account, err := config.LoadAccount(os.Getenv("ACCOUNTS_PATH"))
-	if err != nil {
-		lg.Error("load account", "err", err, "path", os.Getenv("ACCOUNTS_PATH"))
+	// defaults < config file < environment < flags, validated as a whole
+	cfg, flags, err := config.Load("irc_collector", os.Args[1:])
+	var invalid *config.ValidationError
+	switch {
+	case errors.Is(err, flag.ErrHelp):
+		return
+	case err != nil && !errors.As(err, &invalid):
+		lg.Error("load configuration", "err", err)
 		os.Exit(1)
 	}
-	selfLogin := strings.ToLower(account.User)
+	if flags.PrintConfig {
+		if err := cfg.Redacted().Write(os.Stdout); err != nil {
+			lg.Error("print configuration", "err", err)
+			os.Exit(1)
+		}
+	}
+	if invalid != nil {
+		lg.Error("invalid configuration", "file", flags.File, "problems", invalid.Problems)
+		os.Exit(1)
+	}
+	if flags.PrintConfig {
+		return
+	}
+	if cfg.Log.Level != "" {
+		_ = observe.SetLevels(cfg.Log.Level) // checked by Validate
+	}
 
-	token, err := oauth.LoadTokenJSON(os.Getenv("TOKENS_PATH"))
-	if err != nil {
-		lg.Error("load token", "err", err, "path", os.Getenv("TOKENS_PATH"))
+	// anonymous without an accounts file reads public chat as justinfan
+	var accounts []types.Account
+	if cfg.Accounts.Path == "" && cfg.Accounts.Anonymous {
+		accounts = []types.Account{config.AnonymousAccount()}
+	} else if accounts, err = config.LoadAccounts(cfg.Accounts.Path); err != nil {
+		lg.Error("load accounts", "err", err, "path", cfg.Accounts.Path)
+		os.Exit(1)
+	}
+	if err := config.ResolveAccountPaths(accounts, cfg.Accounts.TokensPath, cfg.Accounts.ChannelsPath); err != nil {
+		lg.Error("resolve account paths", "err", err)
 		os.Exit(1)
 	}
 
@@ -47,72 +79,161 @@ func main() {
 	// pipeline context derives from root
 	g, ctx := errgroup.WithContext(root)
 
-	// pipeline channels
-	controlCh := make(chan types.IRCCommand, 100)
-	rectifierOutCh := make(chan types.IRCCommand, 100)
-	membershipCh := make(chan types.MembershipEvent, 100)
-	writerCh := make(chan string, 100)
-	readerCh := make(chan string, 1000)
-	parseCh := make(chan ircevents.Event, 1000)
+	// shared pipeline channels; each account owns the ones up to parseCh
+	parseCh := make(chan ircevents.Event, cfg.Buffers.Parse)
+	filterCh := make(chan ircevents.Event, cfg.Buffers.Filter)
+	kafkaCh := make(chan ircevents.Event, cfg.Buffers.Kafka)
 
-	// connect (fail fast before goroutines)
-	lg.Info("starting", "nick", account.Nick)
+	// queue depths sampled at scrape time
+	depth := func(name string, fn func() int) {
+		observe.Metrics().GaugeFunc("pipeline_channel_depth", "Buffered items waiting in a pipeline channel.",
+			func() float64 { return float64(fn()) }, "account", "", "channel", name)
+	}
+	depth("parseCh", func() int { return len(parseCh) })
+	depth("filterCh", func() int { return len(filterCh) })
+	depth("kafkaCh", func() int { return len(kafkaCh) })
+
+	// readiness and liveness rules per component
+	health := healthcheck.Default()
+	health.SetOptional(cfg.Health.Optional...)
+	health.Register("kafka", healthcheck.Options{StallAfter: cfg.Health.StallAfter, Pending: func() int { return len(kafkaCh) }})
 
-	conn, err := TwitchWebsocket(ctx, token.AccessToken, account.Nick, os.Getenv("TWITCH_IRC_URI"))
+	guard, err := apiauth.NewGuardFromFiles(cfg.HTTP.AuthFile, cfg.HTTP.AuditLog)
 	if err != nil {
-		lg.Error("websocket connect failed", "err", err, "uri", os.Getenv("TWITCH_IRC_URI"))
+		lg.Error("init api auth", "err", err)
 		os.Exit(1)
 	}
-	defer conn.Close()
+	defer guard.Close()
 
-	lg.Info("connected", "uri", os.Getenv("TWITCH_IRC_URI"))
+	// Twitch rate-limit profile shared by JOINs and outgoing messages
+	profiles, err := ratelimit.LoadProfiles(cfg.RateLimit.ProfilesPath)
+	if err != nil {
+		lg.Error("load rate-limit profiles", "err", err)
+		os.Exit(1)
+	}
+	profile, ok := profiles[cfg.RateLimit.Profile]
+	if !ok {
+		lg.Error("unknown rate-limit profile", "profile", cfg.RateLimit.Profile)
+		os.Exit(1)
+	}
+	lg.Info("rate-limit profile", "profile", profile.Name, "join_limit", profile.JoinLimit,
+		"msg_limit", profile.MsgLimit, "mod_msg_limit", profile.ModMsgLimit)
+
+	// one token, connection and channel set per account; all connect
+	// before any stage runs. A channel several accounts hold is collected
+	// once per account, under the strictest of their policies (policySet).
+	pipelines := make([]*accountPipeline, 0, len(accounts))
+	policies := make(policySet, 0, len(accounts))
+	for _, acct := range accounts {
+		p, err := newAccountPipeline(root, acct, len(accounts) > 1, cfg, profile)
+		if err != nil {
+			lg.Error("account setup failed", "account", acct.Name(), "err", err)
+			os.Exit(1)
+		}
+		pipelines = append(pipelines, p)
+		policies = append(policies, p.ctl)
+	}
 
-	// Build JSON controller (single writer), consuming HTTP intents from controlCh.
-	ctl, err := channelrecord.NewController(os.Getenv("CHANNELS_PATH"), account.Nick, controlCh)
+	// drop, sample, route and tag rules between the classifier and Kafka
+	rules, err := filter.Load(cfg.Filter.RulesPath)
 	if err != nil {
-		observe.C("channelrecord").Error("init controller", "err", err, "path", os.Getenv("CHANNELS_PATH"))
+		lg.Error("load filter rules", "err", err)
 		os.Exit(1)
 	}
+	filters := filter.NewEngine(rules)
+
+	// per-line tracing; off unless tracing.sample_ratio is set
+	tracer, err := tracing.FromSettings("irc_collector", tracing.Settings{
+		SampleRatio:  cfg.Tracing.SampleRatio,
+		Exporters:    cfg.Tracing.Exporters,
+		File:         cfg.Tracing.File,
+		OTLPEndpoint: cfg.Tracing.OTLPEndpoint,
+	})
+	if err != nil {
+		lg.Error("init tracing", "err", err)
+		os.Exit(1)
+	}
+	tracing.SetDefault(tracer)
+
+	// live tail fan-out for debugging; never backpressures the Kafka path
+	hub := livetail.NewHub()
 
 	// kafka writer (lifecycle tied to main)
-	w := kstream.NewWriter(os.Getenv("KAFKA_BROKERS"), os.Getenv("KAFKA_TOPIC"))
+	w := kstream.NewWriter(cfg.Kafka.Brokers)
 	defer w.Close()
+	topic := kstream.NewTopic(cfg.Kafka.Topic)
 
-	// all stages run under errgroup
+	// SIGHUP re-reads the configuration and applies the live settings
+	reload := newReloader("irc_collector", os.Args[1:], cfg, pipelines, topic, filters)
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+	g.Go(func() error {
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-hup:
+				_, _ = reload.Reload("SIGHUP") // outcome logged and kept for GET /v1/config
+			}
+		}
+	})
 
-	// Channels controller
-	g.Go(func() error { return ctl.Run(ctx) })
+	// all stages run under errgroup
+	for _, p := range pipelines {
+		p.start(ctx, g, parseCh, policies, cfg)
+	}
 
-	// HTTP control plane
-	g.Go(func() error { return httpapi.Run(ctx, controlCh) })
+	// Span export
+	if tracer != nil {
+		g.Go(func() error { return tracer.Run(ctx) })
+	}
 
-	// Channel rectifier
-	cfg := channelrecord.NewDefaultConfig()
-	g.Go(func() error {
-		return channelrecord.Run(ctx, ctl, membershipCh, rectifierOutCh, cfg)
-	})
+	// HTTP control plane; unscoped routes act on the first account
+	first := pipelines[0]
+	apiOpts := []httpapi.Option{
+		httpapi.WithGuard(guard),
+		httpapi.WithServer(httpapi.ServerConfig{
+			Host:              cfg.HTTP.Host,
+			Port:              cfg.HTTP.Port,
+			TLSCert:           cfg.HTTP.TLSCert,
+			TLSKey:            cfg.HTTP.TLSKey,
+			ClientCA:          cfg.HTTP.ClientCA,
+			RequireClientCert: cfg.HTTP.RequireClientCert,
+			ReadHeaderTimeout: cfg.HTTP.ReadHeaderTimeout,
+			ReadTimeout:       cfg.HTTP.ReadTimeout,
+			WriteTimeout:      cfg.HTTP.WriteTimeout,
+			IdleTimeout:       cfg.HTTP.IdleTimeout,
+			ShutdownTimeout:   cfg.HTTP.ShutdownTimeout,
+		}),
+		httpapi.WithConfig(reload),
+		httpapi.WithHub(hub),
+		httpapi.WithHistory(first.ctl),
+		httpapi.WithCatalog(first.ctl),
+		httpapi.WithSender(first.sender),
+		httpapi.WithStatus("rate_limits", func() any { return first.limits.Status(time.Now()) }),
+		httpapi.WithStatus("filter", filters.Status),
+	}
+	for _, p := range pipelines {
+		apiOpts = append(apiOpts, httpapi.WithAccount(p.acct.Name(), p.api()))
+	}
+	g.Go(func() error { return httpapi.Run(ctx, first.controlCh, apiOpts...) })
 
-	// IRC control scheduler (JOIN/PART -> writerCh)
+	// Live tail: parseCh -> subscribers, filterCh
 	g.Go(func() error {
-		scheduler.Control_scheduler(ctx, rectifierOutCh, writerCh)
+		hub.Tee(ctx, parseCh, filterCh)
 		return nil
 	})
 
-	// IRC socket reader -> readerCh (and PING signals -> writerCh)
-	g.Go(func() error { return StartReader(ctx, conn, writerCh, readerCh) })
-
-	// Single writer to the socket
-	g.Go(func() error { return IRCWriter(ctx, conn, writerCh) })
-
-	// Parser: readerCh -> parseCh
+	// Filter rules: filterCh -> kafkaCh
 	g.Go(func() error {
-		ClassifyLine(ctx, readerCh, parseCh, membershipCh, selfLogin)
+		filter.Run(ctx, filterCh, kafkaCh, filters)
 		return nil
 	})
 
-	// Kafka producer: parseCh -> Kafka
+	// Kafka producer: kafkaCh -> Kafka
 	g.Go(func() error {
-		kstream.KafkaProducer(ctx, w, parseCh)
+		kstream.KafkaProducer(ctx, w, kafkaCh, policies, topic)
 		return nil
 	})
 
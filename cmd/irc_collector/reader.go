@@ -6,11 +6,23 @@ import (
 
 	"github.com/gorilla/websocket"
 
+	"github.com/Jamie-38/stream-pipeline/internal/healthcheck"
 	"github.com/Jamie-38/stream-pipeline/internal/observe"
+	"github.com/Jamie-38/stream-pipeline/internal/tracing"
 )
 
-func StartReader(ctx context.Context, conn *websocket.Conn, writerCh chan<- string, readCh chan<- string) error {
+var linesRead = observe.Metrics().Counter("irc_lines_read_total",
+	"IRC lines read from the socket, including PINGs.")
+
+// ircLine is one raw IRC line with the trace it was sampled into, if any.
+type ircLine struct {
+	Text  string
+	Trace tracing.SpanContext
+}
+
+func StartReader(ctx context.Context, conn *websocket.Conn, writerCh chan<- string, readCh chan<- ircLine, health *healthcheck.Component) error {
 	lg := observe.C("reader")
+	tracer := tracing.Default()
 
 	for {
 		_, payload, err := conn.ReadMessage()
@@ -20,6 +32,7 @@ func StartReader(ctx context.Context, conn *websocket.Conn, writerCh chan<- stri
 				return ctx.Err()
 			}
 			lg.Warn("socket read failed", "err", err)
+			health.SetReady(false, "read failed: "+err.Error())
 			return err
 		}
 
@@ -27,17 +40,25 @@ func StartReader(ctx context.Context, conn *websocket.Conn, writerCh chan<- stri
 			if line == "" {
 				continue
 			}
+			linesRead.With().Inc()
 			if strings.HasPrefix(line, "PING") {
 				select {
 				case writerCh <- "PONG :tmi.twitch.tv\r\n":
+					health.Beat()
 				case <-ctx.Done():
 					return ctx.Err()
 				}
 				continue
 			}
+
+			// sampled per line; the span covers the wait for room in readCh
+			sp := tracer.Root("irc.read", tracing.KindInternal)
+			sp.SetAttr("irc.line_bytes", len(line))
 			select {
-			case readCh <- line:
+			case readCh <- ircLine{Text: line, Trace: sp.Context()}:
+				sp.End()
 			case <-ctx.Done():
+				sp.End()
 				return ctx.Err()
 			}
 		}
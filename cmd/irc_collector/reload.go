@@ -0,0 +1,151 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/Jamie-38/stream-pipeline/internal/config"
+	"github.com/Jamie-38/stream-pipeline/internal/filter"
+	kstream "github.com/Jamie-38/stream-pipeline/internal/kafka"
+	"github.com/Jamie-38/stream-pipeline/internal/observe"
+	"github.com/Jamie-38/stream-pipeline/internal/ratelimit"
+)
+
+// reloader re-reads the configuration on SIGHUP or POST /v1/config/reload
+// and applies the settings tagged reload:"live" to the running pipelines.
+// Everything else is reported as needing a restart and keeps its running
+// value. A configuration that does not load or validate changes nothing.
+type reloader struct {
+	name      string
+	args      []string
+	pipelines []*accountPipeline
+	topic     *kstream.Topic
+	filters   *filter.Engine
+	lg        *slog.Logger
+
+	mu      sync.Mutex
+	running config.Config
+	last    *reloadResult
+}
+
+type reloadResult struct {
+	At              time.Time       `json:"at"`
+	By              string          `json:"by"`
+	OK              bool            `json:"ok"`
+	File            string          `json:"file,omitempty"`
+	Applied         []config.Change `json:"applied"`
+	RestartRequired []config.Change `json:"restart_required"`
+	Problems        []string        `json:"problems,omitempty"`
+	Error           string          `json:"error,omitempty"`
+}
+
+func newReloader(name string, args []string, running config.Config, pipelines []*accountPipeline, topic *kstream.Topic, filters *filter.Engine) *reloader {
+	return &reloader{
+		name:      name,
+		args:      args,
+		pipelines: pipelines,
+		topic:     topic,
+		filters:   filters,
+		running:   running,
+		lg:        observe.C("config_reload"),
+	}
+}
+
+// Config returns the running configuration, secrets redacted.
+func (rl *reloader) Config() any {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	return rl.running.Redacted()
+}
+
+// LastReload returns the last reload's outcome, nil before the first.
+func (rl *reloader) LastReload() any {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	if rl.last == nil {
+		return nil
+	}
+	return rl.last
+}
+
+// Reload re-reads the configuration, applies the live changes and records
+// the outcome for LastReload.
+func (rl *reloader) Reload(by string) (any, error) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	res := &reloadResult{At: time.Now().UTC(), By: by}
+	rl.last = res
+	if err := rl.reload(res); err != nil {
+		res.Error = err.Error()
+		rl.lg.Warn("config reload rejected", "by", by, "file", res.File, "err", err, "problems", res.Problems)
+		return res, err
+	}
+	res.OK = true
+	rl.lg.Info("config reloaded", "by", by, "file", res.File,
+		"applied", len(res.Applied), "restart_required", len(res.RestartRequired))
+	return res, nil
+}
+
+func (rl *reloader) reload(res *reloadResult) error {
+	loaded, flags, err := config.Load(rl.name, rl.args)
+	res.File = flags.File
+	var invalid *config.ValidationError
+	if errors.As(err, &invalid) {
+		res.Problems = invalid.Problems
+		return errors.New("invalid configuration")
+	}
+	if err != nil {
+		return err
+	}
+
+	old := rl.running
+	next, live, restart := config.Reconcile(old, loaded)
+
+	// resolve the profile and the filter rules before touching anything,
+	// so a bad profiles or rules file rejects the reload as a whole
+	profiles, err := ratelimit.LoadProfiles(next.RateLimit.ProfilesPath)
+	if err != nil {
+		return fmt.Errorf("load rate-limit profiles: %w", err)
+	}
+	profile, ok := profiles[next.RateLimit.Profile]
+	if !ok {
+		return fmt.Errorf("unknown rate-limit profile %q", next.RateLimit.Profile)
+	}
+	rules, err := filter.Load(next.Filter.RulesPath)
+	if err != nil {
+		return err
+	}
+
+	if next.Log.Level != old.Log.Level {
+		// overrides absent from the new spec stay; "component=default" drops one
+		spec := next.Log.Level
+		if spec == "" {
+			spec = "info"
+		}
+		_ = observe.SetLevels(spec) // checked by Validate
+	}
+	if next.Kafka.Topic != old.Kafka.Topic {
+		rl.topic.Set(next.Kafka.Topic)
+	}
+	rl.filters.Set(rules)
+	retune := next.Rectifier != old.Rectifier || next.Health.MinJoinedRatio != old.Health.MinJoinedRatio
+	for _, p := range rl.pipelines {
+		if p.limits.Profile() != profile {
+			p.limits.SetProfile(profile)
+			rl.lg.Info("rate-limit profile applied", "account", p.acct.Name(), "profile", profile.Name)
+		}
+		if retune {
+			p.retuneRectifier(rectifierConfig(next))
+		}
+	}
+	for _, c := range restart {
+		rl.lg.Warn("config change needs a restart", "key", c.Key, "running", c.Old, "file", c.New)
+	}
+
+	rl.running = next
+	res.Applied, res.RestartRequired = live, restart
+	return nil
+}
@@ -4,11 +4,55 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"net/http"
+	"os"
+	"time"
 
 	"github.com/segmentio/kafka-go"
+
+	"github.com/Jamie-38/stream-pipeline/internal/observe"
+	"github.com/Jamie-38/stream-pipeline/internal/tracing"
+)
+
+var (
+	consumed = observe.Metrics().Counter("kafka_consumer_messages_total",
+		"Messages read from Kafka.")
+	readErrors = observe.Metrics().Counter("kafka_consumer_read_errors_total",
+		"Kafka reads that failed.")
 )
 
 func main() {
+	// optional Prometheus endpoint
+	if port := os.Getenv("METRICS_PORT"); port != "" {
+		mux := http.NewServeMux()
+		mux.Handle("GET /metrics", observe.Metrics().Handler())
+		srv := &http.Server{Addr: ":" + port, Handler: mux, ReadHeaderTimeout: 5 * time.Second}
+		go func() {
+			if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				log.Println("metrics server error:", err)
+			}
+		}()
+	}
+
+	// consumer spans link to the producer's trace via the traceparent header
+	tracer, err := tracing.FromEnv("kafka_consumer")
+	if err != nil {
+		log.Fatal("init tracing:", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan struct{})
+	if tracer != nil {
+		go func() {
+			defer close(done)
+			if err := tracer.Run(ctx); err != nil {
+				log.Println("trace export shutdown:", err)
+			}
+		}()
+	} else {
+		close(done)
+	}
+
 	// make a new reader that consumes from topic
 	r := kafka.NewReader(kafka.ReaderConfig{
 		Brokers:  []string{"localhost:9094"},
@@ -20,12 +64,31 @@ func main() {
 	for {
 		m, err := r.ReadMessage(context.Background())
 		if err != nil {
+			readErrors.With().Inc()
 			break
 		}
+		consumed.With().Inc()
+		sp := tracer.Linked(remoteSpan(m), "kafka.consume", tracing.KindConsumer)
+		sp.SetAttr("kafka.partition", m.Partition)
+		sp.SetAttr("kafka.offset", m.Offset)
 		fmt.Printf("message at topic/partition/offset %v/%v/%v: %s = %s\n", m.Topic, m.Partition, m.Offset, string(m.Key), string(m.Value))
+		sp.End()
 	}
 
 	if err := r.Close(); err != nil {
 		log.Fatal("failed to close reader:", err)
 	}
+	cancel()
+	<-done
+}
+
+// remoteSpan reads the producer's span context from the message headers.
+func remoteSpan(m kafka.Message) tracing.SpanContext {
+	for _, h := range m.Headers {
+		if h.Key == "traceparent" {
+			sc, _ := tracing.ParseTraceparent(string(h.Value))
+			return sc
+		}
+	}
+	return tracing.SpanContext{}
 }
@@ -8,6 +8,7 @@ import (
 	"os/signal"
 	"time"
 
+	"github.com/Jamie-38/stream-pipeline/internal/apiauth"
 	"github.com/Jamie-38/stream-pipeline/internal/config"
 	"github.com/Jamie-38/stream-pipeline/internal/healthcheck"
 	"github.com/Jamie-38/stream-pipeline/internal/oauth"
@@ -22,12 +23,27 @@ func main() {
 	}
 
 	mux := http.NewServeMux()
-	probe := healthcheck.New("oauth_server")
-	probe.Register(mux)
-	probe.SetNotReady()
+	health := healthcheck.Default()
+	health.RegisterHandlers(mux)
+	server := health.Register("oauth_server", healthcheck.Options{})
 
-	mux.HandleFunc("/", oauth.Index)
-	mux.HandleFunc("/callback", oauth.Callback)
+	auth := &oauth.Handler{
+		Endpoints: oauth.EndpointsFromEnv(),
+		Client:    &http.Client{Timeout: 10 * time.Second},
+	}
+	mux.HandleFunc("/", auth.Index)
+	mux.HandleFunc("/callback", auth.Callback)
+
+	// token admin shares the control plane's credentials and audit log
+	guard, err := apiauth.NewGuardFromEnv()
+	if err != nil {
+		lg.Error("init api auth", "err", err)
+		os.Exit(1)
+	}
+	defer guard.Close()
+	mux.Handle("GET /status", guard.RequireFunc(apiauth.RoleRead, auth.Status))
+	mux.Handle("POST /revoke", guard.RequireFunc(apiauth.RoleAdmin, auth.Revoke))
+	mux.Handle("GET /metrics", observe.Metrics().Handler())
 
 	port := os.Getenv("OAUTH_SERVER_PORT")
 	if port == "" {
@@ -52,7 +68,7 @@ func main() {
 
 	go func() {
 		lg.Info("listening", "addr", ":"+port)
-		probe.SetReady()
+		server.SetReady(true, "listening")
 		if err := srv.Serve(ln); err != nil && err != http.ErrServerClosed {
 			lg.Error("serve error", "err", err)
 			os.Exit(1)
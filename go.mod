@@ -7,8 +7,10 @@ toolchain go1.24.4
 require github.com/joho/godotenv v1.5.1
 
 require (
+	github.com/BurntSushi/toml v1.4.0
 	github.com/gorilla/websocket v1.5.3
 	github.com/segmentio/kafka-go v0.4.48
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
@@ -0,0 +1,223 @@
+package apiauth
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+type Role int
+
+const (
+	RoleNone Role = iota
+	RoleRead
+	RoleAdmin
+)
+
+func (r Role) String() string {
+	switch r {
+	case RoleRead:
+		return "read"
+	case RoleAdmin:
+		return "admin"
+	default:
+		return "none"
+	}
+}
+
+func ParseRole(s string) (Role, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "read", "readonly", "read-only":
+		return RoleRead, nil
+	case "admin":
+		return RoleAdmin, nil
+	default:
+		return RoleNone, fmt.Errorf("unknown role %q", s)
+	}
+}
+
+// Allows reports whether r grants at least the privileges of need.
+func (r Role) Allows(need Role) bool { return r >= need }
+
+// Identity is the authenticated caller of a request.
+type Identity struct {
+	Name   string
+	Role   Role
+	Method string // "bearer", "apikey", "mtls" or "open"
+}
+
+var (
+	ErrNoCredentials      = errors.New("no credentials")
+	ErrInvalidCredentials = errors.New("invalid credentials")
+)
+
+// Authenticator resolves the caller of a request. It returns ErrNoCredentials
+// when the request carries nothing it understands, so chains can fall through.
+type Authenticator interface {
+	Authenticate(r *http.Request) (Identity, error)
+}
+
+// Chain tries each authenticator in order and returns the first identity.
+type Chain []Authenticator
+
+func (c Chain) Authenticate(r *http.Request) (Identity, error) {
+	for _, a := range c {
+		id, err := a.Authenticate(r)
+		if errors.Is(err, ErrNoCredentials) {
+			continue
+		}
+		return id, err
+	}
+	return Identity{}, ErrNoCredentials
+}
+
+// Open admits every caller as admin; used when no credentials are configured.
+type Open struct{}
+
+func (Open) Authenticate(*http.Request) (Identity, error) {
+	return Identity{Name: "anonymous", Role: RoleAdmin, Method: "open"}, nil
+}
+
+type credential struct {
+	name string
+	role Role
+	hash [sha256.Size]byte
+}
+
+// StaticTokens checks "Authorization: Bearer" tokens and "X-API-Key" keys
+// against a fixed set loaded at startup. Secrets are held as digests and
+// compared in constant time.
+type StaticTokens struct {
+	bearer []credential
+	apiKey []credential
+}
+
+func (s *StaticTokens) Authenticate(r *http.Request) (Identity, error) {
+	if h := r.Header.Get("Authorization"); h != "" {
+		tok, ok := strings.CutPrefix(h, "Bearer ")
+		if !ok {
+			return Identity{}, ErrInvalidCredentials
+		}
+		return match(s.bearer, tok, "bearer")
+	}
+	if k := r.Header.Get("X-API-Key"); k != "" {
+		return match(s.apiKey, k, "apikey")
+	}
+	return Identity{}, ErrNoCredentials
+}
+
+func match(creds []credential, secret, method string) (Identity, error) {
+	sum := sha256.Sum256([]byte(strings.TrimSpace(secret)))
+	var found *credential
+	for i := range creds {
+		if subtle.ConstantTimeCompare(sum[:], creds[i].hash[:]) == 1 {
+			found = &creds[i]
+		}
+	}
+	if found == nil {
+		return Identity{}, ErrInvalidCredentials
+	}
+	return Identity{Name: found.name, Role: found.role, Method: method}, nil
+}
+
+// ClientCerts maps the common name of a verified TLS client certificate to
+// a role. Verification itself is done by the server's tls.Config. A
+// certificate whose name is not mapped counts as no credentials, so a
+// caller presenting one, e.g. through a TLS proxy, can still authenticate
+// with a token or key.
+type ClientCerts map[string]Role
+
+func (c ClientCerts) Authenticate(r *http.Request) (Identity, error) {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return Identity{}, ErrNoCredentials
+	}
+	cn := r.TLS.VerifiedChains[0][0].Subject.CommonName
+	role, ok := c[cn]
+	if !ok {
+		return Identity{}, ErrNoCredentials
+	}
+	return Identity{Name: cn, Role: role, Method: "mtls"}, nil
+}
+
+// File is the on-disk credentials format read by LoadFile.
+type File struct {
+	Tokens      []FileEntry `json:"tokens"`
+	APIKeys     []FileEntry `json:"api_keys"`
+	ClientCerts []FileEntry `json:"client_certs"`
+}
+
+type FileEntry struct {
+	Name   string `json:"name"`
+	Secret string `json:"secret,omitempty"` // token or key; unused for client certs
+	CN     string `json:"cn,omitempty"`     // client certificate common name
+	Role   string `json:"role"`
+}
+
+// LoadFile builds an authenticator chain (mTLS, then bearer/API key) from a
+// JSON credentials file.
+func LoadFile(path string) (Chain, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("apiauth: read %q: %w", path, err)
+	}
+	var f File
+	if err := json.Unmarshal(b, &f); err != nil {
+		return nil, fmt.Errorf("apiauth: decode %q: %w", path, err)
+	}
+
+	toCreds := func(kind string, entries []FileEntry) ([]credential, error) {
+		out := make([]credential, 0, len(entries))
+		for i, e := range entries {
+			role, err := ParseRole(e.Role)
+			if err != nil {
+				return nil, fmt.Errorf("apiauth: %s[%d]: %w", kind, i, err)
+			}
+			if e.Name == "" || e.Secret == "" {
+				return nil, fmt.Errorf("apiauth: %s[%d]: name and secret are required", kind, i)
+			}
+			out = append(out, credential{name: e.Name, role: role, hash: sha256.Sum256([]byte(e.Secret))})
+		}
+		return out, nil
+	}
+
+	st := &StaticTokens{}
+	if st.bearer, err = toCreds("tokens", f.Tokens); err != nil {
+		return nil, err
+	}
+	if st.apiKey, err = toCreds("api_keys", f.APIKeys); err != nil {
+		return nil, err
+	}
+
+	certs := ClientCerts{}
+	for i, e := range f.ClientCerts {
+		role, err := ParseRole(e.Role)
+		if err != nil {
+			return nil, fmt.Errorf("apiauth: client_certs[%d]: %w", i, err)
+		}
+		if e.CN == "" {
+			return nil, fmt.Errorf("apiauth: client_certs[%d]: cn is required", i)
+		}
+		certs[e.CN] = role
+	}
+
+	return Chain{certs, st}, nil
+}
+
+type ctxKey struct{}
+
+// FromContext returns the identity stored by Guard.Require.
+func FromContext(ctx context.Context) (Identity, bool) {
+	id, ok := ctx.Value(ctxKey{}).(Identity)
+	return id, ok
+}
+
+// WithIdentity returns a copy of ctx carrying id.
+func WithIdentity(ctx context.Context, id Identity) context.Context {
+	return context.WithValue(ctx, ctxKey{}, id)
+}
@@ -0,0 +1,192 @@
+package apiauth
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Jamie-38/stream-pipeline/internal/observe"
+)
+
+// Guard wraps handlers with authentication, role checks and, for admin
+// routes, an audit trail.
+type Guard struct {
+	auth  Authenticator
+	audit *AuditLog
+	lg    *slog.Logger
+}
+
+func NewGuard(auth Authenticator, audit *AuditLog) *Guard {
+	if auth == nil {
+		auth = Open{}
+	}
+	return &Guard{auth: auth, audit: audit, lg: observe.C("apiauth")}
+}
+
+// NewGuardFromEnv reads HTTP_API_AUTH_FILE and HTTP_API_AUDIT_LOG. Without an
+// auth file every caller is admitted as admin, matching the unauthenticated
+// behaviour of earlier releases.
+func NewGuardFromEnv() (*Guard, error) {
+	return NewGuardFromFiles(strings.TrimSpace(os.Getenv("HTTP_API_AUTH_FILE")), strings.TrimSpace(os.Getenv("HTTP_API_AUDIT_LOG")))
+}
+
+// NewGuardFromFiles loads credentials from authFile and audits to
+// auditLog; either may be empty, as in NewGuardFromEnv.
+func NewGuardFromFiles(authFile, auditLog string) (*Guard, error) {
+	var auth Authenticator = Open{}
+	if authFile != "" {
+		chain, err := LoadFile(authFile)
+		if err != nil {
+			return nil, err
+		}
+		auth = chain
+	} else {
+		observe.C("apiauth").Warn("no auth file configured; control plane is unauthenticated")
+	}
+
+	audit, err := OpenAuditLog(auditLog)
+	if err != nil {
+		return nil, err
+	}
+	return NewGuard(auth, audit), nil
+}
+
+// Close releases the audit log.
+func (g *Guard) Close() error { return g.audit.Close() }
+
+// Require admits callers holding at least role need. Admin routes are
+// treated as mutating and every attempt, allowed or not, is audited.
+func (g *Guard) Require(need Role, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, err := g.auth.Authenticate(r)
+		status := 0
+		switch {
+		case err != nil:
+			status = http.StatusUnauthorized
+			w.Header().Set("WWW-Authenticate", `Bearer realm="stream-pipeline"`)
+		case !id.Role.Allows(need):
+			status = http.StatusForbidden
+		}
+
+		if status != 0 {
+			g.lg.Warn("request denied", "path", r.URL.Path, "remote", r.RemoteAddr,
+				"identity", id.Name, "status", status, "err", err)
+			if need == RoleAdmin {
+				g.audit.Record(r, id, status)
+			}
+			http.Error(w, http.StatusText(status), status)
+			return
+		}
+
+		r = r.WithContext(WithIdentity(r.Context(), id))
+		if need != RoleAdmin {
+			next.ServeHTTP(w, r)
+			return
+		}
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(sw, r)
+		g.audit.Record(r, id, sw.status)
+	})
+}
+
+func (g *Guard) RequireFunc(need Role, fn http.HandlerFunc) http.Handler {
+	return g.Require(need, fn)
+}
+
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+	wrote  bool
+}
+
+func (s *statusWriter) WriteHeader(code int) {
+	if !s.wrote {
+		s.status = code
+		s.wrote = true
+	}
+	s.ResponseWriter.WriteHeader(code)
+}
+
+func (s *statusWriter) Write(b []byte) (int, error) {
+	s.wrote = true
+	return s.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (s *statusWriter) Unwrap() http.ResponseWriter { return s.ResponseWriter }
+
+// AuditEntry is one line of the audit log.
+type AuditEntry struct {
+	Time     time.Time `json:"ts"`
+	Identity string    `json:"identity"`
+	Role     string    `json:"role"`
+	Method   string    `json:"auth_method"`
+	Verb     string    `json:"method"`
+	Path     string    `json:"path"`
+	Query    string    `json:"query,omitempty"`
+	Remote   string    `json:"remote"`
+	Status   int       `json:"status"`
+}
+
+// AuditLog appends JSON lines to a file, or to the "audit" logger when no
+// path is configured. A nil *AuditLog discards entries.
+type AuditLog struct {
+	mu  sync.Mutex
+	w   io.WriteCloser
+	enc *json.Encoder
+	lg  *slog.Logger
+}
+
+func OpenAuditLog(path string) (*AuditLog, error) {
+	a := &AuditLog{lg: observe.C("audit")}
+	if path == "" {
+		return a, nil
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("apiauth: open audit log %q: %w", path, err)
+	}
+	a.w = f
+	a.enc = json.NewEncoder(f)
+	return a, nil
+}
+
+func (a *AuditLog) Record(r *http.Request, id Identity, status int) {
+	if a == nil {
+		return
+	}
+	e := AuditEntry{
+		Time:     time.Now().UTC(),
+		Identity: id.Name,
+		Role:     id.Role.String(),
+		Method:   id.Method,
+		Verb:     r.Method,
+		Path:     r.URL.Path,
+		Query:    r.URL.RawQuery,
+		Remote:   r.RemoteAddr,
+		Status:   status,
+	}
+	if a.enc == nil {
+		a.lg.Info("audit", "identity", e.Identity, "role", e.Role, "auth_method", e.Method,
+			"method", e.Verb, "path", e.Path, "query", e.Query, "remote", e.Remote, "status", e.Status)
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if err := a.enc.Encode(&e); err != nil {
+		a.lg.Error("audit write failed", "err", err)
+	}
+}
+
+func (a *AuditLog) Close() error {
+	if a == nil || a.w == nil {
+		return nil
+	}
+	return a.w.Close()
+}
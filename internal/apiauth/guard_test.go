@@ -0,0 +1,166 @@
+package apiauth
+
+import (
+	"bufio"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeCreds(t *testing.T) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "auth.json")
+	f := File{
+		Tokens:      []FileEntry{{Name: "ops", Secret: "admintok", Role: "admin"}, {Name: "viewer", Secret: "readtok", Role: "read"}},
+		APIKeys:     []FileEntry{{Name: "ci", Secret: "key1", Role: "admin"}},
+		ClientCerts: []FileEntry{{Name: "deployer", CN: "deployer", Role: "admin"}},
+	}
+	b, _ := json.Marshal(f)
+	if err := os.WriteFile(path, b, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func newTestGuard(t *testing.T) (*Guard, string) {
+	t.Helper()
+	chain, err := LoadFile(writeCreds(t))
+	if err != nil {
+		t.Fatalf("LoadFile: %v", err)
+	}
+	auditPath := filepath.Join(t.TempDir(), "audit.jsonl")
+	audit, err := OpenAuditLog(auditPath)
+	if err != nil {
+		t.Fatalf("OpenAuditLog: %v", err)
+	}
+	t.Cleanup(func() { audit.Close() })
+	return NewGuard(chain, audit), auditPath
+}
+
+func okHandler(w http.ResponseWriter, r *http.Request) {
+	id, _ := FromContext(r.Context())
+	w.Write([]byte(id.Name))
+}
+
+func TestGuard_RoleChecks(t *testing.T) {
+	g, _ := newTestGuard(t)
+	admin := g.RequireFunc(RoleAdmin, okHandler)
+	read := g.RequireFunc(RoleRead, okHandler)
+
+	cases := []struct {
+		name   string
+		h      http.Handler
+		header string
+		value  string
+		want   int
+	}{
+		{"no credentials", admin, "", "", http.StatusUnauthorized},
+		{"bad token", admin, "Authorization", "Bearer nope", http.StatusUnauthorized},
+		{"malformed header", admin, "Authorization", "Basic abc", http.StatusUnauthorized},
+		{"read on admin", admin, "Authorization", "Bearer readtok", http.StatusForbidden},
+		{"admin on admin", admin, "Authorization", "Bearer admintok", http.StatusOK},
+		{"read on read", read, "Authorization", "Bearer readtok", http.StatusOK},
+		{"admin on read", read, "Authorization", "Bearer admintok", http.StatusOK},
+		{"api key", admin, "X-API-Key", "key1", http.StatusOK},
+	}
+	for _, tc := range cases {
+		req := httptest.NewRequest("GET", "/join?channel=x", nil)
+		if tc.header != "" {
+			req.Header.Set(tc.header, tc.value)
+		}
+		w := httptest.NewRecorder()
+		tc.h.ServeHTTP(w, req)
+		if w.Code != tc.want {
+			t.Errorf("%s: status = %d, want %d", tc.name, w.Code, tc.want)
+		}
+	}
+}
+
+func TestGuard_ClientCert(t *testing.T) {
+	g, _ := newTestGuard(t)
+	h := g.RequireFunc(RoleAdmin, okHandler)
+
+	req := httptest.NewRequest("GET", "/join", nil)
+	cert := &x509.Certificate{Subject: pkix.Name{CommonName: "deployer"}}
+	req.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusOK || w.Body.String() != "deployer" {
+		t.Fatalf("status = %d body = %q, want 200 deployer", w.Code, w.Body.String())
+	}
+
+	// an unmapped certificate falls through to the other credentials
+	other := &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{{Subject: pkix.Name{CommonName: "proxy"}}}}}
+	for _, tc := range []struct {
+		token string
+		want  int
+		body  string
+	}{
+		{"admintok", http.StatusOK, "ops"},
+		{"", http.StatusUnauthorized, ""},
+	} {
+		req := httptest.NewRequest("GET", "/join", nil)
+		req.TLS = other
+		if tc.token != "" {
+			req.Header.Set("Authorization", "Bearer "+tc.token)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		if w.Code != tc.want || (tc.body != "" && w.Body.String() != tc.body) {
+			t.Fatalf("unmapped cert, token %q: status = %d body = %q, want %d %s", tc.token, w.Code, w.Body.String(), tc.want, tc.body)
+		}
+	}
+}
+
+func TestGuard_AuditsMutatingRequests(t *testing.T) {
+	g, auditPath := newTestGuard(t)
+	admin := g.RequireFunc(RoleAdmin, okHandler)
+	read := g.RequireFunc(RoleRead, okHandler)
+
+	for _, tc := range []struct {
+		h   http.Handler
+		tok string
+	}{{admin, "admintok"}, {admin, "readtok"}, {read, "readtok"}} {
+		req := httptest.NewRequest("POST", "/join?channel=chess", nil)
+		req.Header.Set("Authorization", "Bearer "+tc.tok)
+		tc.h.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	f, err := os.Open(auditPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var entries []AuditEntry
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		var e AuditEntry
+		if err := json.Unmarshal(sc.Bytes(), &e); err != nil {
+			t.Fatalf("decode audit line: %v", err)
+		}
+		entries = append(entries, e)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("audit entries = %d, want 2 (read-only route is not audited)", len(entries))
+	}
+	if entries[0].Identity != "ops" || entries[0].Status != http.StatusOK || entries[0].Query != "channel=chess" {
+		t.Fatalf("first entry = %+v", entries[0])
+	}
+	if entries[1].Identity != "viewer" || entries[1].Status != http.StatusForbidden {
+		t.Fatalf("second entry = %+v", entries[1])
+	}
+}
+
+func TestLoadFileRejectsUnknownRole(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "auth.json")
+	os.WriteFile(path, []byte(`{"tokens":[{"name":"x","secret":"y","role":"root"}]}`), 0o600)
+	if _, err := LoadFile(path); err == nil {
+		t.Fatal("expected error for unknown role")
+	}
+}
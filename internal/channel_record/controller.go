@@ -1,14 +1,18 @@
 package channelrecord
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"log/slog"
+	"maps"
 	"os"
 	"path/filepath"
+	"slices"
 	"sort"
+	"strings"
 	"sync"
 	"time"
 
@@ -21,10 +25,15 @@ type Controller struct {
 	account         string // validated account name
 	schema          int    // schema version
 	controlCh       <-chan types.IRCCommand
+	restoreCh       chan restoreReq
+	metaCh          chan metaReq
 	updatesCh       chan struct{}
 	mu              sync.RWMutex
-	snap            snapshot // immutable view for readers
-	writeDebounceMs int      // debounce window
+	snap            snapshot      // immutable view for readers
+	journal         *journal      // append-only change history
+	stamp           fileStamp     // what we last wrote or accepted; owned by Run
+	watchInterval   time.Duration // poll period for external edits; 0 disables
+	writeDebounceMs int           // debounce window
 	lg              *slog.Logger
 }
 
@@ -33,6 +42,20 @@ type snapshot struct {
 	Account   string
 	UpdatedAt time.Time
 	Channels  []string
+	Meta      map[string]types.ChannelEntry // by channel name; never mutated once published
+	policies  map[string]types.ChannelPolicy
+}
+
+type metaReq struct {
+	entry  types.ChannelEntry
+	source string
+	reply  chan types.CommandResult
+}
+
+type restoreReq struct {
+	version uint64
+	source  string
+	reply   chan types.CommandResult
 }
 
 func NewController(path string, expectedAccount string, controlCh <-chan types.IRCCommand) (*Controller, error) {
@@ -50,42 +73,95 @@ func NewController(path string, expectedAccount string, controlCh <-chan types.I
 	c := &Controller{
 		path:            path,
 		account:         expectedAccount,
-		schema:          1,
+		schema:          types.ChannelsSchema,
 		controlCh:       controlCh,
+		restoreCh:       make(chan restoreReq),
+		metaCh:          make(chan metaReq),
 		updatesCh:       make(chan struct{}, 1),
 		writeDebounceMs: 150,
+		watchInterval:   2 * time.Second,
 		lg:              lg,
 	}
 
-	onDisk, err := loadFile(path)
+	onDisk, raw, fromSchema, err := loadFile(path)
 	if err != nil && !errors.Is(err, os.ErrNotExist) {
 		return nil, fmt.Errorf("channelrecord: load channels file %q: %w", path, err)
 	}
+	missing := errors.Is(err, os.ErrNotExist)
+	migrated := !missing && fromSchema != types.ChannelsSchema
 
 	var desired map[string]struct{}
-	if err == nil {
+	meta := make(map[string]types.ChannelEntry)
+	if !missing {
 		if onDisk.Account != "" && onDisk.Account != expectedAccount {
 			return nil, fmt.Errorf("channelrecord: channels file account %q != expected %q",
 				onDisk.Account, expectedAccount)
 		}
-		desired = sliceToSet(onDisk.Channels)
-		c.schema = onDisk.Schema
-		lg.Debug("loaded channels file", "schema", c.schema, "channels", len(desired))
+		if migrated {
+			bak, err := backupOriginal(path, raw, fromSchema)
+			if err != nil {
+				return nil, fmt.Errorf("channelrecord: back up channels file before migration: %w", err)
+			}
+			lg.Info("migrated channels file", "from_schema", fromSchema, "to_schema", types.ChannelsSchema, "backup", bak)
+		}
+		desired = sliceToSet(onDisk.Names())
+		meta = entriesToMeta(onDisk.Channels)
+		lg.Debug("loaded channels file", "schema", onDisk.Schema, "channels", len(desired))
 	} else {
 		desired = make(map[string]struct{})
 		lg.Debug("no existing channels file; will initialize")
 	}
 
+	c.journal, err = openJournal(journalPath(path))
+	if err != nil {
+		return nil, fmt.Errorf("channelrecord: %w", err)
+	}
+
+	version := max(onDisk.Version, c.journal.lastVersion(), 1)
+	now := time.Now().UTC()
+	rewrite := missing || migrated
+
+	if c.journal.lastVersion() == 0 {
+		base := types.ChannelJournalEntry{
+			Version:  version,
+			Op:       "RESET",
+			Channels: setToSortedSlice(desired),
+			Time:     now,
+			Source:   "baseline",
+		}
+		if err := c.journal.append(base); err != nil {
+			return nil, fmt.Errorf("channelrecord: %w", err)
+		}
+	} else if known, err := c.journal.setAt(c.journal.lastVersion()); err == nil {
+		// channels.json changed while we were not running; journal the difference
+		added, removed := diffSets(known, desired)
+		if len(added)+len(removed) > 0 {
+			version++
+			if err := c.journal.append(changeEntries(version, now, "file", 0, added, removed)...); err != nil {
+				return nil, fmt.Errorf("channelrecord: %w", err)
+			}
+			lg.Info("journaled offline edit", "version", version, "added", len(added), "removed", len(removed))
+			rewrite = true
+		}
+	}
+
 	// Build initial immutable snapshot
 	chans := setToSortedSlice(desired)
 	c.snap = snapshot{
-		Version:   1,
+		Version:   version,
 		Account:   expectedAccount,
-		UpdatedAt: time.Now().UTC(),
+		UpdatedAt: now,
 		Channels:  chans,
+		Meta:      meta,
+		policies:  policiesByLogin(meta),
 	}
 
-	if errors.Is(err, os.ErrNotExist) {
+	if !rewrite {
+		if b, err := os.ReadFile(path); err == nil {
+			c.stamp = stampOf(path, b)
+		}
+	}
+	if rewrite {
 		if err := c.writeFile(c.snap); err != nil {
 			return nil, fmt.Errorf("channelrecord: initialize channels file %q: %w", path, err)
 		}
@@ -97,14 +173,70 @@ func NewController(path string, expectedAccount string, controlCh <-chan types.I
 	return c, nil
 }
 
+func journalPath(path string) string { return path + ".journal" }
+
+func changeEntries(version uint64, ts time.Time, source string, restoredFrom uint64, added, removed []string) []types.ChannelJournalEntry {
+	out := make([]types.ChannelJournalEntry, 0, len(added)+len(removed))
+	for _, ch := range added {
+		out = append(out, types.ChannelJournalEntry{Version: version, Op: "JOIN", Channel: ch, Time: ts, Source: source, RestoredFrom: restoredFrom})
+	}
+	for _, ch := range removed {
+		out = append(out, types.ChannelJournalEntry{Version: version, Op: "PART", Channel: ch, Time: ts, Source: source, RestoredFrom: restoredFrom})
+	}
+	return out
+}
+
 func (c *Controller) Run(ctx context.Context) error {
 	lg := c.lg
 
 	desired := sliceToSet(c.readSnap().Channels)
+	meta := maps.Clone(c.readSnap().Meta)
 	version := c.readSnap().Version
 
 	dirty := false
 	var debounce <-chan time.Time
+	var pending []pendingReply              // replies owed once the next snapshot is persisted
+	var changes []types.ChannelJournalEntry // journal entries for the next snapshot
+
+	// apply mutates desired and queues a journal entry; it reports whether
+	// the set changed.
+	markDirty := func() {
+		dirty = true
+		if debounce == nil {
+			debounce = time.After(time.Duration(c.writeDebounceMs) * time.Millisecond)
+		}
+	}
+	apply := func(op, ch, source string, restoredFrom uint64) bool {
+		now := time.Now().UTC()
+		switch op {
+		case "JOIN":
+			if _, exists := desired[ch]; exists {
+				return false
+			}
+			desired[ch] = struct{}{}
+			meta[ch] = types.ChannelEntry{Name: ch, AddedBy: source, AddedAt: now}
+		case "PART":
+			if _, exists := desired[ch]; !exists {
+				return false
+			}
+			delete(desired, ch)
+			delete(meta, ch)
+		default:
+			return false
+		}
+		changes = append(changes, types.ChannelJournalEntry{
+			Op: op, Channel: ch, Time: now, Source: source, RestoredFrom: restoredFrom,
+		})
+		markDirty()
+		return true
+	}
+
+	var poll <-chan time.Time
+	if c.watchInterval > 0 {
+		t := time.NewTicker(c.watchInterval)
+		defer t.Stop()
+		poll = t.C
+	}
 
 	for {
 		select {
@@ -117,38 +249,140 @@ func (c *Controller) Run(ctx context.Context) error {
 				"path", c.path,
 				"account", c.account,
 			)
+			for _, p := range pending {
+				reply(p.cmd, types.CommandResult{ID: p.cmd.ID, Err: ctx.Err()})
+			}
 
 			return ctx.Err()
 
 		case cmd := <-c.controlCh:
 			ch, ok := normalizeChannel(cmd.Channel)
 			if !ok {
-				lg.Debug("dropping invalid command", "op", cmd.Op, "raw_channel", cmd.Channel)
+				lg.Debug("dropping invalid command", "op", cmd.Op, "raw_channel", cmd.Channel, "request_id", cmd.ID)
+				reply(cmd, types.CommandResult{ID: cmd.ID, Err: fmt.Errorf("invalid channel %q", cmd.Channel)})
+				continue
+			}
+			if cmd.Op != "JOIN" && cmd.Op != "PART" {
+				lg.Debug("unknown op", "op", cmd.Op, "request_id", cmd.ID)
+				reply(cmd, types.CommandResult{ID: cmd.ID, Err: fmt.Errorf("unknown op %q", cmd.Op)})
 				continue
 			}
 
-			switch cmd.Op {
-			case "JOIN":
-				if _, exists := desired[ch]; !exists {
-					desired[ch] = struct{}{}
-					dirty = true
-					lg.Info("desired add", "channel", ch)
-					if debounce == nil {
-						debounce = time.After(time.Duration(c.writeDebounceMs) * time.Millisecond)
-					}
-				}
-			case "PART":
-				if _, exists := desired[ch]; exists {
-					delete(desired, ch)
-					dirty = true
-					lg.Info("desired remove", "channel", ch)
-					if debounce == nil {
-						debounce = time.After(time.Duration(c.writeDebounceMs) * time.Millisecond)
-					}
+			source := cmd.Source
+			if source == "" {
+				source = "unknown"
+			}
+			changed := apply(cmd.Op, ch, source, 0)
+			if changed {
+				if cmd.Op == "JOIN" {
+					lg.Info("desired add", "channel", ch, "request_id", cmd.ID, "source", source)
+				} else {
+					lg.Info("desired remove", "channel", ch, "request_id", cmd.ID, "source", source)
 				}
+			}
+
+			switch {
+			case changed:
+				pending = append(pending, pendingReply{cmd: cmd, changed: true})
+			case dirty:
+				// no-op for this channel, but an earlier change is still unpersisted
+				pending = append(pending, pendingReply{cmd: cmd})
 			default:
-				lg.Debug("unknown op", "op", cmd.Op)
+				reply(cmd, types.CommandResult{ID: cmd.ID, Version: version})
+			}
+
+		case req := <-c.restoreCh:
+			target, err := c.journal.setAt(req.version)
+			if err != nil {
+				req.reply <- types.CommandResult{Err: err}
+				continue
+			}
+			added, removed := diffSets(desired, target)
+			for _, ch := range added {
+				apply("JOIN", ch, req.source, req.version)
+			}
+			for _, ch := range removed {
+				apply("PART", ch, req.source, req.version)
+			}
+			lg.Info("restore requested", "from_version", req.version, "source", req.source,
+				"added", len(added), "removed", len(removed))
+
+			cmd := types.IRCCommand{Op: "RESTORE", Reply: req.reply}
+			switch {
+			case len(added)+len(removed) > 0:
+				pending = append(pending, pendingReply{cmd: cmd, changed: true})
+			case dirty:
+				pending = append(pending, pendingReply{cmd: cmd})
+			default:
+				reply(cmd, types.CommandResult{Version: version})
+			}
+
+		case req := <-c.metaCh:
+			ch, ok := normalizeChannel(req.entry.Name)
+			if _, desiredNow := desired[ch]; !ok || !desiredNow {
+				req.reply <- types.CommandResult{Err: fmt.Errorf("channel %q is not desired", req.entry.Name)}
+				continue
+			}
+			cur := meta[ch]
+			next := req.entry
+			next.Name, next.AddedBy, next.AddedAt = ch, cur.AddedBy, cur.AddedAt
+			cmd := types.IRCCommand{Op: "META", Reply: req.reply}
+			if entryEqual(cur, next) {
+				if dirty {
+					pending = append(pending, pendingReply{cmd: cmd})
+				} else {
+					reply(cmd, types.CommandResult{Version: version})
+				}
+				continue
+			}
+			meta[ch] = next
+			changes = append(changes, types.ChannelJournalEntry{
+				Op: "META", Channel: ch, Time: time.Now().UTC(), Source: req.source,
+			})
+			markDirty()
+			pending = append(pending, pendingReply{cmd: cmd, changed: true})
+			lg.Info("metadata updated", "channel", ch, "source", req.source, "priority", next.Priority)
+
+		case <-poll:
+			edit, ok := c.checkExternalEdit()
+			if !ok {
+				continue
+			}
+			// merge the operator's changes relative to what we last persisted,
+			// so commands still waiting for the debounce are kept
+			added, removed := diffSets(sliceToSet(c.readSnap().Channels), sliceToSet(edit.Names()))
+			n := 0
+			for _, ch := range added {
+				if apply("JOIN", ch, "file", 0) {
+					n++
+				}
+			}
+			for _, ch := range removed {
+				if apply("PART", ch, "file", 0) {
+					n++
+				}
+			}
+			// metadata edits on channels we keep are taken as written
+			metaChanged := 0
+			edited := entriesToMeta(edit.Channels)
+			for _, ch := range slices.Sorted(maps.Keys(edited)) {
+				e := edited[ch]
+				if _, ok := desired[ch]; !ok || entryEqual(meta[ch], e) {
+					continue
+				}
+				meta[ch] = e
+				if !slices.Contains(added, ch) { // the JOIN entry covers new channels
+					changes = append(changes, types.ChannelJournalEntry{
+						Op: "META", Channel: ch, Time: time.Now().UTC(), Source: "file",
+					})
+				}
+				metaChanged++
+			}
+			if metaChanged > 0 {
+				markDirty()
 			}
+			lg.Info("applied external edit", "added", len(added), "removed", len(removed),
+				"changed", n, "metadata_changed", metaChanged)
 
 		case <-debounce:
 			if dirty {
@@ -158,21 +392,74 @@ func (c *Controller) Run(ctx context.Context) error {
 					Account:   c.account,
 					UpdatedAt: time.Now().UTC(),
 					Channels:  setToSortedSlice(desired),
+					Meta:      maps.Clone(meta),
+				}
+				for i := range changes {
+					changes[i].Version = version
 				}
 
-				if err := c.writeFile(newSnap); err != nil {
+				err := c.journal.append(changes...)
+				if err == nil {
+					err = c.writeFile(newSnap)
+				}
+				if err != nil {
+					for _, p := range pending {
+						reply(p.cmd, types.CommandResult{ID: p.cmd.ID, Err: err})
+					}
 					return err
 				}
 				c.writeSnap(newSnap)
 				c.nonBlockingNotify()
 				lg.Info("persisted snapshot", "version", version, "channels", len(newSnap.Channels))
+				for _, p := range pending {
+					reply(p.cmd, types.CommandResult{ID: p.cmd.ID, Version: version, Changed: p.changed})
+				}
 			}
 			dirty = false
 			debounce = nil
+			pending = pending[:0]
+			changes = changes[:0]
 		}
 	}
 }
 
+// History returns journal entries with from <= version <= to; to == 0 means
+// up to the latest version.
+func (c *Controller) History(from, to uint64) []types.ChannelJournalEntry {
+	return c.journal.between(from, to)
+}
+
+// Diff reports channels added and removed going from version from to version to.
+func (c *Controller) Diff(from, to uint64) (added, removed []string, err error) {
+	a, err := c.journal.setAt(from)
+	if err != nil {
+		return nil, nil, err
+	}
+	b, err := c.journal.setAt(to)
+	if err != nil {
+		return nil, nil, err
+	}
+	added, removed = diffSets(a, b)
+	return added, removed, nil
+}
+
+// Restore makes the desired set equal to what it was at version. The result
+// carries the new snapshot version once persisted.
+func (c *Controller) Restore(ctx context.Context, version uint64, source string) (types.CommandResult, error) {
+	req := restoreReq{version: version, source: source, reply: make(chan types.CommandResult, 1)}
+	select {
+	case c.restoreCh <- req:
+	case <-ctx.Done():
+		return types.CommandResult{}, ctx.Err()
+	}
+	select {
+	case res := <-req.reply:
+		return res, res.Err
+	case <-ctx.Done():
+		return types.CommandResult{}, ctx.Err()
+	}
+}
+
 func (c *Controller) Snapshot() (version uint64, channels []string, updatedAt time.Time, account string) {
 	s := c.readSnap()
 	cp := make([]string, len(s.Channels))
@@ -180,6 +467,50 @@ func (c *Controller) Snapshot() (version uint64, channels []string, updatedAt ti
 	return s.Version, cp, s.UpdatedAt, s.Account
 }
 
+// Entries returns the desired channels with their metadata, sorted by name.
+func (c *Controller) Entries() []types.ChannelEntry {
+	s := c.readSnap()
+	out := make([]types.ChannelEntry, 0, len(s.Channels))
+	for _, ch := range s.Channels {
+		e, ok := s.Meta[ch]
+		if !ok {
+			e = types.ChannelEntry{Name: ch}
+		}
+		out = append(out, e)
+	}
+	return out
+}
+
+// Priority returns the configured priority of a desired channel (0 if unset).
+func (c *Controller) Priority(channel string) int {
+	return c.readSnap().Meta[channel].Priority
+}
+
+// Policy implements types.PolicyLookup.
+func (c *Controller) Policy(channelLogin string) (types.ChannelPolicy, bool) {
+	c.mu.RLock()
+	p, ok := c.snap.policies[channelLogin]
+	c.mu.RUnlock()
+	return p, ok
+}
+
+// UpdateMeta replaces the operator metadata (priority, labels, notes, room
+// id and policy) of a desired channel. Provenance fields are kept.
+func (c *Controller) UpdateMeta(ctx context.Context, e types.ChannelEntry, source string) (types.CommandResult, error) {
+	req := metaReq{entry: e, source: source, reply: make(chan types.CommandResult, 1)}
+	select {
+	case c.metaCh <- req:
+	case <-ctx.Done():
+		return types.CommandResult{}, ctx.Err()
+	}
+	select {
+	case res := <-req.reply:
+		return res, res.Err
+	case <-ctx.Done():
+		return types.CommandResult{}, ctx.Err()
+	}
+}
+
 func (c *Controller) Updates() <-chan struct{} {
 	return c.updatesCh
 }
@@ -191,6 +522,7 @@ func (c *Controller) readSnap() snapshot {
 }
 
 func (c *Controller) writeSnap(s snapshot) {
+	s.policies = policiesByLogin(s.Meta)
 	c.mu.Lock()
 	c.snap = s
 	c.mu.Unlock()
@@ -204,6 +536,23 @@ func (c *Controller) nonBlockingNotify() {
 	}
 }
 
+type pendingReply struct {
+	cmd     types.IRCCommand
+	changed bool
+}
+
+// reply delivers res to cmd's reply channel without blocking the controller.
+func reply(cmd types.IRCCommand, res types.CommandResult) {
+	if cmd.Reply == nil {
+		return
+	}
+	select {
+	case cmd.Reply <- res:
+	default:
+		// caller gave up or did not buffer; never block the single writer
+	}
+}
+
 func normalizeChannel(raw string) (string, bool) {
 	if raw == "" {
 		return "", false
@@ -263,18 +612,31 @@ func (c *Controller) writeFile(s snapshot) error {
 	if err != nil {
 		return fmt.Errorf("open tmp: %w", err)
 	}
-	enc := json.NewEncoder(f)
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
 	enc.SetIndent("", "  ")
 	onDisk := types.Channels{
 		Schema:    c.schema,
 		Account:   c.account,
+		Version:   s.Version,
 		UpdatedAt: s.UpdatedAt,
-		Channels:  s.Channels,
+		Channels:  make([]types.ChannelEntry, 0, len(s.Channels)),
+	}
+	for _, ch := range s.Channels {
+		e, ok := s.Meta[ch]
+		if !ok {
+			e = types.ChannelEntry{Name: ch}
+		}
+		onDisk.Channels = append(onDisk.Channels, e)
 	}
 	if err := enc.Encode(&onDisk); err != nil {
 		_ = f.Close()
 		return fmt.Errorf("encode json: %w", err)
 	}
+	if _, err := f.Write(buf.Bytes()); err != nil {
+		_ = f.Close()
+		return fmt.Errorf("write tmp: %w", err)
+	}
 	if err := f.Sync(); err != nil {
 		_ = f.Close()
 		return fmt.Errorf("fsync tmp: %w", err)
@@ -285,17 +647,63 @@ func (c *Controller) writeFile(s snapshot) error {
 	if err := os.Rename(tmp, c.path); err != nil {
 		return fmt.Errorf("rename tmp→final: %w", err)
 	}
+	c.stamp = stampOf(c.path, buf.Bytes())
 	return nil
 }
 
-func loadFile(path string) (types.Channels, error) {
-	var v types.Channels
-	b, err := os.ReadFile(path)
+// loadFile reads channels.json, migrating it in memory to the current
+// schema. It also returns the raw bytes and the schema found on disk so the
+// caller can back up and rewrite migrated files.
+func loadFile(path string) (v types.Channels, raw []byte, fromSchema int, err error) {
+	raw, err = os.ReadFile(path)
+	if err != nil {
+		return v, nil, 0, err
+	}
+	b, fromSchema, err := migrateBytes(raw)
 	if err != nil {
-		return v, err
+		return v, raw, fromSchema, fmt.Errorf("%s: %w", path, err)
 	}
 	if err := json.Unmarshal(b, &v); err != nil {
-		return v, fmt.Errorf("decode %s: %w", path, err)
+		return v, raw, fromSchema, fmt.Errorf("decode %s: %w", path, err)
+	}
+	return v, raw, fromSchema, nil
+}
+
+// entriesToMeta indexes entries by normalized channel name.
+func entriesToMeta(es []types.ChannelEntry) map[string]types.ChannelEntry {
+	m := make(map[string]types.ChannelEntry, len(es))
+	for _, e := range es {
+		ch, ok := normalizeChannel(e.Name)
+		if !ok {
+			continue
+		}
+		e.Name = ch
+		m[ch] = e
+	}
+	return m
+}
+
+func entryEqual(a, b types.ChannelEntry) bool {
+	return a.Name == b.Name && a.Priority == b.Priority && slices.Equal(a.Labels, b.Labels) &&
+		a.AddedBy == b.AddedBy && a.AddedAt.Equal(b.AddedAt) && a.Notes == b.Notes && a.RoomID == b.RoomID &&
+		policyEqual(a.Policy, b.Policy)
+}
+
+func policyEqual(a, b *types.ChannelPolicy) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return slices.Equal(a.Kinds, b.Kinds) && a.SampleRate == b.SampleRate && a.RedactText == b.RedactText
+}
+
+// policiesByLogin indexes explicit policies by channel login without '#',
+// the form the classifier and producer see on the hot path.
+func policiesByLogin(meta map[string]types.ChannelEntry) map[string]types.ChannelPolicy {
+	out := make(map[string]types.ChannelPolicy)
+	for ch, e := range meta {
+		if e.Policy != nil {
+			out[strings.TrimPrefix(ch, "#")] = *e.Policy
+		}
 	}
-	return v, nil
+	return out
 }
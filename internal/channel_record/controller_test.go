@@ -0,0 +1,188 @@
+package channelrecord
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Jamie-38/stream-pipeline/internal/types"
+)
+
+func sendCmd(t *testing.T, ch chan<- types.IRCCommand, op, channel string) types.CommandResult {
+	t.Helper()
+	reply := make(chan types.CommandResult, 1)
+	ch <- types.IRCCommand{ID: op + channel, Op: op, Channel: channel, Reply: reply}
+	select {
+	case res := <-reply:
+		return res
+	case <-time.After(2 * time.Second):
+		t.Fatalf("no reply for %s %s", op, channel)
+		return types.CommandResult{}
+	}
+}
+
+func TestController_AcksWithPersistedVersion(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "channels.json")
+	controlCh := make(chan types.IRCCommand, 4)
+
+	c, err := NewController(path, "me", controlCh)
+	if err != nil {
+		t.Fatalf("NewController: %v", err)
+	}
+	c.writeDebounceMs = 5
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go c.Run(ctx)
+
+	res := sendCmd(t, controlCh, "JOIN", "Chess")
+	if res.Err != nil || !res.Changed || res.Version != 2 || res.ID != "JOINChess" {
+		t.Fatalf("join result = %+v, want changed at version 2", res)
+	}
+	if v, chans, _, _ := c.Snapshot(); v != 2 || len(chans) != 1 || chans[0] != "#chess" {
+		t.Fatalf("snapshot = v%d %v", v, chans)
+	}
+
+	res = sendCmd(t, controlCh, "JOIN", "#chess")
+	if res.Err != nil || res.Changed || res.Version != 2 {
+		t.Fatalf("repeat join result = %+v, want unchanged at version 2", res)
+	}
+
+	res = sendCmd(t, controlCh, "NOPE", "#chess")
+	if res.Err == nil {
+		t.Fatal("unknown op should be rejected")
+	}
+}
+
+func TestController_JournalHistoryDiffRestore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "channels.json")
+	controlCh := make(chan types.IRCCommand, 4)
+
+	c, err := NewController(path, "me", controlCh)
+	if err != nil {
+		t.Fatalf("NewController: %v", err)
+	}
+	c.writeDebounceMs = 5
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go c.Run(ctx)
+
+	sendCmd(t, controlCh, "JOIN", "#a") // v2
+	sendCmd(t, controlCh, "JOIN", "#b") // v3
+	sendCmd(t, controlCh, "PART", "#a") // v4
+
+	hist := c.History(2, 0)
+	if len(hist) != 3 || hist[0].Op != "JOIN" || hist[0].Channel != "#a" || hist[2].Op != "PART" || hist[2].Version != 4 {
+		t.Fatalf("history = %+v", hist)
+	}
+	if hist[0].Source != "unknown" {
+		t.Fatalf("source = %q, want unknown", hist[0].Source)
+	}
+
+	added, removed, err := c.Diff(2, 4)
+	if err != nil || len(added) != 1 || added[0] != "#b" || len(removed) != 1 || removed[0] != "#a" {
+		t.Fatalf("diff(2,4) = +%v -%v err=%v", added, removed, err)
+	}
+	if _, _, err := c.Diff(1, 9); err == nil {
+		t.Fatal("diff to unknown version should fail")
+	}
+
+	res, err := c.Restore(ctx, 3, "test")
+	if err != nil || !res.Changed || res.Version != 5 {
+		t.Fatalf("restore = %+v err=%v", res, err)
+	}
+	if _, chans, _, _ := c.Snapshot(); len(chans) != 2 {
+		t.Fatalf("restored channels = %v, want [#a #b]", chans)
+	}
+	last := c.History(5, 5)
+	if len(last) != 1 || last[0].RestoredFrom != 3 || last[0].Source != "test" {
+		t.Fatalf("restore journal = %+v", last)
+	}
+	cancel()
+
+	// version and history survive a restart
+	c2, err := NewController(path, "me", controlCh)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	if v, _, _, _ := c2.Snapshot(); v != 5 {
+		t.Fatalf("version after restart = %d, want 5", v)
+	}
+	if n := len(c2.History(0, 0)); n != 5 {
+		t.Fatalf("history after restart = %d entries, want 5", n)
+	}
+}
+
+func TestController_UpdateMetaPublishesPolicy(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "channels.json")
+	controlCh := make(chan types.IRCCommand, 4)
+	c, err := NewController(path, "me", controlCh)
+	if err != nil {
+		t.Fatalf("NewController: %v", err)
+	}
+	c.writeDebounceMs = 5
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go c.Run(ctx)
+
+	if _, err := c.UpdateMeta(ctx, types.ChannelEntry{Name: "#chess"}, "test"); err == nil {
+		t.Fatal("metadata for an undesired channel should be rejected")
+	}
+
+	sendCmd(t, controlCh, "JOIN", "#chess")
+	res, err := c.UpdateMeta(ctx, types.ChannelEntry{
+		Name:     "Chess",
+		Priority: 3,
+		Policy:   &types.ChannelPolicy{RedactText: true},
+	}, "test")
+	if err != nil || !res.Changed {
+		t.Fatalf("UpdateMeta = %+v, %v", res, err)
+	}
+
+	if p, ok := c.Policy("chess"); !ok || !p.RedactText {
+		t.Fatalf("Policy(chess) = %+v, %v", p, ok)
+	}
+	if c.Priority("#chess") != 3 {
+		t.Fatalf("Priority = %d, want 3", c.Priority("#chess"))
+	}
+	if e := c.Entries(); len(e) != 1 || e[0].AddedBy != "unknown" {
+		t.Fatalf("entries = %+v, want provenance kept", e)
+	}
+}
+
+func TestController_MetaEditIsJournaled(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "channels.json")
+	controlCh := make(chan types.IRCCommand, 4)
+	c, err := NewController(path, "me", controlCh)
+	if err != nil {
+		t.Fatalf("NewController: %v", err)
+	}
+	c.writeDebounceMs = 5
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go c.Run(ctx)
+
+	sendCmd(t, controlCh, "JOIN", "#chess") // v2
+	res, err := c.UpdateMeta(ctx, types.ChannelEntry{Name: "#chess", Priority: 5}, "test")
+	if err != nil || !res.Changed || res.Version != 3 {
+		t.Fatalf("UpdateMeta = %+v, %v; want changed at version 3", res, err)
+	}
+	if last := c.History(3, 3); len(last) != 1 || last[0].Op != "META" || last[0].Channel != "#chess" {
+		t.Fatalf("journal at version 3 = %+v", last)
+	}
+
+	added, removed, err := c.Diff(2, res.Version)
+	if err != nil || len(added)+len(removed) != 0 {
+		t.Fatalf("diff(2,%d) = +%v -%v err=%v", res.Version, added, removed, err)
+	}
+	restored, err := c.Restore(ctx, res.Version, "test")
+	if err != nil || restored.Changed || restored.Version != res.Version {
+		t.Fatalf("restore(%d) = %+v err=%v", res.Version, restored, err)
+	}
+	if _, chans, _, _ := c.Snapshot(); len(chans) != 1 || chans[0] != "#chess" {
+		t.Fatalf("channels after restore = %v", chans)
+	}
+}
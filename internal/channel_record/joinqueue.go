@@ -0,0 +1,79 @@
+package channelrecord
+
+import (
+	"container/heap"
+	"time"
+)
+
+// joinCandidate is a channel eligible for a JOIN in the current reconcile.
+type joinCandidate struct {
+	name     string
+	s        *chanState
+	priority int
+	retry    bool      // has failed before and is not yet aged into the first-attempt class
+	due      time.Time // when it became eligible: wantedSince or nextTryAt
+}
+
+// joinQueue orders candidates by priority (high first), then first attempts
+// before retries so repeatedly failing channels cannot starve new ones, then
+// by how long they have been due, then by name for determinism.
+type joinQueue []*joinCandidate
+
+func (q joinQueue) Len() int { return len(q) }
+
+func (q joinQueue) Less(i, j int) bool {
+	a, b := q[i], q[j]
+	if a.priority != b.priority {
+		return a.priority > b.priority
+	}
+	if a.retry != b.retry {
+		return !a.retry
+	}
+	if !a.due.Equal(b.due) {
+		return a.due.Before(b.due)
+	}
+	return a.name < b.name
+}
+
+func (q joinQueue) Swap(i, j int) { q[i], q[j] = q[j], q[i] }
+
+func (q *joinQueue) Push(x any) { *q = append(*q, x.(*joinCandidate)) }
+
+func (q *joinQueue) Pop() any {
+	old := *q
+	n := len(old)
+	c := old[n-1]
+	old[n-1] = nil
+	*q = old[:n-1]
+	return c
+}
+
+// joinQueue collects the channels that want a JOIN now. A retry that has
+// been due for longer than cfg.RetryAging competes as a first attempt, so
+// fairness never turns into starvation in the other direction.
+func (r *reconciler) joinQueue(now time.Time) *joinQueue {
+	q := make(joinQueue, 0, len(r.state))
+	for name, s := range r.state {
+		if !s.want || s.have {
+			continue
+		}
+		var c *joinCandidate
+		switch {
+		case s.phase == Idle:
+			c = &joinCandidate{name: name, s: s, priority: s.priority, due: s.wantedSince}
+			if s.failures > 0 {
+				c.retry, c.due = true, s.nextTryAt
+			}
+		case s.phase == Error && now.After(s.nextTryAt):
+			c = &joinCandidate{name: name, s: s, priority: s.priority, retry: true, due: s.nextTryAt}
+		default:
+			continue
+		}
+		if c.retry && r.cfg.RetryAging > 0 && now.Sub(c.due) > r.cfg.RetryAging {
+			c.retry = false
+		}
+		q = append(q, c)
+	}
+	heap.Init(&q)
+	return &q
+}
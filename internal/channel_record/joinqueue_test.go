@@ -0,0 +1,122 @@
+package channelrecord
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Jamie-38/stream-pipeline/internal/observe"
+	"github.com/Jamie-38/stream-pipeline/internal/types"
+)
+
+func newQueueRig(t *testing.T, clk *fakeClock, tokens int) (*reconciler, chan types.IRCCommand) {
+	t.Helper()
+	cfg := NewDefaultConfig()
+	cfg.TokensPerSecond = 0.0001
+	cfg.Burst = tokens
+	cfg.BackoffMin = time.Second
+	cfg.RetryAging = time.Minute
+	out := make(chan types.IRCCommand, 16)
+	return &reconciler{
+		desired:     newDesiredStub("me", nil, clk.Now()),
+		out:         out,
+		cfg:         cfg,
+		state:       make(map[string]*chanState),
+		tokenBucket: newBucket(cfg.TokensPerSecond, cfg.Burst, clk),
+		lg:          observe.C("rectifier_test"),
+		clk:         clk,
+	}, out
+}
+
+func drain(out chan types.IRCCommand) []string {
+	var got []string
+	for len(out) > 0 {
+		got = append(got, (<-out).Channel)
+	}
+	return got
+}
+
+func TestJoinQueue_OrdersByPriorityThenFairnessThenAge(t *testing.T) {
+	clk := newFakeClock(time.Unix(1_700_000_000, 0))
+	r, out := newQueueRig(t, clk, 4)
+	now := clk.Now()
+
+	// failing channel, retry due
+	f := r.ensure("#flaky")
+	f.want, f.phase, f.failures, f.nextTryAt, f.wantedSince = true, Error, 3, now.Add(-time.Second), now.Add(-time.Hour)
+	// two first attempts, #old wanted earlier
+	o := r.ensure("#old")
+	o.want, o.wantedSince = true, now.Add(-10*time.Second)
+	n := r.ensure("#new")
+	n.want, n.wantedSince = true, now.Add(-5*time.Second)
+	// high priority retry still goes first
+	v := r.ensure("#vip")
+	v.want, v.priority, v.phase, v.failures, v.nextTryAt = true, 5, Error, 1, now.Add(-time.Millisecond)
+
+	r.reconcile(now)
+
+	got := drain(out)
+	want := []string{"#vip", "#old", "#new", "#flaky"}
+	if len(got) != len(want) {
+		t.Fatalf("order = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("order = %v, want %v", got, want)
+		}
+	}
+}
+
+func TestJoinQueue_FailingChannelsCannotStarveFirstAttempts(t *testing.T) {
+	clk := newFakeClock(time.Unix(1_700_000_000, 0))
+	r, out := newQueueRig(t, clk, 1)
+	now := clk.Now()
+
+	for _, ch := range []string{"#a", "#b", "#c"} {
+		s := r.ensure(ch)
+		s.want, s.phase, s.failures, s.nextTryAt = true, Error, 5, now.Add(-time.Second)
+	}
+	fresh := r.ensure("#fresh")
+	fresh.want, fresh.wantedSince = true, now
+
+	r.reconcile(now)
+	if got := drain(out); len(got) != 1 || got[0] != "#fresh" {
+		t.Fatalf("single token went to %v, want #fresh", got)
+	}
+}
+
+func TestJoinQueue_AgedRetryCompetesWithFirstAttempts(t *testing.T) {
+	clk := newFakeClock(time.Unix(1_700_000_000, 0))
+	r, out := newQueueRig(t, clk, 1)
+	now := clk.Now()
+
+	old := r.ensure("#stale")
+	old.want, old.phase, old.failures, old.nextTryAt = true, Error, 9, now.Add(-2*r.cfg.RetryAging)
+	fresh := r.ensure("#fresh")
+	fresh.want, fresh.wantedSince = true, now.Add(-time.Second)
+
+	r.reconcile(now)
+	if got := drain(out); len(got) != 1 || got[0] != "#stale" {
+		t.Fatalf("single token went to %v, want aged #stale", got)
+	}
+}
+
+func TestObserveDesired_TracksWantedSince(t *testing.T) {
+	clk := newFakeClock(time.Unix(1_700_000_000, 0))
+	r, _ := newQueueRig(t, clk, 1)
+	ds := newDesiredStub("me", []string{"#a"}, clk.Now())
+	r.desired = ds
+
+	r.observeDesired()
+	first := r.state["#a"].wantedSince
+
+	clk.Advance(time.Minute)
+	ds.v, ds.chs = 2, []string{"#a", "#b"}
+	r.observeDesired()
+
+	if !r.state["#a"].wantedSince.Equal(first) {
+		t.Fatal("wantedSince of a still-wanted channel must not move")
+	}
+	if !r.state["#b"].wantedSince.Equal(clk.Now()) {
+		t.Fatal("newly wanted channel should record the current time")
+	}
+}
@@ -0,0 +1,154 @@
+package channelrecord
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/Jamie-38/stream-pipeline/internal/types"
+)
+
+// journal is the append-only history of desired-set changes kept next to
+// channels.json. Entries are cached in memory for queries; the controller is
+// the only writer.
+type journal struct {
+	path    string
+	mu      sync.RWMutex
+	entries []types.ChannelJournalEntry
+}
+
+func openJournal(path string) (*journal, error) {
+	j := &journal{path: path}
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return j, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("open journal: %w", err)
+	}
+	defer f.Close()
+
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for n := 1; sc.Scan(); n++ {
+		if len(sc.Bytes()) == 0 {
+			continue
+		}
+		var e types.ChannelJournalEntry
+		if err := json.Unmarshal(sc.Bytes(), &e); err != nil {
+			return nil, fmt.Errorf("journal %s line %d: %w", path, n, err)
+		}
+		j.entries = append(j.entries, e)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("read journal: %w", err)
+	}
+	return j, nil
+}
+
+func (j *journal) append(es ...types.ChannelJournalEntry) error {
+	if len(es) == 0 {
+		return nil
+	}
+	f, err := os.OpenFile(j.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return fmt.Errorf("open journal: %w", err)
+	}
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	for i := range es {
+		if err := enc.Encode(&es[i]); err != nil {
+			_ = f.Close()
+			return fmt.Errorf("encode journal entry: %w", err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		_ = f.Close()
+		return fmt.Errorf("write journal: %w", err)
+	}
+	if err := f.Sync(); err != nil {
+		_ = f.Close()
+		return fmt.Errorf("fsync journal: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("close journal: %w", err)
+	}
+
+	j.mu.Lock()
+	j.entries = append(j.entries, es...)
+	j.mu.Unlock()
+	return nil
+}
+
+func (j *journal) lastVersion() uint64 {
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+	if len(j.entries) == 0 {
+		return 0
+	}
+	return j.entries[len(j.entries)-1].Version
+}
+
+// between returns entries with from <= version <= to; to == 0 means no upper bound.
+func (j *journal) between(from, to uint64) []types.ChannelJournalEntry {
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+	out := []types.ChannelJournalEntry{}
+	for _, e := range j.entries {
+		if e.Version < from || (to != 0 && e.Version > to) {
+			continue
+		}
+		e.Channels = append([]string(nil), e.Channels...)
+		out = append(out, e)
+	}
+	return out
+}
+
+// setAt replays the journal up to and including version.
+func (j *journal) setAt(version uint64) (map[string]struct{}, error) {
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+	if len(j.entries) == 0 || version < j.entries[0].Version {
+		return nil, fmt.Errorf("version %d not in journal", version)
+	}
+	if last := j.entries[len(j.entries)-1].Version; version > last {
+		return nil, fmt.Errorf("version %d is newer than latest %d", version, last)
+	}
+	set := make(map[string]struct{})
+	for _, e := range j.entries {
+		if e.Version > version {
+			break
+		}
+		switch e.Op {
+		case "RESET":
+			set = sliceToSet(e.Channels)
+		case "JOIN":
+			set[e.Channel] = struct{}{}
+		case "PART":
+			delete(set, e.Channel)
+		case "META":
+			// metadata edits only advance the version
+		}
+	}
+	return set, nil
+}
+
+// diffSets returns channels in b but not a (added) and in a but not b (removed).
+func diffSets(a, b map[string]struct{}) (added, removed []string) {
+	add := make(map[string]struct{})
+	rem := make(map[string]struct{})
+	for ch := range b {
+		if _, ok := a[ch]; !ok {
+			add[ch] = struct{}{}
+		}
+	}
+	for ch := range a {
+		if _, ok := b[ch]; !ok {
+			rem[ch] = struct{}{}
+		}
+	}
+	return setToSortedSlice(add), setToSortedSlice(rem)
+}
@@ -0,0 +1,159 @@
+package channelrecord
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/Jamie-38/stream-pipeline/internal/types"
+)
+
+// document is channels.json decoded only far enough for migrations to
+// rewrite individual fields while passing unknown ones through.
+type document map[string]json.RawMessage
+
+// migration upgrades a document from schema `from` to from+1.
+type migration struct {
+	from     int
+	describe string
+	up       func(doc document) error
+}
+
+// migrations must form a contiguous chain ending at types.ChannelsSchema.
+var migrations = []migration{
+	{from: 0, describe: "stamp schema field on pre-versioned files", up: migrate0to1},
+	{from: 1, describe: "channel names to per-channel objects", up: migrate1to2},
+}
+
+var errNewerSchema = errors.New("channels file was written by a newer schema")
+
+func docSchema(doc document) (int, error) {
+	raw, ok := doc["schema"]
+	if !ok || string(raw) == "null" {
+		return 0, nil
+	}
+	var v int
+	if err := json.Unmarshal(raw, &v); err != nil {
+		return 0, fmt.Errorf("schema field: %w", err)
+	}
+	return v, nil
+}
+
+func setField(doc document, key string, v any) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	doc[key] = b
+	return nil
+}
+
+func migrate0to1(doc document) error {
+	if _, ok := doc["channels"]; !ok {
+		if err := setField(doc, "channels", []string{}); err != nil {
+			return err
+		}
+	}
+	return setField(doc, "schema", 1)
+}
+
+func migrate1to2(doc document) error {
+	var names []string
+	if raw, ok := doc["channels"]; ok && string(raw) != "null" {
+		if err := json.Unmarshal(raw, &names); err != nil {
+			return fmt.Errorf("channels: %w", err)
+		}
+	}
+	entries := make([]types.ChannelEntry, 0, len(names))
+	seen := make(map[string]struct{}, len(names))
+	for _, n := range names {
+		ch, ok := normalizeChannel(n)
+		if !ok {
+			continue
+		}
+		if _, dup := seen[ch]; dup {
+			continue
+		}
+		seen[ch] = struct{}{}
+		entries = append(entries, types.ChannelEntry{Name: ch})
+	}
+	if err := setField(doc, "channels", entries); err != nil {
+		return err
+	}
+	return setField(doc, "schema", 2)
+}
+
+// migrateBytes upgrades raw channels.json content to the current schema. It
+// returns the input unchanged (and from == types.ChannelsSchema) when no
+// migration is needed, and errNewerSchema for files it does not understand.
+func migrateBytes(raw []byte) (out []byte, from int, err error) {
+	var doc document
+	if err := json.Unmarshal(raw, &doc); err != nil {
+		return nil, 0, fmt.Errorf("decode: %w", err)
+	}
+	from, err = docSchema(doc)
+	if err != nil {
+		return nil, 0, err
+	}
+	if from > types.ChannelsSchema {
+		return nil, from, fmt.Errorf("%w: file schema %d, supported %d", errNewerSchema, from, types.ChannelsSchema)
+	}
+	if from == types.ChannelsSchema {
+		return raw, from, nil
+	}
+
+	for v := from; v < types.ChannelsSchema; v++ {
+		m, ok := findMigration(v)
+		if !ok {
+			return nil, from, fmt.Errorf("no migration from schema %d", v)
+		}
+		if err := m.up(doc); err != nil {
+			return nil, from, fmt.Errorf("migrate schema %d→%d (%s): %w", v, v+1, m.describe, err)
+		}
+	}
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		return nil, from, fmt.Errorf("encode migrated: %w", err)
+	}
+	return buf.Bytes(), from, nil
+}
+
+func findMigration(from int) (migration, bool) {
+	for _, m := range migrations {
+		if m.from == from {
+			return m, true
+		}
+	}
+	return migration{}, false
+}
+
+// backupOriginal keeps the pre-migration file as <path>.v<schema>.bak,
+// never overwriting an earlier backup.
+func backupOriginal(path string, raw []byte, schema int) (string, error) {
+	base := fmt.Sprintf("%s.v%d.bak", path, schema)
+	dst := base
+	for i := 1; ; i++ {
+		f, err := os.OpenFile(dst, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+		if errors.Is(err, os.ErrExist) {
+			dst = fmt.Sprintf("%s.%d", base, i)
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		if _, err := f.Write(raw); err != nil {
+			_ = f.Close()
+			return "", err
+		}
+		if err := f.Sync(); err != nil {
+			_ = f.Close()
+			return "", err
+		}
+		return dst, f.Close()
+	}
+}
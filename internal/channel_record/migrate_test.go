@@ -0,0 +1,116 @@
+package channelrecord
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/Jamie-38/stream-pipeline/internal/types"
+)
+
+var updateGolden = flag.Bool("update", false, "rewrite migration golden files")
+
+// runStep applies exactly one migration to input and renders it the way
+// migrateBytes does.
+func runStep(t *testing.T, m migration, input []byte) []byte {
+	t.Helper()
+	var doc document
+	if err := json.Unmarshal(input, &doc); err != nil {
+		t.Fatalf("decode input: %v", err)
+	}
+	if got, err := docSchema(doc); err != nil || got != m.from {
+		t.Fatalf("input schema = %d (%v), want %d", got, err, m.from)
+	}
+	if err := m.up(doc); err != nil {
+		t.Fatalf("migration %d: %v", m.from, err)
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestMigrations_Golden(t *testing.T) {
+	for _, m := range migrations {
+		in := filepath.Join("testdata", "migrations", "v"+strconv.Itoa(m.from)+".input.json")
+		golden := filepath.Join("testdata", "migrations", "v"+strconv.Itoa(m.from)+"_to_v"+strconv.Itoa(m.from+1)+".golden.json")
+
+		input, err := os.ReadFile(in)
+		if err != nil {
+			t.Fatalf("missing input for migration %d: %v", m.from, err)
+		}
+		got := runStep(t, m, input)
+		if *updateGolden {
+			if err := os.WriteFile(golden, got, 0o644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		want, err := os.ReadFile(golden)
+		if err != nil {
+			t.Fatalf("read golden (run with -update): %v", err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("migration %d→%d mismatch\ngot:\n%s\nwant:\n%s", m.from, m.from+1, got, want)
+		}
+	}
+}
+
+func TestMigrations_ChainIsContiguous(t *testing.T) {
+	for v := 0; v < types.ChannelsSchema; v++ {
+		if _, ok := findMigration(v); !ok {
+			t.Fatalf("no migration from schema %d", v)
+		}
+	}
+}
+
+func TestMigrateBytes_RefusesNewerSchema(t *testing.T) {
+	_, _, err := migrateBytes([]byte(`{"schema":99}`))
+	if !errors.Is(err, errNewerSchema) {
+		t.Fatalf("err = %v, want errNewerSchema", err)
+	}
+}
+
+func TestNewController_MigratesAndBacksUp(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "channels.json")
+	orig, err := os.ReadFile(filepath.Join("testdata", "migrations", "v0.input.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, orig, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := NewController(path, "me", make(chan types.IRCCommand))
+	if err != nil {
+		t.Fatalf("NewController: %v", err)
+	}
+	if _, chans, _, _ := c.Snapshot(); len(chans) != 2 || chans[0] != "#chess" || chans[1] != "#speedrun" {
+		t.Fatalf("channels = %v", chans)
+	}
+
+	bak, err := os.ReadFile(path + ".v0.bak")
+	if err != nil || !bytes.Equal(bak, orig) {
+		t.Fatalf("backup = %q, %v; want original bytes", bak, err)
+	}
+	var onDisk types.Channels
+	b, _ := os.ReadFile(path)
+	if err := json.Unmarshal(b, &onDisk); err != nil || onDisk.Schema != types.ChannelsSchema {
+		t.Fatalf("rewritten file schema = %d (%v)", onDisk.Schema, err)
+	}
+
+	if err := os.WriteFile(path, []byte(`{"schema":99,"account":"me"}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewController(path, "me", make(chan types.IRCCommand)); !errors.Is(err, errNewerSchema) {
+		t.Fatalf("newer schema err = %v, want errNewerSchema", err)
+	}
+}
@@ -1,19 +1,42 @@
 package channelrecord
 
 import (
+	"cmp"
+	"container/heap"
 	"context"
+	"fmt"
 	"strings"
 	"time"
 
 	"log/slog"
 
+	"github.com/Jamie-38/stream-pipeline/internal/healthcheck"
 	"github.com/Jamie-38/stream-pipeline/internal/observe"
 	"github.com/Jamie-38/stream-pipeline/internal/types"
 )
 
+var (
+	phaseGauge = observe.Metrics().Gauge("rectifier_channels",
+		"Channels tracked by the rectifier, by phase.", "account", "phase")
+	joinLatency = observe.Metrics().Histogram("rectifier_join_latency_seconds",
+		"Time from emitting a JOIN to its membership confirmation.", observe.DefBuckets, "account")
+	retries = observe.Metrics().Counter("rectifier_retries_total",
+		"JOIN and PART attempts that timed out and were scheduled for retry with backoff.", "account", "op")
+	tokensGauge = observe.Metrics().Gauge("rectifier_tokens_available",
+		"JOIN/PART commands the rate limiter would admit right now.", "account")
+)
+
 type DesiredSnapshot interface {
 	Snapshot() (version uint64, channels []string, updatedAt time.Time, account string)
 	Updates() <-chan struct{}
+	Priority(channel string) int
+}
+
+// Limiter admits JOIN and PART commands. *ratelimit.Window satisfies it;
+// without one the rectifier falls back to a token bucket.
+type Limiter interface {
+	Take(now time.Time) bool
+	Refund(now time.Time)
 }
 
 type Config struct {
@@ -23,6 +46,20 @@ type Config struct {
 	BackoffMin      time.Duration
 	BackoffMax      time.Duration
 	Tick            time.Duration
+	// RetryAging lets a retry that has been due this long compete with
+	// first attempts again; 0 disables aging.
+	RetryAging time.Duration
+	// Limiter, when set, replaces the TokensPerSecond/Burst bucket.
+	Limiter Limiter
+	// MinJoinedRatio is the share of desired channels that must be joined
+	// for the rectifier to report ready.
+	MinJoinedRatio float64
+	// HealthName is the health component it reports as; "" means
+	// "rectifier".
+	HealthName string
+	// Retune delivers new tunables while Run is going. Their Limiter,
+	// HealthName and Retune are ignored.
+	Retune <-chan Config
 }
 
 func NewDefaultConfig() Config {
@@ -33,6 +70,8 @@ func NewDefaultConfig() Config {
 		BackoffMin:      2 * time.Second,
 		BackoffMax:      60 * time.Second,
 		Tick:            1 * time.Second,
+		RetryAging:      5 * time.Minute,
+		MinJoinedRatio:  0.8,
 	}
 }
 
@@ -54,13 +93,19 @@ func Run(ctx context.Context, desired DesiredSnapshot, events <-chan types.Membe
 		out:          out,
 		cfg:          cfg,
 		state:        make(map[string]*chanState),
-		tokenBucket:  newBucket(cfg.TokensPerSecond, cfg.Burst, realClock{}),
+		tokenBucket:  cfg.Limiter,
 		lastDesiredV: 0,
 		lg:           lg,
 		clk:          realClock{},
+		account:      acct,
+		health:       healthcheck.Default().Component(cmp.Or(cfg.HealthName, "rectifier")),
+	}
+
+	if r.tokenBucket == nil {
+		r.tokenBucket = newBucket(cfg.TokensPerSecond, cfg.Burst, realClock{})
 	}
 
-	lg.Info("rectifier starting")
+	lg.Info("rectifier starting", "custom_limiter", cfg.Limiter != nil)
 	err := r.loop(ctx)
 	if err != nil {
 		lg.Error("rectifier stopped", "err", err)
@@ -97,14 +142,19 @@ const (
 	Error
 )
 
+var phases = []phase{Idle, Joining, Joined, Parting, Error}
+
 type chanState struct {
-	want      bool
-	have      bool
-	phase     phase
-	lastTry   time.Time
-	deadline  time.Time
-	backoff   time.Duration
-	nextTryAt time.Time
+	priority    int // higher joins first when rate-limited
+	want        bool
+	have        bool
+	phase       phase
+	wantedSince time.Time // when want last became true
+	failures    int       // consecutive timed-out attempts
+	lastTry     time.Time
+	deadline    time.Time
+	backoff     time.Duration
+	nextTryAt   time.Time
 }
 
 type reconciler struct {
@@ -113,10 +163,12 @@ type reconciler struct {
 	out          chan<- types.IRCCommand
 	cfg          Config
 	state        map[string]*chanState
-	tokenBucket  *bucket
+	tokenBucket  Limiter
 	lastDesiredV uint64
 	lg           *slog.Logger
 	clk          Clock
+	account      string                 // metrics label
+	health       *healthcheck.Component // nil in tests
 }
 
 func (r *reconciler) loop(ctx context.Context) error {
@@ -126,6 +178,9 @@ func (r *reconciler) loop(ctx context.Context) error {
 	updates := r.desired.Updates()
 
 	for {
+		if r.health != nil {
+			r.health.Beat()
+		}
 		select {
 		case <-ctx.Done():
 			r.lg.Info("rectifier stopping: context canceled")
@@ -144,6 +199,12 @@ func (r *reconciler) loop(ctx context.Context) error {
 			r.lg.Debug("membership event", "op", evt.Op, "channel", evt.Channel)
 			r.observeEvent(evt)
 			r.reconcile(r.clk.Now())
+
+		case c := <-r.cfg.Retune:
+			if r.retune(c) {
+				tick.Reset(r.cfg.Tick)
+			}
+			r.reconcile(r.clk.Now())
 		}
 	}
 }
@@ -154,13 +215,19 @@ func (r *reconciler) observeDesired() {
 		return
 	}
 	r.lg.Info("desired set changed", "version", v, "channels", len(chans))
+	now := r.clk.Now()
+	prev := make(map[string]bool, len(r.state))
 	for name, s := range r.state {
+		prev[name] = s.want
 		s.want = false
-		_ = name
 	}
 	for _, ch := range chans {
 		s := r.ensure(ch)
+		if !prev[ch] {
+			s.wantedSince = now
+		}
 		s.want = true
+		s.priority = r.desired.Priority(ch)
 	}
 	r.lastDesiredV = v
 }
@@ -174,8 +241,12 @@ func (r *reconciler) observeEvent(evt types.MembershipEvent) {
 		}
 		s := r.ensure(ch)
 		if !s.have {
+			if s.phase == Joining {
+				joinLatency.With(r.account).Observe(r.clk.Now().Sub(s.lastTry).Seconds())
+			}
 			s.have = true
 			s.phase = Joined
+			s.failures = 0
 			r.lg.Info("join confirmed", "channel", ch)
 		}
 	case "PART":
@@ -189,6 +260,20 @@ func (r *reconciler) observeEvent(evt types.MembershipEvent) {
 			s.phase = Idle
 			r.lg.Info("part confirmed", "channel", ch)
 		}
+	case "DISCONNECT":
+		// a new connection starts in no channel; rejoin without backoff
+		n := 0
+		for _, s := range r.state {
+			if s.have || s.phase == Joining {
+				n++
+			}
+			s.have = false
+			s.phase = Idle
+			s.failures = 0
+			s.backoff = r.cfg.BackoffMin
+			s.nextTryAt = time.Time{}
+		}
+		r.lg.Info("connection lost; rejoining", "channels", n)
 	default:
 		// ignore
 	}
@@ -210,22 +295,55 @@ func (r *reconciler) reconcile(now time.Time) {
 		r.maybeTimeout(now, s)
 	}
 
-	for name, s := range r.state {
-		if !s.want {
-			continue
+	for _, s := range r.state {
+		if s.want {
+			r.maybeTimeout(now, s)
 		}
-		if !s.have && (s.phase == Idle || s.phase == Error && now.After(s.nextTryAt)) {
-			r.lg.Debug("trying JOIN", "channel", name, "phase", s.phase.String())
-			if r.trySend(now, "JOIN", name, s) {
-				continue
+	}
+
+	// JOINs drain a priority queue so scarce tokens go to the channels that
+	// matter most; once a send fails the bucket or out channel is exhausted.
+	q := r.joinQueue(now)
+	for q.Len() > 0 {
+		c := heap.Pop(q).(*joinCandidate)
+		r.lg.Debug("trying JOIN", "channel", c.name, "phase", c.s.phase.String(),
+			"priority", c.priority, "retry", c.retry)
+		if !r.trySend(now, "JOIN", c.name, c.s) {
+			break
+		}
+	}
+
+	r.record(now)
+}
+
+// record publishes phase counts, limiter headroom and readiness after a
+// pass.
+func (r *reconciler) record(now time.Time) {
+	counts := make(map[phase]int, len(phases))
+	wanted, joined := 0, 0
+	for _, s := range r.state {
+		counts[s.phase]++
+		if s.want {
+			wanted++
+			if s.have {
+				joined++
 			}
 		}
-		r.maybeTimeout(now, s)
+	}
+	if r.health != nil {
+		ok := wanted == 0 || float64(joined) >= r.cfg.MinJoinedRatio*float64(wanted)
+		r.health.SetReady(ok, fmt.Sprintf("joined %d/%d desired channels", joined, wanted))
+	}
+	for _, p := range phases {
+		phaseGauge.With(r.account, strings.ToLower(p.String())).Set(float64(counts[p]))
+	}
+	if rem, ok := r.tokenBucket.(interface{ Remaining(time.Time) int }); ok {
+		tokensGauge.With(r.account).Set(float64(rem.Remaining(now)))
 	}
 }
 
 func (r *reconciler) trySend(now time.Time, op string, channel string, s *chanState) bool {
-	if !r.tokenBucket.take(now) {
+	if !r.tokenBucket.Take(now) {
 		r.lg.Debug("rate-limited; skipping for now", "op", op, "channel", channel)
 		return false
 	}
@@ -248,7 +366,7 @@ func (r *reconciler) trySend(now time.Time, op string, channel string, s *chanSt
 		r.lg.Info("command emitted", "op", op, "channel", channel, "deadline_s", r.cfg.JoinTimeout.Seconds())
 		return true
 	default:
-		r.tokenBucket.refund(now)
+		r.tokenBucket.Refund(now)
 		r.lg.Warn("out channel full; command not emitted", "op", op, "channel", channel)
 		return false
 	}
@@ -259,7 +377,9 @@ func (r *reconciler) maybeTimeout(now time.Time, s *chanState) {
 		op := s.phase.String()
 
 		s.phase = Error
+		s.failures++
 		s.nextTryAt = now.Add(s.backoff)
+		retries.With(r.account, strings.ToLower(op)).Inc()
 
 		r.lg.Info("operation timed out; scheduling retry",
 			"phase", op,
@@ -273,6 +393,35 @@ func (r *reconciler) maybeTimeout(now time.Time, s *chanState) {
 	}
 }
 
+// retune adopts the tunables of c and reports whether the tick changed.
+// Pending backoffs are clamped into the new bounds; the token bucket is
+// rebuilt unless a custom limiter governs commands.
+func (r *reconciler) retune(c Config) bool {
+	old := r.cfg
+	r.cfg.TokensPerSecond, r.cfg.Burst = c.TokensPerSecond, c.Burst
+	r.cfg.JoinTimeout = c.JoinTimeout
+	r.cfg.BackoffMin, r.cfg.BackoffMax = c.BackoffMin, c.BackoffMax
+	r.cfg.Tick = c.Tick
+	r.cfg.RetryAging = c.RetryAging
+	r.cfg.MinJoinedRatio = c.MinJoinedRatio
+
+	if r.cfg.Limiter == nil && (old.TokensPerSecond != c.TokensPerSecond || old.Burst != c.Burst) {
+		r.tokenBucket = newBucket(c.TokensPerSecond, c.Burst, r.clk)
+	}
+	for _, s := range r.state {
+		s.backoff = min(max(s.backoff, r.cfg.BackoffMin), r.cfg.BackoffMax)
+	}
+	r.lg.Info("rectifier retuned",
+		"tokens_per_sec", c.TokensPerSecond,
+		"burst", c.Burst,
+		"join_timeout_s", c.JoinTimeout.Seconds(),
+		"backoff_min_s", c.BackoffMin.Seconds(),
+		"backoff_max_s", c.BackoffMax.Seconds(),
+		"tick_ms", c.Tick.Milliseconds(),
+	)
+	return old.Tick != c.Tick
+}
+
 func (r *reconciler) ensure(ch string) *chanState {
 	if st, ok := r.state[ch]; ok {
 		return st
@@ -318,7 +467,7 @@ func (b *bucket) refill(now time.Time) {
 	}
 }
 
-func (b *bucket) take(now time.Time) bool {
+func (b *bucket) Take(now time.Time) bool {
 	b.refill(now)
 	if b.tokens >= 1.0 {
 		b.tokens -= 1.0
@@ -327,7 +476,13 @@ func (b *bucket) take(now time.Time) bool {
 	return false
 }
 
-func (b *bucket) refund(now time.Time) {
+// Remaining reports how many whole tokens are available at now.
+func (b *bucket) Remaining(now time.Time) int {
+	b.refill(now)
+	return int(b.tokens)
+}
+
+func (b *bucket) Refund(now time.Time) {
 	b.refill(now)
 	b.tokens++
 	if b.tokens > b.capacity {
@@ -4,6 +4,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/Jamie-38/stream-pipeline/internal/healthcheck"
 	"github.com/Jamie-38/stream-pipeline/internal/observe"
 	"github.com/Jamie-38/stream-pipeline/internal/types"
 )
@@ -13,6 +14,7 @@ type desiredStub struct {
 	chs     []string
 	t       time.Time
 	acct    string
+	prio    map[string]int
 	updates chan struct{}
 }
 
@@ -34,6 +36,8 @@ func (d *desiredStub) Snapshot() (uint64, []string, time.Time, string) {
 
 func (d *desiredStub) Updates() <-chan struct{} { return d.updates }
 
+func (d *desiredStub) Priority(ch string) int { return d.prio[ch] }
+
 // test
 func TestRectifier_JoinRetryAndConfirm(t *testing.T) {
 	start := time.Unix(1_700_000_000, 0)
@@ -60,6 +64,7 @@ func TestRectifier_JoinRetryAndConfirm(t *testing.T) {
 		tokenBucket:  newBucket(cfg.TokensPerSecond, cfg.Burst, clk),
 		lastDesiredV: 0,
 		lg:           observe.C("rectifier_test"),
+		clk:          clk,
 	}
 
 	r.observeDesired()
@@ -166,3 +171,152 @@ func TestRectifier_PartConfirmResetsHave(t *testing.T) {
 		t.Fatalf("expected have=false after PART confirm, got %+v", st)
 	}
 }
+
+func TestRectifier_JoinsHigherPriorityFirst(t *testing.T) {
+	clk := newFakeClock(time.Unix(1_700_000_000, 0))
+
+	cfg := NewDefaultConfig()
+	cfg.TokensPerSecond = 0.001
+	cfg.Burst = 1
+
+	ds := newDesiredStub("me", []string{"#a", "#b", "#vip"}, clk.Now())
+	ds.prio = map[string]int{"#vip": 10, "#b": 1}
+	out := make(chan types.IRCCommand, 4)
+
+	r := &reconciler{
+		desired:     ds,
+		out:         out,
+		cfg:         cfg,
+		state:       make(map[string]*chanState),
+		tokenBucket: newBucket(cfg.TokensPerSecond, cfg.Burst, clk),
+		lg:          observe.C("rectifier_test"),
+		clk:         clk,
+	}
+	r.observeDesired()
+	r.reconcile(clk.Now())
+
+	if len(out) != 1 {
+		t.Fatalf("emitted %d commands with one token, want 1", len(out))
+	}
+	if cmd := <-out; cmd.Channel != "#vip" {
+		t.Fatalf("first JOIN = %s, want #vip", cmd.Channel)
+	}
+}
+
+func TestRectifier_ReadyOnceEnoughChannelsJoined(t *testing.T) {
+	clk := newFakeClock(time.Unix(1_700_000_000, 0))
+	cfg := NewDefaultConfig()
+	cfg.TokensPerSecond = 100
+	cfg.Burst = 10
+	cfg.MinJoinedRatio = 0.5
+
+	health := healthcheck.NewRegistry()
+	ds := newDesiredStub("me", []string{"#a", "#b"}, clk.Now())
+	r := &reconciler{
+		desired:     ds,
+		out:         make(chan types.IRCCommand, 4),
+		cfg:         cfg,
+		state:       make(map[string]*chanState),
+		tokenBucket: newBucket(cfg.TokensPerSecond, cfg.Burst, clk),
+		lg:          observe.C("rectifier_test"),
+		clk:         clk,
+		health:      health.Register("rectifier", healthcheck.Options{}),
+	}
+	r.observeDesired()
+	r.reconcile(clk.Now())
+	if ready, _, _ := health.Check(clk.Now()); ready {
+		t.Fatal("ready with no channels joined")
+	}
+
+	r.observeEvent(types.MembershipEvent{Op: "JOIN", Channel: "#a"})
+	r.reconcile(clk.Now())
+	ready, _, rep := health.Check(clk.Now())
+	if !ready || rep.Components["rectifier"].Detail != "joined 1/2 desired channels" {
+		t.Fatalf("rectifier status = %+v", rep.Components["rectifier"])
+	}
+}
+
+func TestRectifier_RetuneRebuildsBucketAndClampsBackoff(t *testing.T) {
+	clk := newFakeClock(time.Unix(1_700_000_000, 0))
+	cfg := NewDefaultConfig()
+	cfg.TokensPerSecond = 0.001
+	cfg.Burst = 1
+
+	ds := newDesiredStub("me", []string{"#a", "#b", "#c"}, clk.Now())
+	out := make(chan types.IRCCommand, 8)
+	r := &reconciler{
+		desired:     ds,
+		out:         out,
+		cfg:         cfg,
+		state:       make(map[string]*chanState),
+		tokenBucket: newBucket(cfg.TokensPerSecond, cfg.Burst, clk),
+		lg:          observe.C("rectifier_test"),
+		clk:         clk,
+	}
+	r.observeDesired()
+	r.reconcile(clk.Now())
+	if len(out) != 1 {
+		t.Fatalf("emitted %d commands with one token, want 1", len(out))
+	}
+	r.state["#a"].backoff = time.Hour
+
+	next := cfg
+	next.TokensPerSecond = 100
+	next.Burst = 10
+	next.BackoffMax = 10 * time.Second
+	if r.retune(next) {
+		t.Fatal("retune reported a tick change")
+	}
+	for ch, st := range r.state {
+		if st.backoff > next.BackoffMax || st.backoff < next.BackoffMin {
+			t.Fatalf("%s backoff %v outside [%v,%v]", ch, st.backoff, next.BackoffMin, next.BackoffMax)
+		}
+	}
+
+	r.reconcile(clk.Now())
+	if len(out) != 3 {
+		t.Fatalf("emitted %d commands after raising the rate, want 3", len(out))
+	}
+
+	next.Tick = 5 * time.Second
+	if !r.retune(next) {
+		t.Fatal("retune missed the tick change")
+	}
+}
+
+func TestRectifier_DisconnectRejoinsEverything(t *testing.T) {
+	clk := newFakeClock(time.Unix(1_700_000_000, 0))
+	cfg := NewDefaultConfig()
+	cfg.TokensPerSecond = 100
+	cfg.Burst = 10
+
+	ds := newDesiredStub("me", []string{"#a", "#b"}, clk.Now())
+	out := make(chan types.IRCCommand, 8)
+	r := &reconciler{
+		desired:     ds,
+		out:         out,
+		cfg:         cfg,
+		state:       make(map[string]*chanState),
+		tokenBucket: newBucket(cfg.TokensPerSecond, cfg.Burst, clk),
+		lg:          observe.C("rectifier_test"),
+		clk:         clk,
+	}
+	r.observeDesired()
+	r.reconcile(clk.Now())
+	r.observeEvent(types.MembershipEvent{Op: "JOIN", Channel: "#a"})
+	r.observeEvent(types.MembershipEvent{Op: "JOIN", Channel: "#b"})
+	for len(out) > 0 {
+		<-out
+	}
+
+	r.observeEvent(types.MembershipEvent{Op: "DISCONNECT"})
+	r.reconcile(clk.Now())
+	if len(out) != 2 {
+		t.Fatalf("emitted %d commands after a disconnect, want 2 JOINs", len(out))
+	}
+	for len(out) > 0 {
+		if cmd := <-out; cmd.Op != "JOIN" {
+			t.Fatalf("command = %+v, want JOIN", cmd)
+		}
+	}
+}
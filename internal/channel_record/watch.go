@@ -0,0 +1,88 @@
+package channelrecord
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/Jamie-38/stream-pipeline/internal/types"
+)
+
+// fileStamp identifies a version of channels.json. Size and mtime are cheap
+// to poll; the hash decides whether a touched file really changed.
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+	hash    [sha256.Size]byte
+}
+
+func stampOf(path string, content []byte) fileStamp {
+	st := fileStamp{size: int64(len(content)), hash: sha256.Sum256(content)}
+	if fi, err := os.Stat(path); err == nil {
+		st.modTime = fi.ModTime()
+		st.size = fi.Size()
+	}
+	return st
+}
+
+// checkExternalEdit polls channels.json and returns its contents when
+// someone other than the controller changed it and the edit is valid.
+// Invalid edits are logged once and otherwise ignored, leaving the last good
+// state in force; the next persisted snapshot overwrites them.
+func (c *Controller) checkExternalEdit() (types.Channels, bool) {
+	fi, err := os.Stat(c.path)
+	if err != nil {
+		c.lg.Warn("channels file not readable", "err", err)
+		return types.Channels{}, false
+	}
+	if fi.ModTime().Equal(c.stamp.modTime) && fi.Size() == c.stamp.size {
+		return types.Channels{}, false
+	}
+
+	b, err := os.ReadFile(c.path)
+	if err != nil {
+		c.lg.Warn("channels file not readable", "err", err)
+		return types.Channels{}, false
+	}
+	prev := c.stamp
+	c.stamp = stampOf(c.path, b)
+	if c.stamp.hash == prev.hash {
+		return types.Channels{}, false
+	}
+
+	edit, err := c.validateEdit(b)
+	if err != nil {
+		c.lg.Error("rejected external edit of channels file; keeping last good state",
+			"err", err, "version", c.readSnap().Version)
+		return types.Channels{}, false
+	}
+	return edit, true
+}
+
+func (c *Controller) validateEdit(b []byte) (types.Channels, error) {
+	var v types.Channels
+	// hand-written files in an older schema are upgraded in memory; the next
+	// persisted snapshot writes them back in the current one
+	b, _, err := migrateBytes(b)
+	if err != nil {
+		return v, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&v); err != nil {
+		return v, fmt.Errorf("decode: %w", err)
+	}
+	if v.Account != c.account {
+		return v, fmt.Errorf("account %q, expected %q", v.Account, c.account)
+	}
+	for _, e := range v.Channels {
+		ch, ok := normalizeChannel(e.Name)
+		if !ok || !types.ValidChannelName(ch) {
+			return v, fmt.Errorf("invalid channel %q", e.Name)
+		}
+	}
+	return v, nil
+}
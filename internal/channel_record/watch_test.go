@@ -0,0 +1,105 @@
+package channelrecord
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Jamie-38/stream-pipeline/internal/types"
+)
+
+func writeChannels(t *testing.T, path string, v types.Channels) {
+	t.Helper()
+	b, _ := json.Marshal(v)
+	writeRaw(t, path, string(b))
+}
+
+func writeRaw(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestController_HotAppliesExternalEdit(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "channels.json")
+	controlCh := make(chan types.IRCCommand, 4)
+	c, err := NewController(path, "me", controlCh)
+	if err != nil {
+		t.Fatalf("NewController: %v", err)
+	}
+	c.writeDebounceMs = 5
+	c.watchInterval = 5 * time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go c.Run(ctx)
+
+	// an operator writing the older flat format is upgraded in memory
+	writeRaw(t, path, `{"schema":1,"account":"me","channels":["#chess","Speedrun"]}`)
+
+	waitFor(t, "edit applied", func() bool {
+		_, chans, _, _ := c.Snapshot()
+		return len(chans) == 2
+	})
+	select {
+	case <-c.Updates():
+	case <-time.After(time.Second):
+		t.Fatal("rectifier was not notified")
+	}
+	hist := c.History(2, 2)
+	if len(hist) != 2 || hist[0].Source != "file" {
+		t.Fatalf("journal = %+v, want two file entries", hist)
+	}
+
+	// wrong account: rejected, state kept
+	writeChannels(t, path, types.Channels{Schema: 2, Account: "someone", Channels: []types.ChannelEntry{{Name: "#x"}}})
+	// bad channel name: rejected
+	time.Sleep(30 * time.Millisecond)
+	writeChannels(t, path, types.Channels{Schema: 2, Account: "me", Channels: []types.ChannelEntry{{Name: "#not a channel"}}})
+	time.Sleep(30 * time.Millisecond)
+	// newer schema: rejected
+	writeRaw(t, path, `{"schema":99,"account":"me","channels":[]}`)
+	time.Sleep(30 * time.Millisecond)
+
+	if v, chans, _, _ := c.Snapshot(); v != 2 || len(chans) != 2 {
+		t.Fatalf("snapshot after invalid edits = v%d %v, want v2 with 2 channels", v, chans)
+	}
+}
+
+func TestController_ExternalMetadataEdit(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "channels.json")
+	writeRaw(t, path, `{"schema":2,"account":"me","channels":[{"name":"#chess"}]}`)
+	c, err := NewController(path, "me", make(chan types.IRCCommand))
+	if err != nil {
+		t.Fatalf("NewController: %v", err)
+	}
+	c.writeDebounceMs = 5
+	c.watchInterval = 5 * time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go c.Run(ctx)
+
+	writeRaw(t, path, `{"schema":2,"account":"me","channels":[{"name":"#chess","priority":5,"notes":"vip"}]}`)
+	waitFor(t, "metadata applied", func() bool {
+		return c.readSnap().Meta["#chess"].Priority == 5
+	})
+	if got := c.readSnap().Meta["#chess"].Notes; got != "vip" {
+		t.Fatalf("notes = %q, want vip", got)
+	}
+}
@@ -1,29 +1,123 @@
 package config
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"os"
+	"strings"
 
 	"github.com/Jamie-38/stream-pipeline/internal/types"
 )
 
-func LoadAccount(path string) (types.Account, error) {
-	var acc types.Account
+// LoadAccounts reads an accounts file holding a single account object, an
+// array of accounts or {"accounts": [...]}. Names must be valid Twitch
+// logins and unique.
+func LoadAccounts(path string) ([]types.Account, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("open account file %q: %w", path, err)
+	}
+	b = bytes.TrimSpace(b)
 
-	f, err := os.Open(path)
+	var accs []types.Account
+	switch {
+	case len(b) > 0 && b[0] == '[':
+		err = json.Unmarshal(b, &accs)
+	default:
+		var probe struct {
+			Accounts *[]types.Account `json:"accounts"`
+		}
+		if err = json.Unmarshal(b, &probe); err == nil && probe.Accounts != nil {
+			accs = *probe.Accounts
+			break
+		}
+		var one types.Account
+		err = json.Unmarshal(b, &one)
+		accs = []types.Account{one}
+	}
 	if err != nil {
-		return acc, fmt.Errorf("open account file %q: %w", path, err)
+		return nil, fmt.Errorf("decode account json %q: %w", path, err)
+	}
+	if len(accs) == 0 {
+		return nil, fmt.Errorf("account file %q lists no accounts", path)
 	}
-	defer f.Close()
 
-	dec := json.NewDecoder(f)
-	if err := dec.Decode(&acc); err != nil {
-		return acc, fmt.Errorf("decode account json %q: %w", path, err)
+	seen := make(map[string]bool, len(accs))
+	for i, a := range accs {
+		if a.User == "" {
+			return nil, fmt.Errorf("account %q entry %d missing required field: username/name", path, i)
+		}
+		if !validLogin(a.Name()) {
+			return nil, fmt.Errorf("account %q entry %d: %q is not a valid login", path, i, a.User)
+		}
+		if seen[a.Name()] {
+			return nil, fmt.Errorf("account %q: duplicate account %q", path, a.Name())
+		}
+		seen[a.Name()] = true
+		if accs[i].Nick == "" {
+			accs[i].Nick = a.Name()
+		}
 	}
+	return accs, nil
+}
+
+// AnonymousAccount is the account used when IRC_ANONYMOUS is set without
+// an accounts file.
+func AnonymousAccount() types.Account {
+	return types.Account{User: "anonymous", Nick: "anonymous", Anonymous: true}
+}
 
-	if acc.User == "" {
-		return acc, fmt.Errorf("account %q missing required field: username/name", path)
+// ResolveAccountPaths fills empty token and channel paths from the given
+// templates, replacing "{account}" with the account name. Anonymous
+// accounts get no token path. Two accounts may not share a file; paths left
+// empty are for the caller to reject.
+func ResolveAccountPaths(accs []types.Account, tokensTmpl, channelsTmpl string) error {
+	tokens := make(map[string]string, len(accs))
+	channels := make(map[string]string, len(accs))
+	for i := range accs {
+		a := &accs[i]
+		if a.TokensPath == "" && !a.Anonymous {
+			a.TokensPath = strings.ReplaceAll(tokensTmpl, "{account}", a.Name())
+		}
+		if a.ChannelsPath == "" {
+			a.ChannelsPath = strings.ReplaceAll(channelsTmpl, "{account}", a.Name())
+		}
+		if other, ok := tokens[a.TokensPath]; ok && a.TokensPath != "" {
+			return fmt.Errorf("accounts %q and %q share token file %q; use {account} in TOKENS_PATH", other, a.Name(), a.TokensPath)
+		}
+		if other, ok := channels[a.ChannelsPath]; ok && a.ChannelsPath != "" {
+			return fmt.Errorf("accounts %q and %q share channels file %q; use {account} in CHANNELS_PATH", other, a.Name(), a.ChannelsPath)
+		}
+		tokens[a.TokensPath] = a.Name()
+		channels[a.ChannelsPath] = a.Name()
+	}
+	return nil
+}
+
+// FindAccount returns the account called name; an empty name selects the
+// only account when there is exactly one.
+func FindAccount(accs []types.Account, name string) (types.Account, bool) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if name == "" && len(accs) == 1 {
+		return accs[0], true
+	}
+	for _, a := range accs {
+		if a.Name() == name {
+			return a, true
+		}
+	}
+	return types.Account{}, false
+}
+
+func validLogin(s string) bool {
+	if s == "" || len(s) > 25 {
+		return false
+	}
+	for _, r := range s {
+		if !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '_') {
+			return false
+		}
 	}
-	return acc, nil
+	return true
 }
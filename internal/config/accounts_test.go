@@ -0,0 +1,73 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeAccounts(t *testing.T, body string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "accounts.json")
+	if err := os.WriteFile(path, []byte(body), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadAccountsForms(t *testing.T) {
+	for name, body := range map[string]string{
+		"single": `{"accountname":"Bot_A","username":"bot_a"}`,
+		"array":  `[{"accountname":"bot_a"},{"accountname":"bot_b"}]`,
+		"object": `{"accounts":[{"accountname":"bot_a"},{"accountname":"bot_b","tokens_path":"/t/b.json"}]}`,
+	} {
+		accs, err := LoadAccounts(writeAccounts(t, body))
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if accs[0].Name() != "bot_a" || accs[0].Nick != "bot_a" {
+			t.Fatalf("%s: first account = %+v", name, accs[0])
+		}
+	}
+}
+
+func TestLoadAccountsRejects(t *testing.T) {
+	for name, body := range map[string]string{
+		"empty":     `[]`,
+		"no name":   `[{"username":"x"}]`,
+		"bad login": `[{"accountname":"../etc"}]`,
+		"duplicate": `[{"accountname":"bot"},{"accountname":"BOT"}]`,
+	} {
+		if _, err := LoadAccounts(writeAccounts(t, body)); err == nil {
+			t.Errorf("%s: accepted", name)
+		}
+	}
+}
+
+func TestResolveAccountPaths(t *testing.T) {
+	accs, _ := LoadAccounts(writeAccounts(t, `[{"accountname":"a"},{"accountname":"b","channels_path":"/c/own.json"}]`))
+	if err := ResolveAccountPaths(accs, "/t/{account}.json", "/c/{account}.json"); err != nil {
+		t.Fatal(err)
+	}
+	if accs[0].TokensPath != "/t/a.json" || accs[1].TokensPath != "/t/b.json" || accs[1].ChannelsPath != "/c/own.json" {
+		t.Fatalf("resolved = %+v", accs)
+	}
+
+	accs, _ = LoadAccounts(writeAccounts(t, `[{"accountname":"a"},{"accountname":"b"}]`))
+	if err := ResolveAccountPaths(accs, "/t/token.json", "/c/{account}.json"); err == nil {
+		t.Fatal("shared token file accepted")
+	}
+}
+
+func TestResolveAccountPathsAnonymous(t *testing.T) {
+	accs, err := LoadAccounts(writeAccounts(t, `[{"accountname":"reader","anonymous":true},{"accountname":"reader2","anonymous":true}]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ResolveAccountPaths(accs, "/t/token.json", "/c/{account}.json"); err != nil {
+		t.Fatal(err)
+	}
+	if accs[0].TokensPath != "" || accs[1].ChannelsPath != "/c/reader2.json" {
+		t.Fatalf("resolved = %+v", accs)
+	}
+}
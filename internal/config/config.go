@@ -0,0 +1,178 @@
+package config
+
+import (
+	"time"
+)
+
+// Config is the collector's configuration. Load layers it: defaults, then
+// a YAML or TOML file, then environment variables, then command-line
+// flags. Every setting keeps the environment variable it had before the
+// file existed. Settings tagged reload:"live" can change without a
+// restart; see Reconcile.
+type Config struct {
+	Twitch    Twitch    `yaml:"twitch" toml:"twitch"`
+	Accounts  Accounts  `yaml:"accounts" toml:"accounts"`
+	Kafka     Kafka     `yaml:"kafka" toml:"kafka"`
+	Filter    Filter    `yaml:"filter" toml:"filter"`
+	HTTP      HTTP      `yaml:"http" toml:"http"`
+	Rectifier Rectifier `yaml:"rectifier" toml:"rectifier"`
+	Scheduler Scheduler `yaml:"scheduler" toml:"scheduler"`
+	Buffers   Buffers   `yaml:"buffers" toml:"buffers"`
+	Health    Health    `yaml:"health" toml:"health"`
+	RateLimit RateLimit `yaml:"rate_limit" toml:"rate_limit"`
+	Log       Log       `yaml:"log" toml:"log"`
+	Tracing   Tracing   `yaml:"tracing" toml:"tracing"`
+}
+
+type Twitch struct {
+	IRCURI       string `yaml:"irc_uri" toml:"irc_uri" env:"TWITCH_IRC_URI"`
+	ClientID     string `yaml:"client_id" toml:"client_id" env:"TWITCH_CLIENT_ID"`
+	ClientSecret string `yaml:"client_secret" toml:"client_secret" env:"TWITCH_CLIENT_SECRET" secret:"true"`
+}
+
+// Accounts names the bot identities. Without a file, Anonymous runs a
+// single read-only justinfan account.
+type Accounts struct {
+	Path         string `yaml:"path" toml:"path" env:"ACCOUNTS_PATH"`
+	Anonymous    bool   `yaml:"anonymous" toml:"anonymous" env:"IRC_ANONYMOUS"`
+	TokensPath   string `yaml:"tokens_path" toml:"tokens_path" env:"TOKENS_PATH"`
+	ChannelsPath string `yaml:"channels_path" toml:"channels_path" env:"CHANNELS_PATH"`
+}
+
+type Kafka struct {
+	Brokers []string `yaml:"brokers" toml:"brokers" env:"KAFKA_BROKERS"`
+	Topic   string   `yaml:"topic" toml:"topic" env:"KAFKA_TOPIC" reload:"live"`
+}
+
+// Filter points at the rules applied between the classifier and Kafka;
+// see package filter for the language. The file is re-read on every
+// reload, even when the path is unchanged.
+type Filter struct {
+	RulesPath string `yaml:"rules_path" toml:"rules_path" env:"FILTER_RULES_PATH" reload:"live"`
+}
+
+// HTTP is the control plane's listener, TLS and auth.
+type HTTP struct {
+	Host              string        `yaml:"host" toml:"host" env:"HTTP_API_HOST"`
+	Port              int           `yaml:"port" toml:"port" env:"HTTP_API_PORT"`
+	TLSCert           string        `yaml:"tls_cert" toml:"tls_cert" env:"HTTP_API_TLS_CERT"`
+	TLSKey            string        `yaml:"tls_key" toml:"tls_key" env:"HTTP_API_TLS_KEY"`
+	ClientCA          string        `yaml:"client_ca" toml:"client_ca" env:"HTTP_API_CLIENT_CA"`
+	RequireClientCert bool          `yaml:"require_client_cert" toml:"require_client_cert" env:"HTTP_API_REQUIRE_CLIENT_CERT"`
+	AuthFile          string        `yaml:"auth_file" toml:"auth_file" env:"HTTP_API_AUTH_FILE"`
+	AuditLog          string        `yaml:"audit_log" toml:"audit_log" env:"HTTP_API_AUDIT_LOG"`
+	ReadHeaderTimeout time.Duration `yaml:"read_header_timeout" toml:"read_header_timeout" env:"HTTP_API_READ_HEADER_TIMEOUT"`
+	ReadTimeout       time.Duration `yaml:"read_timeout" toml:"read_timeout" env:"HTTP_API_READ_TIMEOUT"`
+	WriteTimeout      time.Duration `yaml:"write_timeout" toml:"write_timeout" env:"HTTP_API_WRITE_TIMEOUT"`
+	IdleTimeout       time.Duration `yaml:"idle_timeout" toml:"idle_timeout" env:"HTTP_API_IDLE_TIMEOUT"`
+	ShutdownTimeout   time.Duration `yaml:"shutdown_timeout" toml:"shutdown_timeout" env:"HTTP_API_SHUTDOWN_TIMEOUT"`
+}
+
+// Rectifier mirrors the tunables of channel_record.Config. JOIN pacing is
+// not here: the rate-limit profile's join bucket governs it.
+type Rectifier struct {
+	JoinTimeout time.Duration `yaml:"join_timeout" toml:"join_timeout" env:"RECTIFIER_JOIN_TIMEOUT" reload:"live"`
+	BackoffMin  time.Duration `yaml:"backoff_min" toml:"backoff_min" env:"RECTIFIER_BACKOFF_MIN" reload:"live"`
+	BackoffMax  time.Duration `yaml:"backoff_max" toml:"backoff_max" env:"RECTIFIER_BACKOFF_MAX" reload:"live"`
+	Tick        time.Duration `yaml:"tick" toml:"tick" env:"RECTIFIER_TICK" reload:"live"`
+	RetryAging  time.Duration `yaml:"retry_aging" toml:"retry_aging" env:"RECTIFIER_RETRY_AGING" reload:"live"`
+}
+
+// Scheduler bounds the comma-batched JOIN and PART lines; see
+// scheduler.BatchConfig.
+type Scheduler struct {
+	MaxChannels int           `yaml:"max_channels" toml:"max_channels" env:"SCHEDULER_MAX_CHANNELS"`
+	MaxBytes    int           `yaml:"max_bytes" toml:"max_bytes" env:"SCHEDULER_MAX_BYTES"`
+	Linger      time.Duration `yaml:"linger" toml:"linger" env:"SCHEDULER_LINGER"`
+}
+
+// Buffers are the capacities of the pipeline's channels. The first group
+// exists once per account, Parse, Filter and Kafka once per collector.
+type Buffers struct {
+	Control      int `yaml:"control" toml:"control" env:"BUFFER_CONTROL"`
+	RectifierOut int `yaml:"rectifier_out" toml:"rectifier_out" env:"BUFFER_RECTIFIER_OUT"`
+	Membership   int `yaml:"membership" toml:"membership" env:"BUFFER_MEMBERSHIP"`
+	Writer       int `yaml:"writer" toml:"writer" env:"BUFFER_WRITER"`
+	Reader       int `yaml:"reader" toml:"reader" env:"BUFFER_READER"`
+	Chat         int `yaml:"chat" toml:"chat" env:"BUFFER_CHAT"`
+	Outbox       int `yaml:"outbox" toml:"outbox" env:"BUFFER_OUTBOX"`
+	Parse        int `yaml:"parse" toml:"parse" env:"BUFFER_PARSE"`
+	Filter       int `yaml:"filter" toml:"filter" env:"BUFFER_FILTER"`
+	Kafka        int `yaml:"kafka" toml:"kafka" env:"BUFFER_KAFKA"`
+}
+
+// Health holds the readiness and liveness thresholds.
+type Health struct {
+	// Optional components never fail /readyz.
+	Optional []string `yaml:"optional" toml:"optional" env:"HEALTH_OPTIONAL"`
+	// MinJoinedRatio is the share of desired channels joined to be ready.
+	MinJoinedRatio float64 `yaml:"min_joined_ratio" toml:"min_joined_ratio" env:"HEALTH_MIN_JOINED_RATIO" reload:"live"`
+	// IRCMaxSilence is the longest gap between server PINGs we answer.
+	IRCMaxSilence time.Duration `yaml:"irc_max_silence" toml:"irc_max_silence" env:"HEALTH_IRC_MAX_SILENCE"`
+	// StallAfter: no progress this long with work queued is a stall.
+	StallAfter time.Duration `yaml:"stall_after" toml:"stall_after" env:"HEALTH_STALL_AFTER"`
+	// TokenValidate is how often tokens are re-validated; 0 is hourly.
+	TokenValidate time.Duration `yaml:"token_validate_interval" toml:"token_validate_interval" env:"TOKEN_VALIDATE_INTERVAL"`
+}
+
+type RateLimit struct {
+	Profile      string `yaml:"profile" toml:"profile" env:"RATE_LIMIT_PROFILE" reload:"live"`
+	ProfilesPath string `yaml:"profiles_path" toml:"profiles_path" env:"RATE_LIMIT_PROFILES_PATH" reload:"live"`
+}
+
+type Log struct {
+	// Level is a spec for observe.SetLevels, e.g. "info,classifier=debug".
+	Level string `yaml:"level" toml:"level" env:"LOG_LEVEL" reload:"live"`
+}
+
+// Tracing is off while SampleRatio is 0.
+type Tracing struct {
+	SampleRatio  float64  `yaml:"sample_ratio" toml:"sample_ratio" env:"TRACE_SAMPLE_RATIO"`
+	Exporters    []string `yaml:"exporters" toml:"exporters" env:"TRACE_EXPORTERS"`
+	File         string   `yaml:"file" toml:"file" env:"TRACE_FILE"`
+	OTLPEndpoint string   `yaml:"otlp_endpoint" toml:"otlp_endpoint" env:"OTEL_EXPORTER_OTLP_ENDPOINT"`
+}
+
+// Default is the configuration before any file, environment or flag.
+func Default() Config {
+	return Config{
+		HTTP: HTTP{
+			ReadHeaderTimeout: 5 * time.Second,
+			ReadTimeout:       10 * time.Second,
+			WriteTimeout:      15 * time.Second,
+			IdleTimeout:       60 * time.Second,
+			ShutdownTimeout:   10 * time.Second,
+		},
+		Rectifier: Rectifier{
+			JoinTimeout: 30 * time.Second,
+			BackoffMin:  2 * time.Second,
+			BackoffMax:  60 * time.Second,
+			Tick:        1 * time.Second,
+			RetryAging:  5 * time.Minute,
+		},
+		Scheduler: Scheduler{
+			MaxChannels: 20,
+			MaxBytes:    512,
+			Linger:      25 * time.Millisecond,
+		},
+		Buffers: Buffers{
+			Control:      100,
+			RectifierOut: 100,
+			Membership:   100,
+			Writer:       100,
+			Reader:       1000,
+			Chat:         100,
+			Outbox:       100,
+			Parse:        1000,
+			Filter:       1000,
+			Kafka:        1000,
+		},
+		Health: Health{
+			MinJoinedRatio: 0.8,
+			IRCMaxSilence:  10 * time.Minute,
+			StallAfter:     30 * time.Second,
+		},
+		RateLimit: RateLimit{Profile: "normal"},
+		Tracing:   Tracing{Exporters: []string{"stdout"}},
+	}
+}
@@ -0,0 +1,190 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+const validYAML = `
+twitch:
+  irc_uri: wss://irc-ws.chat.twitch.tv:443
+  client_id: cid
+  client_secret: hunter2
+accounts:
+  path: /etc/collector/accounts.json
+kafka:
+  brokers: [k1:9092, k2:9092]
+  topic: chat
+http:
+  host: 0.0.0.0
+  port: 8080
+  write_timeout: 20s
+rectifier:
+  backoff_max: 2m
+buffers:
+  reader: 5000
+`
+
+func writeConfig(t *testing.T, name, body string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(body), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadLayersFileEnvFlags(t *testing.T) {
+	t.Setenv("CONFIG_FILE", writeConfig(t, "collector.yaml", validYAML))
+	t.Setenv("KAFKA_TOPIC", "chat-env")
+	t.Setenv("HTTP_API_PORT", "9090")
+	t.Setenv("RECTIFIER_TICK", "5s")
+	t.Setenv("SCHEDULER_MAX_CHANNELS", "10")
+
+	cfg, fl, err := Load("test", []string{"-http.port=9191", "--rectifier.join_timeout", "45s"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(fl.File, "collector.yaml") {
+		t.Fatalf("file = %q", fl.File)
+	}
+	if cfg.Twitch.ClientSecret != "hunter2" || !slices.Equal(cfg.Kafka.Brokers, []string{"k1:9092", "k2:9092"}) {
+		t.Fatalf("file values not loaded: %+v", cfg)
+	}
+	if cfg.HTTP.WriteTimeout != 20*time.Second || cfg.Rectifier.BackoffMax != 2*time.Minute || cfg.Buffers.Reader != 5000 {
+		t.Fatalf("file durations or ints not loaded: %+v %+v", cfg.HTTP, cfg.Rectifier)
+	}
+	if cfg.Kafka.Topic != "chat-env" || cfg.Rectifier.Tick != 5*time.Second || cfg.Scheduler.MaxChannels != 10 {
+		t.Fatal("env does not override the file")
+	}
+	if cfg.HTTP.Port != 9191 || cfg.Rectifier.JoinTimeout != 45*time.Second {
+		t.Fatal("flags do not override env")
+	}
+	if cfg.HTTP.ReadTimeout != 10*time.Second || cfg.Buffers.Control != 100 {
+		t.Fatal("defaults lost for unset keys")
+	}
+}
+
+func TestLoadTOML(t *testing.T) {
+	path := writeConfig(t, "collector.toml", `
+[twitch]
+irc_uri = "wss://irc-ws.chat.twitch.tv:443"
+
+[accounts]
+anonymous = true
+
+[kafka]
+brokers = ["k1:9092"]
+topic = "chat"
+
+[http]
+host = "127.0.0.1"
+port = 8080
+
+[health]
+stall_after = "1m"
+optional = ["kafka"]
+`)
+	cfg, _, err := Load("test", []string{"-config", path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cfg.Accounts.Anonymous || cfg.Health.StallAfter != time.Minute || !slices.Equal(cfg.Health.Optional, []string{"kafka"}) {
+		t.Fatalf("toml not applied: %+v", cfg)
+	}
+}
+
+func TestLoadReportsAllProblems(t *testing.T) {
+	t.Setenv("CONFIG_FILE", "")
+	path := writeConfig(t, "collector.yaml", `
+twitch:
+  irc_uri: http://example.com
+kafka:
+  topik: chat
+http:
+  host: localhost
+  port: 70000
+rectifier:
+  backoff_min: 5m
+  backoff_max: 1m
+scheduler:
+  max_channels: 0
+  max_bytes: 1024
+`)
+	t.Setenv("BUFFER_READER", "lots")
+	_, _, err := Load("test", []string{"-config", path, "-log.level", "loud"})
+
+	var ve *ValidationError
+	if !errors.As(err, &ve) {
+		t.Fatalf("err = %v, want *ValidationError", err)
+	}
+	all := strings.Join(ve.Problems, "\n")
+	for _, want := range []string{
+		`field topik not found`,
+		`BUFFER_READER: invalid integer "lots"`,
+		`twitch.irc_uri "http://example.com" must be a ws:// or wss:// URL`,
+		`accounts.path is required`,
+		`kafka.brokers is required`,
+		`kafka.topic is required`,
+		`http.port 70000 is out of range`,
+		`rectifier.backoff_min 5m0s exceeds rectifier.backoff_max 1m0s`,
+		`scheduler.max_channels must be at least 1`,
+		`scheduler.max_bytes 1024 must be within [33,512]`,
+		`log.level: unknown log level "loud"`,
+	} {
+		if !strings.Contains(all, want) {
+			t.Errorf("missing problem %q in:\n%s", want, all)
+		}
+	}
+}
+
+func TestLoadRejectsUnknownFormat(t *testing.T) {
+	path := writeConfig(t, "collector.ini", "x=1")
+	_, _, err := Load("test", []string{"-config", path})
+	var ve *ValidationError
+	if err == nil || errors.As(err, &ve) {
+		t.Fatalf("err = %v, want a plain error", err)
+	}
+}
+
+func TestPrintConfigRedactsAndRoundTrips(t *testing.T) {
+	t.Setenv("CONFIG_FILE", writeConfig(t, "collector.yaml", validYAML))
+	cfg, _, err := Load("test", []string{"-print-config"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	if err := cfg.Redacted().Write(&out); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(out.String(), "hunter2") || !strings.Contains(out.String(), "client_secret: REDACTED") {
+		t.Fatalf("secret not redacted:\n%s", out.String())
+	}
+	if !strings.Contains(out.String(), "write_timeout: 20s") {
+		t.Fatalf("durations not printed as strings:\n%s", out.String())
+	}
+	if cfg.Twitch.ClientSecret != "hunter2" {
+		t.Fatal("Redacted modified the original")
+	}
+
+	// the printed form loads back to the same settings
+	t.Setenv("CONFIG_FILE", writeConfig(t, "printed.yaml", out.String()))
+	again, _, err := Load("test", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	again.Twitch.ClientSecret = cfg.Twitch.ClientSecret
+	var a, b bytes.Buffer
+	_ = cfg.Write(&a)
+	_ = again.Write(&b)
+	if a.String() != b.String() {
+		t.Fatalf("round trip differs:\n%s\n---\n%s", a.String(), b.String())
+	}
+}
@@ -0,0 +1,216 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// Flags are the command-line options that are not settings.
+type Flags struct {
+	File        string // config file; CONFIG_FILE when unset
+	PrintConfig bool
+}
+
+// Load builds the configuration from Default, the config file, the
+// environment and args, in that order. Settings that do not parse are
+// collected into a *ValidationError together with whatever Validate finds
+// wrong, so every problem is reported at once. A file that cannot be read
+// at all, or bad flag syntax, is returned as a plain error.
+func Load(name string, args []string) (Config, Flags, error) {
+	cfg := Default()
+	var fl Flags
+	var problems []string
+
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.StringVar(&fl.File, "config", "", "YAML or TOML config file (env CONFIG_FILE)")
+	fs.BoolVar(&fl.PrintConfig, "print-config", false, "print the effective configuration with secrets redacted and exit")
+	type override struct{ key, value string }
+	var overrides []override
+	for _, s := range settings(&cfg) {
+		usage := "sets " + s.key
+		if s.env != "" {
+			usage += " (env " + s.env + ")"
+		}
+		fs.Func(s.key, usage, func(v string) error {
+			overrides = append(overrides, override{s.key, v})
+			return nil
+		})
+	}
+	if err := fs.Parse(args); err != nil {
+		return cfg, fl, err
+	}
+	if fs.NArg() > 0 {
+		return cfg, fl, fmt.Errorf("unexpected arguments: %s", strings.Join(fs.Args(), " "))
+	}
+
+	if fl.File == "" {
+		fl.File = os.Getenv("CONFIG_FILE")
+	}
+	if fl.File != "" {
+		p, err := decodeFile(fl.File, &cfg)
+		if err != nil {
+			return cfg, fl, err
+		}
+		problems = append(problems, p...)
+	}
+
+	byKey := make(map[string]setting)
+	for _, s := range settings(&cfg) {
+		byKey[s.key] = s
+		if s.env == "" {
+			continue
+		}
+		if v := strings.TrimSpace(os.Getenv(s.env)); v != "" {
+			if err := s.set(v); err != nil {
+				problems = append(problems, fmt.Sprintf("%s: %v", s.env, err))
+			}
+		}
+	}
+	for _, o := range overrides {
+		if err := byKey[o.key].set(o.value); err != nil {
+			problems = append(problems, fmt.Sprintf("-%s: %v", o.key, err))
+		}
+	}
+
+	if err := cfg.Validate(); err != nil {
+		var ve *ValidationError
+		if !errors.As(err, &ve) {
+			return cfg, fl, err
+		}
+		problems = append(problems, ve.Problems...)
+	}
+	if len(problems) > 0 {
+		return cfg, fl, &ValidationError{Problems: problems}
+	}
+	return cfg, fl, nil
+}
+
+// decodeFile reads path into cfg by its extension. Unknown keys and values
+// of the wrong type come back as problems; anything else is an error.
+func decodeFile(path string, cfg *Config) ([]string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("open config file %q: %w", path, err)
+	}
+	var problems []string
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		dec := yaml.NewDecoder(bytes.NewReader(b))
+		dec.KnownFields(true)
+		err := dec.Decode(cfg)
+		var te *yaml.TypeError
+		switch {
+		case errors.Is(err, io.EOF): // empty file
+		case errors.As(err, &te):
+			for _, e := range te.Errors {
+				problems = append(problems, path+": "+e)
+			}
+		case err != nil:
+			return nil, fmt.Errorf("decode config file %q: %w", path, err)
+		}
+	case ".toml":
+		md, err := toml.Decode(string(b), cfg)
+		if err != nil {
+			return nil, fmt.Errorf("decode config file %q: %w", path, err)
+		}
+		for _, k := range md.Undecoded() {
+			problems = append(problems, fmt.Sprintf("%s: unknown key %q", path, k.String()))
+		}
+	default:
+		return nil, fmt.Errorf("config file %q: unsupported format; use .yaml, .yml or .toml", path)
+	}
+	return problems, nil
+}
+
+// setting is one leaf of Config, addressed by its dotted file key.
+type setting struct {
+	key    string // e.g. "kafka.brokers"
+	env    string
+	secret bool
+	live   bool // reload:"live"
+	v      reflect.Value
+}
+
+// settings lists the leaves of cfg in declaration order.
+func settings(cfg *Config) []setting {
+	var out []setting
+	var walk func(v reflect.Value, prefix string)
+	walk = func(v reflect.Value, prefix string) {
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			key := prefix + strings.Split(f.Tag.Get("yaml"), ",")[0]
+			if f.Type.Kind() == reflect.Struct {
+				walk(v.Field(i), key+".")
+				continue
+			}
+			out = append(out, setting{
+				key:    key,
+				env:    f.Tag.Get("env"),
+				secret: f.Tag.Get("secret") == "true",
+				live:   f.Tag.Get("reload") == "live",
+				v:      v.Field(i),
+			})
+		}
+	}
+	walk(reflect.ValueOf(cfg).Elem(), "")
+	return out
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// set parses s into the setting. Lists are comma-separated.
+func (s setting) set(raw string) error {
+	raw = strings.TrimSpace(raw)
+	switch {
+	case s.v.Type() == durationType:
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return fmt.Errorf("invalid duration %q", raw)
+		}
+		s.v.SetInt(int64(d))
+	case s.v.Kind() == reflect.String:
+		s.v.SetString(raw)
+	case s.v.Kind() == reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return fmt.Errorf("invalid boolean %q", raw)
+		}
+		s.v.SetBool(b)
+	case s.v.Kind() == reflect.Int:
+		n, err := strconv.Atoi(raw)
+		if err != nil {
+			return fmt.Errorf("invalid integer %q", raw)
+		}
+		s.v.SetInt(int64(n))
+	case s.v.Kind() == reflect.Float64:
+		f, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return fmt.Errorf("invalid number %q", raw)
+		}
+		s.v.SetFloat(f)
+	case s.v.Kind() == reflect.Slice && s.v.Type().Elem().Kind() == reflect.String:
+		var list []string
+		for _, p := range strings.Split(raw, ",") {
+			if p = strings.TrimSpace(p); p != "" {
+				list = append(list, p)
+			}
+		}
+		s.v.Set(reflect.ValueOf(list))
+	default:
+		return fmt.Errorf("unsupported setting type %s", s.v.Type())
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+package config
+
+import (
+	"encoding/json"
+	"io"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Redacted returns c with every non-empty secret replaced.
+func (c Config) Redacted() Config {
+	for _, s := range settings(&c) {
+		if s.secret && s.v.String() != "" {
+			s.v.SetString("REDACTED")
+		}
+	}
+	return c
+}
+
+// Write prints c as YAML in declaration order, durations as strings. It
+// does not redact; pass c.Redacted() for display.
+func (c Config) Write(w io.Writer) error {
+	root := &yaml.Node{Kind: yaml.MappingNode}
+	sections := map[string]*yaml.Node{}
+	for _, s := range settings(&c) {
+		section, leaf, _ := strings.Cut(s.key, ".")
+		m, ok := sections[section]
+		if !ok {
+			m = &yaml.Node{Kind: yaml.MappingNode}
+			sections[section] = m
+			root.Content = append(root.Content, scalar(section), m)
+		}
+		val := &yaml.Node{}
+		var v any = s.v.Interface()
+		if s.v.Type() == durationType {
+			v = time.Duration(s.v.Int()).String()
+		}
+		if err := val.Encode(v); err != nil {
+			return err
+		}
+		if val.Kind == yaml.SequenceNode {
+			val.Style = yaml.FlowStyle
+		}
+		m.Content = append(m.Content, scalar(leaf), val)
+	}
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(root); err != nil {
+		return err
+	}
+	return enc.Close()
+}
+
+// MarshalJSON encodes c by section and file key, durations as strings,
+// for the admin API. Like Write, it does not redact.
+func (c Config) MarshalJSON() ([]byte, error) {
+	out := map[string]map[string]any{}
+	for _, s := range settings(&c) {
+		section, leaf, _ := strings.Cut(s.key, ".")
+		if out[section] == nil {
+			out[section] = map[string]any{}
+		}
+		var v any = s.v.Interface()
+		if s.v.Type() == durationType {
+			v = time.Duration(s.v.Int()).String()
+		}
+		out[section][leaf] = v
+	}
+	return json.Marshal(out)
+}
+
+func scalar(s string) *yaml.Node { return &yaml.Node{Kind: yaml.ScalarNode, Value: s} }
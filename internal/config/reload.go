@@ -0,0 +1,64 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// Change is one setting that differs between two configurations. Secret
+// values are redacted.
+type Change struct {
+	Key  string `json:"key"`
+	Old  string `json:"old"`
+	New  string `json:"new"`
+	Live bool   `json:"live"` // applied without a restart
+}
+
+// Diff lists the settings that differ from a to b, in declaration order.
+func Diff(a, b Config) []Change {
+	as, bs := settings(&a), settings(&b)
+	var out []Change
+	for i, s := range as {
+		if reflect.DeepEqual(s.v.Interface(), bs[i].v.Interface()) {
+			continue
+		}
+		out = append(out, Change{Key: s.key, Old: s.display(), New: bs[i].display(), Live: s.live})
+	}
+	return out
+}
+
+// Reconcile splits the differences between running and loaded into live
+// changes, which next carries over from loaded, and changes that need a
+// restart, which next leaves as they are running.
+func Reconcile(running, loaded Config) (next Config, live, restart []Change) {
+	next = running
+	ns, ls := settings(&next), settings(&loaded)
+	for _, c := range Diff(running, loaded) {
+		if !c.Live {
+			restart = append(restart, c)
+			continue
+		}
+		live = append(live, c)
+		for j := range ns {
+			if ns[j].key == c.Key {
+				ns[j].v.Set(ls[j].v)
+			}
+		}
+	}
+	return next, live, restart
+}
+
+// display renders the value for diffs, redacting secrets.
+func (s setting) display() string {
+	switch {
+	case s.secret && s.v.String() != "":
+		return "REDACTED"
+	case s.v.Type() == durationType:
+		return time.Duration(s.v.Int()).String()
+	case s.v.Kind() == reflect.Slice:
+		return strings.Join(s.v.Interface().([]string), ",")
+	}
+	return fmt.Sprint(s.v.Interface())
+}
@@ -0,0 +1,50 @@
+package config
+
+import (
+	"testing"
+	"time"
+)
+
+func TestReconcileSplitsLiveAndRestart(t *testing.T) {
+	running := Default()
+	running.Twitch.ClientSecret = "old-secret"
+	running.Kafka.Topic = "chat"
+	running.HTTP.Port = 8080
+
+	loaded := running
+	loaded.Twitch.ClientSecret = "new-secret"
+	loaded.Kafka.Topic = "chat-v2"
+	loaded.HTTP.Port = 9090
+	loaded.Rectifier.BackoffMax = 2 * time.Minute
+	loaded.Log.Level = "debug"
+
+	next, live, restart := Reconcile(running, loaded)
+
+	keys := func(cs []Change) map[string]Change {
+		m := make(map[string]Change)
+		for _, c := range cs {
+			m[c.Key] = c
+		}
+		return m
+	}
+	l, r := keys(live), keys(restart)
+	if len(l) != 3 || l["kafka.topic"].New != "chat-v2" || l["rectifier.backoff_max"].New != "2m0s" || l["log.level"].New != "debug" {
+		t.Fatalf("live = %+v", live)
+	}
+	if len(r) != 2 || r["http.port"].Old != "8080" || r["http.port"].Live {
+		t.Fatalf("restart = %+v", restart)
+	}
+	if c := r["twitch.client_secret"]; c.Old != "REDACTED" || c.New != "REDACTED" {
+		t.Fatalf("secret change not redacted: %+v", c)
+	}
+
+	if next.Kafka.Topic != "chat-v2" || next.Rectifier.BackoffMax != 2*time.Minute || next.Log.Level != "debug" {
+		t.Fatalf("live changes not carried over: %+v", next)
+	}
+	if next.HTTP.Port != 8080 || next.Twitch.ClientSecret != "old-secret" {
+		t.Fatalf("restart changes applied: %+v", next)
+	}
+	if running.Kafka.Topic != "chat" {
+		t.Fatal("Reconcile modified running")
+	}
+}
@@ -0,0 +1,150 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/Jamie-38/stream-pipeline/internal/observe"
+)
+
+// ValidationError lists every problem found in a configuration.
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return "invalid configuration:\n  - " + strings.Join(e.Problems, "\n  - ")
+}
+
+// Validate checks c as a whole and reports all problems in one
+// *ValidationError.
+func (c Config) Validate() error {
+	var p []string
+	bad := func(format string, args ...any) { p = append(p, fmt.Sprintf(format, args...)) }
+
+	if c.Twitch.IRCURI == "" {
+		bad("twitch.irc_uri is required")
+	} else if u, err := url.Parse(c.Twitch.IRCURI); err != nil || (u.Scheme != "ws" && u.Scheme != "wss") || u.Host == "" {
+		bad("twitch.irc_uri %q must be a ws:// or wss:// URL", c.Twitch.IRCURI)
+	}
+	if c.Accounts.Path == "" && !c.Accounts.Anonymous {
+		bad("accounts.path is required unless accounts.anonymous is set")
+	}
+
+	if len(c.Kafka.Brokers) == 0 {
+		bad("kafka.brokers is required")
+	}
+	if c.Kafka.Topic == "" {
+		bad("kafka.topic is required")
+	}
+
+	h := c.HTTP
+	if h.Host == "" {
+		bad("http.host is required")
+	}
+	if h.Port < 1 || h.Port > 65535 {
+		bad("http.port %d is out of range", h.Port)
+	}
+	if (h.TLSCert == "") != (h.TLSKey == "") {
+		bad("http.tls_cert and http.tls_key must be set together")
+	}
+	if h.ClientCA != "" && h.TLSCert == "" {
+		bad("http.client_ca needs http.tls_cert and http.tls_key")
+	}
+	if h.RequireClientCert && h.ClientCA == "" {
+		bad("http.require_client_cert needs http.client_ca")
+	}
+	durations(bad, false, map[string]time.Duration{
+		"http.read_header_timeout": h.ReadHeaderTimeout,
+		"http.read_timeout":        h.ReadTimeout,
+		"http.write_timeout":       h.WriteTimeout,
+		"http.idle_timeout":        h.IdleTimeout,
+		"http.shutdown_timeout":    h.ShutdownTimeout,
+	})
+
+	r := c.Rectifier
+	durations(bad, false, map[string]time.Duration{
+		"rectifier.join_timeout": r.JoinTimeout,
+		"rectifier.backoff_min":  r.BackoffMin,
+		"rectifier.backoff_max":  r.BackoffMax,
+		"rectifier.tick":         r.Tick,
+	})
+	durations(bad, true, map[string]time.Duration{"rectifier.retry_aging": r.RetryAging})
+	if r.BackoffMin > r.BackoffMax {
+		bad("rectifier.backoff_min %v exceeds rectifier.backoff_max %v", r.BackoffMin, r.BackoffMax)
+	}
+
+	sc := c.Scheduler
+	if sc.MaxChannels < 1 {
+		bad("scheduler.max_channels must be at least 1")
+	}
+	// a line must hold one 25-character channel and fit Twitch's 512 bytes
+	if sc.MaxBytes < len("PART #\r\n")+25 || sc.MaxBytes > 512 {
+		bad("scheduler.max_bytes %d must be within [%d,512]", sc.MaxBytes, len("PART #\r\n")+25)
+	}
+	durations(bad, false, map[string]time.Duration{"scheduler.linger": sc.Linger})
+
+	for _, s := range settings(&c) {
+		if strings.HasPrefix(s.key, "buffers.") && s.v.Int() < 1 {
+			bad("%s must be at least 1", s.key)
+		}
+	}
+
+	if c.Health.MinJoinedRatio < 0 || c.Health.MinJoinedRatio > 1 {
+		bad("health.min_joined_ratio must be within [0,1]")
+	}
+	durations(bad, true, map[string]time.Duration{
+		"health.irc_max_silence":         c.Health.IRCMaxSilence,
+		"health.stall_after":             c.Health.StallAfter,
+		"health.token_validate_interval": c.Health.TokenValidate,
+	})
+
+	if c.RateLimit.Profile == "" {
+		bad("rate_limit.profile is required")
+	}
+	if err := observe.CheckLevels(c.Log.Level); err != nil {
+		bad("log.level: %v", err)
+	}
+
+	t := c.Tracing
+	if t.SampleRatio < 0 || t.SampleRatio > 1 {
+		bad("tracing.sample_ratio must be within [0,1]")
+	}
+	for _, e := range t.Exporters {
+		switch e {
+		case "stdout", "otlp":
+		case "file":
+			if t.File == "" {
+				bad("tracing.file is required for the file exporter")
+			}
+		default:
+			bad("tracing.exporters: unknown exporter %q", e)
+		}
+	}
+
+	if len(p) > 0 {
+		return &ValidationError{Problems: p}
+	}
+	return nil
+}
+
+// durations reports, in key order, the durations in ds that are negative
+// or, unless zeroOK, zero.
+func durations(bad func(string, ...any), zeroOK bool, ds map[string]time.Duration) {
+	keys := make([]string, 0, len(ds))
+	for k := range ds {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	for _, k := range keys {
+		switch d := ds[k]; {
+		case d < 0 && zeroOK:
+			bad("%s must not be negative", k)
+		case d <= 0 && !zeroOK:
+			bad("%s must be positive", k)
+		}
+	}
+}
@@ -0,0 +1,332 @@
+package filter
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// token is one lexeme of a rule line.
+type token struct {
+	text   string
+	quoted bool // a string literal; never a keyword or operator
+}
+
+// lex splits a rule line into words, quoted strings and the operators
+// ( ) , = != ~ !~.
+func lex(line string) ([]token, error) {
+	var out []token
+	for i := 0; i < len(line); {
+		c := line[i]
+		switch {
+		case c == ' ' || c == '\t':
+			i++
+		case c == '"' || c == '`':
+			end := strings.IndexByte(line[i+1:], c)
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated string")
+			}
+			lit := line[i : i+end+2]
+			s, err := strconv.Unquote(lit)
+			if err != nil {
+				return nil, fmt.Errorf("bad string %s", lit)
+			}
+			out = append(out, token{text: s, quoted: true})
+			i += end + 2
+		case c == '(' || c == ')' || c == ',' || c == '=' || c == '~':
+			out = append(out, token{text: string(c)})
+			i++
+		case c == '!':
+			if i+1 < len(line) && (line[i+1] == '=' || line[i+1] == '~') {
+				out = append(out, token{text: line[i : i+2]})
+				i += 2
+				continue
+			}
+			return nil, fmt.Errorf("unexpected %q", c)
+		default:
+			j := i
+			for j < len(line) {
+				r, size := utf8.DecodeRuneInString(line[j:])
+				if !isWord(r) {
+					break
+				}
+				j += size
+			}
+			if j == i {
+				r, _ := utf8.DecodeRuneInString(line[i:])
+				return nil, fmt.Errorf("unexpected %q", r)
+			}
+			out = append(out, token{text: line[i:j]})
+			i = j
+		}
+	}
+	return out, nil
+}
+
+func isWord(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || strings.ContainsRune("_-.:/#*", r)
+}
+
+// parser turns the tokens of one line into a rule.
+type parser struct {
+	toks []token
+	pos  int
+}
+
+func (p *parser) peek() (token, bool) {
+	if p.pos >= len(p.toks) {
+		return token{}, false
+	}
+	return p.toks[p.pos], true
+}
+
+func (p *parser) next() (token, error) {
+	t, ok := p.peek()
+	if !ok {
+		return token{}, fmt.Errorf("unexpected end of rule")
+	}
+	p.pos++
+	return t, nil
+}
+
+// keyword reports whether the next token is the bare word kw, and if so
+// consumes it.
+func (p *parser) keyword(kw string) bool {
+	if t, ok := p.peek(); ok && !t.quoted && strings.EqualFold(t.text, kw) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *parser) expect(s string) error {
+	t, err := p.next()
+	if err != nil {
+		return fmt.Errorf("expected %q: %w", s, err)
+	}
+	if t.quoted || t.text != s {
+		return fmt.Errorf("expected %q, got %q", s, t.text)
+	}
+	return nil
+}
+
+// parseRule reads: action [argument] [if condition].
+func parseRule(line string) (rule, error) {
+	toks, err := lex(line)
+	if err != nil {
+		return rule{}, err
+	}
+	p := &parser{toks: toks}
+	var r rule
+
+	act, err := p.next()
+	if err != nil {
+		return r, err
+	}
+	switch strings.ToLower(act.text) {
+	case "drop":
+		r.action = actDrop
+	case "route":
+		r.action = actRoute
+		if r.arg, err = p.argument("topic"); err != nil {
+			return r, err
+		}
+	case "tag":
+		r.action = actTag
+		if r.arg, err = p.argument("tag"); err != nil {
+			return r, err
+		}
+	case "sample":
+		r.action = actSample
+		arg, err := p.argument("ratio")
+		if err != nil {
+			return r, err
+		}
+		if r.ratio, err = strconv.ParseFloat(arg, 64); err != nil || r.ratio <= 0 || r.ratio > 1 {
+			return r, fmt.Errorf("sample ratio %q must be within (0,1]", arg)
+		}
+	default:
+		return r, fmt.Errorf("unknown action %q; want drop, route, tag or sample", act.text)
+	}
+
+	if _, ok := p.peek(); !ok {
+		return r, nil // unconditional
+	}
+	if !p.keyword("if") {
+		t, _ := p.peek()
+		return r, fmt.Errorf("expected \"if\", got %q", t.text)
+	}
+	if r.cond, err = p.or(); err != nil {
+		return r, err
+	}
+	if t, ok := p.peek(); ok {
+		return r, fmt.Errorf("unexpected %q after condition", t.text)
+	}
+	return r, nil
+}
+
+func (p *parser) argument(what string) (string, error) {
+	t, err := p.next()
+	if err != nil || (!t.quoted && strings.EqualFold(t.text, "if")) {
+		return "", fmt.Errorf("missing %s", what)
+	}
+	if t.text == "" {
+		return "", fmt.Errorf("empty %s", what)
+	}
+	return t.text, nil
+}
+
+func (p *parser) or() (cond, error) {
+	left, err := p.and()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("or") {
+		right, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(v *view) bool { return l(v) || right(v) }
+	}
+	return left, nil
+}
+
+func (p *parser) and() (cond, error) {
+	left, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("and") {
+		right, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(v *view) bool { return l(v) && right(v) }
+	}
+	return left, nil
+}
+
+func (p *parser) unary() (cond, error) {
+	if p.keyword("not") {
+		c, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return func(v *view) bool { return !c(v) }, nil
+	}
+	if t, ok := p.peek(); ok && !t.quoted && t.text == "(" {
+		p.pos++
+		c, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		return c, p.expect(")")
+	}
+	return p.comparison()
+}
+
+// comparison reads: field op value, or field in (value, ...).
+func (p *parser) comparison() (cond, error) {
+	ft, err := p.next()
+	if err != nil {
+		return nil, err
+	}
+	f, ok := fields[strings.ToLower(ft.text)]
+	if !ok || ft.quoted {
+		return nil, fmt.Errorf("unknown field %q; want kind, channel, user, user_id, badge or text", ft.text)
+	}
+
+	op, err := p.next()
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case !op.quoted && strings.EqualFold(op.text, "in"):
+		vals, err := p.list()
+		if err != nil {
+			return nil, err
+		}
+		return f.in(vals), nil
+	case !op.quoted && strings.EqualFold(op.text, "contains"):
+		if f.badge {
+			return nil, fmt.Errorf("badge does not support contains")
+		}
+		val, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		get := f.get
+		return func(v *view) bool { return strings.Contains(get(v), val) }, nil
+	case op.quoted:
+	case op.text == "=" || op.text == "!=":
+		val, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		c := f.in([]string{val})
+		if op.text == "!=" {
+			return func(v *view) bool { return !c(v) }, nil
+		}
+		return c, nil
+	case op.text == "~" || op.text == "!~":
+		if f.badge {
+			return nil, fmt.Errorf("badge does not support %s", op.text)
+		}
+		val, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		re, err := regexp.Compile(val)
+		if err != nil {
+			return nil, fmt.Errorf("bad regexp %q: %w", val, err)
+		}
+		get := f.get
+		if op.text == "!~" {
+			return func(v *view) bool { return !re.MatchString(get(v)) }, nil
+		}
+		return func(v *view) bool { return re.MatchString(get(v)) }, nil
+	}
+	return nil, fmt.Errorf("unknown operator %q after %s; want =, !=, ~, !~, contains or in", op.text, ft.text)
+}
+
+func (p *parser) value() (string, error) {
+	t, err := p.next()
+	if err != nil {
+		return "", fmt.Errorf("missing value: %w", err)
+	}
+	if !t.quoted && strings.ContainsAny(t.text, "(),=~") {
+		return "", fmt.Errorf("expected a value, got %q", t.text)
+	}
+	return t.text, nil
+}
+
+// list reads: ( value {, value} ).
+func (p *parser) list() ([]string, error) {
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+	var vals []string
+	for {
+		v, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		vals = append(vals, v)
+		t, err := p.next()
+		if err != nil {
+			return nil, fmt.Errorf("unterminated list")
+		}
+		switch {
+		case !t.quoted && t.text == ")":
+			return vals, nil
+		case !t.quoted && t.text == ",":
+		default:
+			return nil, fmt.Errorf("expected \",\" or \")\" in list, got %q", t.text)
+		}
+	}
+}
@@ -0,0 +1,237 @@
+// Package filter drops, samples, routes and tags events on their way to
+// Kafka according to a small rule language, one rule per line:
+//
+//	# comments start with '#'
+//	drop if user in (nightbot, streamelements)
+//	drop if kind = privmsg and not channel in (chess, speedrun)
+//	sample 0.1 if channel = xqc and not badge in (moderator, vip)
+//	route chat-giveaways if text ~ `(?i)\bgiveaway\b`
+//	tag mod if badge = moderator
+//
+// A rule is an action, an optional argument and an optional condition.
+// Actions:
+//
+//	drop          discard the event
+//	sample RATIO  keep only that share, in (0,1], of matching events
+//	route TOPIC   write the event to TOPIC instead of the default topic
+//	tag NAME      label the event; labels go out as a Kafka header
+//
+// Conditions compare the fields kind, channel, user (login), user_id,
+// badge and text with = and != (case-insensitive except for user_id and
+// text), ~ and !~ (regular expressions), contains (substring, case
+// sensitive) and in (a parenthesised list), combined with not, and, or
+// and parentheses. badge = NAME matches any version of that badge;
+// badge = NAME/VERSION matches exactly. Channel values may keep their '#'.
+// Bare values are made of letters and digits in any script and _-.:/#*;
+// anything else, such as spaces, operators or emoji, is written as a Go
+// string, in double quotes or, for regular expressions, backquotes.
+//
+// Rules run top to bottom. drop, and sample for the events it leaves out,
+// end evaluation; the first matching route wins; tags accumulate.
+package filter
+
+import (
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+
+	ircevents "github.com/Jamie-38/stream-pipeline/internal/irc_events"
+)
+
+type action int
+
+const (
+	actDrop action = iota
+	actSample
+	actRoute
+	actTag
+)
+
+// cond is a compiled condition.
+type cond func(v *view) bool
+
+type rule struct {
+	action action
+	arg    string  // topic or tag
+	ratio  float64 // sample
+	cond   cond    // nil matches everything
+}
+
+// view is the part of an event the rules can see.
+type view struct {
+	kind    string
+	channel string
+	user    string
+	userID  string
+	text    string
+	badges  map[string]string
+}
+
+// views recycles the view Eval hands to conditions, which would
+// otherwise escape to the heap on every event.
+var views = sync.Pool{New: func() any { return new(view) }}
+
+func (v *view) load(evt ircevents.Event) {
+	*v = view{kind: evt.Kind()}
+	switch e := evt.(type) {
+	case ircevents.PrivMsg:
+		v.channel, v.user, v.userID, v.text, v.badges = e.ChannelLogin, e.UserLogin, e.UserID, e.Text, e.Badges
+	case ircevents.Attributed:
+		v.channel, v.user = e.Channel(), e.User()
+	}
+}
+
+// field describes how a condition reads one field of a view.
+type field struct {
+	get   func(v *view) string
+	fold  bool // compared case-insensitively
+	badge bool // matched against the badge set instead of get
+}
+
+var fields = map[string]field{
+	"kind":    {get: func(v *view) string { return v.kind }, fold: true},
+	"channel": {get: func(v *view) string { return v.channel }, fold: true},
+	"user":    {get: func(v *view) string { return v.user }, fold: true},
+	"user_id": {get: func(v *view) string { return v.userID }},
+	"text":    {get: func(v *view) string { return v.text }},
+	"badge":   {badge: true, fold: true},
+}
+
+// in compiles membership of the field in vals.
+func (f field) in(vals []string) cond {
+	set := make(map[string]struct{}, len(vals))
+	for _, s := range vals {
+		if f.fold {
+			s = strings.ToLower(s)
+		}
+		if f.get != nil && f.fold {
+			s = strings.TrimPrefix(s, "#") // channels
+		}
+		set[s] = struct{}{}
+	}
+	if f.badge {
+		return func(v *view) bool {
+			for name, version := range v.badges {
+				if _, ok := set[name]; ok {
+					return true
+				}
+				if _, ok := set[name+"/"+version]; ok {
+					return true
+				}
+			}
+			return false
+		}
+	}
+	get := f.get
+	if f.fold {
+		return func(v *view) bool {
+			_, ok := set[strings.ToLower(strings.TrimPrefix(get(v), "#"))]
+			return ok
+		}
+	}
+	return func(v *view) bool {
+		_, ok := set[get(v)]
+		return ok
+	}
+}
+
+// Rules is a compiled rule set. The zero value and nil keep everything.
+type Rules struct {
+	Path  string // file the rules came from, if any
+	rules []rule
+	rand  func() float64
+}
+
+// Decision is what the rules made of one event.
+type Decision struct {
+	Drop    bool
+	Sampled bool     // dropped by a sample rule
+	Topic   string   // first matching route; empty keeps the default
+	Tags    []string // in rule order, without duplicates
+}
+
+// Parse compiles src. name prefixes error positions. Every bad line is
+// reported, not only the first.
+func Parse(name, src string) (*Rules, error) {
+	rs := &Rules{rand: rand.Float64}
+	var errs []error
+	for i, line := range strings.Split(src, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		r, err := parseRule(line)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s:%d: %w", name, i+1, err))
+			continue
+		}
+		rs.rules = append(rs.rules, r)
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return rs, nil
+}
+
+// Load compiles the rules file at path. An empty path yields no rules.
+func Load(path string) (*Rules, error) {
+	if path == "" {
+		return &Rules{}, nil
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("filter: read rules %q: %w", path, err)
+	}
+	rs, err := Parse(path, string(b))
+	if err != nil {
+		return nil, fmt.Errorf("filter: %w", err)
+	}
+	rs.Path = path
+	return rs, nil
+}
+
+// Len is the number of rules.
+func (rs *Rules) Len() int {
+	if rs == nil {
+		return 0
+	}
+	return len(rs.rules)
+}
+
+// Eval runs the rules against evt.
+func (rs *Rules) Eval(evt ircevents.Event) Decision {
+	var d Decision
+	if rs.Len() == 0 {
+		return d
+	}
+	v := views.Get().(*view)
+	defer views.Put(v)
+	v.load(evt)
+	for i := range rs.rules {
+		r := &rs.rules[i]
+		if r.cond != nil && !r.cond(v) {
+			continue
+		}
+		switch r.action {
+		case actDrop:
+			return Decision{Drop: true}
+		case actSample:
+			if rs.rand() >= r.ratio {
+				return Decision{Drop: true, Sampled: true}
+			}
+		case actRoute:
+			if d.Topic == "" {
+				d.Topic = r.arg
+			}
+		case actTag:
+			if !slices.Contains(d.Tags, r.arg) {
+				d.Tags = append(d.Tags, r.arg)
+			}
+		}
+	}
+	return d
+}
@@ -0,0 +1,208 @@
+package filter
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	ircevents "github.com/Jamie-38/stream-pipeline/internal/irc_events"
+)
+
+const spamRules = `
+# bots and channels we do not collect
+drop if user in (nightbot, streamelements, moobot)
+drop if kind = privmsg and not channel in (#chess, speedrun, xqc)
+
+sample 0.5 if channel = xqc and not badge in (moderator, vip)
+route chat-giveaways if text ~ ` + "`(?i)\\bgiveaway\\b`" + `
+route chat-other if text contains "!drop"
+tag mod if badge = moderator
+tag sub12 if badge = subscriber/12
+tag known if user_id = "42" or (user = Alice and badge != broadcaster)
+`
+
+func msg(channel, user, text string, badges ...string) ircevents.PrivMsg {
+	m := ircevents.PrivMsg{UserID: "1", UserLogin: user, ChannelID: "9", ChannelLogin: channel, Text: text}
+	for _, b := range badges {
+		if m.Badges == nil {
+			m.Badges = map[string]string{}
+		}
+		name, version, _ := strings.Cut(b, "/")
+		m.Badges[name] = version
+	}
+	return m
+}
+
+func mustParse(t testing.TB, src string) *Rules {
+	t.Helper()
+	rs, err := Parse("test", src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rs
+}
+
+func TestEval(t *testing.T) {
+	rs := mustParse(t, spamRules)
+	keep := 0.9
+	rs.rand = func() float64 { return keep }
+
+	for _, tc := range []struct {
+		name string
+		evt  ircevents.Event
+		want Decision
+	}{
+		{"bot", msg("chess", "nightbot", "hi"), Decision{Drop: true}},
+		{"plain", msg("chess", "bob", "hi"), Decision{}},
+		{"other channel dropped", msg("knitting", "bob", "hi"), Decision{Drop: true}},
+		{"sampled out", msg("xqc", "bob", "hi"), Decision{Drop: true, Sampled: true}},
+		{"mods skip sampling", msg("xqc", "bob", "hi", "moderator/1"), Decision{Tags: []string{"mod"}}},
+		{"regex route", msg("chess", "bob", "A GiveAway now"), Decision{Topic: "chat-giveaways"}},
+		{"first route wins", msg("chess", "bob", "giveaway !drop"), Decision{Topic: "chat-giveaways"}},
+		{"substring route", msg("chess", "bob", "type !drop"), Decision{Topic: "chat-other"}},
+		{"badge version", msg("chess", "bob", "hi", "subscriber/12"), Decision{Tags: []string{"sub12"}}},
+		{"other badge version", msg("chess", "bob", "hi", "subscriber/3"), Decision{}},
+		{"tags accumulate", msg("speedrun", "alice", "hi", "moderator/1"), Decision{Tags: []string{"mod", "known"}}},
+		{"not broadcaster", msg("speedrun", "alice", "hi", "broadcaster/1"), Decision{}},
+	} {
+		got := rs.Eval(tc.evt)
+		if got.Drop != tc.want.Drop || got.Sampled != tc.want.Sampled || got.Topic != tc.want.Topic || !slices.Equal(got.Tags, tc.want.Tags) {
+			t.Errorf("%s: got %+v, want %+v", tc.name, got, tc.want)
+		}
+	}
+
+	keep = 0.1
+	if d := rs.Eval(msg("xqc", "bob", "hi")); d.Drop {
+		t.Fatalf("sample kept nothing: %+v", d)
+	}
+}
+
+func TestParseReportsEveryBadLine(t *testing.T) {
+	_, err := Parse("rules.txt", `
+drop if usr = bob
+route
+sample 2 if channel = xqc
+drop if text ~ "("
+tag x if channel = a and
+drop if badge contains mod
+explode
+drop if channel in (a b)
+`)
+	if err == nil {
+		t.Fatal("bad rules compiled")
+	}
+	for _, want := range []string{
+		`rules.txt:2: unknown field "usr"`,
+		`rules.txt:3: missing topic`,
+		`rules.txt:4: sample ratio "2" must be within (0,1]`,
+		`rules.txt:5: bad regexp "("`,
+		`rules.txt:6: unexpected end of rule`,
+		`rules.txt:7: badge does not support contains`,
+		`rules.txt:8: unknown action "explode"`,
+		`rules.txt:9: expected "," or ")" in list, got "b"`,
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("missing %q in:\n%v", want, err)
+		}
+	}
+}
+
+func TestParseUnicodeWords(t *testing.T) {
+	rs := mustParse(t, "route chat-fr if text contains café or user = Émilie")
+	if d := rs.Eval(msg("chess", "bob", "un café ?")); d.Topic != "chat-fr" {
+		t.Fatalf("unquoted UTF-8 value did not match: %+v", d)
+	}
+	if d := rs.Eval(msg("chess", "émilie", "salut")); d.Topic != "chat-fr" {
+		t.Fatalf("UTF-8 login not folded: %+v", d)
+	}
+	if _, err := Parse("test", "drop if text contains 🎉"); err == nil || !strings.Contains(err.Error(), `unexpected '🎉'`) {
+		t.Fatalf("bare emoji err = %v", err)
+	}
+}
+
+func TestNilAndEmptyRulesKeepEverything(t *testing.T) {
+	var nilRules *Rules
+	if d := nilRules.Eval(msg("chess", "bob", "hi")); d.Drop || d.Topic != "" {
+		t.Fatalf("nil rules decided %+v", d)
+	}
+	rs, err := Load("")
+	if err != nil || rs.Len() != 0 {
+		t.Fatalf("Load(\"\") = %v, %v", rs, err)
+	}
+}
+
+func TestRunAppliesReloadedRules(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rules.txt")
+	if err := os.WriteFile(path, []byte("route chat-b if channel = b\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	rs, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := NewEngine(rs)
+
+	in := make(chan ircevents.Event, 4)
+	out := make(chan ircevents.Event, 4)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go Run(ctx, in, out, e)
+
+	recv := func() ircevents.Event {
+		t.Helper()
+		select {
+		case evt := <-out:
+			return evt
+		case <-time.After(time.Second):
+			t.Fatal("no event forwarded")
+			return nil
+		}
+	}
+
+	in <- msg("a", "bob", "hi")
+	if _, ok := recv().(ircevents.PrivMsg); !ok {
+		t.Fatal("unmatched event was wrapped")
+	}
+	in <- msg("b", "bob", "hi")
+	if r, ok := recv().(ircevents.Routed); !ok || r.Topic != "chat-b" {
+		t.Fatalf("matched event not routed: %+v", r)
+	}
+
+	e.Set(mustParse(t, "drop if channel = a"))
+	in <- msg("a", "bob", "dropped")
+	in <- msg("b", "bob", "kept")
+	if m, ok := recv().(ircevents.PrivMsg); !ok || m.Text != "kept" {
+		t.Fatalf("reloaded rules not applied: %+v", m)
+	}
+}
+
+var sink Decision
+
+func benchRules(b *testing.B, src string, evt ircevents.Event) {
+	rs := mustParse(b, src)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		sink = rs.Eval(evt)
+	}
+}
+
+func BenchmarkEvalNoRules(b *testing.B) {
+	benchRules(b, "", msg("chess", "bob", "hello there"))
+}
+
+func BenchmarkEvalNoMatch(b *testing.B) {
+	benchRules(b, spamRules, msg("chess", "bob", "just an ordinary chat line about the opening", "subscriber/3"))
+}
+
+func BenchmarkEvalDrop(b *testing.B) {
+	benchRules(b, spamRules, msg("chess", "nightbot", "follow the channel!"))
+}
+
+func BenchmarkEvalRegexRoute(b *testing.B) {
+	benchRules(b, spamRules, msg("speedrun", "bob", "there is a GIVEAWAY at the end of the run", "moderator/1"))
+}
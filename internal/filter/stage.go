@@ -0,0 +1,90 @@
+package filter
+
+import (
+	"context"
+	"sync/atomic"
+
+	ircevents "github.com/Jamie-38/stream-pipeline/internal/irc_events"
+	"github.com/Jamie-38/stream-pipeline/internal/observe"
+)
+
+var (
+	filterEvents = observe.Metrics().Counter("filter_events_total",
+		"Events through the filter stage, by outcome.", "outcome")
+	passed     = filterEvents.With("passed")
+	dropped    = filterEvents.With("dropped")
+	sampledOut = filterEvents.With("sampled_out")
+	routed     = filterEvents.With("routed")
+)
+
+// Engine holds the rules the filter stage evaluates. Set swaps them
+// atomically, so rules reload while events flow.
+type Engine struct {
+	rules atomic.Pointer[Rules]
+}
+
+func NewEngine(rs *Rules) *Engine {
+	e := &Engine{}
+	e.Set(rs)
+	return e
+}
+
+// Rules returns the current rules.
+func (e *Engine) Rules() *Rules { return e.rules.Load() }
+
+// Set replaces the rules; nil keeps everything.
+func (e *Engine) Set(rs *Rules) {
+	e.rules.Store(rs)
+	observe.C("filter").Info("filter rules loaded", "path", rs.pathOrNone(), "rules", rs.Len())
+}
+
+// Status reports the rules in force for /v1/status.
+func (e *Engine) Status() any {
+	rs := e.Rules()
+	return map[string]any{"path": rs.pathOrNone(), "rules": rs.Len()}
+}
+
+func (rs *Rules) pathOrNone() string {
+	if rs == nil {
+		return ""
+	}
+	return rs.Path
+}
+
+// Run forwards events from in to out under the engine's current rules.
+// Routed or tagged events go out wrapped in an ircevents.Routed.
+func Run(ctx context.Context, in <-chan ircevents.Event, out chan<- ircevents.Event, e *Engine) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case evt, ok := <-in:
+			if !ok {
+				return
+			}
+			d := e.Rules().Eval(evt)
+			switch {
+			case d.Sampled:
+				sampledOut.Inc()
+				continue
+			case d.Drop:
+				dropped.Inc()
+				continue
+			case d.Topic != "" || len(d.Tags) > 0:
+				if d.Topic != "" {
+					routed.Inc()
+				} else {
+					passed.Inc()
+				}
+				evt = ircevents.Routed{Event: evt, Topic: d.Topic, Tags: d.Tags}
+			default:
+				passed.Inc()
+			}
+			select {
+			case out <- evt:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
+}
@@ -1,8 +1,11 @@
 package healthcheck
 
 import (
+	"encoding/json"
 	"net/http"
-	"sync/atomic"
+	"sort"
+	"strings"
+	"sync"
 	"time"
 
 	"log/slog"
@@ -10,42 +13,225 @@ import (
 	"github.com/Jamie-38/stream-pipeline/internal/observe"
 )
 
-type Probe struct {
-	ready int32 // 0 = not ready, 1 = ready
-	lg    *slog.Logger
+// Options are the rules one component is judged by.
+type Options struct {
+	// Optional components are reported but never fail /readyz.
+	Optional bool
+	// MaxSilence makes the component not ready when Beat has not been
+	// called for this long. 0 disables the rule.
+	MaxSilence time.Duration
+	// StallAfter fails /livez when Beat has not been called for this long.
+	// 0 disables liveness tracking for the component.
+	StallAfter time.Duration
+	// Pending, when set, limits stall detection to times when the component
+	// has queued work, so an idle consumer is not reported as stalled.
+	Pending func() int
 }
 
-func New(component string) *Probe {
-	return &Probe{
-		lg: observe.C("healthcheck").With("component", component),
+// Component is one part of the process reporting its own health.
+type Component struct {
+	name string
+	lg   *slog.Logger
+
+	mu       sync.Mutex
+	opts     Options
+	ready    bool
+	detail   string
+	lastBeat time.Time
+	changed  time.Time
+}
+
+// SetReady records whether the component can do its job, with a short
+// human-readable reason.
+func (c *Component) SetReady(ready bool, detail string) {
+	c.mu.Lock()
+	prev := c.ready
+	c.ready, c.detail = ready, detail
+	if prev != ready {
+		c.changed = time.Now()
+	}
+	c.mu.Unlock()
+	if prev != ready {
+		from, to := "not_ready", "ready"
+		if !ready {
+			from, to = to, from
+		}
+		c.lg.Info("readiness transition", "from", from, "to", to, "detail", detail)
+	}
+}
+
+// Beat records activity for liveness and silence rules.
+func (c *Component) Beat() {
+	c.mu.Lock()
+	c.lastBeat = time.Now()
+	c.mu.Unlock()
+}
+
+// ComponentStatus is the JSON view of one component.
+type ComponentStatus struct {
+	Ready        bool      `json:"ready"`
+	Live         bool      `json:"live"`
+	Optional     bool      `json:"optional,omitempty"`
+	Detail       string    `json:"detail,omitempty"`
+	LastActivity time.Time `json:"last_activity"`
+	Since        time.Time `json:"since"` // last readiness change
+}
+
+func (c *Component) status(now time.Time) ComponentStatus {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	st := ComponentStatus{
+		Ready:        c.ready,
+		Live:         true,
+		Optional:     c.opts.Optional,
+		Detail:       c.detail,
+		LastActivity: c.lastBeat.UTC(),
+		Since:        c.changed.UTC(),
+	}
+	silent := now.Sub(c.lastBeat)
+	if st.Ready && c.opts.MaxSilence > 0 && silent > c.opts.MaxSilence {
+		st.Ready = false
+		st.Detail = "no activity for " + silent.Truncate(time.Second).String()
+	}
+	if c.opts.StallAfter > 0 && silent > c.opts.StallAfter && (c.opts.Pending == nil || c.opts.Pending() > 0) {
+		st.Live = false
+		st.Detail = "stalled for " + silent.Truncate(time.Second).String()
+	}
+	return st
+}
+
+// Registry aggregates components into /livez and /readyz.
+type Registry struct {
+	mu         sync.Mutex
+	components map[string]*Component
+	optional   map[string]bool // forced optional by SetOptional
+}
+
+func NewRegistry() *Registry {
+	return &Registry{components: make(map[string]*Component), optional: make(map[string]bool)}
+}
+
+var defaultRegistry = NewRegistry()
+
+// Default returns the process-wide registry.
+func Default() *Registry { return defaultRegistry }
+
+// Component returns the named component, creating it not ready. It never
+// changes the component's options, so stages can look themselves up
+// without knowing how main configured them.
+func (r *Registry) Component(name string) *Component {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.componentLocked(name)
+}
+
+func (r *Registry) componentLocked(name string) *Component {
+	if c, ok := r.components[name]; ok {
+		return c
+	}
+	now := time.Now()
+	c := &Component{
+		name:     name,
+		lg:       observe.C("healthcheck").With("check", name),
+		detail:   "no report yet",
+		lastBeat: now,
+		changed:  now,
+	}
+	c.opts.Optional = r.optional[name]
+	r.components[name] = c
+	return c
+}
+
+// Register returns the named component with opts applied.
+func (r *Registry) Register(name string, opts Options) *Component {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	c := r.componentLocked(name)
+	opts.Optional = opts.Optional || r.optional[name]
+	c.mu.Lock()
+	c.opts = opts
+	c.mu.Unlock()
+	return c
+}
+
+// SetOptional marks components, present or future, as not gating /readyz.
+func (r *Registry) SetOptional(names ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, n := range names {
+		n = strings.TrimSpace(n)
+		if n == "" {
+			continue
+		}
+		r.optional[n] = true
+		if c, ok := r.components[n]; ok {
+			c.mu.Lock()
+			c.opts.Optional = true
+			c.mu.Unlock()
+		}
+	}
+}
+
+// Report is the JSON body of /livez and /readyz.
+type Report struct {
+	Status     string                     `json:"status"`
+	Time       time.Time                  `json:"ts"`
+	Components map[string]ComponentStatus `json:"components"`
+}
+
+// Check evaluates every component at now.
+func (r *Registry) Check(now time.Time) (ready, live bool, rep Report) {
+	r.mu.Lock()
+	names := make([]string, 0, len(r.components))
+	for n := range r.components {
+		names = append(names, n)
+	}
+	cs := make([]*Component, len(names))
+	sort.Strings(names)
+	for i, n := range names {
+		cs[i] = r.components[n]
+	}
+	r.mu.Unlock()
+
+	ready, live = true, true
+	rep = Report{Time: now.UTC(), Components: make(map[string]ComponentStatus, len(cs))}
+	for i, c := range cs {
+		st := c.status(now)
+		rep.Components[names[i]] = st
+		if !st.Live {
+			live = false
+		}
+		if !st.Ready && !st.Optional {
+			ready = false
+		}
 	}
+	return ready, live, rep
 }
 
-func (p *Probe) Register(mux *http.ServeMux) {
+// RegisterHandlers mounts /healthz (process up), /livez and /readyz on mux.
+func (r *Registry) RegisterHandlers(mux *http.ServeMux) {
 	mux.HandleFunc("/healthz", func(w http.ResponseWriter, _ *http.Request) {
 		w.WriteHeader(http.StatusOK)
 		w.Write([]byte("ok"))
 	})
+	mux.HandleFunc("/livez", func(w http.ResponseWriter, _ *http.Request) {
+		_, live, rep := r.Check(time.Now())
+		writeReport(w, live, "live", "stalled", rep)
+	})
 	mux.HandleFunc("/readyz", func(w http.ResponseWriter, _ *http.Request) {
-		if atomic.LoadInt32(&p.ready) == 1 {
-			w.WriteHeader(http.StatusOK)
-			w.Write([]byte("ready"))
-			return
-		}
-		http.Error(w, "not ready", http.StatusServiceUnavailable)
+		ready, _, rep := r.Check(time.Now())
+		writeReport(w, ready, "ready", "not_ready", rep)
 	})
 }
 
-func (p *Probe) SetReady() {
-	prev := atomic.SwapInt32(&p.ready, 1)
-	if prev != 1 {
-		p.lg.Info("readiness transition", "from", "not_ready", "to", "ready", "ts", time.Now().UTC())
-	}
-}
-
-func (p *Probe) SetNotReady() {
-	prev := atomic.SwapInt32(&p.ready, 0)
-	if prev != 0 {
-		p.lg.Info("readiness transition", "from", "ready", "to", "not_ready", "ts", time.Now().UTC())
+func writeReport(w http.ResponseWriter, ok bool, good, bad string, rep Report) {
+	code := http.StatusOK
+	rep.Status = good
+	if !ok {
+		code = http.StatusServiceUnavailable
+		rep.Status = bad
 	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(rep)
 }
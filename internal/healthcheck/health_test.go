@@ -0,0 +1,83 @@
+package healthcheck
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestReadinessAggregatesRequiredComponents(t *testing.T) {
+	r := NewRegistry()
+	ws := r.Register("irc_websocket", Options{})
+	kafka := r.Register("kafka", Options{})
+	r.SetOptional("kafka")
+
+	if ready, _, _ := r.Check(time.Now()); ready {
+		t.Fatal("ready before any component reported")
+	}
+	ws.SetReady(true, "connected")
+	kafka.SetReady(false, "last write failed")
+	ready, _, rep := r.Check(time.Now())
+	if !ready {
+		t.Fatalf("optional failure blocked readiness: %+v", rep)
+	}
+	if st := rep.Components["kafka"]; st.Ready || !st.Optional || st.Detail != "last write failed" {
+		t.Fatalf("kafka status = %+v", st)
+	}
+}
+
+func TestMaxSilenceAndStall(t *testing.T) {
+	r := NewRegistry()
+	ws := r.Register("irc_websocket", Options{MaxSilence: time.Minute})
+	pending := 0
+	cls := r.Register("classifier", Options{StallAfter: time.Minute, Pending: func() int { return pending }})
+	ws.SetReady(true, "connected")
+	cls.SetReady(true, "running")
+	ws.Beat()
+	cls.Beat()
+
+	later := time.Now().Add(2 * time.Minute)
+	ready, live, rep := r.Check(later)
+	if ready {
+		t.Fatalf("silent websocket still ready: %+v", rep.Components["irc_websocket"])
+	}
+	if !live {
+		t.Fatal("idle classifier reported stalled")
+	}
+
+	pending = 3
+	if _, live, rep := r.Check(later); live || rep.Components["classifier"].Live {
+		t.Fatalf("classifier with queued work not stalled: %+v", rep.Components["classifier"])
+	}
+}
+
+func TestHandlersReportJSON(t *testing.T) {
+	r := NewRegistry()
+	r.Register("http_api", Options{})
+	mux := http.NewServeMux()
+	r.RegisterHandlers(mux)
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("GET", "/readyz", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("readyz = %d, want 503", w.Code)
+	}
+	var rep Report
+	if err := json.NewDecoder(w.Body).Decode(&rep); err != nil {
+		t.Fatal(err)
+	}
+	if rep.Status != "not_ready" || rep.Components["http_api"].Detail != "no report yet" {
+		t.Fatalf("report = %+v", rep)
+	}
+
+	r.Component("http_api").SetReady(true, "listening")
+	for _, path := range []string{"/readyz", "/livez", "/healthz"} {
+		w = httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s = %d, want 200", path, w.Code)
+		}
+	}
+}
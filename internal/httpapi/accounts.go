@@ -0,0 +1,158 @@
+package httpapi
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/Jamie-38/stream-pipeline/internal/apiauth"
+	"github.com/Jamie-38/stream-pipeline/internal/observe"
+	"github.com/Jamie-38/stream-pipeline/internal/types"
+)
+
+// Account is the control surface of one collector account. ControlCh is
+// required; the other fields are optional like their With* counterparts.
+type Account struct {
+	ControlCh chan types.IRCCommand
+	History   ChannelHistory
+	Catalog   ChannelCatalog
+	Sender    ChatSender
+	Status    StatusFunc
+}
+
+// WithAccount exposes a under /v1/accounts/{name}/. The unscoped routes
+// keep serving the control channel passed to Run.
+func WithAccount(name string, a Account) Option {
+	return func(o *options) {
+		if o.accounts == nil {
+			o.accounts = make(map[string]Account)
+		}
+		o.accounts[strings.ToLower(name)] = a
+	}
+}
+
+// accountHandlers are the controllers of one account.
+type accountHandlers struct {
+	api     *APIController
+	history *HistoryController
+	catalog *CatalogController
+	chat    *ChatController
+	status  StatusFunc
+}
+
+type accountRoutes map[string]*accountHandlers
+
+func newAccountRoutes(accounts map[string]Account) accountRoutes {
+	ar := make(accountRoutes, len(accounts))
+	for name, a := range accounts {
+		h := &accountHandlers{
+			api:    &APIController{ControlCh: a.ControlCh, lg: observe.C("http_api").With("account", name)},
+			status: a.Status,
+		}
+		if a.History != nil {
+			h.history = &HistoryController{History: a.History, lg: observe.C("http_history").With("account", name)}
+		}
+		if a.Catalog != nil {
+			h.catalog = &CatalogController{Catalog: a.Catalog, lg: observe.C("http_channels").With("account", name)}
+		}
+		if a.Sender != nil {
+			h.chat = &ChatController{Sender: a.Sender, lg: observe.C("http_chat").With("account", name)}
+		}
+		ar[name] = h
+	}
+	return ar
+}
+
+type accountSummary struct {
+	Name   string `json:"name"`
+	Status any    `json:"status,omitempty"`
+}
+
+// List names every account with its status section, if any.
+func (ar accountRoutes) List(w http.ResponseWriter, r *http.Request) {
+	out := make([]accountSummary, 0, len(ar))
+	for name, h := range ar {
+		s := accountSummary{Name: name}
+		if h.status != nil {
+			s.Status = h.status()
+		}
+		out = append(out, s)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	writeJSON(w, http.StatusOK, out)
+}
+
+// scoped resolves {acct} and serves the handler pick returns for it; a nil
+// handler means the account lacks that capability.
+func (ar accountRoutes) scoped(pick func(*accountHandlers) http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		h, ok := ar[strings.ToLower(r.PathValue("acct"))]
+		if !ok {
+			http.Error(w, "Unknown account", http.StatusNotFound)
+			return
+		}
+		fn := pick(h)
+		if fn == nil {
+			http.NotFound(w, r)
+			return
+		}
+		fn(w, r)
+	}
+}
+
+func (ar accountRoutes) mount(mux *http.ServeMux, guard *apiauth.Guard) {
+	route := func(pattern string, role apiauth.Role, pick func(*accountHandlers) http.HandlerFunc) {
+		mux.Handle(pattern, guard.RequireFunc(role, ar.scoped(pick)))
+	}
+	const base = "/v1/accounts/{acct}"
+
+	mux.Handle("GET /v1/accounts", guard.RequireFunc(apiauth.RoleRead, ar.List))
+	route(base+"/join", apiauth.RoleAdmin, func(h *accountHandlers) http.HandlerFunc { return h.api.Join })
+	route(base+"/part", apiauth.RoleAdmin, func(h *accountHandlers) http.HandlerFunc { return h.api.Part })
+	route("POST "+base+"/channels/batch", apiauth.RoleAdmin, func(h *accountHandlers) http.HandlerFunc { return h.api.Batch })
+
+	route("GET "+base+"/channels/history", apiauth.RoleRead, func(h *accountHandlers) http.HandlerFunc {
+		if h.history == nil {
+			return nil
+		}
+		return h.history.List
+	})
+	route("GET "+base+"/channels/diff", apiauth.RoleRead, func(h *accountHandlers) http.HandlerFunc {
+		if h.history == nil {
+			return nil
+		}
+		return h.history.Diff
+	})
+	route("POST "+base+"/channels/restore", apiauth.RoleAdmin, func(h *accountHandlers) http.HandlerFunc {
+		if h.history == nil {
+			return nil
+		}
+		return h.history.Restore
+	})
+
+	route("GET "+base+"/channels", apiauth.RoleRead, func(h *accountHandlers) http.HandlerFunc {
+		if h.catalog == nil {
+			return nil
+		}
+		return h.catalog.List
+	})
+	route("PUT "+base+"/channels/{channel}", apiauth.RoleAdmin, func(h *accountHandlers) http.HandlerFunc {
+		if h.catalog == nil {
+			return nil
+		}
+		return h.catalog.Update
+	})
+
+	route("POST "+base+"/chat/send", apiauth.RoleAdmin, func(h *accountHandlers) http.HandlerFunc {
+		if h.chat == nil {
+			return nil
+		}
+		return h.chat.Send
+	})
+	route("GET "+base+"/status", apiauth.RoleRead, func(h *accountHandlers) http.HandlerFunc {
+		if h.status == nil {
+			return nil
+		}
+		return func(w http.ResponseWriter, r *http.Request) { writeJSON(w, http.StatusOK, h.status()) }
+	})
+}
@@ -0,0 +1,56 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Jamie-38/stream-pipeline/internal/apiauth"
+	"github.com/Jamie-38/stream-pipeline/internal/types"
+)
+
+func TestAccountScopedRoutes(t *testing.T) {
+	chA := make(chan types.IRCCommand, 1)
+	chB := make(chan types.IRCCommand, 1)
+	mux := http.NewServeMux()
+	newAccountRoutes(map[string]Account{
+		"bot_a": {ControlCh: chA, Status: func() any { return "a-ok" }},
+		"bot_b": {ControlCh: chB},
+	}).mount(mux, apiauth.NewGuard(apiauth.Open{}, nil))
+
+	got := ackNext(chB, 4)
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("POST", "/v1/accounts/Bot_B/join?channel=chess", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("join status = %d, want 200: %s", w.Code, w.Body)
+	}
+	if cmd := <-got; cmd.Op != "JOIN" || cmd.Channel != "#chess" {
+		t.Fatalf("bot_b got %+v", cmd)
+	}
+	if len(chA) != 0 {
+		t.Fatal("bot_a received bot_b's command")
+	}
+
+	for path, want := range map[string]int{
+		"/v1/accounts/nobody/join?channel=x": http.StatusNotFound,
+		"/v1/accounts/bot_a/channels":        http.StatusNotFound, // no catalog
+		"/v1/accounts/bot_a/status":          http.StatusOK,
+	} {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		if w.Code != want {
+			t.Errorf("%s: status = %d, want %d", path, w.Code, want)
+		}
+	}
+
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("GET", "/v1/accounts", nil))
+	var list []accountSummary
+	if err := json.NewDecoder(w.Body).Decode(&list); err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 2 || list[0].Name != "bot_a" || list[0].Status != "a-ok" || list[1].Name != "bot_b" {
+		t.Fatalf("accounts = %+v", list)
+	}
+}
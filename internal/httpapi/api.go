@@ -2,11 +2,76 @@ package httpapi
 
 import (
 	"log/slog"
+	"time"
 
+	"github.com/Jamie-38/stream-pipeline/internal/apiauth"
+	"github.com/Jamie-38/stream-pipeline/internal/livetail"
 	"github.com/Jamie-38/stream-pipeline/internal/types"
 )
 
+// defaultAckTimeout bounds how long a handler waits for the controller to
+// persist a change when the request carries no earlier deadline.
+const defaultAckTimeout = 2 * time.Second
+
 type APIController struct {
-	ControlCh chan types.IRCCommand
-	lg        *slog.Logger
+	ControlCh  chan types.IRCCommand
+	AckTimeout time.Duration // 0 means defaultAckTimeout
+	lg         *slog.Logger
+}
+
+// Option customises Run.
+type Option func(*options)
+
+type options struct {
+	guard    *apiauth.Guard
+	hub      *livetail.Hub
+	history  ChannelHistory
+	catalog  ChannelCatalog
+	status   map[string]StatusFunc
+	sender   ChatSender
+	accounts map[string]Account
+	server   *ServerConfig
+	config   ConfigReloader
+}
+
+// WithGuard protects the API with guard. Without it every caller is admin.
+func WithGuard(g *apiauth.Guard) Option {
+	return func(o *options) { o.guard = g }
+}
+
+// WithHub exposes the live event tail at /v1/stream (SSE) and
+// /v1/stream/ws (WebSocket) for read-role callers.
+func WithHub(h *livetail.Hub) Option {
+	return func(o *options) { o.hub = h }
+}
+
+// WithHistory exposes the desired-channel journal under /v1/channels.
+func WithHistory(h ChannelHistory) Option {
+	return func(o *options) { o.history = h }
+}
+
+// WithCatalog exposes per-channel metadata at /v1/channels.
+func WithCatalog(c ChannelCatalog) Option {
+	return func(o *options) { o.catalog = c }
+}
+
+// WithSender exposes POST /v1/chat/send for admin callers.
+func WithSender(s ChatSender) Option {
+	return func(o *options) { o.sender = s }
+}
+
+// WithServer sets the listener. Without it Run reads ServerConfigFromEnv.
+func WithServer(sc ServerConfig) Option {
+	return func(o *options) { o.server = &sc }
+}
+
+// WithStatus adds a named section to GET /v1/status. Sections are computed
+// on every request and must be safe to call concurrently.
+func WithStatus(name string, fn StatusFunc) Option {
+	return func(o *options) {
+		if o.status == nil {
+			o.status = make(map[string]StatusFunc)
+		}
+		o.status[name] = fn
+	}
 }
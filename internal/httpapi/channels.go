@@ -0,0 +1,80 @@
+package httpapi
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Jamie-38/stream-pipeline/internal/types"
+)
+
+// ChannelCatalog exposes per-channel metadata of the desired set.
+type ChannelCatalog interface {
+	Entries() []types.ChannelEntry
+	UpdateMeta(ctx context.Context, e types.ChannelEntry, source string) (types.CommandResult, error)
+}
+
+type CatalogController struct {
+	Catalog ChannelCatalog
+	lg      *slog.Logger
+}
+
+// List returns every desired channel with its metadata.
+func (cc *CatalogController) List(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, cc.Catalog.Entries())
+}
+
+type metaRequest struct {
+	Priority int                  `json:"priority"`
+	Labels   []string             `json:"labels"`
+	Notes    string               `json:"notes"`
+	RoomID   string               `json:"room_id"`
+	Policy   *types.ChannelPolicy `json:"policy"`
+}
+
+// Update replaces the metadata of the channel named in the path.
+func (cc *CatalogController) Update(w http.ResponseWriter, r *http.Request) {
+	ch := strings.TrimPrefix(strings.TrimSpace(strings.ToLower(r.PathValue("channel"))), "#")
+	if ch == "" {
+		http.Error(w, "Missing channel", http.StatusBadRequest)
+		return
+	}
+	var req metaRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&req); err != nil {
+		http.Error(w, "invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	if p := req.Policy; p != nil && (p.SampleRate < 0 || p.SampleRate > 1) {
+		http.Error(w, "sample_rate must be within [0,1]", http.StatusBadRequest)
+		return
+	}
+
+	id := requestID(r)
+	w.Header().Set("X-Request-ID", id)
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	e := types.ChannelEntry{
+		Name: "#" + ch, Priority: req.Priority, Labels: req.Labels,
+		Notes: req.Notes, RoomID: req.RoomID, Policy: req.Policy,
+	}
+	res, err := cc.Catalog.UpdateMeta(ctx, e, "http:"+caller(r))
+	if err != nil {
+		cc.lg.Warn("metadata update rejected", "channel", ch, "request_id", id, "err", err)
+		status := http.StatusUnprocessableEntity
+		if ctx.Err() != nil {
+			status = http.StatusServiceUnavailable
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+	cc.lg.Info("metadata updated", "channel", ch, "version", res.Version, "request_id", id)
+	resp := commandResponse{RequestID: id, Op: "META", Channel: e.Name, Version: res.Version, Status: "unchanged"}
+	if res.Changed {
+		resp.Status = "applied"
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
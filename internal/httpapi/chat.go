@@ -0,0 +1,71 @@
+package httpapi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/Jamie-38/stream-pipeline/internal/scheduler"
+	"github.com/Jamie-38/stream-pipeline/internal/types"
+)
+
+// sendTimeout bounds queueing plus confirmation; it stays under the
+// server's WriteTimeout.
+const sendTimeout = 12 * time.Second
+
+// ChatSender delivers outgoing PRIVMSGs and reports their fate.
+type ChatSender interface {
+	Send(ctx context.Context, msg types.OutgoingMessage) (types.DeliveryStatus, error)
+}
+
+type ChatController struct {
+	Sender ChatSender
+	lg     *slog.Logger
+}
+
+// Send posts a message (optionally a threaded reply) and waits for Twitch
+// to confirm or reject it. Confirmed is 200, rejected 422, and a message
+// sent but never acknowledged 202.
+func (cc *ChatController) Send(w http.ResponseWriter, r *http.Request) {
+	var msg types.OutgoingMessage
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 16<<10)).Decode(&msg); err != nil {
+		http.Error(w, "invalid JSON body", http.StatusBadRequest)
+		return
+	}
+
+	id := requestID(r)
+	w.Header().Set("X-Request-ID", id)
+	ctx, cancel := context.WithTimeout(r.Context(), sendTimeout)
+	defer cancel()
+
+	st, err := cc.Sender.Send(ctx, msg)
+	switch {
+	case errors.Is(err, scheduler.ErrInvalidInput):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case errors.Is(err, scheduler.ErrReadOnly):
+		http.Error(w, "Account is read-only", http.StatusForbidden)
+		return
+	case errors.Is(err, scheduler.ErrQueueFull):
+		http.Error(w, "Send queue saturated", http.StatusServiceUnavailable)
+		return
+	case err != nil:
+		cc.lg.Warn("send expired", "channel", msg.Channel, "request_id", id, "err", err)
+		writeJSON(w, http.StatusServiceUnavailable, st)
+		return
+	}
+
+	cc.lg.Info("send finished", "channel", st.Channel, "status", st.Status, "reason", st.Reason,
+		"request_id", id, "caller", caller(r))
+	code := http.StatusOK
+	switch st.Status {
+	case "rejected":
+		code = http.StatusUnprocessableEntity
+	case "unconfirmed":
+		code = http.StatusAccepted
+	}
+	writeJSON(w, code, st)
+}
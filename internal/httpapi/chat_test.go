@@ -0,0 +1,51 @@
+package httpapi
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Jamie-38/stream-pipeline/internal/observe"
+	"github.com/Jamie-38/stream-pipeline/internal/scheduler"
+	"github.com/Jamie-38/stream-pipeline/internal/types"
+)
+
+type senderStub struct {
+	got types.OutgoingMessage
+	st  types.DeliveryStatus
+	err error
+}
+
+func (s *senderStub) Send(ctx context.Context, msg types.OutgoingMessage) (types.DeliveryStatus, error) {
+	s.got = msg
+	return s.st, s.err
+}
+
+func TestChatSendStatusCodes(t *testing.T) {
+	cases := []struct {
+		stub senderStub
+		want int
+	}{
+		{senderStub{st: types.DeliveryStatus{Status: "confirmed"}}, http.StatusOK},
+		{senderStub{st: types.DeliveryStatus{Status: "rejected", Reason: "msg_duplicate"}}, http.StatusUnprocessableEntity},
+		{senderStub{st: types.DeliveryStatus{Status: "unconfirmed"}}, http.StatusAccepted},
+		{senderStub{err: scheduler.ErrQueueFull}, http.StatusServiceUnavailable},
+		{senderStub{err: scheduler.ErrReadOnly}, http.StatusForbidden},
+		{senderStub{err: fmt.Errorf("%w: empty", scheduler.ErrInvalidInput)}, http.StatusBadRequest},
+	}
+	for _, tc := range cases {
+		cc := &ChatController{Sender: &tc.stub, lg: observe.C("httpapi_test")}
+		w := httptest.NewRecorder()
+		body := `{"channel":"#chess","text":"gg","reply_parent_msg_id":"p1"}`
+		cc.Send(w, httptest.NewRequest("POST", "/v1/chat/send", strings.NewReader(body)))
+		if w.Code != tc.want {
+			t.Fatalf("%+v: status = %d, want %d", tc.stub, w.Code, tc.want)
+		}
+		if tc.stub.got.ReplyParentMsgID != "p1" {
+			t.Fatalf("reply parent not forwarded: %+v", tc.stub.got)
+		}
+	}
+}
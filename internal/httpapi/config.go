@@ -0,0 +1,50 @@
+package httpapi
+
+import (
+	"log/slog"
+	"net/http"
+)
+
+// ConfigReloader is the running configuration and the way to re-read it.
+type ConfigReloader interface {
+	// Config returns the running configuration with secrets redacted.
+	Config() any
+	// LastReload returns the outcome of the last reload, nil before one.
+	LastReload() any
+	// Reload re-reads the configuration and applies what it can live. A
+	// rejected reload returns its outcome together with the error.
+	Reload(by string) (any, error)
+}
+
+// WithConfig exposes GET /v1/config and POST /v1/config/reload for admin
+// callers.
+func WithConfig(c ConfigReloader) Option {
+	return func(o *options) { o.config = c }
+}
+
+type ConfigController struct {
+	Reloader ConfigReloader
+	lg       *slog.Logger
+}
+
+type configResponse struct {
+	Config     any `json:"config"`
+	LastReload any `json:"last_reload"`
+}
+
+// Get returns the running configuration and the last reload.
+func (cc *ConfigController) Get(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, configResponse{Config: cc.Reloader.Config(), LastReload: cc.Reloader.LastReload()})
+}
+
+// Reload re-reads the configuration, like SIGHUP. A configuration that
+// does not load or validate is rejected as a whole with 422.
+func (cc *ConfigController) Reload(w http.ResponseWriter, r *http.Request) {
+	res, err := cc.Reloader.Reload(caller(r))
+	if err != nil {
+		cc.lg.Warn("config reload rejected", "err", err, "by", caller(r))
+		writeJSON(w, http.StatusUnprocessableEntity, res)
+		return
+	}
+	writeJSON(w, http.StatusOK, res)
+}
@@ -0,0 +1,58 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Jamie-38/stream-pipeline/internal/observe"
+)
+
+type reloaderStub struct {
+	fail  bool
+	calls []string
+	last  any
+}
+
+func (s *reloaderStub) Config() any     { return map[string]string{"topic": "chat"} }
+func (s *reloaderStub) LastReload() any { return s.last }
+
+func (s *reloaderStub) Reload(by string) (any, error) {
+	s.calls = append(s.calls, by)
+	s.last = map[string]any{"ok": !s.fail}
+	if s.fail {
+		return s.last, errors.New("invalid configuration")
+	}
+	return s.last, nil
+}
+
+func TestConfigGetAndReload(t *testing.T) {
+	stub := &reloaderStub{}
+	cc := &ConfigController{Reloader: stub, lg: observe.C("httpapi_test")}
+
+	w := httptest.NewRecorder()
+	cc.Get(w, httptest.NewRequest("GET", "/v1/config", nil))
+	var resp struct {
+		Config     map[string]string `json:"config"`
+		LastReload any               `json:"last_reload"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil || resp.Config["topic"] != "chat" || resp.LastReload != nil {
+		t.Fatalf("get = %+v, %v", resp, err)
+	}
+
+	w = httptest.NewRecorder()
+	cc.Reload(w, httptest.NewRequest("POST", "/v1/config/reload", nil))
+	if w.Code != http.StatusOK || len(stub.calls) != 1 {
+		t.Fatalf("reload status = %d, calls %v", w.Code, stub.calls)
+	}
+
+	stub.fail = true
+	w = httptest.NewRecorder()
+	cc.Reload(w, httptest.NewRequest("POST", "/v1/config/reload", nil))
+	var res map[string]any
+	if err := json.NewDecoder(w.Body).Decode(&res); err != nil || w.Code != http.StatusUnprocessableEntity || res["ok"] != false {
+		t.Fatalf("rejected reload = %d %v, %v", w.Code, res, err)
+	}
+}
@@ -2,6 +2,11 @@ package httpapi
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
 
 	"net"
@@ -11,84 +16,213 @@ import (
 	"strings"
 	"time"
 
+	"github.com/Jamie-38/stream-pipeline/internal/apiauth"
 	"github.com/Jamie-38/stream-pipeline/internal/healthcheck"
 	"github.com/Jamie-38/stream-pipeline/internal/observe"
 	"github.com/Jamie-38/stream-pipeline/internal/types"
 )
 
 func (api *APIController) Join(w http.ResponseWriter, r *http.Request) {
+	api.enqueue(w, r, "JOIN")
+}
+
+func (api *APIController) Part(w http.ResponseWriter, r *http.Request) {
+	api.enqueue(w, r, "PART")
+}
+
+type commandResponse struct {
+	RequestID string `json:"request_id"`
+	Op        string `json:"op"`
+	Channel   string `json:"channel"`
+	Status    string `json:"status"` // "applied", "unchanged" or "queued"
+	Version   uint64 `json:"version,omitempty"`
+}
+
+// enqueue hands a command to the controller without blocking and waits for
+// the persisted snapshot version until the request deadline. It answers 200
+// with the version once acknowledged, 202 if the deadline passes first, and
+// 503 when the control queue is saturated.
+func (api *APIController) enqueue(w http.ResponseWriter, r *http.Request, op string) {
 	ch := strings.TrimSpace(strings.ToLower(r.URL.Query().Get("channel")))
 	if ch == "" {
-		api.lg.Warn("join request missing channel parameter", "remote", r.RemoteAddr)
+		api.lg.Warn(strings.ToLower(op)+" request missing channel parameter", "remote", r.RemoteAddr)
 		http.Error(w, "Missing channel parameter", http.StatusBadRequest)
 		return
 	}
 
-	api.lg.Info("enqueue join", "channel", ch, "remote", r.RemoteAddr)
-	api.ControlCh <- types.IRCCommand{Op: "JOIN", Channel: "#" + ch}
-	w.Write([]byte("Queued join for channel: " + ch))
-}
+	id := requestID(r)
+	w.Header().Set("X-Request-ID", id)
+	replyCh := make(chan types.CommandResult, 1)
+	cmd := types.IRCCommand{ID: id, Op: op, Channel: "#" + ch, Source: "http:" + caller(r), Reply: replyCh}
 
-func (api *APIController) Part(w http.ResponseWriter, r *http.Request) {
-	ch := strings.TrimSpace(strings.ToLower(r.URL.Query().Get("channel")))
-	if ch == "" {
-		api.lg.Warn("part request missing channel parameter", "remote", r.RemoteAddr)
-		http.Error(w, "Missing channel parameter", http.StatusBadRequest)
+	select {
+	case api.ControlCh <- cmd:
+		api.lg.Info("enqueued "+strings.ToLower(op), "channel", ch, "request_id", id, "remote", r.RemoteAddr)
+	default:
+		api.lg.Warn("control queue saturated", "op", op, "channel", ch, "request_id", id, "remote", r.RemoteAddr)
+		w.Header().Set("Retry-After", "1")
+		http.Error(w, "Control queue saturated", http.StatusServiceUnavailable)
 		return
 	}
-	api.lg.Info("enqueue part", "channel", ch, "remote", r.RemoteAddr)
-	api.ControlCh <- types.IRCCommand{Op: "PART", Channel: "#" + ch}
-	w.Write([]byte("Queued part for channel: " + ch))
+
+	timeout := api.AckTimeout
+	if timeout <= 0 {
+		timeout = defaultAckTimeout
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+
+	resp := commandResponse{RequestID: id, Op: op, Channel: cmd.Channel}
+	select {
+	case res := <-replyCh:
+		if res.Err != nil {
+			api.lg.Warn("command rejected", "op", op, "channel", ch, "request_id", id, "err", res.Err)
+			http.Error(w, res.Err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+		resp.Version = res.Version
+		resp.Status = "unchanged"
+		if res.Changed {
+			resp.Status = "applied"
+		}
+		writeJSON(w, http.StatusOK, resp)
+	case <-ctx.Done():
+		api.lg.Debug("ack wait expired", "op", op, "channel", ch, "request_id", id)
+		resp.Status = "queued"
+		writeJSON(w, http.StatusAccepted, resp)
+	}
 }
 
-func Run(ctx context.Context, controlCh chan types.IRCCommand) error {
+// caller names the authenticated identity for journal sources.
+func caller(r *http.Request) string {
+	if id, ok := apiauth.FromContext(r.Context()); ok && id.Name != "" {
+		return id.Name
+	}
+	return "anonymous"
+}
+
+func requestID(r *http.Request) string {
+	if id := strings.TrimSpace(r.Header.Get("X-Request-ID")); id != "" && len(id) <= 64 {
+		return id
+	}
+	var b [8]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 36)
+	}
+	return hex.EncodeToString(b[:])
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func Run(ctx context.Context, controlCh chan types.IRCCommand, opts ...Option) error {
 	lg := observe.C("http_api")
 	api := &APIController{ControlCh: controlCh, lg: lg}
 
+	o := options{}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	guard := o.guard
+	if guard == nil {
+		guard = apiauth.NewGuard(apiauth.Open{}, nil)
+	}
+
 	mux := http.NewServeMux()
-	probe := healthcheck.New("http_api")
-	probe.Register(mux)
-	probe.SetNotReady()
+	health := healthcheck.Default()
+	health.RegisterHandlers(mux)
+	server := health.Register("http_api", healthcheck.Options{})
 
-	mux.HandleFunc("/join", api.Join)
-	mux.HandleFunc("/part", api.Part)
+	mux.Handle("/join", guard.RequireFunc(apiauth.RoleAdmin, api.Join))
+	mux.Handle("/part", guard.RequireFunc(apiauth.RoleAdmin, api.Part))
 
-	host := strings.TrimSpace(os.Getenv("HTTP_API_HOST"))
-	if host == "" {
-		return fmt.Errorf("HTTP_API_HOST missing")
+	mux.Handle("GET /metrics", guard.Require(apiauth.RoleRead, observe.Metrics().Handler()))
+
+	lc := &LogLevelController{lg: observe.C("http_log")}
+	mux.Handle("GET /v1/log/levels", guard.RequireFunc(apiauth.RoleRead, lc.List))
+	mux.Handle("PUT /v1/log/levels", guard.RequireFunc(apiauth.RoleAdmin, lc.Update))
+
+	mux.Handle("POST /v1/channels/batch", guard.RequireFunc(apiauth.RoleAdmin, api.Batch))
+
+	if o.history != nil {
+		hc := &HistoryController{History: o.history, lg: observe.C("http_history")}
+		mux.Handle("GET /v1/channels/history", guard.RequireFunc(apiauth.RoleRead, hc.List))
+		mux.Handle("GET /v1/channels/diff", guard.RequireFunc(apiauth.RoleRead, hc.Diff))
+		mux.Handle("POST /v1/channels/restore", guard.RequireFunc(apiauth.RoleAdmin, hc.Restore))
 	}
-	portEnv := strings.TrimSpace(os.Getenv("HTTP_API_PORT"))
-	if portEnv == "" {
-		return fmt.Errorf("HTTP_API_PORT missing")
+
+	if o.catalog != nil {
+		cc := &CatalogController{Catalog: o.catalog, lg: observe.C("http_channels")}
+		mux.Handle("GET /v1/channels", guard.RequireFunc(apiauth.RoleRead, cc.List))
+		mux.Handle("PUT /v1/channels/{channel}", guard.RequireFunc(apiauth.RoleAdmin, cc.Update))
 	}
-	port, err := strconv.Atoi(portEnv)
-	if err != nil {
-		return fmt.Errorf("HTTP_API_PORT parse error")
+
+	if o.sender != nil {
+		chat := &ChatController{Sender: o.sender, lg: observe.C("http_chat")}
+		mux.Handle("POST /v1/chat/send", guard.RequireFunc(apiauth.RoleAdmin, chat.Send))
 	}
-	if port <= 1 || port >= 65535 {
-		return fmt.Errorf("HTTP_API_PORT out of bounds")
+
+	if len(o.status) > 0 {
+		mux.Handle("GET /v1/status", guard.Require(apiauth.RoleRead, statusHandler(o.status)))
 	}
 
-	address := net.JoinHostPort(host, portEnv)
+	if o.config != nil {
+		cfg := &ConfigController{Reloader: o.config, lg: observe.C("http_config")}
+		mux.Handle("GET /v1/config", guard.RequireFunc(apiauth.RoleAdmin, cfg.Get))
+		mux.Handle("POST /v1/config/reload", guard.RequireFunc(apiauth.RoleAdmin, cfg.Reload))
+	}
+
+	if len(o.accounts) > 0 {
+		newAccountRoutes(o.accounts).mount(mux, guard)
+	}
+
+	if o.hub != nil {
+		sc := &StreamController{Hub: o.hub, lg: observe.C("http_stream")}
+		mux.Handle("GET /v1/stream", guard.RequireFunc(apiauth.RoleRead, sc.SSE))
+		mux.Handle("GET /v1/stream/ws", guard.RequireFunc(apiauth.RoleRead, sc.WebSocket))
+	}
+
+	sc := o.server
+	if sc == nil {
+		fromEnv, err := ServerConfigFromEnv()
+		if err != nil {
+			return err
+		}
+		sc = &fromEnv
+	}
+	sc.withDefaults()
+	tlsCfg, err := sc.tlsConfig()
+	if err != nil {
+		return fmt.Errorf("http_api: %w", err)
+	}
+
+	address := net.JoinHostPort(sc.Host, strconv.Itoa(sc.Port))
 
 	srv := &http.Server{
 		Addr:              address,
 		Handler:           mux,
-		ReadHeaderTimeout: 5 * time.Second,
-		ReadTimeout:       10 * time.Second,
-		WriteTimeout:      15 * time.Second,
-		IdleTimeout:       60 * time.Second,
+		ReadHeaderTimeout: sc.ReadHeaderTimeout,
+		ReadTimeout:       sc.ReadTimeout,
+		WriteTimeout:      sc.WriteTimeout,
+		IdleTimeout:       sc.IdleTimeout,
 	}
 
 	ln, err := net.Listen("tcp", address)
 	if err != nil {
 		return fmt.Errorf("http_api: listen error on %s: %w", address, err)
 	}
+	if tlsCfg != nil {
+		ln = tls.NewListener(ln, tlsCfg)
+	}
 
 	errCh := make(chan error, 1)
 	go func() {
-		lg.Info("listening", "address", address)
-		probe.SetReady()
+		lg.Info("listening", "address", address, "tls", tlsCfg != nil,
+			"client_certs", tlsCfg != nil && tlsCfg.ClientCAs != nil)
+		server.SetReady(true, "listening")
 		if err := srv.Serve(ln); err != nil && err != http.ErrServerClosed {
 			errCh <- err
 			return
@@ -99,7 +233,8 @@ func Run(ctx context.Context, controlCh chan types.IRCCommand) error {
 	select {
 	case <-ctx.Done():
 		lg.Info("shutdown requested")
-		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		server.SetReady(false, "shutting down")
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), sc.ShutdownTimeout)
 		defer cancel()
 		if err := srv.Shutdown(shutdownCtx); err != nil {
 			_ = srv.Close()
@@ -111,3 +246,99 @@ func Run(ctx context.Context, controlCh chan types.IRCCommand) error {
 		return err
 	}
 }
+
+// ServerConfig is where and how the control plane listens. Zero timeouts
+// take the defaults.
+type ServerConfig struct {
+	Host string
+	Port int
+	// TLSCert and TLSKey enable TLS. ClientCA adds client-certificate
+	// verification; certificates are optional unless RequireClientCert.
+	TLSCert, TLSKey   string
+	ClientCA          string
+	RequireClientCert bool
+
+	ReadHeaderTimeout time.Duration
+	ReadTimeout       time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+	ShutdownTimeout   time.Duration
+}
+
+// ServerConfigFromEnv reads HTTP_API_HOST, HTTP_API_PORT, HTTP_API_TLS_CERT,
+// HTTP_API_TLS_KEY, HTTP_API_CLIENT_CA and HTTP_API_REQUIRE_CLIENT_CERT.
+func ServerConfigFromEnv() (ServerConfig, error) {
+	sc := ServerConfig{
+		Host:              strings.TrimSpace(os.Getenv("HTTP_API_HOST")),
+		TLSCert:           strings.TrimSpace(os.Getenv("HTTP_API_TLS_CERT")),
+		TLSKey:            strings.TrimSpace(os.Getenv("HTTP_API_TLS_KEY")),
+		ClientCA:          strings.TrimSpace(os.Getenv("HTTP_API_CLIENT_CA")),
+		RequireClientCert: strings.EqualFold(strings.TrimSpace(os.Getenv("HTTP_API_REQUIRE_CLIENT_CERT")), "true"),
+	}
+	if sc.Host == "" {
+		return sc, fmt.Errorf("HTTP_API_HOST missing")
+	}
+	portEnv := strings.TrimSpace(os.Getenv("HTTP_API_PORT"))
+	if portEnv == "" {
+		return sc, fmt.Errorf("HTTP_API_PORT missing")
+	}
+	port, err := strconv.Atoi(portEnv)
+	if err != nil {
+		return sc, fmt.Errorf("HTTP_API_PORT parse error")
+	}
+	if port <= 1 || port >= 65535 {
+		return sc, fmt.Errorf("HTTP_API_PORT out of bounds")
+	}
+	sc.Port = port
+	return sc, nil
+}
+
+func (sc *ServerConfig) withDefaults() {
+	for _, d := range []struct {
+		dst *time.Duration
+		def time.Duration
+	}{
+		{&sc.ReadHeaderTimeout, 5 * time.Second},
+		{&sc.ReadTimeout, 10 * time.Second},
+		{&sc.WriteTimeout, 15 * time.Second},
+		{&sc.IdleTimeout, 60 * time.Second},
+		{&sc.ShutdownTimeout, 10 * time.Second},
+	} {
+		if *d.dst <= 0 {
+			*d.dst = d.def
+		}
+	}
+}
+
+// tlsConfig is nil when TLS is off.
+func (sc *ServerConfig) tlsConfig() (*tls.Config, error) {
+	if sc.TLSCert == "" && sc.TLSKey == "" {
+		return nil, nil
+	}
+	cert, err := tls.LoadX509KeyPair(sc.TLSCert, sc.TLSKey)
+	if err != nil {
+		return nil, fmt.Errorf("load tls key pair: %w", err)
+	}
+	cfg := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if sc.ClientCA == "" {
+		return cfg, nil
+	}
+	pem, err := os.ReadFile(sc.ClientCA)
+	if err != nil {
+		return nil, fmt.Errorf("read client ca %q: %w", sc.ClientCA, err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("client ca %q: no certificates found", sc.ClientCA)
+	}
+	cfg.ClientCAs = pool
+	cfg.ClientAuth = tls.VerifyClientCertIfGiven
+	if sc.RequireClientCert {
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return cfg, nil
+}
@@ -1,20 +1,43 @@
 package httpapi
 
 import (
+	"encoding/json"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/Jamie-38/stream-pipeline/internal/observe"
 	"github.com/Jamie-38/stream-pipeline/internal/types"
 )
 
+// ackNext plays the controller: it receives one command and acknowledges it.
+func ackNext(ch <-chan types.IRCCommand, version uint64) <-chan types.IRCCommand {
+	got := make(chan types.IRCCommand, 1)
+	go func() {
+		cmd := <-ch
+		cmd.Reply <- types.CommandResult{ID: cmd.ID, Version: version, Changed: true}
+		got <- cmd
+	}()
+	return got
+}
+
+func decodeResp(t *testing.T, w *httptest.ResponseRecorder) commandResponse {
+	t.Helper()
+	var resp commandResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("decode body: %v", err)
+	}
+	return resp
+}
+
 func TestJoinEnqueuesLowercasedHashChannel(t *testing.T) {
 	ch := make(chan types.IRCCommand, 1)
 	api := &APIController{
 		ControlCh: ch,
 		lg:        observe.C("httpapi_test"),
 	}
+	got := ackNext(ch, 7)
 
 	req := httptest.NewRequest("GET", "/join?channel=Chess", nil)
 	w := httptest.NewRecorder()
@@ -24,13 +47,13 @@ func TestJoinEnqueuesLowercasedHashChannel(t *testing.T) {
 	if w.Code != http.StatusOK {
 		t.Fatalf("status = %d, want 200", w.Code)
 	}
-	select {
-	case cmd := <-ch:
-		if cmd.Op != "JOIN" || cmd.Channel != "#chess" {
-			t.Fatalf("enqueued = %+v, want JOIN #chess", cmd)
-		}
-	default:
-		t.Fatal("no command enqueued")
+	cmd := <-got
+	if cmd.Op != "JOIN" || cmd.Channel != "#chess" {
+		t.Fatalf("enqueued = %+v, want JOIN #chess", cmd)
+	}
+	resp := decodeResp(t, w)
+	if resp.Version != 7 || resp.Status != "applied" || resp.RequestID != cmd.ID {
+		t.Fatalf("response = %+v, want version 7 applied for %q", resp, cmd.ID)
 	}
 }
 
@@ -57,8 +80,10 @@ func TestJoinMissingParam(t *testing.T) {
 func TestPartEnqueuesLowercasedHashChannel(t *testing.T) {
 	ch := make(chan types.IRCCommand, 1)
 	api := &APIController{ControlCh: ch, lg: observe.C("httpapi_test")}
+	got := ackNext(ch, 3)
 
 	req := httptest.NewRequest("GET", "/part?channel=Chess", nil)
+	req.Header.Set("X-Request-ID", "abc")
 	w := httptest.NewRecorder()
 
 	api.Part(w, req)
@@ -66,13 +91,12 @@ func TestPartEnqueuesLowercasedHashChannel(t *testing.T) {
 	if w.Code != http.StatusOK {
 		t.Fatalf("status = %d, want 200", w.Code)
 	}
-	select {
-	case cmd := <-ch:
-		if cmd.Op != "PART" || cmd.Channel != "#chess" {
-			t.Fatalf("enqueued = %+v, want PART #chess", cmd)
-		}
-	default:
-		t.Fatal("no command enqueued")
+	cmd := <-got
+	if cmd.Op != "PART" || cmd.Channel != "#chess" || cmd.ID != "abc" {
+		t.Fatalf("enqueued = %+v, want PART #chess id=abc", cmd)
+	}
+	if got := w.Header().Get("X-Request-ID"); got != "abc" {
+		t.Fatalf("X-Request-ID = %q, want abc", got)
 	}
 }
 
@@ -92,3 +116,37 @@ func TestPartMissingParam(t *testing.T) {
 		t.Fatal("should not enqueue on error")
 	}
 }
+
+func TestJoinAcceptedWhenAckTimesOut(t *testing.T) {
+	ch := make(chan types.IRCCommand, 1)
+	api := &APIController{ControlCh: ch, AckTimeout: 10 * time.Millisecond, lg: observe.C("httpapi_test")}
+
+	req := httptest.NewRequest("GET", "/join?channel=chess", nil)
+	w := httptest.NewRecorder()
+
+	api.Join(w, req)
+
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("status = %d, want 202", w.Code)
+	}
+	if resp := decodeResp(t, w); resp.Status != "queued" {
+		t.Fatalf("status field = %q, want queued", resp.Status)
+	}
+	if len(ch) != 1 {
+		t.Fatal("command should remain queued")
+	}
+}
+
+func TestJoinQueueSaturated(t *testing.T) {
+	ch := make(chan types.IRCCommand) // unbuffered with no reader: always full
+	api := &APIController{ControlCh: ch, lg: observe.C("httpapi_test")}
+
+	req := httptest.NewRequest("GET", "/join?channel=chess", nil)
+	w := httptest.NewRecorder()
+
+	api.Join(w, req)
+
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("status = %d, want 503", w.Code)
+	}
+}
@@ -0,0 +1,217 @@
+package httpapi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Jamie-38/stream-pipeline/internal/types"
+)
+
+// ChannelHistory is the journal view of the desired-channel controller.
+type ChannelHistory interface {
+	History(from, to uint64) []types.ChannelJournalEntry
+	Diff(from, to uint64) (added, removed []string, err error)
+	Restore(ctx context.Context, version uint64, source string) (types.CommandResult, error)
+}
+
+type HistoryController struct {
+	History ChannelHistory
+	lg      *slog.Logger
+}
+
+// List returns journal entries; "from" and "to" bound the versions.
+func (hc *HistoryController) List(w http.ResponseWriter, r *http.Request) {
+	from, err := versionParam(r, "from", 0)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	to, err := versionParam(r, "to", 0)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeJSON(w, http.StatusOK, hc.History.History(from, to))
+}
+
+type diffResponse struct {
+	From    uint64   `json:"from"`
+	To      uint64   `json:"to"`
+	Added   []string `json:"added"`
+	Removed []string `json:"removed"`
+}
+
+// Diff reports the channels added and removed between two versions.
+func (hc *HistoryController) Diff(w http.ResponseWriter, r *http.Request) {
+	from, err := versionParam(r, "from", 0)
+	if err == nil && from == 0 {
+		err = errors.New("missing from parameter")
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	to, err := versionParam(r, "to", 0)
+	if err == nil && to == 0 {
+		err = errors.New("missing to parameter")
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	added, removed, err := hc.History.Diff(from, to)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, diffResponse{From: from, To: to, Added: added, Removed: removed})
+}
+
+type restoreResponse struct {
+	RequestID string `json:"request_id"`
+	From      uint64 `json:"restored_from"`
+	Version   uint64 `json:"version"`
+	Changed   bool   `json:"changed"`
+}
+
+// Restore resets the desired set to the one recorded at "version".
+func (hc *HistoryController) Restore(w http.ResponseWriter, r *http.Request) {
+	v, err := versionParam(r, "version", 0)
+	if err == nil && v == 0 {
+		err = errors.New("missing version parameter")
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	id := requestID(r)
+	w.Header().Set("X-Request-ID", id)
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	res, err := hc.History.Restore(ctx, v, "http:"+caller(r))
+	switch {
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, context.Canceled):
+		http.Error(w, "restore not acknowledged", http.StatusServiceUnavailable)
+		return
+	case err != nil:
+		hc.lg.Warn("restore rejected", "version", v, "request_id", id, "err", err)
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	hc.lg.Info("restored desired set", "from_version", v, "version", res.Version, "request_id", id)
+	writeJSON(w, http.StatusOK, restoreResponse{RequestID: id, From: v, Version: res.Version, Changed: res.Changed})
+}
+
+func versionParam(r *http.Request, name string, def uint64) (uint64, error) {
+	raw := strings.TrimSpace(r.URL.Query().Get(name))
+	if raw == "" {
+		return def, nil
+	}
+	v, err := strconv.ParseUint(raw, 10, 64)
+	if err != nil {
+		return 0, errors.New("invalid " + name + " parameter")
+	}
+	return v, nil
+}
+
+type batchRequest struct {
+	Join []string `json:"join"`
+	Part []string `json:"part"`
+}
+
+type batchResponse struct {
+	RequestID string   `json:"request_id"`
+	Status    string   `json:"status"` // "applied", "queued", "partial" or "failed"
+	Version   uint64   `json:"version,omitempty"`
+	Errors    []string `json:"errors,omitempty"`
+}
+
+// Batch applies several joins and parts as one journaled change set. The
+// controller coalesces them into a single snapshot version. Items the
+// controller rejects make the batch "partial" (207), or "failed" (422)
+// when none succeeded.
+func (api *APIController) Batch(w http.ResponseWriter, r *http.Request) {
+	var req batchRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&req); err != nil {
+		http.Error(w, "invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	n := len(req.Join) + len(req.Part)
+	if n == 0 {
+		http.Error(w, "empty batch", http.StatusBadRequest)
+		return
+	}
+	if n > cap(api.ControlCh)-len(api.ControlCh) {
+		w.Header().Set("Retry-After", "1")
+		http.Error(w, "Control queue saturated", http.StatusServiceUnavailable)
+		return
+	}
+
+	id := requestID(r)
+	w.Header().Set("X-Request-ID", id)
+	source := "api-batch:" + caller(r)
+	replyCh := make(chan types.CommandResult, n)
+
+	sent := 0
+	enqueue := func(op string, chans []string) bool {
+		for _, ch := range chans {
+			ch = strings.TrimPrefix(strings.TrimSpace(strings.ToLower(ch)), "#")
+			cmd := types.IRCCommand{ID: id, Op: op, Channel: "#" + ch, Source: source, Reply: replyCh}
+			select {
+			case api.ControlCh <- cmd:
+				sent++
+			default:
+				return false
+			}
+		}
+		return true
+	}
+	if !enqueue("JOIN", req.Join) || !enqueue("PART", req.Part) {
+		api.lg.Warn("control queue saturated mid-batch", "request_id", id, "sent", sent, "total", n)
+	}
+	api.lg.Info("enqueued batch", "request_id", id, "join", len(req.Join), "part", len(req.Part))
+
+	timeout := api.AckTimeout
+	if timeout <= 0 {
+		timeout = defaultAckTimeout
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+
+	resp := batchResponse{RequestID: id, Status: "applied"}
+	for i := 0; i < sent; i++ {
+		select {
+		case res := <-replyCh:
+			if res.Err != nil {
+				resp.Errors = append(resp.Errors, res.Err.Error())
+				continue
+			}
+			resp.Version = max(resp.Version, res.Version)
+		case <-ctx.Done():
+			resp.Status = "queued"
+			writeJSON(w, http.StatusAccepted, resp)
+			return
+		}
+	}
+	switch {
+	case sent < n:
+		resp.Status = "partial"
+		writeJSON(w, http.StatusServiceUnavailable, resp)
+	case len(resp.Errors) == n:
+		resp.Status = "failed"
+		writeJSON(w, http.StatusUnprocessableEntity, resp)
+	case len(resp.Errors) > 0:
+		resp.Status = "partial"
+		writeJSON(w, http.StatusMultiStatus, resp)
+	default:
+		writeJSON(w, http.StatusOK, resp)
+	}
+}
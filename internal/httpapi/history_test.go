@@ -0,0 +1,112 @@
+package httpapi
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Jamie-38/stream-pipeline/internal/observe"
+	"github.com/Jamie-38/stream-pipeline/internal/types"
+)
+
+type historyStub struct {
+	restored uint64
+	source   string
+}
+
+func (h *historyStub) History(from, to uint64) []types.ChannelJournalEntry { return nil }
+
+func (h *historyStub) Diff(from, to uint64) ([]string, []string, error) {
+	return []string{"#b"}, []string{"#a"}, nil
+}
+
+func (h *historyStub) Restore(ctx context.Context, version uint64, source string) (types.CommandResult, error) {
+	h.restored, h.source = version, source
+	return types.CommandResult{Version: 9, Changed: true}, nil
+}
+
+func TestRestoreRequiresVersion(t *testing.T) {
+	hc := &HistoryController{History: &historyStub{}, lg: observe.C("httpapi_test")}
+	w := httptest.NewRecorder()
+	hc.Restore(w, httptest.NewRequest("POST", "/v1/channels/restore", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("status = %d, want 400", w.Code)
+	}
+}
+
+func TestRestoreForwardsCaller(t *testing.T) {
+	stub := &historyStub{}
+	hc := &HistoryController{History: stub, lg: observe.C("httpapi_test")}
+	w := httptest.NewRecorder()
+	hc.Restore(w, httptest.NewRequest("POST", "/v1/channels/restore?version=3", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", w.Code)
+	}
+	if stub.restored != 3 || stub.source != "http:anonymous" {
+		t.Fatalf("restore got version=%d source=%q", stub.restored, stub.source)
+	}
+}
+
+func TestBatchEnqueuesWithBatchSource(t *testing.T) {
+	ch := make(chan types.IRCCommand, 4)
+	api := &APIController{ControlCh: ch, lg: observe.C("httpapi_test")}
+	go func() {
+		for i := 0; i < 2; i++ {
+			cmd := <-ch
+			if !strings.HasPrefix(cmd.Source, "api-batch:") {
+				cmd.Reply <- types.CommandResult{Err: context.Canceled}
+				continue
+			}
+			cmd.Reply <- types.CommandResult{ID: cmd.ID, Version: 4, Changed: true}
+		}
+	}()
+
+	body := strings.NewReader(`{"join":["Chess"],"part":["#speedrun"]}`)
+	w := httptest.NewRecorder()
+	api.Batch(w, httptest.NewRequest("POST", "/v1/channels/batch", body))
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", w.Code, w.Body.String())
+	}
+	if !strings.Contains(w.Body.String(), `"version":4`) {
+		t.Fatalf("body = %s", w.Body.String())
+	}
+}
+
+func TestBatchReportsFailedItems(t *testing.T) {
+	for _, tc := range []struct {
+		fail       map[string]bool
+		wantCode   int
+		wantStatus string
+	}{
+		{map[string]bool{"#bad": true}, http.StatusMultiStatus, `"status":"partial"`},
+		{map[string]bool{"#bad": true, "#chess": true}, http.StatusUnprocessableEntity, `"status":"failed"`},
+	} {
+		ch := make(chan types.IRCCommand, 4)
+		api := &APIController{ControlCh: ch, lg: observe.C("httpapi_test")}
+		go func() {
+			for i := 0; i < 2; i++ {
+				cmd := <-ch
+				if tc.fail[cmd.Channel] {
+					cmd.Reply <- types.CommandResult{ID: cmd.ID, Err: errors.New("invalid channel " + cmd.Channel)}
+					continue
+				}
+				cmd.Reply <- types.CommandResult{ID: cmd.ID, Version: 5, Changed: true}
+			}
+		}()
+
+		body := strings.NewReader(`{"join":["chess","bad"]}`)
+		w := httptest.NewRecorder()
+		api.Batch(w, httptest.NewRequest("POST", "/v1/channels/batch", body))
+
+		if w.Code != tc.wantCode || !strings.Contains(w.Body.String(), tc.wantStatus) {
+			t.Fatalf("status = %d %s, want %d %s", w.Code, w.Body.String(), tc.wantCode, tc.wantStatus)
+		}
+		if !strings.Contains(w.Body.String(), "invalid channel #bad") {
+			t.Fatalf("errors missing from %s", w.Body.String())
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package httpapi
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/Jamie-38/stream-pipeline/internal/observe"
+)
+
+type LogLevelController struct {
+	lg *slog.Logger
+}
+
+type logLevelsResponse struct {
+	Spec   string            `json:"spec"`
+	Levels map[string]string `json:"levels"`
+}
+
+func currentLevels() logLevelsResponse {
+	return logLevelsResponse{Spec: observe.LevelSpec(), Levels: observe.Levels()}
+}
+
+// List returns the default level and every per-component override.
+func (lc *LogLevelController) List(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, currentLevels())
+}
+
+// Update applies a spec such as "classifier=debug,rectifier=info", taken
+// from the "levels" query parameter or the request body.
+func (lc *LogLevelController) Update(w http.ResponseWriter, r *http.Request) {
+	spec := r.URL.Query().Get("levels")
+	if spec == "" {
+		b, err := io.ReadAll(http.MaxBytesReader(w, r.Body, 4<<10))
+		if err != nil {
+			http.Error(w, "body too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		spec = string(b)
+	}
+	spec = strings.TrimSpace(spec)
+	if spec == "" {
+		http.Error(w, "Missing levels", http.StatusBadRequest)
+		return
+	}
+	if err := observe.SetLevels(spec); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	lc.lg.Info("log levels changed", "spec", spec, "by", caller(r))
+	writeJSON(w, http.StatusOK, currentLevels())
+}
@@ -0,0 +1,35 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Jamie-38/stream-pipeline/internal/observe"
+)
+
+func TestLogLevelUpdate(t *testing.T) {
+	defer observe.SetLevels("info,classifier=default")
+	lc := &LogLevelController{lg: observe.C("httpapi_test")}
+
+	w := httptest.NewRecorder()
+	lc.Update(w, httptest.NewRequest("PUT", "/v1/log/levels", strings.NewReader("classifier=debug")))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", w.Code, w.Body)
+	}
+	var resp logLevelsResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Levels["classifier"] != "debug" {
+		t.Fatalf("levels = %v", resp.Levels)
+	}
+
+	w = httptest.NewRecorder()
+	lc.Update(w, httptest.NewRequest("PUT", "/v1/log/levels?levels=classifier=chatty", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("bad spec status = %d", w.Code)
+	}
+}
@@ -0,0 +1,24 @@
+package httpapi
+
+import (
+	"net/http"
+	"time"
+)
+
+// StatusFunc reports one section of GET /v1/status.
+type StatusFunc func() any
+
+type statusResponse struct {
+	Time     time.Time      `json:"ts"`
+	Sections map[string]any `json:"status"`
+}
+
+func statusHandler(sections map[string]StatusFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		resp := statusResponse{Time: time.Now().UTC(), Sections: make(map[string]any, len(sections))}
+		for name, fn := range sections {
+			resp.Sections[name] = fn()
+		}
+		writeJSON(w, http.StatusOK, resp)
+	}
+}
@@ -0,0 +1,145 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	ircevents "github.com/Jamie-38/stream-pipeline/internal/irc_events"
+	"github.com/Jamie-38/stream-pipeline/internal/livetail"
+)
+
+const streamHeartbeat = 15 * time.Second
+
+// StreamController serves live events from a livetail.Hub.
+type StreamController struct {
+	Hub      *livetail.Hub
+	upgrader websocket.Upgrader
+	lg       *slog.Logger
+}
+
+type streamFrame struct {
+	Kind string          `json:"kind"`
+	Data json.RawMessage `json:"data"`
+}
+
+// SSE streams matching events as Server-Sent Events, one "event: <kind>"
+// record per event, with a comment heartbeat to keep proxies from idling out.
+func (sc *StreamController) SSE(w http.ResponseWriter, r *http.Request) {
+	rc := http.NewResponseController(w)
+	// the stream outlives the server's WriteTimeout
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+		sc.lg.Debug("clear write deadline", "err", err)
+	}
+
+	sub := sc.Hub.Subscribe(livetail.ParseFilter(r.URL.Query()), 0)
+	defer sub.Close()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	if err := rc.Flush(); err != nil {
+		sc.lg.Warn("sse flush unsupported", "err", err)
+		return
+	}
+	sc.lg.Info("sse subscriber connected", "remote", r.RemoteAddr, "query", r.URL.RawQuery)
+
+	hb := time.NewTicker(streamHeartbeat)
+	defer hb.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			sc.lg.Info("sse subscriber gone", "remote", r.RemoteAddr, "dropped", sub.Dropped())
+			return
+		case <-sub.Done():
+			fmt.Fprint(w, "event: dropped\ndata: {}\n\n")
+			_ = rc.Flush()
+			sc.lg.Info("sse subscriber dropped as slow", "remote", r.RemoteAddr, "dropped", sub.Dropped())
+			return
+		case <-hb.C:
+			if _, err := fmt.Fprint(w, ": keepalive\n\n"); err != nil {
+				return
+			}
+			_ = rc.Flush()
+		case evt := <-sub.C:
+			data, err := evt.Marshal()
+			if err != nil {
+				sc.lg.Debug("marshal event", "err", err)
+				continue
+			}
+			if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", evt.Kind(), data); err != nil {
+				return
+			}
+			_ = rc.Flush()
+		}
+	}
+}
+
+// WebSocket streams matching events as JSON text frames of {kind, data}.
+func (sc *StreamController) WebSocket(w http.ResponseWriter, r *http.Request) {
+	filter := livetail.ParseFilter(r.URL.Query())
+	conn, err := sc.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		sc.lg.Warn("websocket upgrade failed", "remote", r.RemoteAddr, "err", err)
+		return
+	}
+	defer conn.Close()
+
+	sub := sc.Hub.Subscribe(filter, 0)
+	defer sub.Close()
+	sc.lg.Info("websocket subscriber connected", "remote", r.RemoteAddr, "query", r.URL.RawQuery)
+
+	// Clients only send control frames; reading surfaces closes.
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	hb := time.NewTicker(streamHeartbeat)
+	defer hb.Stop()
+
+	write := func(evt ircevents.Event) error {
+		data, err := evt.Marshal()
+		if err != nil {
+			sc.lg.Debug("marshal event", "err", err)
+			return nil
+		}
+		_ = conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
+		return conn.WriteJSON(streamFrame{Kind: evt.Kind(), Data: data})
+	}
+
+	for {
+		select {
+		case <-closed:
+			sc.lg.Info("websocket subscriber gone", "remote", r.RemoteAddr, "dropped", sub.Dropped())
+			return
+		case <-r.Context().Done():
+			return
+		case <-sub.Done():
+			_ = conn.WriteControl(websocket.CloseMessage,
+				websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "too slow"),
+				time.Now().Add(time.Second))
+			sc.lg.Info("websocket subscriber dropped as slow", "remote", r.RemoteAddr, "dropped", sub.Dropped())
+			return
+		case <-hb.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(5*time.Second)); err != nil {
+				return
+			}
+		case evt := <-sub.C:
+			if err := write(evt); err != nil {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package httpapi
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	ircevents "github.com/Jamie-38/stream-pipeline/internal/irc_events"
+	"github.com/Jamie-38/stream-pipeline/internal/livetail"
+	"github.com/Jamie-38/stream-pipeline/internal/observe"
+)
+
+func TestStreamSSEFiltersByChannel(t *testing.T) {
+	hub := livetail.NewHub()
+	sc := &StreamController{Hub: hub, lg: observe.C("httpapi_test")}
+	srv := httptest.NewServer(http.HandlerFunc(sc.SSE))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/v1/stream?channel=chess")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("content-type = %q", ct)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for hub.Subscribers() == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	hub.Publish(ircevents.PrivMsg{ChannelLogin: "other", Text: "skip"})
+	hub.Publish(ircevents.PrivMsg{ChannelLogin: "chess", Text: "keep"})
+
+	lines := make(chan string)
+	go func() {
+		sc := bufio.NewScanner(resp.Body)
+		for sc.Scan() {
+			lines <- sc.Text()
+		}
+	}()
+
+	var got []string
+	for len(got) < 2 {
+		select {
+		case l := <-lines:
+			if l != "" {
+				got = append(got, l)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("timed out; got %v", got)
+		}
+	}
+	if got[0] != "event: privmsg" || !strings.Contains(got[1], `"Text":"keep"`) {
+		t.Fatalf("unexpected frames: %v", got)
+	}
+}
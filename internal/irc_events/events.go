@@ -1,6 +1,10 @@
 package ircevents
 
-import "encoding/json"
+import (
+	"encoding/json"
+
+	"github.com/Jamie-38/stream-pipeline/internal/tracing"
+)
 
 type Event interface {
 	Kind() string
@@ -8,12 +12,43 @@ type Event interface {
 	Marshal() ([]byte, error)
 }
 
+// Attributed is implemented by events tied to a channel and a user, so
+// consumers can filter without knowing concrete event types.
+type Attributed interface {
+	Channel() string
+	User() string
+}
+
+// Redactable is implemented by events carrying free text that a channel
+// policy may strip before the event leaves the process.
+type Redactable interface {
+	Redact() Event
+}
+
+// Traced is implemented by events that carry the span context of the line
+// they were parsed from, so later stages can continue the trace.
+type Traced interface {
+	SpanContext() tracing.SpanContext
+}
+
 type PrivMsg struct {
 	UserID       string
 	UserLogin    string
 	ChannelID    string
 	ChannelLogin string
 	Text         string
+	Badges       map[string]string   `json:",omitempty"` // badge name -> version, e.g. "subscriber": "12"
+	Redacted     bool                `json:",omitempty"` // Text was removed by channel policy
+	Trace        tracing.SpanContext `json:"-"`
+}
+
+// Routed wraps an event with the topic and tags filter rules chose for
+// it. It is an Event itself, but stages that inspect the concrete event
+// should look at the wrapped one.
+type Routed struct {
+	Event
+	Topic string // empty keeps the default topic
+	Tags  []string
 }
 
 type JoinPart struct {
@@ -30,6 +65,28 @@ func (msg PrivMsg) Key() string {
 	return msg.ChannelID
 }
 
+// Channel returns the channel login (without '#') the message was sent to.
+func (msg PrivMsg) Channel() string {
+	return msg.ChannelLogin
+}
+
+// User returns the sender's login.
+func (msg PrivMsg) User() string {
+	return msg.UserLogin
+}
+
+// SpanContext returns the trace of the line this message was parsed from.
+func (msg PrivMsg) SpanContext() tracing.SpanContext {
+	return msg.Trace
+}
+
+// Redact returns a copy without message text.
+func (msg PrivMsg) Redact() Event {
+	msg.Text = ""
+	msg.Redacted = true
+	return msg
+}
+
 func (msg PrivMsg) Marshal() ([]byte, error) {
 	return json.Marshal(msg)
 }
@@ -3,30 +3,106 @@ package kafka
 import (
 	"context"
 	"log"
+	"strings"
+	"time"
 
 	kafkago "github.com/segmentio/kafka-go"
 
+	"github.com/Jamie-38/stream-pipeline/internal/healthcheck"
 	ircevents "github.com/Jamie-38/stream-pipeline/internal/irc_events"
+	"github.com/Jamie-38/stream-pipeline/internal/observe"
+	"github.com/Jamie-38/stream-pipeline/internal/tracing"
+	"github.com/Jamie-38/stream-pipeline/internal/types"
 )
 
-func KafkaProducer(ctx context.Context, writer MessageWriter, parseCh <-chan ircevents.Event) {
+var (
+	writeLatency = observe.Metrics().Histogram("kafka_write_duration_seconds",
+		"Latency of Kafka WriteMessages calls.", observe.DefBuckets)
+	writeErrors = observe.Metrics().Counter("kafka_write_errors_total",
+		"Kafka writes that failed, by stage.", "stage")
+)
+
+// KafkaProducer writes events to topic, redacting text for channels whose
+// policy asks for it; the classifier already does, so this only guards
+// events from other sources. policies may be nil, and so may topic when writer
+// has a topic of its own. An ircevents.Routed goes to its own topic when
+// it names one, with its tags comma-separated in a "tags" header. Sampled
+// events get a "traceparent" header so consumers can link to the
+// producing trace.
+func KafkaProducer(ctx context.Context, writer MessageWriter, parseCh <-chan ircevents.Event, policies types.PolicyLookup, topic *Topic) {
+	tracer := tracing.Default()
+	health := healthcheck.Default().Component("kafka")
+	health.SetReady(true, "no writes yet")
 	for {
 		select {
 		case <-ctx.Done():
 			return
 		case evt := <-parseCh:
+			var route ircevents.Routed
+			if r, ok := evt.(ircevents.Routed); ok {
+				route, evt = r, r.Event
+			}
+			var sp *tracing.Span
+			if t, ok := evt.(ircevents.Traced); ok {
+				sp = tracer.Child(t.SpanContext(), "kafka.write", tracing.KindProducer)
+				sp.SetAttr("event.kind", evt.Kind())
+			}
+			evt = redact(policies, evt)
 			value, err := evt.Marshal()
 			if err != nil {
+				writeErrors.With("marshal").Inc()
 				log.Println("marshal error:", err)
+				sp.SetError(err)
+				sp.End()
 				continue
 			}
 			msg := kafkago.Message{
 				Key:   []byte(evt.Key()),
 				Value: value,
 			}
-			if err := writer.WriteMessages(ctx, msg); err != nil {
+			if topic != nil {
+				msg.Topic = topic.Name()
+			}
+			if route.Topic != "" {
+				msg.Topic = route.Topic
+			}
+			if len(route.Tags) > 0 {
+				msg.Headers = append(msg.Headers, kafkago.Header{Key: "tags", Value: []byte(strings.Join(route.Tags, ","))})
+			}
+			if sc := sp.Context(); sc.IsValid() {
+				msg.Headers = append(msg.Headers, kafkago.Header{Key: "traceparent", Value: []byte(sc.Traceparent())})
+			}
+			start := time.Now()
+			err = writer.WriteMessages(ctx, msg)
+			writeLatency.With().Since(start)
+			health.Beat()
+			if err != nil {
+				writeErrors.With("write").Inc()
 				log.Println("kafka write error:", err)
+				health.SetReady(false, "last write failed: "+err.Error())
+			} else {
+				health.SetReady(true, "last write succeeded")
 			}
+			sp.SetError(err)
+			sp.End()
 		}
 	}
 }
+
+func redact(policies types.PolicyLookup, evt ircevents.Event) ircevents.Event {
+	if policies == nil {
+		return evt
+	}
+	a, ok := evt.(ircevents.Attributed)
+	if !ok {
+		return evt
+	}
+	r, ok := evt.(ircevents.Redactable)
+	if !ok {
+		return evt
+	}
+	if p, ok := policies.Policy(a.Channel()); ok && p.RedactText {
+		return r.Redact()
+	}
+	return evt
+}
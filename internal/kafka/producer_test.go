@@ -0,0 +1,144 @@
+package kafka
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+
+	kafkago "github.com/segmentio/kafka-go"
+
+	ircevents "github.com/Jamie-38/stream-pipeline/internal/irc_events"
+	"github.com/Jamie-38/stream-pipeline/internal/tracing"
+	"github.com/Jamie-38/stream-pipeline/internal/types"
+)
+
+type memWriter struct {
+	mu   sync.Mutex
+	msgs []kafkago.Message
+}
+
+func (m *memWriter) WriteMessages(_ context.Context, msgs ...kafkago.Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.msgs = append(m.msgs, msgs...)
+	return nil
+}
+
+func (m *memWriter) Close() error { return nil }
+
+func (m *memWriter) len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.msgs)
+}
+
+type policyStub map[string]types.ChannelPolicy
+
+func (p policyStub) Policy(ch string) (types.ChannelPolicy, bool) {
+	v, ok := p[ch]
+	return v, ok
+}
+
+func TestKafkaProducerRedactsPerPolicy(t *testing.T) {
+	w := &memWriter{}
+	in := make(chan ircevents.Event, 2)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go KafkaProducer(ctx, w, in, policyStub{"secret": {RedactText: true}}, nil)
+
+	in <- ircevents.PrivMsg{ChannelID: "1", ChannelLogin: "secret", Text: "hidden"}
+	in <- ircevents.PrivMsg{ChannelID: "2", ChannelLogin: "open", Text: "visible"}
+
+	deadline := time.Now().Add(time.Second)
+	for w.len() < 2 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if w.len() != 2 {
+		t.Fatalf("wrote %d messages, want 2", w.len())
+	}
+
+	var a, b ircevents.PrivMsg
+	json.Unmarshal(w.msgs[0].Value, &a)
+	json.Unmarshal(w.msgs[1].Value, &b)
+	if a.Text != "" || !a.Redacted {
+		t.Fatalf("secret channel not redacted: %+v", a)
+	}
+	if b.Text != "visible" || b.Redacted {
+		t.Fatalf("open channel altered: %+v", b)
+	}
+}
+
+func TestKafkaProducerPropagatesTraceparent(t *testing.T) {
+	tracing.SetDefault(tracing.NewTracer(tracing.Config{SampleRatio: 1}, tracing.NewStdoutExporter()))
+	defer tracing.SetDefault(nil)
+
+	parent := tracing.Default().Root("irc.classify", tracing.KindInternal)
+	w := &memWriter{}
+	in := make(chan ircevents.Event, 2)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go KafkaProducer(ctx, w, in, nil, NewTopic("chat"))
+
+	in <- ircevents.PrivMsg{ChannelID: "1", Text: "traced", Trace: parent.Context()}
+	in <- ircevents.PrivMsg{ChannelID: "2", Text: "untraced"}
+
+	deadline := time.Now().Add(time.Second)
+	for w.len() < 2 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if w.len() != 2 {
+		t.Fatalf("wrote %d messages, want 2", w.len())
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if len(w.msgs[0].Headers) != 1 || w.msgs[0].Headers[0].Key != "traceparent" {
+		t.Fatalf("traced message headers = %+v", w.msgs[0].Headers)
+	}
+	sc, ok := tracing.ParseTraceparent(string(w.msgs[0].Headers[0].Value))
+	if !ok || sc.TraceID != parent.Context().TraceID || sc.SpanID == parent.Context().SpanID {
+		t.Fatalf("header %q does not continue trace %s", w.msgs[0].Headers[0].Value, parent.Context().TraceID)
+	}
+	if len(w.msgs[1].Headers) != 0 {
+		t.Fatalf("untraced message got headers %+v", w.msgs[1].Headers)
+	}
+}
+
+func TestKafkaProducerHonoursRoutes(t *testing.T) {
+	w := &memWriter{}
+	in := make(chan ircevents.Event, 2)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go KafkaProducer(ctx, w, in, policyStub{"secret": {RedactText: true}}, NewTopic("chat"))
+
+	in <- ircevents.Routed{
+		Event: ircevents.PrivMsg{ChannelID: "1", ChannelLogin: "secret", Text: "giveaway"},
+		Topic: "chat-giveaways",
+		Tags:  []string{"keyword", "mod"},
+	}
+	in <- ircevents.PrivMsg{ChannelID: "2", ChannelLogin: "open", Text: "hi"}
+
+	deadline := time.Now().Add(time.Second)
+	for w.len() < 2 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if w.len() != 2 {
+		t.Fatalf("wrote %d messages, want 2", w.len())
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	routed, plain := w.msgs[0], w.msgs[1]
+	if routed.Topic != "chat-giveaways" || plain.Topic != "chat" {
+		t.Fatalf("topics = %q, %q", routed.Topic, plain.Topic)
+	}
+	if len(routed.Headers) != 1 || routed.Headers[0].Key != "tags" || string(routed.Headers[0].Value) != "keyword,mod" {
+		t.Fatalf("routed headers = %+v", routed.Headers)
+	}
+	var m ircevents.PrivMsg
+	if err := json.Unmarshal(routed.Value, &m); err != nil || m.Text != "" || !m.Redacted {
+		t.Fatalf("routed event not redacted by policy: %+v, %v", m, err)
+	}
+}
@@ -2,7 +2,7 @@ package kafka
 
 import (
 	"context"
-	"strings"
+	"sync/atomic"
 
 	kafkago "github.com/segmentio/kafka-go"
 )
@@ -12,14 +12,27 @@ type MessageWriter interface {
 	Close() error
 }
 
-func NewWriter(brokersCSV, topic string) *kafkago.Writer {
-	parts := strings.Split(brokersCSV, ",")
-	for i := range parts {
-		parts[i] = strings.TrimSpace(parts[i])
-	}
+// NewWriter writes to brokers. Without a writer-wide topic every message
+// names its own; see Topic.
+func NewWriter(brokers []string) *kafkago.Writer {
 	return &kafkago.Writer{
-		Addr:     kafkago.TCP(parts...),
-		Topic:    topic,
+		Addr:     kafkago.TCP(brokers...),
 		Balancer: &kafkago.LeastBytes{},
 	}
 }
+
+// Topic is where KafkaProducer sends messages. Set may be called while the
+// producer runs.
+type Topic struct {
+	name atomic.Pointer[string]
+}
+
+func NewTopic(name string) *Topic {
+	t := &Topic{}
+	t.Set(name)
+	return t
+}
+
+func (t *Topic) Name() string { return *t.name.Load() }
+
+func (t *Topic) Set(name string) { t.name.Store(&name) }
@@ -0,0 +1,203 @@
+package livetail
+
+import (
+	"context"
+	"log/slog"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	ircevents "github.com/Jamie-38/stream-pipeline/internal/irc_events"
+	"github.com/Jamie-38/stream-pipeline/internal/observe"
+)
+
+const (
+	DefaultBuffer = 256
+	// DefaultMaxDrops is how many consecutive events a subscriber may miss
+	// before it is disconnected as hopelessly slow.
+	DefaultMaxDrops = 1024
+)
+
+// Filter selects events for a subscriber. Empty sets match everything.
+type Filter struct {
+	Channels map[string]struct{} // logins without '#'
+	Kinds    map[string]struct{}
+	Users    map[string]struct{} // logins or numeric ids
+}
+
+// ParseFilter reads comma-separated "channel", "kind" and "user" query values.
+func ParseFilter(q url.Values) Filter {
+	return Filter{
+		Channels: csvSet(q["channel"], func(s string) string { return strings.TrimPrefix(s, "#") }),
+		Kinds:    csvSet(q["kind"], nil),
+		Users:    csvSet(q["user"], nil),
+	}
+}
+
+func csvSet(vals []string, norm func(string) string) map[string]struct{} {
+	var m map[string]struct{}
+	for _, v := range vals {
+		for _, part := range strings.Split(v, ",") {
+			part = strings.ToLower(strings.TrimSpace(part))
+			if norm != nil {
+				part = norm(part)
+			}
+			if part == "" {
+				continue
+			}
+			if m == nil {
+				m = make(map[string]struct{})
+			}
+			m[part] = struct{}{}
+		}
+	}
+	return m
+}
+
+func (f Filter) Match(evt ircevents.Event) bool {
+	if len(f.Kinds) > 0 {
+		if _, ok := f.Kinds[evt.Kind()]; !ok {
+			return false
+		}
+	}
+	if len(f.Channels) == 0 && len(f.Users) == 0 {
+		return true
+	}
+	a, ok := evt.(ircevents.Attributed)
+	if !ok {
+		return false
+	}
+	if len(f.Channels) > 0 {
+		if _, ok := f.Channels[a.Channel()]; !ok {
+			return false
+		}
+	}
+	if len(f.Users) > 0 {
+		if _, ok := f.Users[a.User()]; ok {
+			return true
+		}
+		if pm, ok := evt.(ircevents.PrivMsg); ok && pm.UserID != "" {
+			_, ok := f.Users[pm.UserID]
+			return ok
+		}
+		return false
+	}
+	return true
+}
+
+// Hub fans events out to live subscribers. Publishing never blocks: a
+// subscriber whose buffer is full misses the event, and one that keeps
+// missing them is disconnected.
+type Hub struct {
+	mu       sync.RWMutex
+	subs     map[*Subscription]struct{}
+	n        atomic.Int32
+	maxDrops uint64
+	lg       *slog.Logger
+}
+
+func NewHub() *Hub {
+	return &Hub{
+		subs:     make(map[*Subscription]struct{}),
+		maxDrops: DefaultMaxDrops,
+		lg:       observe.C("livetail"),
+	}
+}
+
+type Subscription struct {
+	C <-chan ircevents.Event
+
+	hub       *Hub
+	ch        chan ircevents.Event
+	filter    Filter
+	done      chan struct{}
+	closeOnce sync.Once
+	dropped   atomic.Uint64 // total
+	streak    atomic.Uint64 // consecutive
+}
+
+// Subscribe registers a subscriber with the given buffer size (0 means
+// DefaultBuffer). Callers must Close the subscription when finished.
+func (h *Hub) Subscribe(f Filter, buf int) *Subscription {
+	if buf <= 0 {
+		buf = DefaultBuffer
+	}
+	ch := make(chan ircevents.Event, buf)
+	s := &Subscription{C: ch, hub: h, ch: ch, filter: f, done: make(chan struct{})}
+	h.mu.Lock()
+	h.subs[s] = struct{}{}
+	h.mu.Unlock()
+	h.n.Add(1)
+	h.lg.Info("subscriber added", "subscribers", h.n.Load())
+	return s
+}
+
+// Done is closed when the subscription ends, either by Close or because the
+// hub dropped a slow subscriber.
+func (s *Subscription) Done() <-chan struct{} { return s.done }
+
+// Dropped reports how many events this subscriber missed.
+func (s *Subscription) Dropped() uint64 { return s.dropped.Load() }
+
+func (s *Subscription) Close() {
+	s.closeOnce.Do(func() {
+		s.hub.mu.Lock()
+		delete(s.hub.subs, s)
+		s.hub.mu.Unlock()
+		s.hub.n.Add(-1)
+		close(s.done)
+	})
+}
+
+// Subscribers returns the number of live subscriptions.
+func (h *Hub) Subscribers() int { return int(h.n.Load()) }
+
+func (h *Hub) Publish(evt ircevents.Event) {
+	if h.n.Load() == 0 {
+		return
+	}
+	var slow []*Subscription
+	h.mu.RLock()
+	for s := range h.subs {
+		if !s.filter.Match(evt) {
+			continue
+		}
+		select {
+		case s.ch <- evt:
+			s.streak.Store(0)
+		default:
+			s.dropped.Add(1)
+			if s.streak.Add(1) >= h.maxDrops {
+				slow = append(slow, s)
+			}
+		}
+	}
+	h.mu.RUnlock()
+
+	for _, s := range slow {
+		h.lg.Warn("dropping slow subscriber", "dropped", s.Dropped())
+		s.Close()
+	}
+}
+
+// Tee forwards events from in to out, publishing each one to the hub on the
+// way. Backpressure comes only from out; subscribers never slow it down.
+func (h *Hub) Tee(ctx context.Context, in <-chan ircevents.Event, out chan<- ircevents.Event) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case evt, ok := <-in:
+			if !ok {
+				return
+			}
+			h.Publish(evt)
+			select {
+			case out <- evt:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
+}
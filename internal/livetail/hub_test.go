@@ -0,0 +1,80 @@
+package livetail
+
+import (
+	"context"
+	"net/url"
+	"testing"
+	"time"
+
+	ircevents "github.com/Jamie-38/stream-pipeline/internal/irc_events"
+)
+
+func pm(channel, user, id string) ircevents.PrivMsg {
+	return ircevents.PrivMsg{ChannelLogin: channel, UserLogin: user, UserID: id, Text: "hi"}
+}
+
+func TestFilterMatch(t *testing.T) {
+	f := ParseFilter(url.Values{"channel": {"#Chess,speedrun"}, "user": {"bob,42"}})
+
+	cases := []struct {
+		evt  ircevents.PrivMsg
+		want bool
+	}{
+		{pm("chess", "bob", ""), true},
+		{pm("speedrun", "alice", "42"), true},
+		{pm("chess", "alice", "7"), false},
+		{pm("other", "bob", ""), false},
+	}
+	for _, tc := range cases {
+		if got := f.Match(tc.evt); got != tc.want {
+			t.Errorf("Match(%+v) = %v, want %v", tc.evt, got, tc.want)
+		}
+	}
+
+	if ParseFilter(url.Values{"kind": {"usernotice"}}).Match(pm("chess", "bob", "")) {
+		t.Error("kind filter should exclude privmsg")
+	}
+	if !ParseFilter(url.Values{}).Match(pm("x", "y", "")) {
+		t.Error("empty filter should match everything")
+	}
+}
+
+func TestHub_SlowSubscriberNeverBlocksAndIsDropped(t *testing.T) {
+	h := NewHub()
+	h.maxDrops = 3
+	slow := h.Subscribe(Filter{}, 1)
+	fast := h.Subscribe(Filter{}, 16)
+	defer fast.Close()
+
+	in := make(chan ircevents.Event, 8)
+	out := make(chan ircevents.Event, 8)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go h.Tee(ctx, in, out)
+
+	for i := 0; i < 5; i++ {
+		in <- pm("chess", "bob", "")
+	}
+	for i := 0; i < 5; i++ {
+		select {
+		case <-out:
+		case <-time.After(time.Second):
+			t.Fatalf("tee blocked after %d events", i)
+		}
+	}
+
+	select {
+	case <-slow.Done():
+	case <-time.After(time.Second):
+		t.Fatal("slow subscriber was not dropped")
+	}
+	if slow.Dropped() < 3 {
+		t.Fatalf("slow dropped = %d, want >= 3", slow.Dropped())
+	}
+	if len(fast.C) != 5 {
+		t.Fatalf("fast subscriber got %d events, want 5", len(fast.C))
+	}
+	if h.Subscribers() != 1 {
+		t.Fatalf("subscribers = %d, want 1", h.Subscribers())
+	}
+}
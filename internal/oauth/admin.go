@@ -0,0 +1,249 @@
+package oauth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Jamie-38/stream-pipeline/internal/observe"
+)
+
+var revocations = observe.Metrics().Counter("oauth_revocations_total",
+	"Token revocations requested through /revoke, by result.", "result")
+
+// validations remembers the last successful validation of each account's
+// token for /status.
+type validations struct {
+	mu   sync.Mutex
+	last map[string]time.Time
+}
+
+func (v *validations) record(account string, at time.Time) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.last == nil {
+		v.last = make(map[string]time.Time)
+	}
+	v.last[account] = at
+}
+
+func (v *validations) get(account string) (time.Time, bool) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	t, ok := v.last[account]
+	return t, ok
+}
+
+// TokenStatus describes a stored token without revealing it.
+type TokenStatus struct {
+	Account         string     `json:"account,omitempty"`
+	Stored          bool       `json:"stored"`
+	Valid           bool       `json:"valid"`
+	Login           string     `json:"login,omitempty"`
+	Scopes          []string   `json:"scopes,omitempty"`
+	ExpiresAt       *time.Time `json:"expires_at,omitempty"`
+	HasRefreshToken bool       `json:"has_refresh_token"`
+	CheckedAt       time.Time  `json:"checked_at"`
+	LastValidAt     *time.Time `json:"last_valid_at,omitempty"`
+	Error           string     `json:"error,omitempty"`
+}
+
+// Status reports the stored token of ?account=, or of every account, after
+// validating it live. Tokens themselves are never included.
+func (h *Handler) Status(w http.ResponseWriter, r *http.Request) {
+	names, err := statusAccounts(r.URL.Query().Get("account"))
+	switch {
+	case errors.Is(err, errUnknownAccount):
+		http.Error(w, "Unknown account", http.StatusNotFound)
+		return
+	case err != nil:
+		lg.Error("load accounts", "err", err)
+		http.Error(w, "Accounts misconfigured", http.StatusInternalServerError)
+		return
+	}
+	out := make([]TokenStatus, 0, len(names))
+	for _, name := range names {
+		out = append(out, h.tokenStatus(r.Context(), name))
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+// statusAccounts lists the accounts /status covers: the named one, or all.
+func statusAccounts(name string) ([]string, error) {
+	if name != "" {
+		if _, err := tokenPath(name); err != nil {
+			return nil, err
+		}
+		return []string{strings.ToLower(name)}, nil
+	}
+	accounts, err := accountsFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	if accounts == nil {
+		return []string{""}, nil
+	}
+	names := make([]string, 0, len(accounts))
+	for _, a := range accounts {
+		names = append(names, a.Name())
+	}
+	return names, nil
+}
+
+func (h *Handler) tokenStatus(ctx context.Context, account string) TokenStatus {
+	st := h.checkToken(ctx, account)
+	if t, ok := h.validated.get(account); ok {
+		st.LastValidAt = &t
+	}
+	return st
+}
+
+// checkToken loads account's token and validates it.
+func (h *Handler) checkToken(ctx context.Context, account string) TokenStatus {
+	st := TokenStatus{Account: account, CheckedAt: time.Now().UTC()}
+	path, err := tokenPath(account)
+	if err != nil {
+		st.Error = err.Error()
+		return st
+	}
+	store, err := TokenStoreFromEnv(path)
+	if err != nil {
+		st.Error = err.Error()
+		return st
+	}
+	tok, err := store.Load()
+	if errors.Is(err, ErrNoToken) {
+		return st
+	}
+	if err != nil {
+		lg.Error("load token for status", "account", account, "err", err)
+		st.Error = "token store unreadable"
+		return st
+	}
+	st.Stored = true
+	st.HasRefreshToken = tok.RefreshToken != ""
+
+	v, err := h.validate(ctx, tok.AccessToken)
+	switch {
+	case errors.Is(err, ErrInvalidToken):
+		st.Error = "token rejected by the identity provider"
+		return st
+	case err != nil:
+		lg.Warn("validate token for status", "account", account, "err", err)
+		st.Error = "validation unavailable"
+		return st
+	}
+	st.Valid = true
+	st.Login = v.Login
+	st.Scopes = v.Scopes
+	if v.ExpiresIn > 0 {
+		exp := st.CheckedAt.Add(time.Duration(v.ExpiresIn) * time.Second)
+		st.ExpiresAt = &exp
+	}
+	h.validated.record(account, st.CheckedAt)
+	return st
+}
+
+type revokeResult struct {
+	Account        string `json:"account,omitempty"`
+	Revoked        bool   `json:"revoked"`
+	AlreadyInvalid bool   `json:"already_invalid,omitempty"`
+}
+
+// Revoke revokes the stored token of ?account= at the identity provider
+// and wipes it from the store. Collectors using it notice on their next
+// validation and report the account unhealthy until it is authorized
+// again. A failed revocation keeps the token so the call can be retried.
+func (h *Handler) Revoke(w http.ResponseWriter, r *http.Request) {
+	account := strings.ToLower(r.FormValue("account"))
+	accounts, err := accountsFromEnv()
+	if err != nil {
+		lg.Error("load accounts", "err", err)
+		http.Error(w, "Accounts misconfigured", http.StatusInternalServerError)
+		return
+	}
+	if accounts != nil && account == "" {
+		http.Error(w, "account is required", http.StatusBadRequest)
+		return
+	}
+	path, err := tokenPath(account)
+	switch {
+	case errors.Is(err, errUnknownAccount):
+		http.Error(w, "Unknown account", http.StatusNotFound)
+		return
+	case err != nil:
+		lg.Error("load accounts", "err", err)
+		http.Error(w, "Accounts misconfigured", http.StatusInternalServerError)
+		return
+	}
+	store, err := TokenStoreFromEnv(path)
+	if err != nil {
+		lg.Error("token store unavailable", "err", err)
+		http.Error(w, "Token storage misconfigured", http.StatusInternalServerError)
+		return
+	}
+	tok, err := store.Load()
+	if errors.Is(err, ErrNoToken) {
+		revocations.With("no_token").Inc()
+		http.Error(w, "No token stored", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		lg.Error("load token for revoke", "account", account, "err", err)
+		revocations.With("store_failed").Inc()
+		http.Error(w, "Failed to load token", http.StatusInternalServerError)
+		return
+	}
+
+	already, err := h.revoke(tok.AccessToken)
+	if err != nil {
+		lg.Error("revoke failed", "account", account, "err", err)
+		revocations.With("revoke_failed").Inc()
+		http.Error(w, "Failed to revoke token", http.StatusBadGateway)
+		return
+	}
+	if err := store.Delete(); err != nil {
+		lg.Error("wipe revoked token", "account", account, "path", path, "err", err)
+		revocations.With("store_failed").Inc()
+		http.Error(w, "Token revoked but not wiped", http.StatusInternalServerError)
+		return
+	}
+
+	lg.Warn("token revoked and wiped", "account", account, "path", path, "already_invalid", already, "remote", r.RemoteAddr)
+	revocations.With("ok").Inc()
+	writeJSON(w, http.StatusOK, revokeResult{Account: account, Revoked: true, AlreadyInvalid: already})
+}
+
+// revoke revokes accessToken, and with it its refresh token. A token the
+// provider no longer knows is reported as already invalid.
+func (h *Handler) revoke(accessToken string) (already bool, err error) {
+	data := url.Values{}
+	data.Set("client_id", os.Getenv("TWITCH_CLIENT_ID"))
+	data.Set("token", accessToken)
+	resp, err := clientOrDefault(h.Client).PostForm(h.endpoints().Revoke, data)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	msg := errorMessage(resp.Body)
+	switch {
+	case resp.StatusCode == http.StatusOK:
+		return false, nil
+	case resp.StatusCode == http.StatusBadRequest && strings.EqualFold(msg, "Invalid token"):
+		return true, nil
+	}
+	return false, fmt.Errorf("revoke: status %d: %s", resp.StatusCode, msg)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
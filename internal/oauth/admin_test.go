@@ -0,0 +1,158 @@
+package oauth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Jamie-38/stream-pipeline/internal/healthcheck"
+	"github.com/Jamie-38/stream-pipeline/internal/types"
+)
+
+func (r *flowRig) revoke() *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	r.h.Revoke(w, httptest.NewRequest("POST", "/revoke", nil))
+	return w
+}
+
+func TestRevokeInvalidatesAndWipesToken(t *testing.T) {
+	r := newFlowRig(t)
+	if w := r.login(); w.Code != http.StatusOK {
+		t.Fatalf("callback status = %d: %s", w.Code, w.Body)
+	}
+	tok, _ := LoadTokenJSON(r.path)
+
+	if w := r.revoke(); w.Code != http.StatusOK {
+		t.Fatalf("revoke status = %d: %s", w.Code, w.Body)
+	}
+	if r.idp.Valid(tok.AccessToken) {
+		t.Fatal("token still valid at the provider")
+	}
+	if _, err := (FileStore{Path: r.path}).Load(); !errors.Is(err, ErrNoToken) {
+		t.Fatalf("Load after revoke = %v, want ErrNoToken", err)
+	}
+	if w := r.revoke(); w.Code != http.StatusNotFound {
+		t.Fatalf("second revoke status = %d, want 404", w.Code)
+	}
+}
+
+func TestRevokeWipesTokenUnknownToProvider(t *testing.T) {
+	r := newFlowRig(t)
+	if err := SaveTokenJSON(r.path, types.Token{AccessToken: "leaked", RefreshToken: "r"}); err != nil {
+		t.Fatal(err)
+	}
+	w := r.revoke()
+	var res revokeResult
+	if err := json.NewDecoder(w.Body).Decode(&res); err != nil || w.Code != http.StatusOK || !res.AlreadyInvalid {
+		t.Fatalf("revoke = %d %+v, %v", w.Code, res, err)
+	}
+	if _, err := LoadTokenJSON(r.path); !errors.Is(err, ErrNoToken) {
+		t.Fatalf("token not wiped: %v", err)
+	}
+}
+
+func TestStatusHidesSecrets(t *testing.T) {
+	r := newFlowRig(t)
+	if w := r.login(); w.Code != http.StatusOK {
+		t.Fatalf("callback status = %d: %s", w.Code, w.Body)
+	}
+	tok, _ := LoadTokenJSON(r.path)
+
+	w := httptest.NewRecorder()
+	r.h.Status(w, httptest.NewRequest("GET", "/status", nil))
+	body := w.Body.String()
+	if strings.Contains(body, tok.AccessToken) || strings.Contains(body, tok.RefreshToken) {
+		t.Fatalf("status leaks the token: %s", body)
+	}
+	var st []TokenStatus
+	if err := json.Unmarshal([]byte(body), &st); err != nil || len(st) != 1 {
+		t.Fatalf("status = %s, %v", body, err)
+	}
+	got := st[0]
+	if !got.Stored || !got.Valid || got.Login != "bot" || !got.HasRefreshToken || got.ExpiresAt == nil || got.LastValidAt == nil {
+		t.Fatalf("status = %+v", got)
+	}
+	if strings.Join(got.Scopes, " ") != "chat:read chat:edit" {
+		t.Fatalf("scopes = %v", got.Scopes)
+	}
+
+	r.revoke()
+	w = httptest.NewRecorder()
+	r.h.Status(w, httptest.NewRequest("GET", "/status", nil))
+	st = nil
+	_ = json.Unmarshal(w.Body.Bytes(), &st)
+	if len(st) != 1 || st[0].Stored || st[0].Valid || st[0].LastValidAt == nil {
+		t.Fatalf("status after revoke = %s", w.Body)
+	}
+}
+
+func TestStatusValidatesWithRequestContext(t *testing.T) {
+	r := newFlowRig(t)
+	if w := r.login(); w.Code != http.StatusOK {
+		t.Fatalf("callback status = %d: %s", w.Code, w.Body)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	w := httptest.NewRecorder()
+	r.h.Status(w, httptest.NewRequest("GET", "/status", nil).WithContext(ctx))
+	var st []TokenStatus
+	if err := json.Unmarshal(w.Body.Bytes(), &st); err != nil || len(st) != 1 {
+		t.Fatalf("status = %s, %v", w.Body, err)
+	}
+	if st[0].Valid || st[0].Error != "validation unavailable" {
+		t.Fatalf("status for a gone caller = %+v, want validation skipped", st[0])
+	}
+}
+
+func TestManagerReportsRevocationAndRecovers(t *testing.T) {
+	r := newFlowRig(t)
+	if w := r.login(); w.Code != http.StatusOK {
+		t.Fatalf("callback status = %d: %s", w.Code, w.Body)
+	}
+	m, err := NewManager(ManagerConfig{
+		Store:        FileStore{Path: r.path},
+		ClientID:     "cid",
+		ClientSecret: "secret",
+		Endpoints:    r.endpoints(),
+		Client:       r.idp.Client(),
+		HealthName:   "oauth_token/revoked",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	if err := m.Ensure(ctx); err != nil {
+		t.Fatalf("Ensure: %v", err)
+	}
+
+	r.revoke()
+	if err := m.Ensure(ctx); !errors.Is(err, ErrTokenRevoked) || !m.Revoked() {
+		t.Fatalf("Ensure after revoke = %v, revoked %v", err, m.Revoked())
+	}
+	_, _, rep := healthcheck.Default().Check(time.Now())
+	if st := rep.Components["oauth_token/revoked"]; st.Ready || !strings.Contains(st.Detail, "revoked") {
+		t.Fatalf("health after revoke = %+v", st)
+	}
+
+	// re-authorizing through the server is picked up without a restart
+	if w := r.login(); w.Code != http.StatusOK {
+		t.Fatalf("re-login status = %d: %s", w.Code, w.Body)
+	}
+	if err := m.Ensure(ctx); err != nil || m.Revoked() {
+		t.Fatalf("Ensure after re-login = %v, revoked %v", err, m.Revoked())
+	}
+	tok, _ := LoadTokenJSON(r.path)
+	if m.AccessToken() != tok.AccessToken {
+		t.Fatal("manager did not adopt the re-authorized token")
+	}
+	_, _, rep = healthcheck.Default().Check(time.Now())
+	if st := rep.Components["oauth_token/revoked"]; !st.Ready {
+		t.Fatalf("health after re-login = %+v", st)
+	}
+}
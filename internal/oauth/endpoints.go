@@ -0,0 +1,68 @@
+package oauth
+
+import (
+	"net/http"
+	"os"
+)
+
+// Endpoints are the identity provider's OAuth URLs.
+type Endpoints struct {
+	Authorize string
+	Token     string
+	Validate  string
+	Revoke    string
+}
+
+// TwitchEndpoints are the production Twitch identity URLs.
+func TwitchEndpoints() Endpoints {
+	return Endpoints{
+		Authorize: "https://id.twitch.tv/oauth2/authorize",
+		Token:     "https://id.twitch.tv/oauth2/token",
+		Validate:  "https://id.twitch.tv/oauth2/validate",
+		Revoke:    "https://id.twitch.tv/oauth2/revoke",
+	}
+}
+
+// EndpointsFromEnv overrides the Twitch URLs with TWITCH_AUTHORIZE_URL,
+// TWITCH_TOKEN_URL, TWITCH_VALIDATE_URL and TWITCH_REVOKE_URL.
+func EndpointsFromEnv() Endpoints {
+	e := TwitchEndpoints()
+	for _, o := range []struct {
+		env string
+		dst *string
+	}{
+		{"TWITCH_AUTHORIZE_URL", &e.Authorize},
+		{"TWITCH_TOKEN_URL", &e.Token},
+		{"TWITCH_VALIDATE_URL", &e.Validate},
+		{"TWITCH_REVOKE_URL", &e.Revoke},
+	} {
+		if v := os.Getenv(o.env); v != "" {
+			*o.dst = v
+		}
+	}
+	return e
+}
+
+// withDefaults fills empty URLs from d.
+func (e Endpoints) withDefaults(d Endpoints) Endpoints {
+	if e.Authorize == "" {
+		e.Authorize = d.Authorize
+	}
+	if e.Token == "" {
+		e.Token = d.Token
+	}
+	if e.Validate == "" {
+		e.Validate = d.Validate
+	}
+	if e.Revoke == "" {
+		e.Revoke = d.Revoke
+	}
+	return e
+}
+
+func clientOrDefault(c *http.Client) *http.Client {
+	if c == nil {
+		return http.DefaultClient
+	}
+	return c
+}
@@ -0,0 +1,155 @@
+package oauth
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Jamie-38/stream-pipeline/internal/oauth/oauthtest"
+)
+
+// flowRig drives Index, the fake authorize endpoint and Callback the way a
+// browser would.
+type flowRig struct {
+	t    *testing.T
+	idp  *oauthtest.Provider
+	h    *Handler
+	path string
+}
+
+func newFlowRig(t *testing.T) *flowRig {
+	t.Helper()
+	idp := oauthtest.NewProvider("cid", "secret", "bot")
+	t.Cleanup(idp.Close)
+	path := filepath.Join(t.TempDir(), "token.json")
+	t.Setenv("TWITCH_CLIENT_ID", "cid")
+	t.Setenv("TWITCH_CLIENT_SECRET", "secret")
+	t.Setenv("TWITCH_REDIRECT_URI", "http://collector.test/callback")
+	t.Setenv("TOKENS_PATH", path)
+	r := &flowRig{t: t, idp: idp, path: path}
+	r.h = &Handler{Endpoints: r.endpoints(), Client: idp.Client()}
+	return r
+}
+
+func (r *flowRig) endpoints() Endpoints {
+	return Endpoints{
+		Authorize: r.idp.AuthorizeURL(),
+		Token:     r.idp.TokenURL(),
+		Validate:  r.idp.ValidateURL(),
+		Revoke:    r.idp.RevokeURL(),
+	}
+}
+
+// login runs the whole flow and returns the callback's response.
+func (r *flowRig) login() *httptest.ResponseRecorder {
+	r.t.Helper()
+	w := httptest.NewRecorder()
+	r.h.Index(w, httptest.NewRequest("GET", "/", nil))
+	cookies := w.Result().Cookies()
+
+	noFollow := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := noFollow.Get(authLink(r.t, w.Body.String()).String())
+	if err != nil {
+		r.t.Fatal(err)
+	}
+	resp.Body.Close()
+	back, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil || resp.StatusCode != http.StatusFound {
+		r.t.Fatalf("authorize: status %d, location %q", resp.StatusCode, resp.Header.Get("Location"))
+	}
+
+	req := httptest.NewRequest("GET", "/callback?"+back.RawQuery, nil)
+	for _, c := range cookies {
+		req.AddCookie(c)
+	}
+	w = httptest.NewRecorder()
+	r.h.Callback(w, req)
+	return w
+}
+
+func TestFlowCallbackThenRefresh(t *testing.T) {
+	r := newFlowRig(t)
+	if w := r.login(); w.Code != http.StatusOK {
+		t.Fatalf("callback status = %d: %s", w.Code, w.Body)
+	}
+	first, err := LoadTokenJSON(r.path)
+	if err != nil || !r.idp.Valid(first.AccessToken) {
+		t.Fatalf("stored token = %+v, %v", first, err)
+	}
+
+	m, err := NewManager(ManagerConfig{
+		Store:        FileStore{Path: r.path},
+		ClientID:     "cid",
+		ClientSecret: "secret",
+		Endpoints:    r.endpoints(),
+		Client:       r.idp.Client(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	if err := m.Ensure(ctx); err != nil || m.Login() != "bot" {
+		t.Fatalf("Ensure = %v, login %q", err, m.Login())
+	}
+
+	// an expired token is refreshed, rotated and persisted
+	r.idp.Expire(first.AccessToken)
+	if err := m.Ensure(ctx); err != nil {
+		t.Fatalf("Ensure after expiry: %v", err)
+	}
+	second, _ := LoadTokenJSON(r.path)
+	if second.AccessToken == first.AccessToken || second.RefreshToken == first.RefreshToken {
+		t.Fatalf("token not rotated: %+v", second)
+	}
+	if m.AccessToken() != second.AccessToken || !r.idp.Valid(second.AccessToken) {
+		t.Fatal("manager does not hold the refreshed token")
+	}
+
+	// a failed refresh keeps the current token
+	r.idp.FailToken(http.StatusBadRequest, "Invalid refresh token")
+	if err := m.Refresh(ctx); err == nil || !strings.Contains(err.Error(), "Invalid refresh token") {
+		t.Fatalf("Refresh err = %v, want the endpoint's message", err)
+	}
+	if onDisk, _ := LoadTokenJSON(r.path); onDisk.AccessToken != second.AccessToken {
+		t.Fatal("failed refresh replaced the stored token")
+	}
+}
+
+func TestFlowTokenEndpointErrors(t *testing.T) {
+	for _, tc := range []struct {
+		status  int
+		message string
+	}{
+		{http.StatusBadRequest, "Invalid authorization code"},
+		{http.StatusForbidden, "invalid client secret"},
+		{http.StatusInternalServerError, "internal error"},
+	} {
+		r := newFlowRig(t)
+		r.idp.FailToken(tc.status, tc.message)
+		if w := r.login(); w.Code != http.StatusBadGateway {
+			t.Fatalf("%d %s: callback status = %d, want 502", tc.status, tc.message, w.Code)
+		}
+		if _, err := os.Stat(r.path); !os.IsNotExist(err) {
+			t.Fatalf("%d: token file written after a failed exchange", tc.status)
+		}
+	}
+}
+
+func TestFlowDeniedAndWrongSecret(t *testing.T) {
+	r := newFlowRig(t)
+	r.idp.Deny(true)
+	if w := r.login(); w.Code != http.StatusBadRequest {
+		t.Fatalf("denied: status = %d, want 400", w.Code)
+	}
+
+	r = newFlowRig(t)
+	t.Setenv("TWITCH_CLIENT_SECRET", "wrong")
+	if w := r.login(); w.Code != http.StatusBadGateway {
+		t.Fatalf("wrong secret: status = %d, want 502", w.Code)
+	}
+}
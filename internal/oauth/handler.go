@@ -1,86 +1,322 @@
 package oauth
 
 import (
+	"context"
+	"crypto/hmac"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"html"
+	"io"
 	"net/http"
 	"net/url"
 	"os"
+	"strings"
+	"time"
 
+	"github.com/Jamie-38/stream-pipeline/internal/config"
 	"github.com/Jamie-38/stream-pipeline/internal/observe"
 	"github.com/Jamie-38/stream-pipeline/internal/types"
 )
 
-var lg = observe.C("oauth")
+var (
+	lg        = observe.C("oauth")
+	callbacks = observe.Metrics().Counter("oauth_callbacks_total",
+		"OAuth callbacks handled, by result.", "result")
+)
 
-func Index(w http.ResponseWriter, r *http.Request) {
-	clientID := os.Getenv("TWITCH_CLIENT_ID")
+const stateCookie = "oauth_state"
+
+// scopesFromEnv reads TWITCH_SCOPES (space- or comma-separated), defaulting
+// to chat:read and chat:edit.
+func scopesFromEnv() string {
+	v := strings.TrimSpace(os.Getenv("TWITCH_SCOPES"))
+	if v == "" {
+		return "chat:read chat:edit"
+	}
+	return strings.Join(strings.FieldsFunc(v, func(r rune) bool { return r == ',' || r == ' ' }), " ")
+}
+
+// pkceEnabled is on unless OAUTH_PKCE is "false".
+func pkceEnabled() bool {
+	return !strings.EqualFold(strings.TrimSpace(os.Getenv("OAUTH_PKCE")), "false")
+}
+
+// Handler serves the authorization code flow and the token admin routes. Empty endpoints are read
+// from the environment on each request; a nil Client is http.DefaultClient.
+type Handler struct {
+	Endpoints Endpoints
+	Client    *http.Client
+
+	validated validations // for Status
+}
+
+var defaultHandler = &Handler{}
+
+// Index serves Handler.Index with endpoints from the environment.
+func Index(w http.ResponseWriter, r *http.Request) { defaultHandler.Index(w, r) }
+
+// Callback serves Handler.Callback with endpoints from the environment.
+func Callback(w http.ResponseWriter, r *http.Request) { defaultHandler.Callback(w, r) }
+
+func (h *Handler) endpoints() Endpoints { return h.Endpoints.withDefaults(EndpointsFromEnv()) }
+
+// accountsFromEnv loads the token-holding accounts of ACCOUNTS_PATH with
+// their paths resolved; nil when it is unset and the server runs for the
+// single TOKENS_PATH.
+func accountsFromEnv() ([]types.Account, error) {
+	path := os.Getenv("ACCOUNTS_PATH")
+	if path == "" {
+		return nil, nil
+	}
+	accs, err := config.LoadAccounts(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := config.ResolveAccountPaths(accs, os.Getenv("TOKENS_PATH"), ""); err != nil {
+		return nil, err
+	}
+	// anonymous accounts have no token to authorize
+	withTokens := accs[:0]
+	for _, a := range accs {
+		if !a.Anonymous {
+			withTokens = append(withTokens, a)
+		}
+	}
+	return withTokens, nil
+}
+
+var errUnknownAccount = errors.New("unknown account")
+
+// tokenPath is the token file of account; "" is the single TOKENS_PATH
+// account of a server without an accounts file.
+func tokenPath(account string) (string, error) {
+	if account == "" {
+		return os.Getenv("TOKENS_PATH"), nil
+	}
+	accounts, err := accountsFromEnv()
+	if err != nil {
+		return "", err
+	}
+	a, ok := config.FindAccount(accounts, account)
+	if !ok {
+		return "", errUnknownAccount
+	}
+	return a.TokensPath, nil
+}
+
+// Index starts an authorization: it issues a signed state bound to this
+// browser by cookie and links to Twitch with it and, if enabled, a PKCE
+// challenge. With several accounts the account is picked by ?account= and
+// travels in the state.
+func (h *Handler) Index(w http.ResponseWriter, r *http.Request) {
 	redirectURI := os.Getenv("TWITCH_REDIRECT_URI")
 
-	authURL := fmt.Sprintf(
-		"https://id.twitch.tv/oauth2/authorize?client_id=%s&redirect_uri=%s&response_type=code&scope=chat:read",
-		clientID, redirectURI)
+	accounts, err := accountsFromEnv()
+	if err != nil {
+		lg.Error("load accounts", "err", err)
+		http.Error(w, "Accounts misconfigured", http.StatusInternalServerError)
+		return
+	}
+	account := ""
+	if accounts != nil {
+		name := r.URL.Query().Get("account")
+		a, ok := config.FindAccount(accounts, name)
+		switch {
+		case !ok && name == "":
+			renderAccountList(w, accounts)
+			return
+		case !ok:
+			http.Error(w, "Unknown account", http.StatusNotFound)
+			return
+		}
+		account = a.Name()
+	}
+	state, verifier := sessions.issue(time.Now(), account)
 
-	lg.Info("oauth index hit", "remote", r.RemoteAddr)
-	fmt.Fprintf(w, `<a href="%s">Click here to authenticate with Twitch</a>`, authURL)
+	q := url.Values{}
+	q.Set("client_id", os.Getenv("TWITCH_CLIENT_ID"))
+	q.Set("redirect_uri", redirectURI)
+	q.Set("response_type", "code")
+	q.Set("scope", scopesFromEnv())
+	q.Set("state", state)
+	if pkceEnabled() {
+		q.Set("code_challenge", pkceChallenge(verifier))
+		q.Set("code_challenge_method", "S256")
+	}
+	if len(accounts) > 1 {
+		// make Twitch ask which user to sign in as
+		q.Set("force_verify", "true")
+	}
+	authURL := h.endpoints().Authorize + "?" + q.Encode()
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     stateCookie,
+		Value:    state,
+		Path:     "/",
+		MaxAge:   int(sessions.ttl.Seconds()),
+		HttpOnly: true,
+		Secure:   r.TLS != nil || strings.HasPrefix(redirectURI, "https://"),
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	lg.Info("oauth index hit", "account", account, "remote", r.RemoteAddr)
+	label := "Twitch"
+	if account != "" {
+		label = "Twitch as " + account
+	}
+	fmt.Fprintf(w, `<a href="%s">Click here to authenticate with %s</a>`, html.EscapeString(authURL), html.EscapeString(label))
 }
 
-func Callback(w http.ResponseWriter, r *http.Request) {
+func renderAccountList(w http.ResponseWriter, accounts []types.Account) {
+	fmt.Fprint(w, "<p>Choose the account to authorize:</p><ul>")
+	for _, a := range accounts {
+		href := "/?" + url.Values{"account": {a.Name()}}.Encode()
+		fmt.Fprintf(w, `<li><a href="%s">%s</a></li>`, html.EscapeString(href), html.EscapeString(a.Name()))
+	}
+	fmt.Fprint(w, "</ul>")
+}
+
+// validate checks an access token against the validate endpoint. A
+// rejected token yields ErrInvalidToken.
+func (h *Handler) validate(ctx context.Context, accessToken string) (validateResponse, error) {
+	return validateToken(ctx, clientOrDefault(h.Client), h.endpoints().Validate, accessToken)
+}
+
+func (h *Handler) Callback(w http.ResponseWriter, r *http.Request) {
 	clientID := os.Getenv("TWITCH_CLIENT_ID")
 	clientSecret := os.Getenv("TWITCH_CLIENT_SECRET")
 	redirectURI := os.Getenv("TWITCH_REDIRECT_URI")
-	tokenURL := "https://id.twitch.tv/oauth2/token"
-	path := os.Getenv("TOKENS_PATH")
 
-	code := r.URL.Query().Get("code")
+	query := r.URL.Query()
+	if e := query.Get("error"); e != "" {
+		lg.Warn("authorization denied", "error", e, "remote", r.RemoteAddr)
+		callbacks.With("denied").Inc()
+		http.Error(w, "Authorization denied: "+e, http.StatusBadRequest)
+		return
+	}
+
+	code := query.Get("code")
 	if code == "" {
 		lg.Warn("callback missing code", "remote", r.RemoteAddr)
+		callbacks.With("missing_code").Inc()
 		http.Error(w, "Error: No code received", http.StatusBadRequest)
 		return
 	}
 
+	// the state must be ours, unexpired, unused and issued to this browser
+	state := query.Get("state")
+	c, err := r.Cookie(stateCookie)
+	if err != nil || state == "" || !hmac.Equal([]byte(c.Value), []byte(state)) {
+		lg.Warn("callback state does not match session", "remote", r.RemoteAddr)
+		callbacks.With("invalid_state").Inc()
+		http.Error(w, "Invalid state", http.StatusForbidden)
+		return
+	}
+	auth, err := sessions.consume(state, time.Now())
+	if err != nil {
+		lg.Warn("callback state rejected", "err", err, "remote", r.RemoteAddr)
+		callbacks.With("invalid_state").Inc()
+		http.Error(w, "Invalid state", http.StatusForbidden)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{Name: stateCookie, Path: "/", MaxAge: -1})
+
+	path, err := tokenPath(auth.account)
+	switch {
+	case errors.Is(err, errUnknownAccount):
+		lg.Warn("callback for removed account", "account", auth.account)
+		callbacks.With("invalid_state").Inc()
+		http.Error(w, "Unknown account", http.StatusNotFound)
+		return
+	case err != nil:
+		lg.Error("load accounts", "err", err)
+		callbacks.With("store_failed").Inc()
+		http.Error(w, "Accounts misconfigured", http.StatusInternalServerError)
+		return
+	}
+	store, err := TokenStoreFromEnv(path)
+	if err != nil {
+		lg.Error("token store unavailable", "err", err)
+		callbacks.With("store_failed").Inc()
+		http.Error(w, "Token storage misconfigured", http.StatusInternalServerError)
+		return
+	}
+
 	data := url.Values{}
 	data.Set("client_id", clientID)
 	data.Set("client_secret", clientSecret)
 	data.Set("code", code)
 	data.Set("grant_type", "authorization_code")
 	data.Set("redirect_uri", redirectURI)
+	if pkceEnabled() {
+		data.Set("code_verifier", auth.verifier)
+	}
 
 	lg.Info("exchanging code for token", "remote", r.RemoteAddr)
 
-	resp, err := http.PostForm(tokenURL, data)
+	resp, err := clientOrDefault(h.Client).PostForm(h.endpoints().Token, data)
 	if err != nil {
 		lg.Error("token request failed", "err", err)
+		callbacks.With("exchange_failed").Inc()
 		http.Error(w, "Failed to post", http.StatusBadGateway)
 		return
 	}
+	defer resp.Body.Close()
 	if resp.StatusCode != http.StatusOK {
-		lg.Error("token endpoint returned non-200", "status", resp.StatusCode)
+		lg.Error("token endpoint returned non-200", "status", resp.StatusCode, "message", errorMessage(resp.Body))
+		callbacks.With("exchange_failed").Inc()
 		http.Error(w, "Token exchange failed", http.StatusBadGateway)
 		return
 	}
-	defer resp.Body.Close()
 
 	var tokenData types.Token
 	if err := json.NewDecoder(resp.Body).Decode(&tokenData); err != nil {
 		lg.Error("decode token response failed", "err", err)
+		callbacks.With("exchange_failed").Inc()
 		http.Error(w, "Failed to parse response", http.StatusBadGateway)
 		return
 	}
 
-	f, err := os.Create(path)
-	if err != nil {
-		lg.Error("failed to write token file", "path", path, "err", err)
-		http.Error(w, "Failed to write token file", http.StatusInternalServerError)
-		return
+	// a token for another Twitch user would make that account's bot log in
+	// as someone else
+	if auth.account != "" {
+		v, err := h.validate(r.Context(), tokenData.AccessToken)
+		if err != nil {
+			lg.Error("validate new token failed", "account", auth.account, "err", err)
+			callbacks.With("exchange_failed").Inc()
+			http.Error(w, "Failed to validate token", http.StatusBadGateway)
+			return
+		}
+		if v.Login != auth.account {
+			lg.Warn("token belongs to another user", "account", auth.account, "login", v.Login)
+			callbacks.With("wrong_account").Inc()
+			http.Error(w, fmt.Sprintf("Signed in as %s, not %s", v.Login, auth.account), http.StatusConflict)
+			return
+		}
 	}
-	defer f.Close()
-	if err := json.NewEncoder(f).Encode(tokenData); err != nil {
-		lg.Error("failed to encode token file", "path", path, "err", err)
-		http.Error(w, "Failed to encode token file", http.StatusInternalServerError)
+
+	if err := store.Save(tokenData); err != nil {
+		lg.Error("failed to save token", "path", path, "err", err)
+		callbacks.With("store_failed").Inc()
+		http.Error(w, "Failed to save token", http.StatusInternalServerError)
 		return
 	}
 
-	lg.Info("oauth token saved", "path", path, "remote", r.RemoteAddr)
+	lg.Info("oauth token saved", "account", auth.account, "path", path, "remote", r.RemoteAddr)
+	callbacks.With("ok").Inc()
 	fmt.Fprintf(w, "Authentication successful. Token saved.")
 }
+
+// errorMessage extracts the message of a Twitch error body
+// ({"status":400,"message":"..."}), or its first bytes otherwise.
+func errorMessage(body io.Reader) string {
+	b, _ := io.ReadAll(io.LimitReader(body, 1<<10))
+	var e struct {
+		Message string `json:"message"`
+	}
+	if json.Unmarshal(b, &e) == nil && e.Message != "" {
+		return e.Message
+	}
+	return strings.TrimSpace(string(b))
+}
@@ -1,11 +1,14 @@
 package oauth
 
 import (
+	"html"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"os"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestIndexRendersAuthLink(t *testing.T) {
@@ -20,10 +23,57 @@ func TestIndexRendersAuthLink(t *testing.T) {
 	if w.Code != http.StatusOK {
 		t.Fatalf("status = %d, want 200", w.Code)
 	}
-	body := w.Body.String()
-	if !strings.Contains(body, "abc123") || !strings.Contains(body, "http://localhost:3000/callback") {
-		t.Fatalf("body missing client/redirect: %q", body)
+	u := authLink(t, w.Body.String())
+	q := u.Query()
+	if q.Get("client_id") != "abc123" || q.Get("redirect_uri") != "http://localhost:3000/callback" {
+		t.Fatalf("link missing client/redirect: %s", u)
 	}
+	if q.Get("response_type") != "code" || q.Get("scope") != "chat:read chat:edit" {
+		t.Fatalf("unexpected response_type/scope: %s", u)
+	}
+	if q.Get("state") == "" || q.Get("code_challenge_method") != "S256" || q.Get("code_challenge") == "" {
+		t.Fatalf("link missing state or PKCE: %s", u)
+	}
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != stateCookie || cookies[0].Value != q.Get("state") || !cookies[0].HttpOnly {
+		t.Fatalf("state cookie = %+v", cookies)
+	}
+}
+
+func TestIndexScopesAndEscaping(t *testing.T) {
+	t.Setenv("TWITCH_CLIENT_ID", "a&b=c")
+	t.Setenv("TWITCH_REDIRECT_URI", "http://localhost:3000/callback?x=1&y=2")
+	t.Setenv("TWITCH_SCOPES", "chat:read, user:read:email")
+	t.Setenv("OAUTH_PKCE", "false")
+
+	w := httptest.NewRecorder()
+	Index(w, httptest.NewRequest("GET", "/", nil))
+
+	q := authLink(t, w.Body.String()).Query()
+	if q.Get("client_id") != "a&b=c" || q.Get("redirect_uri") != "http://localhost:3000/callback?x=1&y=2" {
+		t.Fatalf("values not escaped: %v", q)
+	}
+	if q.Get("scope") != "chat:read user:read:email" {
+		t.Fatalf("scope = %q", q.Get("scope"))
+	}
+	if q.Has("code_challenge") {
+		t.Fatalf("PKCE disabled but challenge sent: %v", q)
+	}
+}
+
+// authLink extracts the href Index rendered.
+func authLink(t *testing.T, body string) *url.URL {
+	t.Helper()
+	_, rest, ok := strings.Cut(body, `href="`)
+	href, _, ok2 := strings.Cut(rest, `"`)
+	if !ok || !ok2 {
+		t.Fatalf("no link in body: %q", body)
+	}
+	u, err := url.Parse(html.UnescapeString(href))
+	if err != nil {
+		t.Fatalf("parse link: %v", err)
+	}
+	return u
 }
 
 func TestCallbackMissingCode(t *testing.T) {
@@ -37,3 +87,135 @@ func TestCallbackMissingCode(t *testing.T) {
 		t.Fatalf("status = %d, want 400", w.Code)
 	}
 }
+
+func TestCallbackRejectsBadState(t *testing.T) {
+	t.Setenv("TOKENS_PATH", t.TempDir()+"/token.json")
+	state, _ := sessions.issue(time.Now(), "")
+	other, _ := sessions.issue(time.Now(), "")
+
+	cases := map[string]struct {
+		param, cookie string
+	}{
+		"missing":   {"", ""},
+		"no cookie": {state, ""},
+		"mismatch":  {state, other},
+		"forged":    {"x.9999999999.sig", "x.9999999999.sig"},
+	}
+	for name, c := range cases {
+		req := httptest.NewRequest("GET", "/callback?code=abc&state="+url.QueryEscape(c.param), nil)
+		if c.cookie != "" {
+			req.AddCookie(&http.Cookie{Name: stateCookie, Value: c.cookie})
+		}
+		w := httptest.NewRecorder()
+		Callback(w, req)
+		if w.Code != http.StatusForbidden {
+			t.Errorf("%s: status = %d, want 403", name, w.Code)
+		}
+	}
+}
+
+func TestCallbackExchangesWithVerifier(t *testing.T) {
+	var got url.Values
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		got = r.PostForm
+		w.Write([]byte(`{"access_token":"at","refresh_token":"rt","expires_in":3600}`))
+	}))
+	defer ts.Close()
+	path := t.TempDir() + "/token.json"
+	t.Setenv("TOKENS_PATH", path)
+	t.Setenv("TWITCH_TOKEN_URL", ts.URL)
+
+	w := httptest.NewRecorder()
+	Index(w, httptest.NewRequest("GET", "/", nil))
+	q := authLink(t, w.Body.String()).Query()
+	state := q.Get("state")
+
+	call := func() int {
+		req := httptest.NewRequest("GET", "/callback?code=abc&state="+url.QueryEscape(state), nil)
+		req.AddCookie(&http.Cookie{Name: stateCookie, Value: state})
+		w := httptest.NewRecorder()
+		Callback(w, req)
+		return w.Code
+	}
+	if code := call(); code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", code)
+	}
+	if pkceChallenge(got.Get("code_verifier")) != q.Get("code_challenge") {
+		t.Fatalf("verifier %q does not match challenge %q", got.Get("code_verifier"), q.Get("code_challenge"))
+	}
+	if tok, err := LoadTokenJSON(path); err != nil || tok.AccessToken != "at" {
+		t.Fatalf("saved token = %+v, %v", tok, err)
+	}
+	if code := call(); code != http.StatusForbidden {
+		t.Fatalf("replayed state: status = %d, want 403", code)
+	}
+}
+
+func TestMultiAccountFlow(t *testing.T) {
+	login := "bot_b"
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/token":
+			w.Write([]byte(`{"access_token":"at-b","refresh_token":"rt"}`))
+		case "/validate":
+			w.Write([]byte(`{"login":"` + login + `"}`))
+		}
+	}))
+	defer ts.Close()
+	dir := t.TempDir()
+	accounts := dir + "/accounts.json"
+	os.WriteFile(accounts, []byte(`[{"accountname":"bot_a"},{"accountname":"Bot_B"}]`), 0o600)
+	t.Setenv("ACCOUNTS_PATH", accounts)
+	t.Setenv("TOKENS_PATH", dir+"/{account}.json")
+	t.Setenv("TWITCH_TOKEN_URL", ts.URL+"/token")
+	t.Setenv("TWITCH_VALIDATE_URL", ts.URL+"/validate")
+
+	w := httptest.NewRecorder()
+	Index(w, httptest.NewRequest("GET", "/", nil))
+	if body := w.Body.String(); !strings.Contains(body, "account=bot_a") || !strings.Contains(body, "account=bot_b") {
+		t.Fatalf("no account chooser: %q", body)
+	}
+
+	w = httptest.NewRecorder()
+	Index(w, httptest.NewRequest("GET", "/?account=nobody", nil))
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("unknown account: status = %d, want 404", w.Code)
+	}
+
+	authorize := func() string {
+		w := httptest.NewRecorder()
+		Index(w, httptest.NewRequest("GET", "/?account=bot_b", nil))
+		q := authLink(t, w.Body.String()).Query()
+		if q.Get("force_verify") != "true" {
+			t.Fatalf("force_verify not set: %v", q)
+		}
+		return q.Get("state")
+	}
+	callback := func(state string) int {
+		req := httptest.NewRequest("GET", "/callback?code=abc&state="+url.QueryEscape(state), nil)
+		req.AddCookie(&http.Cookie{Name: stateCookie, Value: state})
+		w := httptest.NewRecorder()
+		Callback(w, req)
+		return w.Code
+	}
+
+	if code := callback(authorize()); code != http.StatusOK {
+		t.Fatalf("callback status = %d, want 200", code)
+	}
+	if tok, err := LoadTokenJSON(dir + "/bot_b.json"); err != nil || tok.AccessToken != "at-b" {
+		t.Fatalf("bot_b token = %+v, %v", tok, err)
+	}
+	if _, err := os.Stat(dir + "/bot_a.json"); !os.IsNotExist(err) {
+		t.Fatalf("bot_a token written: %v", err)
+	}
+
+	login = "someone_else"
+	os.Remove(dir + "/bot_b.json")
+	if code := callback(authorize()); code != http.StatusConflict {
+		t.Fatalf("wrong user: status = %d, want 409", code)
+	}
+	if _, err := os.Stat(dir + "/bot_b.json"); !os.IsNotExist(err) {
+		t.Fatal("token for another user was saved")
+	}
+}
@@ -0,0 +1,369 @@
+package oauth
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"log/slog"
+
+	"github.com/Jamie-38/stream-pipeline/internal/healthcheck"
+	"github.com/Jamie-38/stream-pipeline/internal/observe"
+	"github.com/Jamie-38/stream-pipeline/internal/types"
+)
+
+// ErrInvalidToken means the validate endpoint rejected the access token.
+var ErrInvalidToken = errors.New("access token invalid")
+
+// ErrTokenRevoked means the token is gone for good: it was rejected and
+// could not be refreshed, or it was wiped from the store. Someone has to
+// authorize the account again.
+var ErrTokenRevoked = errors.New("token revoked; re-authorize the account via oauth_server")
+
+// errRefreshRejected means the token endpoint refused the refresh token
+// itself, as opposed to failing to answer.
+var errRefreshRejected = errors.New("refresh token rejected")
+
+type ManagerConfig struct {
+	Store        TokenStore // reloaded when the token is rejected, rewritten on refresh
+	ClientID     string
+	ClientSecret string
+	Endpoints    Endpoints     // empty URLs take TwitchEndpoints
+	ValidateEach time.Duration // Twitch asks apps to validate hourly
+	RefreshEarly time.Duration // refresh this long before expiry
+	RetryMin     time.Duration // first delay after a failed refresh
+	RetryMax     time.Duration
+	Client       *http.Client
+	HealthName   string // health component; "" means "oauth_token"
+}
+
+func NewDefaultManagerConfig() ManagerConfig {
+	return ManagerConfig{
+		Endpoints:    TwitchEndpoints(),
+		ValidateEach: time.Hour,
+		RefreshEarly: 10 * time.Minute,
+		RetryMin:     5 * time.Second,
+		RetryMax:     5 * time.Minute,
+		Client:       &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Manager owns the collector's OAuth token: it validates it, refreshes it
+// before expiry and persists every new token. Readers always get the
+// latest credentials from AccessToken.
+type Manager struct {
+	cfg    ManagerConfig
+	lg     *slog.Logger
+	health *healthcheck.Component
+
+	mu        sync.RWMutex
+	tok       types.Token
+	login     string
+	expiresAt time.Time // zero when unknown or non-expiring
+	revoked   bool
+}
+
+// NewManager loads the token from cfg.Store. Zero fields of cfg take the
+// defaults.
+func NewManager(cfg ManagerConfig) (*Manager, error) {
+	d := NewDefaultManagerConfig()
+	cfg.Endpoints = cfg.Endpoints.withDefaults(d.Endpoints)
+	if cfg.ValidateEach <= 0 {
+		cfg.ValidateEach = d.ValidateEach
+	}
+	if cfg.RefreshEarly <= 0 {
+		cfg.RefreshEarly = d.RefreshEarly
+	}
+	if cfg.RetryMin <= 0 {
+		cfg.RetryMin = d.RetryMin
+	}
+	if cfg.RetryMax < cfg.RetryMin {
+		cfg.RetryMax = max(d.RetryMax, cfg.RetryMin)
+	}
+	if cfg.Client == nil {
+		cfg.Client = d.Client
+	}
+	if cfg.Store == nil {
+		return nil, errors.New("token manager: no token store")
+	}
+	tok, err := cfg.Store.Load()
+	if err != nil {
+		return nil, err
+	}
+	return &Manager{
+		cfg:    cfg,
+		lg:     observe.C("token_manager"),
+		health: healthcheck.Default().Component(cmp.Or(cfg.HealthName, "oauth_token")),
+		tok:    tok,
+	}, nil
+}
+
+// AccessToken returns the current access token.
+func (m *Manager) AccessToken() string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.tok.AccessToken
+}
+
+// Login returns the login the token belongs to, once validated.
+func (m *Manager) Login() string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.login
+}
+
+// ExpiresAt reports when the current token expires; zero when unknown.
+func (m *Manager) ExpiresAt() time.Time {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.expiresAt
+}
+
+// Revoked reports whether the last upkeep found the token revoked.
+func (m *Manager) Revoked() bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.revoked
+}
+
+type validateResponse struct {
+	ClientID  string   `json:"client_id"`
+	Login     string   `json:"login"`
+	Scopes    []string `json:"scopes"`
+	UserID    string   `json:"user_id"`
+	ExpiresIn int      `json:"expires_in"`
+}
+
+// validateToken asks endpoint about accessToken. The login comes back
+// lowercased; a rejected token yields ErrInvalidToken.
+func validateToken(ctx context.Context, client *http.Client, endpoint, accessToken string) (validateResponse, error) {
+	var v validateResponse
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return v, err
+	}
+	req.Header.Set("Authorization", "OAuth "+accessToken)
+	resp, err := client.Do(req)
+	if err != nil {
+		return v, fmt.Errorf("validate: %w", err)
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized:
+		_, _ = io.Copy(io.Discard, resp.Body)
+		return v, ErrInvalidToken
+	case resp.StatusCode != http.StatusOK:
+		return v, fmt.Errorf("validate: status %d: %s", resp.StatusCode, errorMessage(resp.Body))
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&v); err != nil {
+		return v, fmt.Errorf("validate: decode: %w", err)
+	}
+	v.Login = strings.ToLower(v.Login)
+	return v, nil
+}
+
+// Validate checks the current token against the validate endpoint and
+// records its remaining lifetime. A rejected token yields ErrInvalidToken.
+func (m *Manager) Validate(ctx context.Context) error {
+	v, err := validateToken(ctx, m.cfg.Client, m.cfg.Endpoints.Validate, m.AccessToken())
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	m.login = v.Login
+	m.expiresAt = time.Time{}
+	if v.ExpiresIn > 0 {
+		m.expiresAt = time.Now().Add(time.Duration(v.ExpiresIn) * time.Second)
+	}
+	expiresAt := m.expiresAt
+	m.mu.Unlock()
+
+	m.lg.Info("token validated", "login", v.Login, "expires_at", expiresAt)
+	m.health.SetReady(true, "token valid")
+	return nil
+}
+
+// Refresh exchanges the refresh token for a new token and persists it.
+func (m *Manager) Refresh(ctx context.Context) error {
+	m.mu.RLock()
+	refresh := m.tok.RefreshToken
+	m.mu.RUnlock()
+	if refresh == "" {
+		return errors.New("refresh: token has no refresh_token")
+	}
+
+	data := url.Values{}
+	data.Set("client_id", m.cfg.ClientID)
+	data.Set("client_secret", m.cfg.ClientSecret)
+	data.Set("grant_type", "refresh_token")
+	data.Set("refresh_token", refresh)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, m.cfg.Endpoints.Token, strings.NewReader(data.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := m.cfg.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("refresh: %w", err)
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusBadRequest || resp.StatusCode == http.StatusUnauthorized:
+		return fmt.Errorf("refresh: status %d: %s: %w", resp.StatusCode, errorMessage(resp.Body), errRefreshRejected)
+	case resp.StatusCode != http.StatusOK:
+		return fmt.Errorf("refresh: status %d: %s", resp.StatusCode, errorMessage(resp.Body))
+	}
+	var tok types.Token
+	if err := json.NewDecoder(resp.Body).Decode(&tok); err != nil {
+		return fmt.Errorf("refresh: decode: %w", err)
+	}
+	if tok.AccessToken == "" {
+		return errors.New("refresh: response missing access_token")
+	}
+	if tok.RefreshToken == "" {
+		tok.RefreshToken = refresh
+	}
+	if err := m.cfg.Store.Save(tok); err != nil {
+		return fmt.Errorf("refresh: persist: %w", err)
+	}
+
+	m.mu.Lock()
+	m.tok = tok
+	m.expiresAt = time.Time{}
+	if tok.ExpiresIn > 0 {
+		m.expiresAt = time.Now().Add(time.Duration(tok.ExpiresIn) * time.Second)
+	}
+	expiresAt := m.expiresAt
+	m.mu.Unlock()
+
+	m.lg.Info("token refreshed", "expires_at", expiresAt)
+	m.health.SetReady(true, "token refreshed")
+	return nil
+}
+
+// Ensure validates the token and refreshes it if it was rejected or is
+// about to expire. A rejected token is first replaced by the stored one,
+// so a re-authorization through oauth_server is picked up without a
+// restart. Run it once before connecting.
+func (m *Manager) Ensure(ctx context.Context) error {
+	err := m.Validate(ctx)
+	if errors.Is(err, ErrInvalidToken) {
+		changed, rerr := m.reload()
+		if rerr != nil {
+			return m.fail(rerr)
+		}
+		if changed {
+			err = m.Validate(ctx)
+		}
+	}
+	if err != nil && !errors.Is(err, ErrInvalidToken) {
+		return m.fail(err)
+	}
+	if err == nil && !m.dueForRefresh(time.Now()) {
+		m.setRevoked(false)
+		return nil
+	}
+	if rerr := m.Refresh(ctx); rerr != nil {
+		if err != nil && errors.Is(rerr, errRefreshRejected) {
+			// neither the access token nor its refresh token is accepted
+			rerr = fmt.Errorf("%w: %w", ErrTokenRevoked, rerr)
+		}
+		return m.fail(rerr)
+	}
+	m.setRevoked(false)
+	return nil
+}
+
+// reload adopts the stored token when it differs from the one in use and
+// reports whether it did. A wiped store means the token was revoked.
+func (m *Manager) reload() (bool, error) {
+	tok, err := m.cfg.Store.Load()
+	if errors.Is(err, ErrNoToken) {
+		return false, fmt.Errorf("%w: %w", ErrTokenRevoked, err)
+	}
+	if err != nil {
+		return false, fmt.Errorf("reload token: %w", err)
+	}
+	m.mu.Lock()
+	changed := tok.AccessToken != m.tok.AccessToken
+	if changed {
+		m.tok = tok
+		m.expiresAt = time.Time{}
+	}
+	m.mu.Unlock()
+	if changed {
+		m.lg.Info("adopted token from store")
+	}
+	return changed, nil
+}
+
+// fail reports err through the health component and returns it.
+func (m *Manager) fail(err error) error {
+	if errors.Is(err, ErrTokenRevoked) {
+		if !m.Revoked() {
+			m.lg.Error("token revoked; re-authorize the account", "err", err)
+		}
+		m.setRevoked(true)
+		m.health.SetReady(false, ErrTokenRevoked.Error())
+		return err
+	}
+	m.health.SetReady(false, err.Error())
+	return err
+}
+
+func (m *Manager) setRevoked(v bool) {
+	m.mu.Lock()
+	m.revoked = v
+	m.mu.Unlock()
+}
+
+func (m *Manager) dueForRefresh(now time.Time) bool {
+	exp := m.ExpiresAt()
+	return !exp.IsZero() && !now.Before(exp.Add(-m.cfg.RefreshEarly))
+}
+
+// next reports how long to wait before the next validation or refresh.
+func (m *Manager) next(now time.Time) time.Duration {
+	wait := m.cfg.ValidateEach
+	if exp := m.ExpiresAt(); !exp.IsZero() {
+		wait = min(wait, exp.Add(-m.cfg.RefreshEarly).Sub(now))
+	}
+	return max(wait, 0)
+}
+
+// Run keeps the token fresh until ctx is canceled. Failures are retried
+// with backoff and reported through the manager's health component.
+func (m *Manager) Run(ctx context.Context) error {
+	backoff := m.cfg.RetryMin
+	timer := time.NewTimer(m.next(time.Now()))
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-timer.C:
+		}
+
+		if err := m.Ensure(ctx); err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			m.lg.Warn("token upkeep failed; retrying", "err", err, "retry_in_s", backoff.Seconds())
+			timer.Reset(backoff)
+			backoff = min(backoff*2, m.cfg.RetryMax)
+			continue
+		}
+		backoff = m.cfg.RetryMin
+		timer.Reset(m.next(time.Now()))
+	}
+}
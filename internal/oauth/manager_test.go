@@ -0,0 +1,129 @@
+package oauth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Jamie-38/stream-pipeline/internal/healthcheck"
+	"github.com/Jamie-38/stream-pipeline/internal/types"
+)
+
+// fakeTwitch accepts only the access token in valid; a successful refresh
+// issues "fresh" and makes it the valid one.
+type fakeTwitch struct {
+	valid       atomic.Value // string
+	expiresIn   int
+	refreshes   atomic.Int32
+	failRefresh bool
+}
+
+func (f *fakeTwitch) server(t *testing.T) *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /validate", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "OAuth "+f.valid.Load().(string) {
+			http.Error(w, `{"status":401}`, http.StatusUnauthorized)
+			return
+		}
+		writeJSONBody(w, `{"login":"Bot","expires_in":`+strconv.Itoa(f.expiresIn)+`}`)
+	})
+	mux.HandleFunc("POST /token", func(w http.ResponseWriter, r *http.Request) {
+		if f.failRefresh || r.FormValue("grant_type") != "refresh_token" || r.FormValue("refresh_token") != "r1" {
+			http.Error(w, "bad refresh", http.StatusBadRequest)
+			return
+		}
+		f.refreshes.Add(1)
+		f.valid.Store("fresh")
+		writeJSONBody(w, `{"access_token":"fresh","refresh_token":"r2","expires_in":14400}`)
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func writeJSONBody(w http.ResponseWriter, body string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(body))
+}
+
+func newTestManager(t *testing.T, f *fakeTwitch) (*Manager, string) {
+	t.Helper()
+	srv := f.server(t)
+	path := filepath.Join(t.TempDir(), "token.json")
+	if err := SaveTokenJSON(path, types.Token{AccessToken: "stale", RefreshToken: "r1"}); err != nil {
+		t.Fatal(err)
+	}
+	m, err := NewManager(ManagerConfig{
+		Store:     FileStore{Path: path},
+		Endpoints: Endpoints{Validate: srv.URL + "/validate", Token: srv.URL + "/token"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m, path
+}
+
+func TestManager_EnsureRefreshesRejectedToken(t *testing.T) {
+	f := &fakeTwitch{expiresIn: 3600}
+	f.valid.Store("fresh")
+	m, path := newTestManager(t, f)
+
+	if err := m.Validate(context.Background()); !errors.Is(err, ErrInvalidToken) {
+		t.Fatalf("Validate = %v, want ErrInvalidToken", err)
+	}
+	if err := m.Ensure(context.Background()); err != nil {
+		t.Fatalf("Ensure: %v", err)
+	}
+	if m.AccessToken() != "fresh" || f.refreshes.Load() != 1 {
+		t.Fatalf("token = %q after %d refreshes", m.AccessToken(), f.refreshes.Load())
+	}
+	onDisk, err := LoadTokenJSON(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if onDisk.AccessToken != "fresh" || onDisk.RefreshToken != "r2" {
+		t.Fatalf("persisted token = %+v", onDisk)
+	}
+}
+
+func TestManager_EnsureRefreshesNearExpiry(t *testing.T) {
+	f := &fakeTwitch{expiresIn: 60} // inside the default RefreshEarly
+	f.valid.Store("stale")
+	m, _ := newTestManager(t, f)
+
+	if err := m.Ensure(context.Background()); err != nil {
+		t.Fatalf("Ensure: %v", err)
+	}
+	if f.refreshes.Load() != 1 || m.AccessToken() != "fresh" {
+		t.Fatalf("expected a proactive refresh, got token %q", m.AccessToken())
+	}
+	if exp := m.ExpiresAt(); time.Until(exp) < 3*time.Hour {
+		t.Fatalf("expiry not taken from refresh: %v", exp)
+	}
+}
+
+func TestManager_RefreshFailureReportsHealth(t *testing.T) {
+	f := &fakeTwitch{failRefresh: true}
+	f.valid.Store("other")
+	m, path := newTestManager(t, f)
+
+	if err := m.Ensure(context.Background()); err == nil {
+		t.Fatal("expected refresh failure")
+	}
+	if m.AccessToken() != "stale" {
+		t.Fatalf("token replaced despite failure: %q", m.AccessToken())
+	}
+	if onDisk, _ := LoadTokenJSON(path); onDisk.AccessToken != "stale" {
+		t.Fatalf("token file rewritten despite failure: %+v", onDisk)
+	}
+	_, _, rep := healthcheck.Default().Check(time.Now())
+	if st := rep.Components["oauth_token"]; st.Ready {
+		t.Fatalf("health still ready after refresh failure: %+v", st)
+	}
+}
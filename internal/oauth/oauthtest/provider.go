@@ -0,0 +1,326 @@
+// Package oauthtest is an in-process fake of the Twitch identity service.
+// It issues, refreshes, validates and revokes tokens the way id.twitch.tv
+// does, so the OAuth flow can be tested without the network.
+package oauthtest
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Provider is a running fake identity service. Its zero value is not
+// usable; call NewProvider.
+type Provider struct {
+	ClientID     string
+	ClientSecret string
+	// TTL is the lifetime of issued access tokens.
+	TTL time.Duration
+
+	srv *httptest.Server
+
+	mu       sync.Mutex
+	login    string // user the authorize endpoint signs in as
+	deny     bool
+	codes    map[string]grant
+	access   map[string]*token
+	refresh  map[string]string // refresh token -> access token
+	tokenErr []failure         // queued token endpoint failures
+	now      func() time.Time
+}
+
+type grant struct {
+	login       string
+	scopes      []string
+	redirectURI string
+	challenge   string
+}
+
+type token struct {
+	access  string
+	login   string
+	scopes  []string
+	expires time.Time
+	refresh string
+}
+
+type failure struct {
+	status  int
+	message string
+}
+
+// NewProvider starts a provider for the given client credentials that
+// signs users in as login.
+func NewProvider(clientID, clientSecret, login string) *Provider {
+	p := &Provider{
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		TTL:          4 * time.Hour,
+		login:        strings.ToLower(login),
+		codes:        make(map[string]grant),
+		access:       make(map[string]*token),
+		refresh:      make(map[string]string),
+		now:          time.Now,
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /oauth2/authorize", p.authorize)
+	mux.HandleFunc("POST /oauth2/token", p.token)
+	mux.HandleFunc("GET /oauth2/validate", p.validate)
+	mux.HandleFunc("POST /oauth2/revoke", p.revoke)
+	p.srv = httptest.NewServer(mux)
+	return p
+}
+
+// Close shuts the provider down.
+func (p *Provider) Close() { p.srv.Close() }
+
+// URL is the provider's base URL.
+func (p *Provider) URL() string { return p.srv.URL }
+
+func (p *Provider) AuthorizeURL() string { return p.srv.URL + "/oauth2/authorize" }
+func (p *Provider) TokenURL() string     { return p.srv.URL + "/oauth2/token" }
+func (p *Provider) ValidateURL() string  { return p.srv.URL + "/oauth2/validate" }
+func (p *Provider) RevokeURL() string    { return p.srv.URL + "/oauth2/revoke" }
+
+// Client returns an HTTP client for the provider.
+func (p *Provider) Client() *http.Client { return p.srv.Client() }
+
+// SignInAs changes the user later authorizations sign in as.
+func (p *Provider) SignInAs(login string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.login = strings.ToLower(login)
+}
+
+// Deny makes the authorize endpoint answer with access_denied, as when the
+// user declines the consent screen.
+func (p *Provider) Deny(deny bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.deny = deny
+}
+
+// FailToken makes the next token request fail with status and a Twitch
+// error body carrying message. Calls queue up.
+func (p *Provider) FailToken(status int, message string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.tokenErr = append(p.tokenErr, failure{status, message})
+}
+
+// Issue mints a token pair for login directly, skipping the code flow.
+func (p *Provider) Issue(login string, scopes ...string) (access, refresh string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	t := p.issueLocked(strings.ToLower(login), scopes)
+	return t.access, t.refresh
+}
+
+// Expire makes an access token invalid as if its lifetime had run out; its
+// refresh token keeps working.
+func (p *Provider) Expire(access string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if t, ok := p.access[access]; ok {
+		t.expires = p.now().Add(-time.Second)
+	}
+}
+
+// Valid reports whether access would pass validation now.
+func (p *Provider) Valid(access string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	t, ok := p.access[access]
+	return ok && p.now().Before(t.expires)
+}
+
+func (p *Provider) issueLocked(login string, scopes []string) *token {
+	t := &token{access: randomToken(), login: login, scopes: scopes, expires: p.now().Add(p.TTL), refresh: randomToken()}
+	p.access[t.access] = t
+	p.refresh[t.refresh] = t.access
+	return t
+}
+
+func (p *Provider) authorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if q.Get("client_id") != p.ClientID {
+		writeError(w, http.StatusBadRequest, "invalid client")
+		return
+	}
+	if q.Get("response_type") != "code" {
+		writeError(w, http.StatusBadRequest, "unsupported response_type")
+		return
+	}
+	if m := q.Get("code_challenge_method"); q.Get("code_challenge") != "" && m != "S256" {
+		writeError(w, http.StatusBadRequest, "unsupported code_challenge_method")
+		return
+	}
+	redirect, err := url.Parse(q.Get("redirect_uri"))
+	if err != nil || redirect.Scheme == "" {
+		writeError(w, http.StatusBadRequest, "invalid redirect_uri")
+		return
+	}
+
+	back := redirect.Query()
+	back.Set("state", q.Get("state"))
+	p.mu.Lock()
+	if p.deny {
+		back.Set("error", "access_denied")
+		back.Set("error_description", "The user denied you access")
+	} else {
+		code := randomToken()
+		p.codes[code] = grant{
+			login:       p.login,
+			scopes:      strings.Fields(q.Get("scope")),
+			redirectURI: q.Get("redirect_uri"),
+			challenge:   q.Get("code_challenge"),
+		}
+		back.Set("code", code)
+		back.Set("scope", q.Get("scope"))
+	}
+	p.mu.Unlock()
+	redirect.RawQuery = back.Encode()
+	http.Redirect(w, r, redirect.String(), http.StatusFound)
+}
+
+type tokenResponse struct {
+	AccessToken  string   `json:"access_token"`
+	RefreshToken string   `json:"refresh_token"`
+	ExpiresIn    int      `json:"expires_in"`
+	Scope        []string `json:"scope"`
+	TokenType    string   `json:"token_type"`
+}
+
+func (p *Provider) token(w http.ResponseWriter, r *http.Request) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.tokenErr) > 0 {
+		f := p.tokenErr[0]
+		p.tokenErr = p.tokenErr[1:]
+		writeError(w, f.status, f.message)
+		return
+	}
+	if r.FormValue("client_id") != p.ClientID || r.FormValue("client_secret") != p.ClientSecret {
+		writeError(w, http.StatusForbidden, "invalid client secret")
+		return
+	}
+
+	var t *token
+	switch r.FormValue("grant_type") {
+	case "authorization_code":
+		code := r.FormValue("code")
+		g, ok := p.codes[code]
+		if !ok {
+			writeError(w, http.StatusBadRequest, "Invalid authorization code")
+			return
+		}
+		delete(p.codes, code) // codes are single use
+		if r.FormValue("redirect_uri") != g.redirectURI {
+			writeError(w, http.StatusBadRequest, "Parameter redirect_uri does not match registered URI")
+			return
+		}
+		if g.challenge != "" {
+			sum := sha256.Sum256([]byte(r.FormValue("code_verifier")))
+			if base64.RawURLEncoding.EncodeToString(sum[:]) != g.challenge {
+				writeError(w, http.StatusBadRequest, "Invalid code verifier")
+				return
+			}
+		}
+		t = p.issueLocked(g.login, g.scopes)
+
+	case "refresh_token":
+		old, ok := p.refresh[r.FormValue("refresh_token")]
+		if !ok {
+			writeError(w, http.StatusBadRequest, "Invalid refresh token")
+			return
+		}
+		prev := p.access[old]
+		delete(p.refresh, prev.refresh)
+		delete(p.access, old)
+		t = p.issueLocked(prev.login, prev.scopes)
+
+	default:
+		writeError(w, http.StatusBadRequest, "unsupported grant_type")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, tokenResponse{
+		AccessToken:  t.access,
+		RefreshToken: t.refresh,
+		ExpiresIn:    int(p.TTL.Seconds()),
+		Scope:        t.scopes,
+		TokenType:    "bearer",
+	})
+}
+
+type validateResponse struct {
+	ClientID  string   `json:"client_id"`
+	Login     string   `json:"login"`
+	Scopes    []string `json:"scopes"`
+	UserID    string   `json:"user_id"`
+	ExpiresIn int      `json:"expires_in"`
+}
+
+func (p *Provider) validate(w http.ResponseWriter, r *http.Request) {
+	a, ok := strings.CutPrefix(r.Header.Get("Authorization"), "OAuth ")
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	t, known := p.access[a]
+	if !ok || !known || !p.now().Before(t.expires) {
+		writeError(w, http.StatusUnauthorized, "invalid access token")
+		return
+	}
+	sum := sha256.Sum256([]byte(t.login))
+	writeJSON(w, http.StatusOK, validateResponse{
+		ClientID:  p.ClientID,
+		Login:     t.login,
+		Scopes:    t.scopes,
+		UserID:    hex.EncodeToString(sum[:4]),
+		ExpiresIn: int(t.expires.Sub(p.now()).Seconds()),
+	})
+}
+
+// revoke invalidates an access token together with its refresh token.
+func (p *Provider) revoke(w http.ResponseWriter, r *http.Request) {
+	if r.FormValue("client_id") != p.ClientID {
+		writeError(w, http.StatusNotFound, "client does not exist")
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	a := r.FormValue("token")
+	t, ok := p.access[a]
+	if !ok {
+		writeError(w, http.StatusBadRequest, "Invalid token")
+		return
+	}
+	delete(p.access, a)
+	delete(p.refresh, t.refresh)
+	w.WriteHeader(http.StatusOK)
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]any{"status": status, "message": message})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func randomToken() string {
+	b := make([]byte, 15)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,163 @@
+package oauth
+
+import (
+	"container/list"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	errStateInvalid = errors.New("invalid state")
+	errStateExpired = errors.New("state expired")
+	errStateUnknown = errors.New("state unknown or already used")
+)
+
+// maxPendingStates bounds the authorizations kept at once. Issuing a state
+// is unauthenticated, so past the bound the oldest is forgotten.
+const maxPendingStates = 10000
+
+// stateStore issues signed, expiring, single-use state values and keeps
+// the PKCE verifier and account of each pending authorization. Every state
+// lives for ttl, so issue order is expiry order: a timer drops expired
+// entries from the front and the cap evicts from the front too.
+type stateStore struct {
+	mu       sync.Mutex
+	key      []byte
+	ttl      time.Duration
+	max      int
+	order    *list.List               // of *pendingAuth, oldest first
+	pending  map[string]*list.Element // by nonce
+	sweeping bool                     // a sweep timer is armed
+}
+
+type pendingAuth struct {
+	nonce    string
+	verifier string
+	account  string // whose token the callback stores; "" without ACCOUNTS_PATH
+	expires  time.Time
+}
+
+func newStateStore(key []byte, ttl time.Duration) *stateStore {
+	return &stateStore{
+		key:     key,
+		ttl:     ttl,
+		max:     maxPendingStates,
+		order:   list.New(),
+		pending: make(map[string]*list.Element),
+	}
+}
+
+// sessions signs with OAUTH_STATE_SECRET, or a per-process key when unset.
+var sessions = newStateStore(stateKeyFromEnv(), 10*time.Minute)
+
+func stateKeyFromEnv() []byte {
+	if s := os.Getenv("OAUTH_STATE_SECRET"); s != "" {
+		return []byte(s)
+	}
+	return randomBytes(32)
+}
+
+func randomBytes(n int) []byte {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		panic("oauth: crypto/rand: " + err.Error())
+	}
+	return b
+}
+
+func (s *stateStore) sign(payload string) string {
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// issue returns a new state ("nonce.expiry.signature") for account and its
+// PKCE verifier.
+func (s *stateStore) issue(now time.Time, account string) (state, verifier string) {
+	nonce := base64.RawURLEncoding.EncodeToString(randomBytes(16))
+	expires := now.Add(s.ttl)
+	payload := nonce + "." + strconv.FormatInt(expires.Unix(), 10)
+	verifier = base64.RawURLEncoding.EncodeToString(randomBytes(32))
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for s.order.Len() >= s.max {
+		s.remove(s.order.Front())
+	}
+	p := &pendingAuth{nonce: nonce, verifier: verifier, account: account, expires: expires}
+	s.pending[nonce] = s.order.PushBack(p)
+	s.armSweep(now)
+	return payload + "." + s.sign(payload), verifier
+}
+
+// armSweep schedules sweep for when the oldest entry expires, unless one
+// is already due. Callers hold s.mu.
+func (s *stateStore) armSweep(now time.Time) {
+	if s.sweeping || s.order.Len() == 0 {
+		return
+	}
+	s.sweeping = true
+	oldest := s.order.Front().Value.(*pendingAuth)
+	time.AfterFunc(oldest.expires.Sub(now), s.sweep)
+}
+
+// sweep drops expired entries and re-arms for the next one.
+func (s *stateStore) sweep() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sweeping = false
+	now := time.Now()
+	for e := s.order.Front(); e != nil && !now.Before(e.Value.(*pendingAuth).expires); e = s.order.Front() {
+		s.remove(e)
+	}
+	s.armSweep(now)
+}
+
+// remove forgets e. Callers hold s.mu.
+func (s *stateStore) remove(e *list.Element) {
+	delete(s.pending, s.order.Remove(e).(*pendingAuth).nonce)
+}
+
+// consume verifies state and returns the authorization it was issued for.
+// A state is accepted once.
+func (s *stateStore) consume(state string, now time.Time) (pendingAuth, error) {
+	parts := strings.Split(state, ".")
+	if len(parts) != 3 {
+		return pendingAuth{}, errStateInvalid
+	}
+	payload := parts[0] + "." + parts[1]
+	if !hmac.Equal([]byte(parts[2]), []byte(s.sign(payload))) {
+		return pendingAuth{}, errStateInvalid
+	}
+	exp, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return pendingAuth{}, errStateInvalid
+	}
+	if now.After(time.Unix(exp, 0)) {
+		return pendingAuth{}, errStateExpired
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.pending[parts[0]]
+	if !ok {
+		return pendingAuth{}, errStateUnknown
+	}
+	p := *e.Value.(*pendingAuth)
+	s.remove(e)
+	return p, nil
+}
+
+// pkceChallenge is the S256 code challenge for verifier.
+func pkceChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
@@ -0,0 +1,101 @@
+package oauth
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStateRoundTrip(t *testing.T) {
+	s := newStateStore([]byte("k"), time.Minute)
+	now := time.Now()
+	state, verifier := s.issue(now, "bot_a")
+
+	got, err := s.consume(state, now.Add(30*time.Second))
+	if err != nil || got.verifier != verifier || got.account != "bot_a" {
+		t.Fatalf("consume = %+v, %v; want verifier %q for bot_a", got, err, verifier)
+	}
+	if _, err := s.consume(state, now); !errors.Is(err, errStateUnknown) {
+		t.Fatalf("second consume err = %v, want errStateUnknown", err)
+	}
+}
+
+func TestStateRejects(t *testing.T) {
+	s := newStateStore([]byte("k"), time.Minute)
+	now := time.Now()
+
+	state, _ := s.issue(now, "")
+	if _, err := s.consume(state, now.Add(2*time.Minute)); !errors.Is(err, errStateExpired) {
+		t.Fatalf("expired err = %v", err)
+	}
+
+	state, _ = s.issue(now, "")
+	parts := strings.Split(state, ".")
+	tampered := parts[0] + ".9999999999." + parts[2]
+	if _, err := s.consume(tampered, now); !errors.Is(err, errStateInvalid) {
+		t.Fatalf("tampered err = %v", err)
+	}
+
+	other := newStateStore([]byte("other"), time.Minute)
+	if _, err := other.consume(state, now); !errors.Is(err, errStateInvalid) {
+		t.Fatalf("foreign key err = %v", err)
+	}
+	if _, err := s.consume("garbage", now); !errors.Is(err, errStateInvalid) {
+		t.Fatalf("garbage err = %v", err)
+	}
+}
+
+func TestStatePendingIsBounded(t *testing.T) {
+	s := newStateStore([]byte("k"), time.Minute)
+	s.max = 3
+	now := time.Now()
+
+	first, _ := s.issue(now, "")
+	for i := 0; i < 5; i++ {
+		s.issue(now, "")
+	}
+	s.mu.Lock()
+	n := len(s.pending)
+	s.mu.Unlock()
+	if n != 3 {
+		t.Fatalf("pending = %d past the cap, want 3", n)
+	}
+	if _, err := s.consume(first, now); !errors.Is(err, errStateUnknown) {
+		t.Fatalf("oldest state after eviction err = %v, want errStateUnknown", err)
+	}
+}
+
+func TestStateExpiredEntriesAreSwept(t *testing.T) {
+	s := newStateStore([]byte("k"), 20*time.Millisecond)
+	s.issue(time.Now(), "")
+	s.issue(time.Now(), "")
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		s.mu.Lock()
+		n := len(s.pending)
+		s.mu.Unlock()
+		if n == 0 {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d expired states never swept", n)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestPKCEChallenge(t *testing.T) {
+	_, verifier := newStateStore([]byte("k"), time.Minute).issue(time.Now(), "")
+	if len(verifier) < 43 || len(verifier) > 128 {
+		t.Fatalf("verifier length %d outside RFC 7636 bounds", len(verifier))
+	}
+	c := pkceChallenge(verifier)
+	if len(c) != 43 || strings.ContainsAny(c, "+/=") {
+		t.Fatalf("challenge %q is not unpadded base64url SHA-256", c)
+	}
+	if c == pkceChallenge(verifier+"x") {
+		t.Fatal("challenge does not depend on verifier")
+	}
+}
@@ -0,0 +1,322 @@
+package oauth
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/Jamie-38/stream-pipeline/internal/observe"
+	"github.com/Jamie-38/stream-pipeline/internal/types"
+)
+
+// TokenStore persists the OAuth token. Save must replace the stored token
+// atomically. Delete wipes it; Load then fails with ErrNoToken.
+type TokenStore interface {
+	Load() (types.Token, error)
+	Save(types.Token) error
+	Delete() error
+}
+
+// ErrNoToken means the store holds no token yet.
+var ErrNoToken = errors.New("no token stored")
+
+// FileStore keeps the token as plaintext JSON, written atomically with 0600
+// permissions.
+type FileStore struct {
+	Path string
+}
+
+func (s FileStore) Load() (types.Token, error) {
+	b, err := readTokenFile(s.Path)
+	if err != nil {
+		return types.Token{}, err
+	}
+	if isSealed(b) {
+		return types.Token{}, fmt.Errorf("token file %q is encrypted; set TOKEN_ENCRYPTION_KEY or TOKEN_KEY_FILE", s.Path)
+	}
+	// files written before the store existed may be group/world readable
+	if fi, err := os.Stat(s.Path); err == nil && fi.Mode().Perm()&0o077 != 0 {
+		if err := os.Chmod(s.Path, 0o600); err == nil {
+			observe.C("oauth").Info("tightened token file permissions", "path", s.Path, "was", fi.Mode().Perm().String())
+		}
+	}
+	return decodeToken(s.Path, b)
+}
+
+func (s FileStore) Save(tok types.Token) error {
+	b, err := json.Marshal(tok)
+	if err != nil {
+		return fmt.Errorf("encode token: %w", err)
+	}
+	return writeFileAtomic(s.Path, b)
+}
+
+func (s FileStore) Delete() error { return removeTokenFile(s.Path) }
+
+// EncryptedFileStore keeps the token sealed with AES-256-GCM. Loading a
+// plaintext token file encrypts it in place.
+type EncryptedFileStore struct {
+	path string
+	aead cipher.AEAD
+}
+
+// tokenAAD binds ciphertexts to this use, so a sealed blob from elsewhere
+// with the same key does not decrypt as a token.
+var tokenAAD = []byte("stream-pipeline/token/v1")
+
+// sealedToken is the on-disk form of an encrypted token.
+type sealedToken struct {
+	Version    int    `json:"version"`
+	Nonce      string `json:"nonce"`
+	Ciphertext string `json:"ciphertext"`
+}
+
+// NewEncryptedFileStore seals the token at path with key, which must be 32
+// bytes.
+func NewEncryptedFileStore(path string, key []byte) (*EncryptedFileStore, error) {
+	if len(key) != 32 {
+		return nil, fmt.Errorf("token key must be 32 bytes, got %d", len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &EncryptedFileStore{path: path, aead: aead}, nil
+}
+
+func (s *EncryptedFileStore) Load() (types.Token, error) {
+	b, err := readTokenFile(s.path)
+	if err != nil {
+		return types.Token{}, err
+	}
+	if !isSealed(b) {
+		return s.migrate(b)
+	}
+	var env sealedToken
+	if err := json.Unmarshal(b, &env); err != nil {
+		return types.Token{}, fmt.Errorf("decode sealed token %q: %w", s.path, err)
+	}
+	if env.Version != 1 {
+		return types.Token{}, fmt.Errorf("token file %q: unsupported version %d", s.path, env.Version)
+	}
+	nonce, err := base64.StdEncoding.DecodeString(env.Nonce)
+	if err != nil || len(nonce) != s.aead.NonceSize() {
+		return types.Token{}, fmt.Errorf("token file %q: bad nonce", s.path)
+	}
+	ct, err := base64.StdEncoding.DecodeString(env.Ciphertext)
+	if err != nil {
+		return types.Token{}, fmt.Errorf("token file %q: bad ciphertext: %w", s.path, err)
+	}
+	plain, err := s.aead.Open(nil, nonce, ct, tokenAAD)
+	if err != nil {
+		return types.Token{}, fmt.Errorf("token file %q: decrypt failed (wrong key?)", s.path)
+	}
+	return decodeToken(s.path, plain)
+}
+
+// migrate encrypts a plaintext token file in place.
+func (s *EncryptedFileStore) migrate(plain []byte) (types.Token, error) {
+	tok, err := decodeToken(s.path, plain)
+	if err != nil {
+		return tok, err
+	}
+	if err := s.Save(tok); err != nil {
+		return tok, fmt.Errorf("encrypt plaintext token %q: %w", s.path, err)
+	}
+	observe.C("oauth").Info("encrypted plaintext token file", "path", s.path)
+	return tok, nil
+}
+
+func (s *EncryptedFileStore) Save(tok types.Token) error {
+	plain, err := json.Marshal(tok)
+	if err != nil {
+		return fmt.Errorf("encode token: %w", err)
+	}
+	nonce := randomBytes(s.aead.NonceSize())
+	b, err := json.Marshal(sealedToken{
+		Version:    1,
+		Nonce:      base64.StdEncoding.EncodeToString(nonce),
+		Ciphertext: base64.StdEncoding.EncodeToString(s.aead.Seal(nil, nonce, plain, tokenAAD)),
+	})
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(s.path, b)
+}
+
+func (s *EncryptedFileStore) Delete() error { return removeTokenFile(s.path) }
+
+// MemoryStore keeps the token in memory; for tests.
+type MemoryStore struct {
+	mu  sync.Mutex
+	tok types.Token
+	ok  bool
+}
+
+// NewMemoryStore returns a store holding tok, or an empty one when tok has
+// no access token.
+func NewMemoryStore(tok types.Token) *MemoryStore {
+	return &MemoryStore{tok: tok, ok: tok.AccessToken != ""}
+}
+
+func (s *MemoryStore) Load() (types.Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.ok {
+		return types.Token{}, ErrNoToken
+	}
+	return s.tok, nil
+}
+
+func (s *MemoryStore) Save(tok types.Token) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tok, s.ok = tok, true
+	return nil
+}
+
+func (s *MemoryStore) Delete() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tok, s.ok = types.Token{}, false
+	return nil
+}
+
+// TokenKeyFromEnv reads the token encryption key from TOKEN_ENCRYPTION_KEY
+// (base64) or from the file named by TOKEN_KEY_FILE (base64 text or 32 raw
+// bytes). It returns nil when neither is set.
+func TokenKeyFromEnv() ([]byte, error) {
+	if v := strings.TrimSpace(os.Getenv("TOKEN_ENCRYPTION_KEY")); v != "" {
+		k, err := base64.StdEncoding.DecodeString(v)
+		if err != nil {
+			return nil, fmt.Errorf("TOKEN_ENCRYPTION_KEY: %w", err)
+		}
+		return k, nil
+	}
+	path := os.Getenv("TOKEN_KEY_FILE")
+	if path == "" {
+		return nil, nil
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("TOKEN_KEY_FILE: %w", err)
+	}
+	if len(b) == 32 {
+		return b, nil
+	}
+	k, err := base64.StdEncoding.DecodeString(string(bytes.TrimSpace(b)))
+	if err != nil {
+		return nil, fmt.Errorf("TOKEN_KEY_FILE %q: not 32 raw bytes or base64: %w", path, err)
+	}
+	return k, nil
+}
+
+// TokenStoreFromEnv returns an encrypted store for path when a key is
+// configured, and a plaintext FileStore otherwise.
+func TokenStoreFromEnv(path string) (TokenStore, error) {
+	if path == "" {
+		return nil, errors.New("TOKENS_PATH is not set")
+	}
+	key, err := TokenKeyFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	if key == nil {
+		return FileStore{Path: path}, nil
+	}
+	return NewEncryptedFileStore(path, key)
+}
+
+func isSealed(b []byte) bool {
+	var probe struct {
+		Ciphertext *string `json:"ciphertext"`
+	}
+	return json.Unmarshal(b, &probe) == nil && probe.Ciphertext != nil
+}
+
+// readTokenFile reads path, reporting a missing file as ErrNoToken.
+func readTokenFile(path string) ([]byte, error) {
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("token file %q: %w", path, ErrNoToken)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("open token file %q: %w", path, err)
+	}
+	return b, nil
+}
+
+// removeTokenFile deletes path; a file that is already gone is not an error.
+func removeTokenFile(path string) error {
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("remove token file %q: %w", path, err)
+	}
+	return nil
+}
+
+func decodeToken(path string, b []byte) (types.Token, error) {
+	var tok types.Token
+	if err := json.Unmarshal(b, &tok); err != nil {
+		return tok, fmt.Errorf("decode token json %q: %w", path, err)
+	}
+	if tok.AccessToken == "" {
+		return tok, fmt.Errorf("token %q missing access_token", path)
+	}
+	return tok, nil
+}
+
+// writeFileAtomic replaces path with b: it writes a uniquely named 0600
+// temporary file next to it, syncs it, renames it over the original and
+// syncs the directory. oauth_server and the collector write the same token
+// file, so each writer needs its own temporary file.
+func writeFileAtomic(path string, b []byte) error {
+	dir := filepath.Dir(path)
+	f, err := os.CreateTemp(dir, "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("create tmp: %w", err)
+	}
+	tmp := f.Name()
+	fail := func(step string, err error) error {
+		_ = f.Close()
+		_ = os.Remove(tmp)
+		return fmt.Errorf("%s: %w", step, err)
+	}
+	if err := f.Chmod(0o600); err != nil {
+		return fail("chmod tmp", err)
+	}
+	if _, err := f.Write(b); err != nil {
+		return fail("write tmp", err)
+	}
+	if err := f.Sync(); err != nil {
+		return fail("fsync tmp", err)
+	}
+	if err := f.Close(); err != nil {
+		_ = os.Remove(tmp)
+		return fmt.Errorf("close tmp: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		_ = os.Remove(tmp)
+		return fmt.Errorf("rename tmp→final: %w", err)
+	}
+	d, err := os.Open(dir)
+	if err != nil {
+		return fmt.Errorf("open dir: %w", err)
+	}
+	defer d.Close()
+	if err := d.Sync(); err != nil {
+		return fmt.Errorf("fsync dir: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,177 @@
+package oauth
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/Jamie-38/stream-pipeline/internal/types"
+)
+
+func TestFileStoreRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "token.json")
+	s := FileStore{Path: path}
+	want := types.Token{AccessToken: "a", RefreshToken: "r", ExpiresIn: 60}
+	if err := s.Save(want); err != nil {
+		t.Fatal(err)
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0o600 {
+		t.Fatalf("mode = %v, want 0600", fi.Mode().Perm())
+	}
+	got, err := s.Load()
+	if err != nil || got.AccessToken != want.AccessToken || got.RefreshToken != want.RefreshToken {
+		t.Fatalf("Load = %+v, %v", got, err)
+	}
+}
+
+func TestFileStoreTightensPermissions(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "token.json")
+	if err := os.WriteFile(path, []byte(`{"access_token":"a"}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	os.Chmod(path, 0o644)
+	if _, err := (FileStore{Path: path}).Load(); err != nil {
+		t.Fatal(err)
+	}
+	if fi, _ := os.Stat(path); fi.Mode().Perm() != 0o600 {
+		t.Fatalf("mode = %v, want 0600", fi.Mode().Perm())
+	}
+}
+
+func TestFileStoreConcurrentSavesStayIntactAndPrivate(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "token.json")
+	// a stale, world-readable temp file from the old fixed-name scheme
+	if err := os.WriteFile(path+".tmp", bytes.Repeat([]byte("x"), 4096), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	s := FileStore{Path: path}
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			tok := types.Token{AccessToken: strings.Repeat(strconv.Itoa(i), 1+i*100), RefreshToken: "r"}
+			if err := s.Save(tok); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	got, err := s.Load()
+	if err != nil || got.RefreshToken != "r" {
+		t.Fatalf("token after concurrent saves = %+v, %v", got, err)
+	}
+	if fi, _ := os.Stat(path); fi.Mode().Perm() != 0o600 {
+		t.Fatalf("mode = %v, want 0600", fi.Mode().Perm())
+	}
+	left, _ := filepath.Glob(filepath.Join(dir, ".token.json.*.tmp"))
+	if len(left) != 0 {
+		t.Fatalf("temporary files left behind: %v", left)
+	}
+}
+
+func TestEncryptedFileStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "token.json")
+	key := bytes.Repeat([]byte{7}, 32)
+	s, err := NewEncryptedFileStore(path, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Save(types.Token{AccessToken: "secret-access", RefreshToken: "secret-refresh"}); err != nil {
+		t.Fatal(err)
+	}
+	raw, _ := os.ReadFile(path)
+	if bytes.Contains(raw, []byte("secret")) {
+		t.Fatalf("token stored in the clear: %s", raw)
+	}
+	got, err := s.Load()
+	if err != nil || got.AccessToken != "secret-access" {
+		t.Fatalf("Load = %+v, %v", got, err)
+	}
+
+	wrong, _ := NewEncryptedFileStore(path, bytes.Repeat([]byte{8}, 32))
+	if _, err := wrong.Load(); err == nil {
+		t.Fatal("Load with wrong key succeeded")
+	}
+	if _, err := (FileStore{Path: path}).Load(); err == nil {
+		t.Fatal("plaintext store read an encrypted file")
+	}
+}
+
+func TestEncryptedFileStoreMigratesPlaintext(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "token.json")
+	if err := SaveTokenJSON(path, types.Token{AccessToken: "old", RefreshToken: "r"}); err != nil {
+		t.Fatal(err)
+	}
+	s, err := NewEncryptedFileStore(path, bytes.Repeat([]byte{1}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := s.Load()
+	if err != nil || got.AccessToken != "old" {
+		t.Fatalf("Load = %+v, %v", got, err)
+	}
+	if raw, _ := os.ReadFile(path); !isSealed(raw) {
+		t.Fatalf("file not encrypted after migration: %s", raw)
+	}
+	if got, err := s.Load(); err != nil || got.RefreshToken != "r" {
+		t.Fatalf("reload = %+v, %v", got, err)
+	}
+}
+
+func TestTokenStoreFromEnv(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "token.json")
+	t.Setenv("TOKEN_ENCRYPTION_KEY", "")
+	t.Setenv("TOKEN_KEY_FILE", "")
+	if s, err := TokenStoreFromEnv(path); err != nil {
+		t.Fatal(err)
+	} else if _, ok := s.(FileStore); !ok {
+		t.Fatalf("store = %T, want FileStore", s)
+	}
+
+	t.Setenv("TOKEN_ENCRYPTION_KEY", base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{2}, 32)))
+	if s, err := TokenStoreFromEnv(path); err != nil {
+		t.Fatal(err)
+	} else if _, ok := s.(*EncryptedFileStore); !ok {
+		t.Fatalf("store = %T, want *EncryptedFileStore", s)
+	}
+
+	t.Setenv("TOKEN_ENCRYPTION_KEY", base64.StdEncoding.EncodeToString([]byte("short")))
+	if _, err := TokenStoreFromEnv(path); err == nil {
+		t.Fatal("short key accepted")
+	}
+
+	t.Setenv("TOKEN_ENCRYPTION_KEY", "")
+	keyFile := filepath.Join(t.TempDir(), "key")
+	os.WriteFile(keyFile, bytes.Repeat([]byte{3}, 32), 0o600)
+	t.Setenv("TOKEN_KEY_FILE", keyFile)
+	if s, err := TokenStoreFromEnv(path); err != nil {
+		t.Fatal(err)
+	} else if _, ok := s.(*EncryptedFileStore); !ok {
+		t.Fatalf("store = %T, want *EncryptedFileStore", s)
+	}
+}
+
+func TestMemoryStore(t *testing.T) {
+	s := NewMemoryStore(types.Token{})
+	if _, err := s.Load(); !errors.Is(err, ErrNoToken) {
+		t.Fatalf("empty Load err = %v", err)
+	}
+	s.Save(types.Token{AccessToken: "x"})
+	if tok, err := s.Load(); err != nil || tok.AccessToken != "x" {
+		t.Fatalf("Load = %+v, %v", tok, err)
+	}
+}
@@ -1,28 +1,15 @@
 package oauth
 
 import (
-	"encoding/json"
-	"fmt"
-	"os"
-
 	"github.com/Jamie-38/stream-pipeline/internal/types"
 )
 
+// LoadTokenJSON reads a plaintext token file; see FileStore.
 func LoadTokenJSON(path string) (types.Token, error) {
-	var tok types.Token
-
-	f, err := os.Open(path)
-	if err != nil {
-		return tok, fmt.Errorf("open token file %q: %w", path, err)
-	}
-	defer f.Close()
-
-	if err := json.NewDecoder(f).Decode(&tok); err != nil {
-		return tok, fmt.Errorf("decode token json %q: %w", path, err)
-	}
+	return FileStore{Path: path}.Load()
+}
 
-	if tok.AccessToken == "" {
-		return tok, fmt.Errorf("token %q missing access_token", path)
-	}
-	return tok, nil
+// SaveTokenJSON replaces path with tok atomically; see FileStore.
+func SaveTokenJSON(path string, tok types.Token) error {
+	return FileStore{Path: path}.Save(tok)
 }
@@ -1,28 +1,63 @@
 package observe
 
 import (
+	"context"
+	"fmt"
 	"log/slog"
 	"os"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
+// defaultLevel applies to L() and to every component without its own level.
+var defaultLevel slog.LevelVar
+
+// componentLevel is the level of one component. Until set it follows
+// defaultLevel.
+type componentLevel struct {
+	own      slog.LevelVar
+	explicit atomic.Bool
+}
+
+func (c *componentLevel) Level() slog.Level {
+	if c.explicit.Load() {
+		return c.own.Level()
+	}
+	return defaultLevel.Level()
+}
+
+var (
+	levelsMu sync.Mutex
+	levels   = make(map[string]*componentLevel)
+)
+
+func levelFor(component string) *componentLevel {
+	levelsMu.Lock()
+	defer levelsMu.Unlock()
+	cl, ok := levels[component]
+	if !ok {
+		cl = &componentLevel{}
+		levels[component] = cl
+	}
+	return cl
+}
+
 var base = newLogger()
 
 func newLogger() *slog.Logger {
-	level := slog.LevelInfo
 	if v := os.Getenv("LOG_LEVEL"); v != "" {
-		switch v {
-		case "DEBUG", "debug":
-			level = slog.LevelDebug
-		case "WARN", "warn":
-			level = slog.LevelWarn
-		case "ERROR", "error":
-			level = slog.LevelError
+		if err := SetLevels(v); err != nil {
+			fmt.Fprintln(os.Stderr, "observe: ignoring LOG_LEVEL:", err)
 		}
 	}
+	// the JSON handler admits everything; levelHandler does the filtering
 	h := slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{
-		Level: level,
+		Level: slog.LevelDebug - 4,
 	})
-	return slog.New(h).With(
+	return slog.New(&levelHandler{inner: h, level: &defaultLevel}).With(
 		slog.String("service", "stream-pipeline"),
 		slog.String("env", os.Getenv("APP_ENV")),
 	)
@@ -31,5 +66,215 @@ func newLogger() *slog.Logger {
 func L() *slog.Logger { return base }
 
 func C(component string) *slog.Logger {
-	return base.With(slog.String("component", component))
+	h := base.Handler().(*levelHandler)
+	return slog.New(h.forComponent(component)).With(slog.String("component", component))
+}
+
+// ParseLevel accepts debug, info, warn and error in any case.
+func ParseLevel(s string) (slog.Level, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "debug":
+		return slog.LevelDebug, nil
+	case "info":
+		return slog.LevelInfo, nil
+	case "warn", "warning":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	}
+	return 0, fmt.Errorf("unknown log level %q", s)
+}
+
+// SetLevel changes one component's level at runtime.
+func SetLevel(component string, level slog.Level) {
+	cl := levelFor(component)
+	cl.own.Set(level)
+	cl.explicit.Store(true)
+}
+
+// ResetLevel makes component follow the default level again.
+func ResetLevel(component string) {
+	levelFor(component).explicit.Store(false)
+}
+
+// levelChange is one part of a level spec.
+type levelChange struct {
+	component string
+	level     slog.Level
+	reset     bool
+}
+
+func parseLevelSpec(spec string) ([]levelChange, error) {
+	var changes []levelChange
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		name, lvl, found := strings.Cut(part, "=")
+		if !found {
+			name, lvl = "", part
+		}
+		name = strings.TrimSpace(name)
+		if found && name == "" {
+			return nil, fmt.Errorf("empty component in %q", part)
+		}
+		if found && strings.EqualFold(strings.TrimSpace(lvl), "default") {
+			changes = append(changes, levelChange{component: name, reset: true})
+			continue
+		}
+		l, err := ParseLevel(lvl)
+		if err != nil {
+			return nil, err
+		}
+		changes = append(changes, levelChange{component: name, level: l})
+	}
+	return changes, nil
+}
+
+// CheckLevels reports whether spec is valid for SetLevels without
+// applying it.
+func CheckLevels(spec string) error {
+	_, err := parseLevelSpec(spec)
+	return err
+}
+
+// SetLevels applies a spec such as "info,classifier=debug,rectifier=warn".
+// A bare level sets the default and "name=default" resets a component. The
+// spec is validated before anything changes.
+func SetLevels(spec string) error {
+	changes, err := parseLevelSpec(spec)
+	if err != nil {
+		return err
+	}
+	for _, c := range changes {
+		switch {
+		case c.component == "":
+			defaultLevel.Set(c.level)
+		case c.reset:
+			ResetLevel(c.component)
+		default:
+			SetLevel(c.component, c.level)
+		}
+	}
+	return nil
+}
+
+// Levels reports the default level under "default" and every component
+// with its own level.
+func Levels() map[string]string {
+	out := map[string]string{"default": strings.ToLower(defaultLevel.Level().String())}
+	levelsMu.Lock()
+	defer levelsMu.Unlock()
+	for name, cl := range levels {
+		if cl.explicit.Load() {
+			out[name] = strings.ToLower(cl.own.Level().String())
+		}
+	}
+	return out
+}
+
+// LevelSpec renders Levels in the form SetLevels accepts.
+func LevelSpec() string {
+	lv := Levels()
+	parts := []string{lv["default"]}
+	delete(lv, "default")
+	names := make([]string, 0, len(lv))
+	for n := range lv {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	for _, n := range names {
+		parts = append(parts, n+"="+lv[n])
+	}
+	return strings.Join(parts, ",")
+}
+
+// levelHandler filters records by a component's level and samples
+// repetitive Debug messages.
+type levelHandler struct {
+	inner     slog.Handler
+	level     slog.Leveler
+	component string
+}
+
+func (h *levelHandler) forComponent(component string) *levelHandler {
+	return &levelHandler{inner: h.inner, level: levelFor(component), component: component}
+}
+
+func (h *levelHandler) Enabled(_ context.Context, l slog.Level) bool {
+	return l >= h.level.Level()
+}
+
+func (h *levelHandler) Handle(ctx context.Context, r slog.Record) error {
+	if r.Level < slog.LevelInfo {
+		ok, suppressed := debugSampler.allow(h.component, r.Message, r.Time)
+		if !ok {
+			return nil
+		}
+		if suppressed > 0 {
+			r = r.Clone()
+			r.AddAttrs(slog.Int("suppressed", suppressed))
+		}
+	}
+	return h.inner.Handle(ctx, r)
+}
+
+func (h *levelHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &levelHandler{inner: h.inner.WithAttrs(attrs), level: h.level, component: h.component}
+}
+
+func (h *levelHandler) WithGroup(name string) slog.Handler {
+	return &levelHandler{inner: h.inner.WithGroup(name), level: h.level, component: h.component}
+}
+
+// sampler lets through at most burst records per message and component in
+// each period, and reports how many were dropped since the last one passed.
+type sampler struct {
+	mu      sync.Mutex
+	burst   int
+	period  time.Duration
+	windows map[string]*sampleWindow
+}
+
+type sampleWindow struct {
+	start      time.Time
+	n          int
+	suppressed int
+}
+
+var debugSampler = &sampler{burst: 10, period: time.Second, windows: make(map[string]*sampleWindow)}
+
+// SetDebugSampling changes how many identical Debug messages per component
+// are logged each period; burst <= 0 disables sampling.
+func SetDebugSampling(burst int, period time.Duration) {
+	debugSampler.mu.Lock()
+	defer debugSampler.mu.Unlock()
+	debugSampler.burst, debugSampler.period = burst, period
+	clear(debugSampler.windows)
+}
+
+func (s *sampler) allow(component, msg string, now time.Time) (bool, int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.burst <= 0 {
+		return true, 0
+	}
+	key := component + "\x00" + msg
+	w, ok := s.windows[key]
+	if !ok {
+		w = &sampleWindow{start: now}
+		s.windows[key] = w
+	}
+	if now.Sub(w.start) >= s.period {
+		w.start, w.n = now, 0
+	}
+	if w.n >= s.burst {
+		w.suppressed++
+		return false, 0
+	}
+	w.n++
+	suppressed := w.suppressed
+	w.suppressed = 0
+	return true, suppressed
 }
@@ -0,0 +1,79 @@
+package observe
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testLogger(buf *bytes.Buffer) *levelHandler {
+	h := slog.NewJSONHandler(buf, &slog.HandlerOptions{Level: slog.LevelDebug - 4})
+	return &levelHandler{inner: h, level: &defaultLevel}
+}
+
+func TestComponentLevels(t *testing.T) {
+	defer SetLevels("info,classifier=default,rectifier=default")
+
+	var buf bytes.Buffer
+	root := testLogger(&buf)
+	cls := slog.New(root.forComponent("classifier"))
+	rect := slog.New(root.forComponent("rectifier")).With("account", "me")
+
+	cls.Debug("hidden")
+	if err := SetLevels("classifier=debug,rectifier=warn"); err != nil {
+		t.Fatal(err)
+	}
+	cls.Debug("shown")
+	rect.Info("hidden too")
+	rect.Warn("warned")
+
+	out := buf.String()
+	if strings.Contains(out, "hidden") || !strings.Contains(out, "shown") || !strings.Contains(out, "warned") {
+		t.Fatalf("unexpected output:\n%s", out)
+	}
+	if got := LevelSpec(); got != "info,classifier=debug,rectifier=warn" {
+		t.Fatalf("LevelSpec = %q", got)
+	}
+
+	// a component reset follows the default again
+	if err := SetLevels("debug,rectifier=default"); err != nil {
+		t.Fatal(err)
+	}
+	if !rect.Enabled(context.Background(), slog.LevelDebug) {
+		t.Fatal("reset component did not follow the default level")
+	}
+}
+
+func TestSetLevelsRejectsBadSpecAtomically(t *testing.T) {
+	defer SetLevels("info,x=default")
+	if err := SetLevels("x=debug,y=loud"); err == nil {
+		t.Fatal("expected error")
+	}
+	if _, ok := Levels()["x"]; ok {
+		t.Fatal("partial spec was applied")
+	}
+	if err := SetLevels("=debug"); err == nil {
+		t.Fatal("expected error for empty component")
+	}
+}
+
+func TestDebugSampling(t *testing.T) {
+	s := &sampler{burst: 2, period: time.Second, windows: make(map[string]*sampleWindow)}
+	now := time.Unix(1_700_000_000, 0)
+
+	for i, want := range []bool{true, true, false, false} {
+		if ok, _ := s.allow("classifier", "skip malformed", now); ok != want {
+			t.Fatalf("call %d: allowed = %v, want %v", i, ok, want)
+		}
+	}
+	if ok, _ := s.allow("rectifier", "skip malformed", now); !ok {
+		t.Fatal("components must be sampled separately")
+	}
+	ok, suppressed := s.allow("classifier", "skip malformed", now.Add(time.Second))
+	if !ok || suppressed != 2 {
+		t.Fatalf("next window: allowed=%v suppressed=%d, want true and 2", ok, suppressed)
+	}
+}
@@ -0,0 +1,363 @@
+package observe
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Registry holds metrics and renders them in the Prometheus text format.
+// Registering a name twice returns the existing metric, so packages can
+// declare their metrics at init without coordinating.
+type Registry struct {
+	mu      sync.Mutex
+	metrics map[string]collector
+}
+
+type collector interface {
+	write(w io.Writer, name string)
+}
+
+func NewRegistry() *Registry {
+	return &Registry{metrics: make(map[string]collector)}
+}
+
+var defaultRegistry = NewRegistry()
+
+// Metrics returns the process-wide registry served at /metrics.
+func Metrics() *Registry { return defaultRegistry }
+
+// DefBuckets are latency buckets in seconds, from 1ms to 10s.
+var DefBuckets = []float64{.001, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+func register[T collector](r *Registry, name string, mk func() T) T {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if m, ok := r.metrics[name]; ok {
+		if t, ok := m.(T); ok {
+			return t
+		}
+		panic(fmt.Sprintf("observe: metric %q registered with a different type", name))
+	}
+	m := mk()
+	r.metrics[name] = m
+	return m
+}
+
+// Counter registers a monotonically increasing counter with the given label names.
+func (r *Registry) Counter(name, help string, labels ...string) *CounterVec {
+	return register(r, name, func() *CounterVec {
+		return &CounterVec{vec: newVec(help, "counter", labels)}
+	})
+}
+
+// Gauge registers a settable gauge with the given label names.
+func (r *Registry) Gauge(name, help string, labels ...string) *GaugeVec {
+	return register(r, name, func() *GaugeVec {
+		return &GaugeVec{vec: newVec(help, "gauge", labels)}
+	})
+}
+
+// GaugeFunc registers a gauge sampled from fn at scrape time. labelPairs are
+// alternating name/value pairs; registering the same labels again replaces fn.
+func (r *Registry) GaugeFunc(name, help string, fn func() float64, labelPairs ...string) {
+	g := register(r, name, func() *gaugeFuncs {
+		return &gaugeFuncs{help: help, fns: make(map[string]func() float64)}
+	})
+	g.mu.Lock()
+	g.fns[formatPairs(labelPairs)] = fn
+	g.mu.Unlock()
+}
+
+// Histogram registers a histogram with the given upper bounds and label names.
+func (r *Registry) Histogram(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	return register(r, name, func() *HistogramVec {
+		b := append([]float64(nil), buckets...)
+		sort.Float64s(b)
+		return &HistogramVec{vec: newVec(help, "histogram", labels), buckets: b}
+	})
+}
+
+// Write renders every metric, sorted by name.
+func (r *Registry) Write(w io.Writer) {
+	r.mu.Lock()
+	names := make([]string, 0, len(r.metrics))
+	for n := range r.metrics {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	ms := make([]collector, len(names))
+	for i, n := range names {
+		ms[i] = r.metrics[n]
+	}
+	r.mu.Unlock()
+
+	for i, m := range ms {
+		m.write(w, names[i])
+	}
+}
+
+// Handler serves the registry for Prometheus scrapes.
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		r.Write(w)
+	})
+}
+
+// vec is the label bookkeeping shared by counters, gauges and histograms.
+type vec struct {
+	mu     sync.Mutex
+	help   string
+	kind   string
+	labels []string
+	series map[string]any // formatted label set -> *Counter, *Gauge or *Histogram
+	order  []string
+}
+
+func newVec(help, kind string, labels []string) *vec {
+	return &vec{help: help, kind: kind, labels: labels, series: make(map[string]any)}
+}
+
+func (v *vec) get(values []string, mk func() any) any {
+	if len(values) != len(v.labels) {
+		panic(fmt.Sprintf("observe: got %d label values, want %d", len(values), len(v.labels)))
+	}
+	pairs := make([]string, 0, 2*len(values))
+	for i, l := range v.labels {
+		pairs = append(pairs, l, values[i])
+	}
+	key := formatPairs(pairs)
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if s, ok := v.series[key]; ok {
+		return s
+	}
+	s := mk()
+	v.series[key] = s
+	v.order = append(v.order, key)
+	sort.Strings(v.order)
+	return s
+}
+
+func (v *vec) each(fn func(labels string, s any)) {
+	v.mu.Lock()
+	keys := append([]string(nil), v.order...)
+	series := make([]any, len(keys))
+	for i, k := range keys {
+		series[i] = v.series[k]
+	}
+	v.mu.Unlock()
+	for i, k := range keys {
+		fn(k, series[i])
+	}
+}
+
+func writeHeader(w io.Writer, name, help, kind string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, strings.ReplaceAll(help, "\n", " "), name, kind)
+}
+
+type CounterVec struct{ *vec }
+
+// With returns the counter for the given label values, creating it on first use.
+func (c *CounterVec) With(values ...string) *Counter {
+	return c.get(values, func() any { return &Counter{} }).(*Counter)
+}
+
+func (c *CounterVec) write(w io.Writer, name string) {
+	writeHeader(w, name, c.help, c.kind)
+	c.each(func(labels string, s any) {
+		fmt.Fprintf(w, "%s%s %s\n", name, labels, formatFloat(s.(*Counter).Value()))
+	})
+}
+
+type Counter struct {
+	mu sync.Mutex
+	v  float64
+}
+
+func (c *Counter) Inc() { c.Add(1) }
+
+// Add increases the counter; negative deltas are ignored.
+func (c *Counter) Add(d float64) {
+	if d < 0 {
+		return
+	}
+	c.mu.Lock()
+	c.v += d
+	c.mu.Unlock()
+}
+
+func (c *Counter) Value() float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.v
+}
+
+type GaugeVec struct{ *vec }
+
+// With returns the gauge for the given label values, creating it on first use.
+func (g *GaugeVec) With(values ...string) *Gauge {
+	return g.get(values, func() any { return &Gauge{} }).(*Gauge)
+}
+
+func (g *GaugeVec) write(w io.Writer, name string) {
+	writeHeader(w, name, g.help, g.kind)
+	g.each(func(labels string, s any) {
+		fmt.Fprintf(w, "%s%s %s\n", name, labels, formatFloat(s.(*Gauge).Value()))
+	})
+}
+
+type Gauge struct {
+	mu sync.Mutex
+	v  float64
+}
+
+func (g *Gauge) Set(v float64) {
+	g.mu.Lock()
+	g.v = v
+	g.mu.Unlock()
+}
+
+func (g *Gauge) Add(d float64) {
+	g.mu.Lock()
+	g.v += d
+	g.mu.Unlock()
+}
+
+func (g *Gauge) Value() float64 {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.v
+}
+
+type gaugeFuncs struct {
+	mu   sync.Mutex
+	help string
+	fns  map[string]func() float64
+}
+
+func (g *gaugeFuncs) write(w io.Writer, name string) {
+	g.mu.Lock()
+	keys := make([]string, 0, len(g.fns))
+	for k := range g.fns {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	fns := make([]func() float64, len(keys))
+	for i, k := range keys {
+		fns[i] = g.fns[k]
+	}
+	g.mu.Unlock()
+
+	writeHeader(w, name, g.help, "gauge")
+	for i, k := range keys {
+		fmt.Fprintf(w, "%s%s %s\n", name, k, formatFloat(fns[i]()))
+	}
+}
+
+type HistogramVec struct {
+	*vec
+	buckets []float64
+}
+
+// With returns the histogram for the given label values, creating it on first use.
+func (h *HistogramVec) With(values ...string) *Histogram {
+	return h.get(values, func() any {
+		return &Histogram{bounds: h.buckets, counts: make([]uint64, len(h.buckets))}
+	}).(*Histogram)
+}
+
+func (h *HistogramVec) write(w io.Writer, name string) {
+	writeHeader(w, name, h.help, h.kind)
+	h.each(func(labels string, s any) {
+		counts, count, sum := s.(*Histogram).snapshot()
+		var cum uint64
+		for i, b := range h.buckets {
+			cum += counts[i]
+			fmt.Fprintf(w, "%s_bucket%s %d\n", name, withLabel(labels, "le", formatFloat(b)), cum)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", name, withLabel(labels, "le", "+Inf"), count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", name, labels, formatFloat(sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", name, labels, count)
+	})
+}
+
+type Histogram struct {
+	mu     sync.Mutex
+	bounds []float64
+	counts []uint64 // per bucket, not cumulative
+	count  uint64
+	sum    float64
+}
+
+func (h *Histogram) Observe(v float64) {
+	i := sort.SearchFloat64s(h.bounds, v)
+	h.mu.Lock()
+	if i < len(h.counts) {
+		h.counts[i]++
+	}
+	h.count++
+	h.sum += v
+	h.mu.Unlock()
+}
+
+// Since observes the seconds elapsed since start.
+func (h *Histogram) Since(start time.Time) {
+	h.Observe(time.Since(start).Seconds())
+}
+
+func (h *Histogram) snapshot() ([]uint64, uint64, float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return append([]uint64(nil), h.counts...), h.count, h.sum
+}
+
+// formatPairs renders name/value pairs as {a="1",b="2"}, or "" when empty.
+func formatPairs(pairs []string) string {
+	if len(pairs) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteByte('{')
+	for i := 0; i+1 < len(pairs); i += 2 {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(pairs[i])
+		b.WriteString(`="`)
+		b.WriteString(escapeLabel(pairs[i+1]))
+		b.WriteByte('"')
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+func withLabel(labels, name, value string) string {
+	extra := name + `="` + value + `"`
+	if labels == "" {
+		return "{" + extra + "}"
+	}
+	return labels[:len(labels)-1] + "," + extra + "}"
+}
+
+func escapeLabel(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s)
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
@@ -0,0 +1,63 @@
+package observe
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRegistry_TextFormat(t *testing.T) {
+	r := NewRegistry()
+	c := r.Counter("lines_total", "Lines seen.", "command")
+	c.With("PRIVMSG").Add(2)
+	c.With("JOIN").Inc()
+	r.GaugeFunc("depth", "Queue depth.", func() float64 { return 7 }, "channel", "readerCh")
+	h := r.Histogram("latency_seconds", "Latency.", []float64{0.1, 1})
+	h.With().Observe(0.05)
+	h.With().Observe(0.5)
+	h.With().Observe(5)
+
+	var b strings.Builder
+	r.Write(&b)
+	out := b.String()
+
+	for _, want := range []string{
+		"# TYPE lines_total counter\n",
+		`lines_total{command="JOIN"} 1` + "\n",
+		`lines_total{command="PRIVMSG"} 2` + "\n",
+		`depth{channel="readerCh"} 7` + "\n",
+		"# TYPE latency_seconds histogram\n",
+		`latency_seconds_bucket{le="0.1"} 1` + "\n",
+		`latency_seconds_bucket{le="1"} 2` + "\n",
+		`latency_seconds_bucket{le="+Inf"} 3` + "\n",
+		"latency_seconds_sum 5.55\n",
+		"latency_seconds_count 3\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("missing %q in:\n%s", want, out)
+		}
+	}
+}
+
+func TestRegistry_ReRegisterReturnsSame(t *testing.T) {
+	r := NewRegistry()
+	a := r.Counter("x_total", "X.")
+	b := r.Counter("x_total", "X.")
+	a.With().Inc()
+	if b.With().Value() != 1 {
+		t.Fatalf("second registration did not share series")
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Fatalf("expected panic on type mismatch")
+		}
+	}()
+	r.Gauge("x_total", "X.")
+}
+
+func TestEscapeLabel(t *testing.T) {
+	got := formatPairs([]string{"k", "a\"b\\c\nd"})
+	if want := `{k="a\"b\\c\nd"}`; got != want {
+		t.Fatalf("got %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,168 @@
+package ratelimit
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Duration is a time.Duration that reads and writes as "10s" in JSON.
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("duration must be a string like \"10s\": %w", err)
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// Profile is a named set of Twitch chat limits.
+type Profile struct {
+	Name        string   `json:"name"`
+	JoinLimit   int      `json:"join_limit"`    // JOINs per JoinWindow
+	JoinWindow  Duration `json:"join_window"`   // Twitch uses 10s
+	MsgLimit    int      `json:"msg_limit"`     // messages per MsgWindow, counted across channels we do not moderate
+	ModMsgLimit int      `json:"mod_msg_limit"` // messages per MsgWindow when we are moderator or broadcaster
+	MsgWindow   Duration `json:"msg_window"`    // Twitch uses 30s
+}
+
+func (p Profile) Validate() error {
+	var errs []string
+	if p.Name == "" {
+		errs = append(errs, "name is required")
+	}
+	if p.JoinLimit <= 0 || p.JoinWindow <= 0 {
+		errs = append(errs, "join_limit and join_window must be positive")
+	}
+	if p.MsgLimit <= 0 || p.ModMsgLimit <= 0 || p.MsgWindow <= 0 {
+		errs = append(errs, "msg_limit, mod_msg_limit and msg_window must be positive")
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("profile %q: %s", p.Name, strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// Builtin profiles follow Twitch's published chat limits for each account tier.
+var Builtin = map[string]Profile{
+	"normal": {
+		Name: "normal", JoinLimit: 20, JoinWindow: Duration(10 * time.Second),
+		MsgLimit: 20, ModMsgLimit: 100, MsgWindow: Duration(30 * time.Second),
+	},
+	"known": {
+		Name: "known", JoinLimit: 20, JoinWindow: Duration(10 * time.Second),
+		MsgLimit: 50, ModMsgLimit: 100, MsgWindow: Duration(30 * time.Second),
+	},
+	"verified": {
+		Name: "verified", JoinLimit: 2000, JoinWindow: Duration(10 * time.Second),
+		MsgLimit: 7500, ModMsgLimit: 7500, MsgWindow: Duration(30 * time.Second),
+	},
+}
+
+// LoadProfiles returns the builtin profiles overlaid with those in the JSON
+// file at path (an array of Profile). An empty path yields the builtins.
+func LoadProfiles(path string) (map[string]Profile, error) {
+	out := make(map[string]Profile, len(Builtin))
+	for k, v := range Builtin {
+		out[k] = v
+	}
+	if path == "" {
+		return out, nil
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("ratelimit: read profiles %q: %w", path, err)
+	}
+	var ps []Profile
+	if err := json.Unmarshal(b, &ps); err != nil {
+		return nil, fmt.Errorf("ratelimit: decode profiles %q: %w", path, err)
+	}
+	for _, p := range ps {
+		if err := p.Validate(); err != nil {
+			return nil, fmt.Errorf("ratelimit: %s: %w", path, err)
+		}
+		out[p.Name] = p
+	}
+	return out, nil
+}
+
+// Limits holds the live windows for one chat identity under a profile.
+type Limits struct {
+	mu      sync.RWMutex
+	profile Profile
+
+	Join   *Window
+	Msg    *Window // non-moderator channels
+	ModMsg *Window // all messages; the only limit where we moderate
+}
+
+func NewLimits(p Profile) *Limits {
+	return &Limits{
+		profile: p,
+		Join:    NewWindow(p.JoinLimit, time.Duration(p.JoinWindow)),
+		Msg:     NewWindow(p.MsgLimit, time.Duration(p.MsgWindow)),
+		ModMsg:  NewWindow(p.ModMsgLimit, time.Duration(p.MsgWindow)),
+	}
+}
+
+func (l *Limits) Profile() Profile {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.profile
+}
+
+// SetProfile switches to p without forgetting recent admissions.
+func (l *Limits) SetProfile(p Profile) {
+	l.mu.Lock()
+	l.profile = p
+	l.mu.Unlock()
+	l.Join.Set(p.JoinLimit, time.Duration(p.JoinWindow))
+	l.Msg.Set(p.MsgLimit, time.Duration(p.MsgWindow))
+	l.ModMsg.Set(p.ModMsgLimit, time.Duration(p.MsgWindow))
+}
+
+// TakeMessage admits one outgoing message. Messages in channels we moderate
+// count only against the moderator budget; others must fit both budgets.
+func (l *Limits) TakeMessage(now time.Time, moderator bool) bool {
+	if moderator {
+		return l.ModMsg.Take(now)
+	}
+	if !l.Msg.Take(now) {
+		return false
+	}
+	if !l.ModMsg.Take(now) {
+		l.Msg.Refund(now)
+		return false
+	}
+	return true
+}
+
+// Status is the JSON view of Limits for the status API.
+type Status struct {
+	Profile         Profile `json:"profile"`
+	JoinRemaining   int     `json:"join_remaining"`
+	MsgRemaining    int     `json:"msg_remaining"`
+	ModMsgRemaining int     `json:"mod_msg_remaining"`
+}
+
+func (l *Limits) Status(now time.Time) Status {
+	return Status{
+		Profile:         l.Profile(),
+		JoinRemaining:   l.Join.Remaining(now),
+		MsgRemaining:    l.Msg.Remaining(now),
+		ModMsgRemaining: l.ModMsg.Remaining(now),
+	}
+}
@@ -0,0 +1,92 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// Window admits at most limit events in any trailing window, the way Twitch
+// counts JOINs and messages. It is safe for concurrent use so status
+// endpoints can read it while the owner spends from it.
+type Window struct {
+	mu     sync.Mutex
+	limit  int
+	window time.Duration
+	events []time.Time // admission times, oldest first
+}
+
+func NewWindow(limit int, window time.Duration) *Window {
+	return &Window{limit: limit, window: window}
+}
+
+// prune drops admissions that have left the window. Callers hold mu.
+func (w *Window) prune(now time.Time) {
+	cut := 0
+	for cut < len(w.events) && !w.events[cut].After(now.Add(-w.window)) {
+		cut++
+	}
+	if cut > 0 {
+		w.events = append(w.events[:0], w.events[cut:]...)
+	}
+}
+
+// Take admits one event at now if the window has room.
+func (w *Window) Take(now time.Time) bool {
+	return w.TakeN(now, 1)
+}
+
+// TakeN admits n events at once, or none.
+func (w *Window) TakeN(now time.Time, n int) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.prune(now)
+	if len(w.events)+n > w.limit {
+		return false
+	}
+	for i := 0; i < n; i++ {
+		w.events = append(w.events, now)
+	}
+	return true
+}
+
+// Refund returns the most recent admission, for events that were admitted
+// but never sent.
+func (w *Window) Refund(now time.Time) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if n := len(w.events); n > 0 {
+		w.events = w.events[:n-1]
+	}
+}
+
+// Remaining reports how many events would be admitted at now.
+func (w *Window) Remaining(now time.Time) int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.prune(now)
+	return max(w.limit-len(w.events), 0)
+}
+
+// NextFree reports when the next event will be admitted; now if immediately.
+func (w *Window) NextFree(now time.Time) time.Time {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.prune(now)
+	if len(w.events) < w.limit || len(w.events) == 0 {
+		return now
+	}
+	return w.events[len(w.events)-w.limit].Add(w.window)
+}
+
+// Set changes the limit and window, keeping admissions already counted.
+func (w *Window) Set(limit int, window time.Duration) {
+	w.mu.Lock()
+	w.limit, w.window = limit, window
+	w.mu.Unlock()
+}
+
+func (w *Window) Limit() (limit int, window time.Duration) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.limit, w.window
+}
@@ -0,0 +1,78 @@
+package ratelimit
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWindow_SlidingAdmission(t *testing.T) {
+	start := time.Unix(1_700_000_000, 0)
+	w := NewWindow(2, 10*time.Second)
+
+	if !w.Take(start) || !w.Take(start.Add(time.Second)) {
+		t.Fatal("first two admissions should succeed")
+	}
+	if w.Take(start.Add(2 * time.Second)) {
+		t.Fatal("third admission inside the window should fail")
+	}
+	if got := w.NextFree(start.Add(2 * time.Second)); !got.Equal(start.Add(10 * time.Second)) {
+		t.Fatalf("NextFree = %v, want %v", got, start.Add(10*time.Second))
+	}
+	// the first admission slides out at exactly +10s
+	if !w.Take(start.Add(10 * time.Second)) {
+		t.Fatal("admission after the oldest left the window should succeed")
+	}
+	if w.Remaining(start.Add(10*time.Second)) != 0 {
+		t.Fatal("window should be full again")
+	}
+	w.Refund(start.Add(10 * time.Second))
+	if w.Remaining(start.Add(10*time.Second)) != 1 {
+		t.Fatal("refund should free one slot")
+	}
+	if w.TakeN(start.Add(10*time.Second), 2) {
+		t.Fatal("TakeN beyond remaining must admit nothing")
+	}
+}
+
+func TestLimits_MessageBudgets(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	l := NewLimits(Profile{Name: "t", JoinLimit: 1, JoinWindow: Duration(time.Second),
+		MsgLimit: 1, ModMsgLimit: 2, MsgWindow: Duration(time.Minute)})
+
+	if !l.TakeMessage(now, false) {
+		t.Fatal("first non-mod message should pass")
+	}
+	if l.TakeMessage(now, false) {
+		t.Fatal("non-mod budget is exhausted")
+	}
+	if !l.TakeMessage(now, true) {
+		t.Fatal("moderator budget still has room")
+	}
+	if l.TakeMessage(now, true) {
+		t.Fatal("moderator budget counts every message")
+	}
+	st := l.Status(now)
+	if st.Profile.Name != "t" || st.MsgRemaining != 0 || st.ModMsgRemaining != 0 || st.JoinRemaining != 1 {
+		t.Fatalf("status = %+v", st)
+	}
+}
+
+func TestLoadProfilesOverlaysBuiltins(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "profiles.json")
+	os.WriteFile(path, []byte(`[{"name":"normal","join_limit":5,"join_window":"10s","msg_limit":1,"mod_msg_limit":2,"msg_window":"30s"}]`), 0o600)
+
+	ps, err := LoadProfiles(path)
+	if err != nil {
+		t.Fatalf("LoadProfiles: %v", err)
+	}
+	if ps["normal"].JoinLimit != 5 || ps["verified"].JoinLimit != 2000 {
+		t.Fatalf("profiles = %+v", ps)
+	}
+
+	os.WriteFile(path, []byte(`[{"name":"bad","join_limit":0}]`), 0o600)
+	if _, err := LoadProfiles(path); err == nil {
+		t.Fatal("invalid profile should be rejected")
+	}
+}
@@ -2,21 +2,96 @@ package scheduler
 
 import (
 	"context"
-	"fmt"
+	"strings"
+	"time"
 
 	"github.com/Jamie-38/stream-pipeline/internal/observe"
 	"github.com/Jamie-38/stream-pipeline/internal/types"
 )
 
-func Control_scheduler(ctx context.Context, controlCh <-chan types.IRCCommand, writerCh chan<- string) {
+// BatchConfig controls how JOIN and PART commands are coalesced into
+// comma-separated lines such as "JOIN #a,#b,#c".
+//
+// Rate-limit tokens are taken per channel by the rectifier before a command
+// reaches the scheduler, so a line carrying n channels has already paid n
+// tokens; batching only saves writes.
+type BatchConfig struct {
+	MaxChannels int           // channels per line
+	MaxBytes    int           // bytes per line including CRLF
+	Linger      time.Duration // how long a partial batch waits for more commands
+}
+
+func NewDefaultBatchConfig() BatchConfig {
+	return BatchConfig{
+		MaxChannels: 20,
+		MaxBytes:    512, // RFC 1459 line limit
+		Linger:      25 * time.Millisecond,
+	}
+}
+
+func (c BatchConfig) withDefaults() BatchConfig {
+	d := NewDefaultBatchConfig()
+	if c.MaxChannels <= 0 {
+		c.MaxChannels = d.MaxChannels
+	}
+	if c.MaxBytes <= 0 {
+		c.MaxBytes = d.MaxBytes
+	}
+	if c.Linger <= 0 {
+		c.Linger = d.Linger
+	}
+	return c
+}
+
+func Control_scheduler(ctx context.Context, controlCh <-chan types.IRCCommand, writerCh chan<- string, cfg BatchConfig) {
 	lg := observe.C("scheduler")
+	cfg = cfg.withDefaults()
+
+	var (
+		op      string
+		batch   []string
+		size    int // bytes of the pending line including CRLF
+		timer   *time.Timer
+		lingerC <-chan time.Time
+	)
 
-	send := func(line string, channel string) {
+	flush := func() {
+		if timer != nil {
+			timer.Stop()
+			timer, lingerC = nil, nil
+		}
+		if len(batch) == 0 {
+			return
+		}
+		line := op + " " + strings.Join(batch, ",") + "\r\n"
 		select {
 		case writerCh <- line:
-			// sent
+			lg.Debug("forwarded batch", "op", op, "channels", len(batch), "bytes", len(line))
 		case <-ctx.Done():
-			lg.Info("stopping before send", "channel", channel)
+			lg.Info("stopping before send", "op", op, "channels", len(batch))
+		}
+		batch, size = batch[:0], 0
+	}
+
+	add := func(cmd types.IRCCommand) {
+		if len(batch) > 0 && (cmd.Op != op || size+1+len(cmd.Channel) > cfg.MaxBytes) {
+			flush()
+		}
+		if len(batch) == 0 {
+			op, size = cmd.Op, len(cmd.Op)+1+2 // "OP " + CRLF
+		} else {
+			size++ // comma
+		}
+		batch = append(batch, cmd.Channel)
+		size += len(cmd.Channel)
+
+		if len(batch) >= cfg.MaxChannels {
+			flush()
+			return
+		}
+		if timer == nil {
+			timer = time.NewTimer(cfg.Linger)
+			lingerC = timer.C
 		}
 	}
 
@@ -26,20 +101,20 @@ func Control_scheduler(ctx context.Context, controlCh <-chan types.IRCCommand, w
 			lg.Info("stopping", "reason", "context_canceled")
 			return
 
+		case <-lingerC:
+			timer, lingerC = nil, nil
+			flush()
+
 		case cmd, ok := <-controlCh:
 			if !ok {
+				flush()
 				lg.Info("stopping", "reason", "control_channel_closed")
 				return
 			}
 
 			switch cmd.Op {
-			case "JOIN":
-				lg.Debug("forwarded JOIN", "channel", cmd.Channel)
-				send(fmt.Sprintf("JOIN %s\r\n", cmd.Channel), cmd.Channel)
-
-			case "PART":
-				lg.Debug("forwarded PART", "channel", cmd.Channel)
-				send(fmt.Sprintf("PART %s\r\n", cmd.Channel), cmd.Channel)
+			case "JOIN", "PART":
+				add(cmd)
 
 			default:
 				lg.Warn("unknown IRC command", "op", cmd.Op, "channel", cmd.Channel)
@@ -0,0 +1,84 @@
+package scheduler
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Jamie-38/stream-pipeline/internal/types"
+)
+
+func runScheduler(t *testing.T, cfg BatchConfig, cmds ...types.IRCCommand) []string {
+	t.Helper()
+	controlCh := make(chan types.IRCCommand, len(cmds))
+	writerCh := make(chan string, len(cmds))
+	for _, c := range cmds {
+		controlCh <- c
+	}
+	close(controlCh)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	Control_scheduler(ctx, controlCh, writerCh, cfg)
+	close(writerCh)
+
+	var lines []string
+	for l := range writerCh {
+		lines = append(lines, l)
+	}
+	return lines
+}
+
+func join(ch string) types.IRCCommand { return types.IRCCommand{Op: "JOIN", Channel: ch} }
+func part(ch string) types.IRCCommand { return types.IRCCommand{Op: "PART", Channel: ch} }
+
+func equalLines(t *testing.T, got, want []string) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("lines = %q, want %q", got, want)
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			t.Fatalf("lines = %q, want %q", got, want)
+		}
+	}
+}
+
+func TestScheduler_CoalescesUntilOpChanges(t *testing.T) {
+	got := runScheduler(t, BatchConfig{Linger: time.Second},
+		join("#a"), join("#b"), part("#c"), join("#d"), join("#e"))
+	equalLines(t, got, []string{
+		"JOIN #a,#b\r\n",
+		"PART #c\r\n",
+		"JOIN #d,#e\r\n",
+	})
+}
+
+func TestScheduler_RespectsChannelAndByteLimits(t *testing.T) {
+	got := runScheduler(t, BatchConfig{MaxChannels: 2},
+		join("#a"), join("#b"), join("#c"))
+	equalLines(t, got, []string{"JOIN #a,#b\r\n", "JOIN #c\r\n"})
+
+	// "JOIN #aaaa,#bbbb\r\n" is 18 bytes; one more channel would exceed 20
+	got = runScheduler(t, BatchConfig{MaxBytes: 20},
+		join("#aaaa"), join("#bbbb"), join("#cc"))
+	equalLines(t, got, []string{"JOIN #aaaa,#bbbb\r\n", "JOIN #cc\r\n"})
+}
+
+func TestScheduler_LingerFlushesPartialBatch(t *testing.T) {
+	controlCh := make(chan types.IRCCommand, 1)
+	writerCh := make(chan string, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go Control_scheduler(ctx, controlCh, writerCh, BatchConfig{Linger: 10 * time.Millisecond})
+
+	controlCh <- join("#solo")
+	select {
+	case l := <-writerCh:
+		if l != "JOIN #solo\r\n" {
+			t.Fatalf("line = %q", l)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("partial batch never flushed")
+	}
+}
@@ -0,0 +1,255 @@
+package scheduler
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Jamie-38/stream-pipeline/internal/observe"
+	"github.com/Jamie-38/stream-pipeline/internal/ratelimit"
+	"github.com/Jamie-38/stream-pipeline/internal/types"
+)
+
+const (
+	maxMessageLen         = 500
+	defaultConfirmTimeout = 10 * time.Second
+	senderTick            = 100 * time.Millisecond
+)
+
+var (
+	ErrQueueFull    = errors.New("scheduler: send queue full")
+	ErrInvalidInput = errors.New("scheduler: invalid message")
+	ErrReadOnly     = errors.New("scheduler: connection is read-only")
+)
+
+type sendReq struct {
+	id    string
+	ctx   context.Context
+	msg   types.OutgoingMessage
+	reply chan types.DeliveryStatus
+}
+
+type inflight struct {
+	req      sendReq
+	sentAt   time.Time
+	deadline time.Time
+}
+
+type roomState struct {
+	slow      time.Duration // ROOMSTATE slow; 0 when off
+	moderator bool          // from USERSTATE badges; exempt from slow mode
+	joining   bool          // the USERSTATE Twitch sends on JOIN is still due
+	lastSent  time.Time
+	waiting   []sendReq  // FIFO not yet written
+	inflight  []inflight // FIFO awaiting USERSTATE/NOTICE
+}
+
+// Sender queues outgoing PRIVMSGs, paces them by channel slow mode and the
+// global message budget, and matches each to the USERSTATE or NOTICE Twitch
+// answers with. Confirmations are per channel and in order, so the oldest
+// in-flight message in a channel owns the next answer. A JOIN, such as the
+// rejoin after a reconnect, ends that order: messages still in flight are
+// reported unconfirmed, and the USERSTATE that answers the JOIN confirms
+// nothing.
+type Sender struct {
+	queue          chan sendReq
+	limits         *ratelimit.Limits
+	ConfirmTimeout time.Duration
+	// ReadOnly refuses every Send, for connections that cannot chat.
+	ReadOnly bool
+	lg       *slog.Logger
+}
+
+func NewSender(limits *ratelimit.Limits, queueSize int) *Sender {
+	return &Sender{
+		queue:          make(chan sendReq, queueSize),
+		limits:         limits,
+		ConfirmTimeout: defaultConfirmTimeout,
+		lg:             observe.C("sender"),
+	}
+}
+
+// Send queues msg and waits until Twitch confirms or rejects it, the
+// confirmation times out, or ctx ends. A message still queued when ctx ends
+// is never sent.
+func (s *Sender) Send(ctx context.Context, msg types.OutgoingMessage) (types.DeliveryStatus, error) {
+	if s.ReadOnly {
+		return types.DeliveryStatus{}, ErrReadOnly
+	}
+	ch := strings.ToLower(strings.TrimSpace(msg.Channel))
+	if ch == "" {
+		return types.DeliveryStatus{}, fmt.Errorf("%w: missing channel", ErrInvalidInput)
+	}
+	if !strings.HasPrefix(ch, "#") {
+		ch = "#" + ch
+	}
+	// one room per line, and nothing that could end the line early
+	if !types.ValidChannelName(ch) {
+		return types.DeliveryStatus{}, fmt.Errorf("%w: bad channel %q", ErrInvalidInput, msg.Channel)
+	}
+	text := strings.TrimSpace(strings.NewReplacer("\r", " ", "\n", " ").Replace(msg.Text))
+	if text == "" || len(text) > maxMessageLen {
+		return types.DeliveryStatus{}, fmt.Errorf("%w: text must be 1-%d bytes", ErrInvalidInput, maxMessageLen)
+	}
+	if strings.ContainsAny(msg.ReplyParentMsgID, " ;\r\n") {
+		return types.DeliveryStatus{}, fmt.Errorf("%w: bad reply-parent-msg-id", ErrInvalidInput)
+	}
+	msg.Channel, msg.Text = ch, text
+
+	req := sendReq{id: newID(), ctx: ctx, msg: msg, reply: make(chan types.DeliveryStatus, 1)}
+	select {
+	case s.queue <- req:
+	default:
+		return types.DeliveryStatus{}, ErrQueueFull
+	}
+
+	select {
+	case st := <-req.reply:
+		return st, nil
+	case <-ctx.Done():
+		return types.DeliveryStatus{ID: req.id, Channel: ch, Status: "expired"}, ctx.Err()
+	}
+}
+
+// Run owns all sender state. chatCh carries USERSTATE, NOTICE and ROOMSTATE
+// from the classifier; lines go to the single socket writer.
+func (s *Sender) Run(ctx context.Context, writerCh chan<- string, chatCh <-chan types.ChatEvent) {
+	rooms := make(map[string]*roomState)
+	room := func(ch string) *roomState {
+		r, ok := rooms[ch]
+		if !ok {
+			r = &roomState{}
+			rooms[ch] = r
+		}
+		return r
+	}
+
+	tick := time.NewTicker(senderTick)
+	defer tick.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			s.lg.Info("stopping", "reason", "context_canceled")
+			return
+
+		case req := <-s.queue:
+			r := room(req.msg.Channel)
+			r.waiting = append(r.waiting, req)
+			s.pump(ctx, time.Now(), req.msg.Channel, r, writerCh)
+
+		case evt := <-chatCh:
+			r := room(evt.Channel)
+			s.observe(time.Now(), r, evt)
+			if evt.Op == "PART" && len(r.waiting) == 0 && len(r.inflight) == 0 {
+				delete(rooms, evt.Channel)
+			}
+
+		case now := <-tick.C:
+			for ch, r := range rooms {
+				s.expire(now, r)
+				s.pump(ctx, now, ch, r, writerCh)
+			}
+		}
+	}
+}
+
+// pump writes as many waiting messages for one channel as slow mode and the
+// global budget allow.
+func (s *Sender) pump(ctx context.Context, now time.Time, ch string, r *roomState, writerCh chan<- string) {
+	for len(r.waiting) > 0 {
+		req := r.waiting[0]
+		if req.ctx.Err() != nil {
+			r.waiting = r.waiting[1:]
+			s.lg.Debug("dropping expired message", "channel", ch, "id", req.id)
+			continue
+		}
+		if !r.moderator && r.slow > 0 && now.Before(r.lastSent.Add(r.slow)) {
+			return
+		}
+		if !s.limits.TakeMessage(now, r.moderator) {
+			s.lg.Debug("message budget exhausted; waiting", "channel", ch, "id", req.id)
+			return
+		}
+
+		line := formatPrivmsg(req.msg)
+		select {
+		case writerCh <- line:
+		case <-ctx.Done():
+			return
+		}
+		r.waiting = r.waiting[1:]
+		r.lastSent = now
+		r.inflight = append(r.inflight, inflight{req: req, sentAt: now, deadline: now.Add(s.ConfirmTimeout)})
+		s.lg.Info("message sent", "channel", ch, "id", req.id, "reply", req.msg.ReplyParentMsgID != "")
+	}
+}
+
+func (s *Sender) observe(now time.Time, r *roomState, evt types.ChatEvent) {
+	switch evt.Op {
+	case "ROOMSTATE":
+		if v, ok := evt.Tags["slow"]; ok {
+			secs, _ := strconv.Atoi(v)
+			r.slow = time.Duration(secs) * time.Second
+			s.lg.Debug("slow mode", "channel", evt.Channel, "seconds", secs)
+		}
+	case "JOIN":
+		// whatever was in flight went out on a socket that is gone, or
+		// before we were in the room; nothing will answer it
+		for len(r.inflight) > 0 {
+			s.lg.Warn("message unconfirmed", "channel", evt.Channel, "id", r.inflight[0].req.id, "reason", "rejoined")
+			s.finish(r, "unconfirmed", "")
+		}
+		r.joining = true
+	case "USERSTATE":
+		r.moderator = evt.Tags["mod"] == "1" || strings.Contains(evt.Tags["badges"], "broadcaster/")
+		if r.joining {
+			r.joining = false // answers the JOIN, not a message
+			return
+		}
+		if len(r.inflight) > 0 {
+			s.finish(r, "confirmed", "")
+		}
+	case "NOTICE":
+		id := evt.Tags["msg-id"]
+		// msg_* notices answer a PRIVMSG we sent (slow mode, duplicate, banned, ...)
+		if strings.HasPrefix(id, "msg_") && len(r.inflight) > 0 {
+			s.lg.Warn("message rejected", "channel", evt.Channel, "msg_id", id, "notice", evt.Text)
+			s.finish(r, "rejected", id)
+		}
+	}
+}
+
+func (s *Sender) finish(r *roomState, status, reason string) {
+	f := r.inflight[0]
+	r.inflight = r.inflight[1:]
+	f.req.reply <- types.DeliveryStatus{
+		ID: f.req.id, Channel: f.req.msg.Channel, Status: status, Reason: reason, SentAt: f.sentAt,
+	}
+}
+
+func (s *Sender) expire(now time.Time, r *roomState) {
+	for len(r.inflight) > 0 && now.After(r.inflight[0].deadline) {
+		s.lg.Warn("message unconfirmed", "channel", r.inflight[0].req.msg.Channel, "id", r.inflight[0].req.id)
+		s.finish(r, "unconfirmed", "")
+	}
+}
+
+func formatPrivmsg(m types.OutgoingMessage) string {
+	if m.ReplyParentMsgID != "" {
+		return fmt.Sprintf("@reply-parent-msg-id=%s PRIVMSG %s :%s\r\n", m.ReplyParentMsgID, m.Channel, m.Text)
+	}
+	return fmt.Sprintf("PRIVMSG %s :%s\r\n", m.Channel, m.Text)
+}
+
+func newID() string {
+	var b [8]byte
+	_, _ = rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
@@ -0,0 +1,159 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Jamie-38/stream-pipeline/internal/ratelimit"
+	"github.com/Jamie-38/stream-pipeline/internal/types"
+)
+
+func newTestSender(t *testing.T) (*Sender, chan string, chan types.ChatEvent) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	s := NewSender(ratelimit.NewLimits(ratelimit.Builtin["normal"]), 8)
+	s.ConfirmTimeout = 300 * time.Millisecond
+	writer := make(chan string, 8)
+	chat := make(chan types.ChatEvent, 8)
+	go s.Run(ctx, writer, chat)
+	return s, writer, chat
+}
+
+type sendResult struct {
+	st  types.DeliveryStatus
+	err error
+}
+
+func sendAsync(s *Sender, msg types.OutgoingMessage) <-chan sendResult {
+	out := make(chan sendResult, 1)
+	go func() {
+		st, err := s.Send(context.Background(), msg)
+		out <- sendResult{st, err}
+	}()
+	return out
+}
+
+func nextLine(t *testing.T, ch <-chan string) string {
+	t.Helper()
+	select {
+	case l := <-ch:
+		return l
+	case <-time.After(time.Second):
+		t.Fatal("no line written")
+		return ""
+	}
+}
+
+func TestSender_ReplyConfirmedByUserstate(t *testing.T) {
+	s, writer, chat := newTestSender(t)
+
+	res := sendAsync(s, types.OutgoingMessage{Channel: "Chess", Text: "hi\nthere", ReplyParentMsgID: "abc-1"})
+	if got, want := nextLine(t, writer), "@reply-parent-msg-id=abc-1 PRIVMSG #chess :hi there\r\n"; got != want {
+		t.Fatalf("line = %q, want %q", got, want)
+	}
+	chat <- types.ChatEvent{Op: "USERSTATE", Channel: "#chess", Tags: map[string]string{}}
+
+	r := <-res
+	if r.err != nil || r.st.Status != "confirmed" || r.st.Channel != "#chess" {
+		t.Fatalf("got %+v, %v", r.st, r.err)
+	}
+}
+
+func TestSender_NoticeRejectsAndTimeoutIsUnconfirmed(t *testing.T) {
+	s, writer, chat := newTestSender(t)
+
+	res := sendAsync(s, types.OutgoingMessage{Channel: "#chess", Text: "one"})
+	nextLine(t, writer)
+	chat <- types.ChatEvent{Op: "NOTICE", Channel: "#chess", Tags: map[string]string{"msg-id": "msg_duplicate"}}
+	if r := <-res; r.st.Status != "rejected" || r.st.Reason != "msg_duplicate" {
+		t.Fatalf("got %+v", r.st)
+	}
+
+	res = sendAsync(s, types.OutgoingMessage{Channel: "#chess", Text: "two"})
+	nextLine(t, writer)
+	if r := <-res; r.st.Status != "unconfirmed" {
+		t.Fatalf("got %+v", r.st)
+	}
+}
+
+func TestSender_RejoinUserstateConfirmsNothing(t *testing.T) {
+	s, writer, chat := newTestSender(t)
+
+	lost := sendAsync(s, types.OutgoingMessage{Channel: "#chess", Text: "lost with the old socket"})
+	nextLine(t, writer)
+	chat <- types.ChatEvent{Op: "JOIN", Channel: "#chess"}
+	if r := <-lost; r.st.Status != "unconfirmed" {
+		t.Fatalf("message in flight across a rejoin = %+v", r.st)
+	}
+
+	res := sendAsync(s, types.OutgoingMessage{Channel: "#chess", Text: "after the rejoin"})
+	nextLine(t, writer)
+	chat <- types.ChatEvent{Op: "USERSTATE", Channel: "#chess", Tags: map[string]string{}} // answers the JOIN
+	select {
+	case r := <-res:
+		t.Fatalf("the JOIN's USERSTATE settled a message: %+v", r.st)
+	case <-time.After(50 * time.Millisecond):
+	}
+	chat <- types.ChatEvent{Op: "USERSTATE", Channel: "#chess", Tags: map[string]string{}}
+	if r := <-res; r.st.Status != "confirmed" {
+		t.Fatalf("got %+v", r.st)
+	}
+}
+
+func TestSender_SlowModeHoldsSecondMessage(t *testing.T) {
+	s, writer, chat := newTestSender(t)
+	chat <- types.ChatEvent{Op: "ROOMSTATE", Channel: "#chess", Tags: map[string]string{"slow": "30"}}
+	time.Sleep(20 * time.Millisecond)
+
+	sendAsync(s, types.OutgoingMessage{Channel: "#chess", Text: "one"})
+	nextLine(t, writer)
+	sendAsync(s, types.OutgoingMessage{Channel: "#chess", Text: "two"})
+
+	select {
+	case l := <-writer:
+		t.Fatalf("second message sent during slow mode: %q", l)
+	case <-time.After(250 * time.Millisecond):
+	}
+
+	// moderators are exempt
+	chat <- types.ChatEvent{Op: "USERSTATE", Channel: "#chess", Tags: map[string]string{"mod": "1"}}
+	if got := nextLine(t, writer); got != "PRIVMSG #chess :two\r\n" {
+		t.Fatalf("line = %q", got)
+	}
+}
+
+func TestSender_RejectsInvalidInput(t *testing.T) {
+	s := NewSender(ratelimit.NewLimits(ratelimit.Builtin["normal"]), 1)
+	for _, m := range []types.OutgoingMessage{
+		{Channel: "", Text: "x"},
+		{Channel: "#a", Text: "  "},
+		{Channel: "#a", Text: "x", ReplyParentMsgID: "a b"},
+		{Channel: "#a\r\nJOIN #b", Text: "x"},
+		{Channel: "#a\nPRIVMSG #b", Text: "x"},
+		{Channel: "#a,#b", Text: "x"},
+		{Channel: "#a #b", Text: "x"},
+		{Channel: "#this_login_is_far_too_long", Text: "x"},
+	} {
+		if _, err := s.Send(context.Background(), m); !errors.Is(err, ErrInvalidInput) {
+			t.Fatalf("%+v: err = %v", m, err)
+		}
+	}
+}
+
+func TestSender_ReadOnlyRefusesSend(t *testing.T) {
+	s, writer, _ := newTestSender(t)
+	s.ReadOnly = true
+
+	_, err := s.Send(context.Background(), types.OutgoingMessage{Channel: "#chess", Text: "hi"})
+	if !errors.Is(err, ErrReadOnly) {
+		t.Fatalf("err = %v, want ErrReadOnly", err)
+	}
+	select {
+	case l := <-writer:
+		t.Fatalf("read-only sender wrote %q", l)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
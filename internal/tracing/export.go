@@ -0,0 +1,295 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// WriterExporter writes one JSON object per span, for local use without a
+// collector.
+type WriterExporter struct {
+	mu     sync.Mutex
+	w      io.Writer
+	closer io.Closer
+}
+
+func NewStdoutExporter() *WriterExporter {
+	return &WriterExporter{w: os.Stdout}
+}
+
+// NewFileExporter appends spans to path.
+func NewFileExporter(path string) (*WriterExporter, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("open trace file %q: %w", path, err)
+	}
+	return &WriterExporter{w: f, closer: f}, nil
+}
+
+func (e *WriterExporter) Export(_ context.Context, spans []SpanData) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	enc := json.NewEncoder(e.w)
+	for _, s := range spans {
+		if err := enc.Encode(s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *WriterExporter) Shutdown(context.Context) error {
+	if e.closer == nil {
+		return nil
+	}
+	return e.closer.Close()
+}
+
+// OTLPExporter posts spans to an OTLP/HTTP collector using the JSON
+// encoding of ExportTraceServiceRequest.
+type OTLPExporter struct {
+	Endpoint string // e.g. http://localhost:4318/v1/traces
+	Service  string
+	Client   *http.Client
+}
+
+func NewOTLPExporter(endpoint, service string) *OTLPExporter {
+	return &OTLPExporter{Endpoint: endpoint, Service: service, Client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+type otlpValue struct {
+	StringValue *string  `json:"stringValue,omitempty"`
+	IntValue    *string  `json:"intValue,omitempty"` // int64 is a string in OTLP JSON
+	DoubleValue *float64 `json:"doubleValue,omitempty"`
+	BoolValue   *bool    `json:"boolValue,omitempty"`
+}
+
+type otlpKeyValue struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpLink struct {
+	TraceID string `json:"traceId"`
+	SpanID  string `json:"spanId"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"` // 1 ok, 2 error
+	Message string `json:"message,omitempty"`
+}
+
+type otlpSpan struct {
+	TraceID           string         `json:"traceId"`
+	SpanID            string         `json:"spanId"`
+	ParentSpanID      string         `json:"parentSpanId,omitempty"`
+	Name              string         `json:"name"`
+	Kind              Kind           `json:"kind"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+	Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+	Links             []otlpLink     `json:"links,omitempty"`
+	Status            otlpStatus     `json:"status"`
+}
+
+type otlpScopeSpans struct {
+	Scope struct {
+		Name string `json:"name"`
+	} `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpResourceSpans struct {
+	Resource struct {
+		Attributes []otlpKeyValue `json:"attributes"`
+	} `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+func attr(key string, v any) otlpKeyValue {
+	kv := otlpKeyValue{Key: key}
+	switch x := v.(type) {
+	case string:
+		kv.Value.StringValue = &x
+	case bool:
+		kv.Value.BoolValue = &x
+	case int:
+		s := strconv.Itoa(x)
+		kv.Value.IntValue = &s
+	case int64:
+		s := strconv.FormatInt(x, 10)
+		kv.Value.IntValue = &s
+	case float64:
+		kv.Value.DoubleValue = &x
+	default:
+		s := fmt.Sprint(x)
+		kv.Value.StringValue = &s
+	}
+	return kv
+}
+
+func toOTLP(service string, spans []SpanData) otlpRequest {
+	var ss otlpScopeSpans
+	ss.Scope.Name = "stream-pipeline"
+	for _, s := range spans {
+		o := otlpSpan{
+			TraceID:           s.TraceID,
+			SpanID:            s.SpanID,
+			ParentSpanID:      s.ParentID,
+			Name:              s.Name,
+			Kind:              s.Kind,
+			StartTimeUnixNano: strconv.FormatInt(s.Start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.End.UnixNano(), 10),
+			Status:            otlpStatus{Code: 1},
+		}
+		for k, v := range s.Attrs {
+			o.Attributes = append(o.Attributes, attr(k, v))
+		}
+		for _, l := range s.Links {
+			if sc, ok := ParseTraceparent(l); ok {
+				o.Links = append(o.Links, otlpLink{TraceID: sc.TraceID.String(), SpanID: sc.SpanID.String()})
+			}
+		}
+		if s.Error != "" {
+			o.Status = otlpStatus{Code: 2, Message: s.Error}
+		}
+		ss.Spans = append(ss.Spans, o)
+	}
+
+	var rs otlpResourceSpans
+	rs.Resource.Attributes = []otlpKeyValue{attr("service.name", service)}
+	rs.ScopeSpans = []otlpScopeSpans{ss}
+	return otlpRequest{ResourceSpans: []otlpResourceSpans{rs}}
+}
+
+func (e *OTLPExporter) Export(ctx context.Context, spans []SpanData) error {
+	body, err := json.Marshal(toOTLP(e.Service, spans))
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := e.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("otlp post: %w", err)
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("otlp post: status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func (e *OTLPExporter) Shutdown(context.Context) error { return nil }
+
+// multiExporter fans spans out to several exporters.
+type multiExporter []Exporter
+
+func (m multiExporter) Export(ctx context.Context, spans []SpanData) error {
+	var errs []error
+	for _, e := range m {
+		errs = append(errs, e.Export(ctx, spans))
+	}
+	return errors.Join(errs...)
+}
+
+func (m multiExporter) Shutdown(ctx context.Context) error {
+	var errs []error
+	for _, e := range m {
+		errs = append(errs, e.Shutdown(ctx))
+	}
+	return errors.Join(errs...)
+}
+
+// Settings select the sampling ratio and exporters of a tracer.
+type Settings struct {
+	SampleRatio  float64  // 0 turns tracing off
+	Exporters    []string // "stdout", "file" and "otlp"; empty means stdout
+	File         string   // for the file exporter
+	OTLPEndpoint string   // for otlp; defaults to http://localhost:4318
+}
+
+// FromEnv builds a tracer from TRACE_SAMPLE_RATIO and TRACE_EXPORTERS, a
+// comma-separated list of "stdout", "file" (TRACE_FILE) and "otlp"
+// (OTEL_EXPORTER_OTLP_ENDPOINT). It returns nil when tracing is off.
+func FromEnv(service string) (*Tracer, error) {
+	ratioEnv := strings.TrimSpace(os.Getenv("TRACE_SAMPLE_RATIO"))
+	if ratioEnv == "" {
+		return nil, nil
+	}
+	ratio, err := strconv.ParseFloat(ratioEnv, 64)
+	if err != nil || ratio < 0 || ratio > 1 {
+		return nil, fmt.Errorf("TRACE_SAMPLE_RATIO must be within [0,1]")
+	}
+	return FromSettings(service, Settings{
+		SampleRatio:  ratio,
+		Exporters:    strings.Split(os.Getenv("TRACE_EXPORTERS"), ","),
+		File:         strings.TrimSpace(os.Getenv("TRACE_FILE")),
+		OTLPEndpoint: strings.TrimSpace(os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT")),
+	})
+}
+
+// FromSettings builds a tracer from s. It returns nil when tracing is off.
+func FromSettings(service string, s Settings) (*Tracer, error) {
+	if s.SampleRatio < 0 || s.SampleRatio > 1 {
+		return nil, fmt.Errorf("trace sample ratio must be within [0,1]")
+	}
+	if s.SampleRatio == 0 {
+		return nil, nil
+	}
+
+	names := s.Exporters
+	if len(strings.Join(names, "")) == 0 {
+		names = []string{"stdout"}
+	}
+	var exps multiExporter
+	for _, name := range names {
+		switch strings.TrimSpace(name) {
+		case "stdout":
+			exps = append(exps, NewStdoutExporter())
+		case "file":
+			if s.File == "" {
+				return nil, fmt.Errorf("TRACE_FILE missing for file exporter")
+			}
+			fe, err := NewFileExporter(s.File)
+			if err != nil {
+				return nil, err
+			}
+			exps = append(exps, fe)
+		case "otlp":
+			endpoint := s.OTLPEndpoint
+			if endpoint == "" {
+				endpoint = "http://localhost:4318"
+			}
+			if !strings.HasSuffix(endpoint, "/v1/traces") {
+				endpoint = strings.TrimRight(endpoint, "/") + "/v1/traces"
+			}
+			exps = append(exps, NewOTLPExporter(endpoint, service))
+		case "":
+		default:
+			return nil, fmt.Errorf("unknown trace exporter %q", name)
+		}
+	}
+
+	cfg := NewDefaultConfig()
+	cfg.SampleRatio = s.SampleRatio
+	return NewTracer(cfg, exps), nil
+}
@@ -0,0 +1,157 @@
+package tracing
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"strings"
+	"sync"
+	"time"
+)
+
+type TraceID [16]byte
+
+type SpanID [8]byte
+
+func (t TraceID) String() string { return hex.EncodeToString(t[:]) }
+
+func (s SpanID) String() string { return hex.EncodeToString(s[:]) }
+
+// SpanContext identifies a span across goroutine and process boundaries.
+// The zero value means "not traced".
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+	Sampled bool
+}
+
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID != TraceID{} && sc.SpanID != SpanID{}
+}
+
+// Traceparent formats sc as a W3C traceparent header value.
+func (sc SpanContext) Traceparent() string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return "00-" + sc.TraceID.String() + "-" + sc.SpanID.String() + "-" + flags
+}
+
+// ParseTraceparent reads a W3C traceparent header value.
+func ParseTraceparent(s string) (SpanContext, bool) {
+	parts := strings.Split(strings.TrimSpace(s), "-")
+	if len(parts) != 4 || parts[0] != "00" || len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return SpanContext{}, false
+	}
+	var sc SpanContext
+	if _, err := hex.Decode(sc.TraceID[:], []byte(parts[1])); err != nil {
+		return SpanContext{}, false
+	}
+	if _, err := hex.Decode(sc.SpanID[:], []byte(parts[2])); err != nil {
+		return SpanContext{}, false
+	}
+	flags, err := hex.DecodeString(parts[3])
+	if err != nil {
+		return SpanContext{}, false
+	}
+	sc.Sampled = flags[0]&1 == 1
+	return sc, sc.IsValid()
+}
+
+// Kind mirrors the OTLP span kinds we emit.
+type Kind int
+
+const (
+	KindInternal Kind = 1
+	KindProducer Kind = 4
+	KindConsumer Kind = 5
+)
+
+// Span is one timed operation. A nil *Span is valid and does nothing, so
+// call sites need no checks for unsampled lines.
+type Span struct {
+	mu     sync.Mutex
+	tracer *Tracer
+	sc     SpanContext
+	data   SpanData
+	ended  bool
+}
+
+// SpanData is the exported, immutable view of a finished span.
+type SpanData struct {
+	Name     string         `json:"name"`
+	Kind     Kind           `json:"kind"`
+	TraceID  string         `json:"trace_id"`
+	SpanID   string         `json:"span_id"`
+	ParentID string         `json:"parent_id,omitempty"`
+	Start    time.Time      `json:"start"`
+	End      time.Time      `json:"end"`
+	Attrs    map[string]any `json:"attrs,omitempty"`
+	Links    []string       `json:"links,omitempty"` // traceparent values
+	Error    string         `json:"error,omitempty"`
+}
+
+// Context returns the span's identity for propagation; zero for a nil span.
+func (s *Span) Context() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.sc
+}
+
+func (s *Span) SetAttr(key string, value any) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ended {
+		return
+	}
+	if s.data.Attrs == nil {
+		s.data.Attrs = make(map[string]any)
+	}
+	s.data.Attrs[key] = value
+}
+
+// SetError marks the span failed; nil errors are ignored.
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.mu.Lock()
+	if !s.ended {
+		s.data.Error = err.Error()
+	}
+	s.mu.Unlock()
+}
+
+// End records the end time and hands the span to the exporter. Only the
+// first call has an effect.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.data.End = time.Now()
+	d := s.data
+	s.mu.Unlock()
+	s.tracer.enqueue(d)
+}
+
+func newTraceID() TraceID {
+	var t TraceID
+	_, _ = rand.Read(t[:])
+	return t
+}
+
+func newSpanID() SpanID {
+	var s SpanID
+	_, _ = rand.Read(s[:])
+	return s
+}
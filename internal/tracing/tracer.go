@@ -0,0 +1,174 @@
+package tracing
+
+import (
+	"context"
+	"math/rand/v2"
+	"sync/atomic"
+	"time"
+
+	"github.com/Jamie-38/stream-pipeline/internal/observe"
+)
+
+var dropped = observe.Metrics().Counter("tracing_spans_dropped_total",
+	"Finished spans dropped because the export queue was full.")
+
+// Exporter ships finished spans somewhere. Export is called from a single
+// goroutine.
+type Exporter interface {
+	Export(ctx context.Context, spans []SpanData) error
+	Shutdown(ctx context.Context) error
+}
+
+type Config struct {
+	SampleRatio   float64       // fraction of root spans kept, in [0,1]
+	QueueSize     int           // finished spans buffered before dropping
+	BatchSize     int           // spans per Export call
+	FlushInterval time.Duration // upper bound on export delay
+}
+
+func NewDefaultConfig() Config {
+	return Config{
+		SampleRatio:   0.01,
+		QueueSize:     4096,
+		BatchSize:     256,
+		FlushInterval: 5 * time.Second,
+	}
+}
+
+// Tracer starts spans and batches them to an Exporter. A nil *Tracer is
+// valid and never samples.
+type Tracer struct {
+	cfg   Config
+	exp   Exporter
+	queue chan SpanData
+}
+
+func NewTracer(cfg Config, exp Exporter) *Tracer {
+	d := NewDefaultConfig()
+	if cfg.QueueSize <= 0 {
+		cfg.QueueSize = d.QueueSize
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = d.BatchSize
+	}
+	if cfg.FlushInterval <= 0 {
+		cfg.FlushInterval = d.FlushInterval
+	}
+	return &Tracer{cfg: cfg, exp: exp, queue: make(chan SpanData, cfg.QueueSize)}
+}
+
+var global atomic.Pointer[Tracer]
+
+// SetDefault installs t as the tracer used by pipeline stages.
+func SetDefault(t *Tracer) { global.Store(t) }
+
+// Default returns the installed tracer, or nil when tracing is off.
+func Default() *Tracer { return global.Load() }
+
+// Root starts a new trace if the sampler keeps it; otherwise it returns nil.
+func (t *Tracer) Root(name string, kind Kind) *Span {
+	if t == nil || t.cfg.SampleRatio <= 0 {
+		return nil
+	}
+	if t.cfg.SampleRatio < 1 && rand.Float64() >= t.cfg.SampleRatio {
+		return nil
+	}
+	return t.start(name, kind, SpanContext{TraceID: newTraceID()}, nil)
+}
+
+// Child starts a span under parent. Unsampled parents yield nil.
+func (t *Tracer) Child(parent SpanContext, name string, kind Kind) *Span {
+	if t == nil || !parent.Sampled || !parent.IsValid() {
+		return nil
+	}
+	return t.start(name, kind, parent, nil)
+}
+
+// Linked starts a new trace linked to remote, for work that consumes what
+// another trace produced. Unsampled remotes yield nil.
+func (t *Tracer) Linked(remote SpanContext, name string, kind Kind) *Span {
+	if t == nil || !remote.Sampled || !remote.IsValid() {
+		return nil
+	}
+	return t.start(name, kind, SpanContext{TraceID: newTraceID()}, []SpanContext{remote})
+}
+
+func (t *Tracer) start(name string, kind Kind, parent SpanContext, links []SpanContext) *Span {
+	sc := SpanContext{TraceID: parent.TraceID, SpanID: newSpanID(), Sampled: true}
+	s := &Span{
+		tracer: t,
+		sc:     sc,
+		data: SpanData{
+			Name:    name,
+			Kind:    kind,
+			TraceID: sc.TraceID.String(),
+			SpanID:  sc.SpanID.String(),
+			Start:   time.Now(),
+		},
+	}
+	if parent.IsValid() {
+		s.data.ParentID = parent.SpanID.String()
+	}
+	for _, l := range links {
+		s.data.Links = append(s.data.Links, l.Traceparent())
+	}
+	return s
+}
+
+func (t *Tracer) enqueue(d SpanData) {
+	select {
+	case t.queue <- d:
+	default:
+		dropped.With().Inc()
+	}
+}
+
+// Run exports batches until ctx is canceled, then flushes what is queued
+// and shuts the exporter down.
+func (t *Tracer) Run(ctx context.Context) error {
+	lg := observe.C("tracing")
+	tick := time.NewTicker(t.cfg.FlushInterval)
+	defer tick.Stop()
+
+	batch := make([]SpanData, 0, t.cfg.BatchSize)
+	export := func(ctx context.Context) {
+		if len(batch) == 0 {
+			return
+		}
+		if err := t.exp.Export(ctx, batch); err != nil {
+			lg.Warn("span export failed", "err", err, "spans", len(batch))
+		}
+		batch = batch[:0]
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			for {
+				select {
+				case d := <-t.queue:
+					batch = append(batch, d)
+					if len(batch) >= t.cfg.BatchSize {
+						export(shutdownCtx)
+					}
+					continue
+				default:
+				}
+				break
+			}
+			export(shutdownCtx)
+			return t.exp.Shutdown(shutdownCtx)
+
+		case d := <-t.queue:
+			batch = append(batch, d)
+			if len(batch) >= t.cfg.BatchSize {
+				export(ctx)
+			}
+
+		case <-tick.C:
+			export(ctx)
+		}
+	}
+}
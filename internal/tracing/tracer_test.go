@@ -0,0 +1,156 @@
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+type memExporter struct {
+	mu    sync.Mutex
+	spans []SpanData
+	shut  bool
+}
+
+func (m *memExporter) Export(_ context.Context, spans []SpanData) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.spans = append(m.spans, spans...)
+	return nil
+}
+
+func (m *memExporter) Shutdown(context.Context) error {
+	m.mu.Lock()
+	m.shut = true
+	m.mu.Unlock()
+	return nil
+}
+
+func TestTraceparentRoundTrip(t *testing.T) {
+	sc := SpanContext{TraceID: newTraceID(), SpanID: newSpanID(), Sampled: true}
+	got, ok := ParseTraceparent(sc.Traceparent())
+	if !ok || got != sc {
+		t.Fatalf("round trip: got %+v ok=%v, want %+v", got, ok, sc)
+	}
+	for _, bad := range []string{"", "00-xyz-abc-01", "01-" + sc.TraceID.String() + "-" + sc.SpanID.String() + "-01",
+		"00-00000000000000000000000000000000-" + sc.SpanID.String() + "-01"} {
+		if _, ok := ParseTraceparent(bad); ok {
+			t.Errorf("ParseTraceparent(%q) accepted", bad)
+		}
+	}
+}
+
+func TestNilTracerAndSpanAreNoops(t *testing.T) {
+	var tr *Tracer
+	sp := tr.Root("x", KindInternal)
+	if sp != nil {
+		t.Fatal("nil tracer sampled a span")
+	}
+	sp.SetAttr("k", 1)
+	sp.SetError(errors.New("boom"))
+	sp.End()
+	if sp.Context().IsValid() {
+		t.Fatal("nil span has a valid context")
+	}
+	if tr.Child(sp.Context(), "y", KindInternal) != nil {
+		t.Fatal("child of an unsampled parent was started")
+	}
+}
+
+func TestTracer_ExportsParentChildAndLinks(t *testing.T) {
+	exp := &memExporter{}
+	tr := NewTracer(Config{SampleRatio: 1, FlushInterval: time.Hour}, exp)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- tr.Run(ctx) }()
+
+	root := tr.Root("irc.read", KindInternal)
+	child := tr.Child(root.Context(), "irc.classify", KindInternal)
+	child.SetAttr("irc.command", "PRIVMSG")
+	child.End()
+	root.End()
+	linked := tr.Linked(child.Context(), "kafka.consume", KindConsumer)
+	linked.SetError(errors.New("bad"))
+	linked.End()
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+
+	exp.mu.Lock()
+	defer exp.mu.Unlock()
+	if !exp.shut || len(exp.spans) != 3 {
+		t.Fatalf("shutdown=%v spans=%d, want true and 3", exp.shut, len(exp.spans))
+	}
+	byName := map[string]SpanData{}
+	for _, s := range exp.spans {
+		byName[s.Name] = s
+	}
+	r, c, l := byName["irc.read"], byName["irc.classify"], byName["kafka.consume"]
+	if c.TraceID != r.TraceID || c.ParentID != r.SpanID {
+		t.Fatalf("child not under root: %+v / %+v", c, r)
+	}
+	if c.Attrs["irc.command"] != "PRIVMSG" {
+		t.Fatalf("attrs = %v", c.Attrs)
+	}
+	if l.TraceID == r.TraceID || len(l.Links) != 1 || l.Links[0] != child.Context().Traceparent() || l.Error != "bad" {
+		t.Fatalf("linked span = %+v", l)
+	}
+}
+
+func TestTracer_SampleRatioZeroNeverSamples(t *testing.T) {
+	tr := NewTracer(Config{SampleRatio: 0}, &memExporter{})
+	for i := 0; i < 100; i++ {
+		if tr.Root("x", KindInternal) != nil {
+			t.Fatal("sampled with ratio 0")
+		}
+	}
+}
+
+func TestOTLPEncoding(t *testing.T) {
+	start := time.Unix(1_700_000_000, 0)
+	req := toOTLP("svc", []SpanData{{
+		Name: "kafka.write", Kind: KindProducer,
+		TraceID: "0af7651916cd43dd8448eb211c80319c", SpanID: "b7ad6b7169203331",
+		Start: start, End: start.Add(time.Millisecond),
+		Attrs: map[string]any{"n": 3}, Error: "boom",
+	}})
+	b, err := json.Marshal(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got struct {
+		ResourceSpans []struct {
+			ScopeSpans []struct {
+				Spans []struct {
+					TraceID           string `json:"traceId"`
+					Kind              int    `json:"kind"`
+					StartTimeUnixNano string `json:"startTimeUnixNano"`
+					Attributes        []struct {
+						Key   string `json:"key"`
+						Value struct {
+							IntValue string `json:"intValue"`
+						} `json:"value"`
+					} `json:"attributes"`
+					Status struct {
+						Code int `json:"code"`
+					} `json:"status"`
+				} `json:"spans"`
+			} `json:"scopeSpans"`
+		} `json:"resourceSpans"`
+	}
+	if err := json.Unmarshal(b, &got); err != nil {
+		t.Fatal(err)
+	}
+	s := got.ResourceSpans[0].ScopeSpans[0].Spans[0]
+	if s.TraceID != "0af7651916cd43dd8448eb211c80319c" || s.Kind != 4 || s.StartTimeUnixNano != "1700000000000000000" {
+		t.Fatalf("span = %+v", s)
+	}
+	if len(s.Attributes) != 1 || s.Attributes[0].Value.IntValue != "3" || s.Status.Code != 2 {
+		t.Fatalf("attributes/status = %+v", s)
+	}
+}
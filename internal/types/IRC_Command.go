@@ -1,6 +1,17 @@
 package types
 
 type IRCCommand struct {
-	Op      string // "JOIN", "PART", etc.
-	Channel string // e.g., "#chess"
+	ID      string               // request correlation id; empty for internal commands
+	Op      string               // "JOIN", "PART", etc.
+	Channel string               // e.g., "#chess"
+	Source  string               // origin for the journal, e.g. "http:ops", "file", "api-batch:ops"
+	Reply   chan<- CommandResult // optional; receives exactly one result, must be buffered
+}
+
+// CommandResult acknowledges an IRCCommand once its effect is persisted.
+type CommandResult struct {
+	ID      string
+	Version uint64 // snapshot version that reflects the command
+	Changed bool   // false when the desired set already matched
+	Err     error
 }
@@ -1,6 +1,20 @@
 package types
 
+import "strings"
+
 type Account struct {
 	User string `json:"accountname"`
 	Nick string `json:"username"`
+
+	// Per-account files; empty takes TOKENS_PATH / CHANNELS_PATH with
+	// "{account}" replaced by Name().
+	TokensPath   string `json:"tokens_path,omitempty"`
+	ChannelsPath string `json:"channels_path,omitempty"`
+
+	// Anonymous accounts connect as a random justinfan nick without a
+	// token and can only read.
+	Anonymous bool `json:"anonymous,omitempty"`
 }
+
+// Name identifies the account in URLs, OAuth state and logs.
+func (a Account) Name() string { return strings.ToLower(a.User) }
@@ -1,6 +1,6 @@
 package types
 
 type MembershipEvent struct {
-	Op      string // "JOIN", "PART", "ROOMSTATE", etc.
+	Op      string // "JOIN", "PART", "DISCONNECT" (all channels lost), etc.
 	Channel string // e.g., "#chess"
 }
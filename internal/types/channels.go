@@ -4,9 +4,87 @@ import (
 	"time"
 )
 
+// ChannelsSchema is the channels.json schema this build reads and writes.
+const ChannelsSchema = 2
+
 type Channels struct {
-	Schema    int       `json:"schema"`
-	Account   string    `json:"account"`
-	UpdatedAt time.Time `json:"updated_at"`
-	Channels  []string  `json:"channels"`
+	Schema    int            `json:"schema"`
+	Account   string         `json:"account"`
+	Version   uint64         `json:"version,omitempty"`
+	UpdatedAt time.Time      `json:"updated_at"`
+	Channels  []ChannelEntry `json:"channels"`
+}
+
+// ChannelEntry is one desired channel and its operator metadata.
+type ChannelEntry struct {
+	Name     string         `json:"name"` // e.g., "#chess"
+	Priority int            `json:"priority,omitempty"`
+	Labels   []string       `json:"labels,omitempty"`
+	AddedBy  string         `json:"added_by,omitempty"`
+	AddedAt  time.Time      `json:"added_at,omitzero"`
+	Notes    string         `json:"notes,omitempty"`
+	RoomID   string         `json:"room_id,omitempty"`
+	Policy   *ChannelPolicy `json:"policy,omitempty"` // nil collects everything
+}
+
+// ValidChannelName accepts "#" followed by a Twitch login: 1-25 of [a-z0-9_].
+func ValidChannelName(ch string) bool {
+	if len(ch) < 2 || len(ch) > 26 || ch[0] != '#' {
+		return false
+	}
+	for i := 1; i < len(ch); i++ {
+		b := ch[i]
+		if (b < 'a' || b > 'z') && (b < '0' || b > '9') && b != '_' {
+			return false
+		}
+	}
+	return true
+}
+
+// ChannelPolicy controls what the collector keeps for a channel.
+type ChannelPolicy struct {
+	Kinds      []string `json:"kinds,omitempty"`       // event kinds to collect; empty means all
+	SampleRate float64  `json:"sample_rate,omitempty"` // fraction of events kept; 0 means 1
+	RedactText bool     `json:"redact_text,omitempty"` // strip message text before it leaves the process
+}
+
+// Collects reports whether events of kind are collected under p.
+func (p ChannelPolicy) Collects(kind string) bool {
+	if len(p.Kinds) == 0 {
+		return true
+	}
+	for _, k := range p.Kinds {
+		if k == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// PolicyLookup resolves the collection policy for a channel login
+// (without '#'). ok is false when the channel has no explicit policy.
+type PolicyLookup interface {
+	Policy(channelLogin string) (p ChannelPolicy, ok bool)
+}
+
+// Names returns the channel names of c in order.
+func (c Channels) Names() []string {
+	out := make([]string, 0, len(c.Channels))
+	for _, e := range c.Channels {
+		out = append(out, e.Name)
+	}
+	return out
+}
+
+// ChannelJournalEntry is one line of the append-only desired-set journal.
+// RESET entries carry the full channel list and replace the set on replay;
+// META entries record a metadata edit and leave the set alone.
+type ChannelJournalEntry struct {
+	Version      uint64    `json:"version"`
+	Op           string    `json:"op"` // "JOIN", "PART", "RESET" or "META"
+	Channel      string    `json:"channel,omitempty"`
+	Channels     []string  `json:"channels,omitempty"`
+	Time         time.Time `json:"ts"`
+	Source       string    `json:"source"`
+	RestoredFrom uint64    `json:"restored_from,omitempty"`
 }
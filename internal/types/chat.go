@@ -0,0 +1,29 @@
+package types
+
+import "time"
+
+// ChatEvent is chat state the classifier forwards to the outbound sender:
+// USERSTATE and NOTICE confirm or reject our messages, ROOMSTATE carries
+// slow mode, and our own JOIN and PART bound each stay in a room.
+type ChatEvent struct {
+	Op      string            // "USERSTATE", "NOTICE", "ROOMSTATE", "JOIN" or "PART"
+	Channel string            // e.g., "#chess"
+	Tags    map[string]string // IRCv3 tags, unescaped
+	Text    string            // trailing text (NOTICE message)
+}
+
+// OutgoingMessage is a PRIVMSG to send.
+type OutgoingMessage struct {
+	Channel          string `json:"channel"`
+	Text             string `json:"text"`
+	ReplyParentMsgID string `json:"reply_parent_msg_id,omitempty"`
+}
+
+// DeliveryStatus reports what became of an OutgoingMessage.
+type DeliveryStatus struct {
+	ID      string    `json:"id"`
+	Channel string    `json:"channel"`
+	Status  string    `json:"status"`           // "confirmed", "rejected", "unconfirmed" or "expired"
+	Reason  string    `json:"reason,omitempty"` // NOTICE msg-id on rejection
+	SentAt  time.Time `json:"sent_at,omitzero"`
+}